@@ -0,0 +1,338 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+(function (native) {
+  "use strict";
+
+  const kName = Symbol("name");
+  const kAborted = Symbol("aborted");
+  const kReason = Symbol("reason");
+  const kListeners = Symbol("listeners");
+  const kHandler = Symbol("handler");
+  const kSignal = Symbol("signal");
+
+  // guards the AbortSignal constructor, signals come from an AbortController
+  const kConstruct = Symbol("construct");
+
+  // the fetch polyfill reads the deadline of a timeout signal through this
+  const kTimeout = Symbol.for("v8go-polyfills.AbortSignal.timeout");
+
+  // https://webidl.spec.whatwg.org/#dfn-error-names-table
+  const errorCodes = {
+    IndexSizeError: 1,
+    HierarchyRequestError: 3,
+    WrongDocumentError: 4,
+    InvalidCharacterError: 5,
+    NoModificationAllowedError: 7,
+    NotFoundError: 8,
+    NotSupportedError: 9,
+    InvalidStateError: 11,
+    SyntaxError: 12,
+    InvalidModificationError: 13,
+    NamespaceError: 14,
+    InvalidAccessError: 15,
+    TypeMismatchError: 17,
+    SecurityError: 18,
+    NetworkError: 19,
+    AbortError: 20,
+    URLMismatchError: 21,
+    QuotaExceededError: 22,
+    TimeoutError: 23,
+    InvalidNodeTypeError: 24,
+    DataCloneError: 25,
+  };
+
+  class DOMException extends Error {
+    constructor(message = "", name = "Error") {
+      super(message);
+      this[kName] = String(name);
+    }
+
+    get name() {
+      return this[kName];
+    }
+
+    get code() {
+      return errorCodes[this[kName]] || 0;
+    }
+
+    get [Symbol.toStringTag]() {
+      return "DOMException";
+    }
+  }
+
+  // exceptions of listeners don't stop the dispatch, they are reported async
+  function reportException(e) {
+    Promise.reject(e);
+  }
+
+  // https://dom.spec.whatwg.org/#concept-event-listener-inner-invoke
+  function dispatch(target, event) {
+    const listeners = target[kListeners].filter((l) => l.type === event.type);
+
+    for (const listener of listeners) {
+      if (listener.removed) {
+        continue;
+      }
+
+      if (listener.once) {
+        removeListener(target, listener);
+      }
+
+      try {
+        if (typeof listener.callback === "function") {
+          listener.callback.call(target, event);
+        } else {
+          listener.callback.handleEvent(event);
+        }
+      } catch (e) {
+        reportException(e);
+      }
+    }
+
+    return true;
+  }
+
+  function removeListener(target, listener) {
+    listener.removed = true;
+    target[kListeners] = target[kListeners].filter((l) => l !== listener);
+  }
+
+  function createEvent(type, target) {
+    return {
+      type,
+      target,
+      currentTarget: target,
+      timeStamp: Date.now(),
+    };
+  }
+
+  // https://dom.spec.whatwg.org/#abortsignal-signal-abort
+  function signalAbort(signal, reason) {
+    if (signal[kAborted]) {
+      return;
+    }
+
+    signal[kAborted] = true;
+    signal[kReason] =
+      reason === undefined
+        ? new DOMException("signal is aborted without reason", "AbortError")
+        : reason;
+
+    dispatch(signal, createEvent("abort", signal));
+  }
+
+  /*
+   * A timeout signal aborts once its deadline passed, checked when it's read.
+   * The Go timer is only started for listeners, so a signal just passed
+   * to fetch doesn't keep one around.
+   */
+  function checkTimeout(signal) {
+    const timeout = signal[kTimeout];
+    if (timeout !== undefined && Date.now() >= timeout.deadline) {
+      timeout.fire();
+    }
+  }
+
+  function startTimeout(signal) {
+    const timeout = signal[kTimeout];
+    if (timeout === undefined || timeout.started || signal[kAborted]) {
+      return;
+    }
+
+    timeout.started = true;
+    native.setTimeout(Math.max(0, timeout.deadline - Date.now()), () =>
+      timeout.fire()
+    );
+  }
+
+  class AbortSignal {
+    constructor(key) {
+      if (key !== kConstruct) {
+        throw new TypeError("Illegal constructor");
+      }
+
+      this[kAborted] = false;
+      this[kReason] = undefined;
+      this[kListeners] = [];
+      this[kHandler] = null;
+    }
+
+    static abort(reason) {
+      const signal = new AbortSignal(kConstruct);
+      signalAbort(signal, reason);
+
+      return signal;
+    }
+
+    // https://dom.spec.whatwg.org/#dom-abortsignal-timeout
+    static timeout(ms) {
+      ms = Number(ms);
+      if (!Number.isFinite(ms) || ms < 0) {
+        throw new TypeError(
+          "Failed to execute 'timeout' on 'AbortSignal': Value is outside the 'unsigned long long' value range."
+        );
+      }
+
+      const signal = new AbortSignal(kConstruct);
+      signal[kTimeout] = {
+        deadline: Date.now() + Math.trunc(ms),
+        started: false,
+        fire: () =>
+          signalAbort(
+            signal,
+            new DOMException("signal timed out", "TimeoutError")
+          ),
+      };
+
+      return signal;
+    }
+
+    get aborted() {
+      checkTimeout(this);
+      return this[kAborted];
+    }
+
+    get reason() {
+      checkTimeout(this);
+      return this[kReason];
+    }
+
+    get onabort() {
+      return this[kHandler] === null ? null : this[kHandler].handler;
+    }
+
+    /*
+     * An event handler is a listener added when it's first set,
+     * so it runs in that order with the others.
+     * https://html.spec.whatwg.org/multipage/webappapis.html#event-handler-attributes
+     */
+    set onabort(handler) {
+      if (typeof handler !== "function") {
+        handler = null;
+      }
+
+      if (handler === null) {
+        if (this[kHandler] !== null) {
+          removeListener(this, this[kHandler].listener);
+          this[kHandler] = null;
+        }
+        return;
+      }
+
+      if (this[kHandler] === null) {
+        const state = { handler };
+        state.listener = {
+          type: "abort",
+          callback: (event) => state.handler.call(this, event),
+          once: false,
+          removed: false,
+        };
+
+        this[kListeners].push(state.listener);
+        this[kHandler] = state;
+        startTimeout(this);
+      }
+
+      this[kHandler].handler = handler;
+    }
+
+    throwIfAborted() {
+      if (this.aborted) {
+        throw this[kReason];
+      }
+    }
+
+    addEventListener(type, callback, options) {
+      if (callback === null || callback === undefined) {
+        return;
+      }
+
+      type = String(type);
+      const once =
+        typeof options === "object" && options !== null && !!options.once;
+
+      const exists = this[kListeners].some(
+        (l) => l.type === type && l.callback === callback
+      );
+      if (!exists) {
+        this[kListeners].push({ type, callback, once, removed: false });
+
+        if (type === "abort") {
+          startTimeout(this);
+        }
+      }
+    }
+
+    removeEventListener(type, callback) {
+      type = String(type);
+      const listener = this[kListeners].find(
+        (l) => l.type === type && l.callback === callback
+      );
+
+      if (listener !== undefined) {
+        removeListener(this, listener);
+      }
+    }
+
+    dispatchEvent(event) {
+      return dispatch(this, event);
+    }
+
+    get [Symbol.toStringTag]() {
+      return "AbortSignal";
+    }
+  }
+
+  class AbortController {
+    constructor() {
+      this[kSignal] = new AbortSignal(kConstruct);
+    }
+
+    get signal() {
+      return this[kSignal];
+    }
+
+    abort(reason) {
+      signalAbort(this[kSignal], reason);
+    }
+
+    get [Symbol.toStringTag]() {
+      return "AbortController";
+    }
+  }
+
+  const exports = { AbortController, AbortSignal };
+
+  // don't replace a native one
+  if (typeof globalThis.DOMException !== "function") {
+    exports.DOMException = DOMException;
+  }
+
+  for (const [name, value] of Object.entries(exports)) {
+    Object.defineProperty(globalThis, name, {
+      value,
+      writable: true,
+      configurable: true,
+    });
+  }
+});
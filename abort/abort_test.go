@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package abort
+
+import (
+	"testing"
+	"time"
+
+	"rogchap.com/v8go"
+)
+
+func TestInject(t *testing.T) {
+	t.Parallel()
+
+	ctx := v8go.NewContext()
+
+	if err := InjectTo(ctx); err != nil {
+		t.Errorf("inject abort polyfill: %v", err)
+	}
+
+	for _, name := range []string{"AbortController", "AbortSignal", "DOMException"} {
+		if val, _ := ctx.RunScript("typeof "+name, ""); val.String() != "function" {
+			t.Errorf("inject %s failed", name)
+		}
+	}
+}
+
+func TestAbortController(t *testing.T) {
+	t.Parallel()
+
+	ctx := v8go.NewContext()
+
+	if err := InjectTo(ctx); err != nil {
+		t.Errorf("inject abort polyfill: %v", err)
+		return
+	}
+
+	cases := [][2]string{
+		{`const c = new AbortController(); c.signal.aborted`, "false"},
+		{`const c = new AbortController(); c.abort(); c.signal.aborted`, "true"},
+		{`const c = new AbortController(); c.abort(); const r = c.signal.reason; r instanceof DOMException && r.name + " " + r.code`, "AbortError 20"},
+		{`const c = new AbortController(); c.abort("why"); c.signal.reason`, "why"},
+		{`const c = new AbortController(); const r = []; c.signal.addEventListener("abort", e => r.push(e.type)); c.signal.onabort = () => r.push("onabort"); c.abort(); c.abort(); r.join()`, "abort,onabort"},
+		{`const c = new AbortController(); const r = []; const fn = () => r.push(1); c.signal.addEventListener("abort", fn); c.signal.removeEventListener("abort", fn); c.abort(); r.length`, "0"},
+		{`const c = new AbortController(); c.signal.addEventListener("abort", () => { throw new Error("x") }); let called = false; c.signal.onabort = () => called = true; c.abort(); called`, "true"},
+		{`const c = new AbortController(); c.abort(); try { c.signal.throwIfAborted(); "no error" } catch (e) { e.name }`, "AbortError"},
+		{`const s = AbortSignal.abort(); s.aborted`, "true"},
+		{`try { new AbortSignal(); "no error" } catch (e) { e instanceof TypeError }`, "true"},
+		{`const e = new DOMException("msg", "TimeoutError"); e instanceof Error && String(e)`, "TimeoutError: msg"},
+	}
+
+	for i, c := range cases {
+		// a block scopes the declarations, and keeps the completion value
+		val, err := ctx.RunScript("{"+c[0]+"}", "")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
+
+func TestAbortSignalTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx := v8go.NewContext()
+
+	if err := InjectTo(ctx); err != nil {
+		t.Errorf("inject abort polyfill: %v", err)
+		return
+	}
+
+	_, err := ctx.RunScript(`const listened = AbortSignal.timeout(50);
+		const read = AbortSignal.timeout(50);
+		let reason;
+		listened.addEventListener("abort", () => reason = listened.reason);`, "abort_signal_timeout.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if val, _ := ctx.RunScript("read.aborted", ""); val.Boolean() {
+		t.Error("signal should not be aborted before the timeout")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if val, _ := ctx.RunScript("reason instanceof DOMException && reason.name", ""); val.String() != "TimeoutError" {
+		t.Errorf("expected a TimeoutError but got '%s'", val.String())
+	}
+
+	if val, _ := ctx.RunScript("read.aborted && read.reason.name", ""); val.String() != "TimeoutError" {
+		t.Errorf("expected a TimeoutError but got '%s'", val.String())
+	}
+
+	if val, _ := ctx.RunScript("try { AbortSignal.timeout(-1); 'no error' } catch (e) { e instanceof TypeError }", ""); !val.Boolean() {
+		t.Errorf("expected a TypeError but got '%s'", val.String())
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package abort
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"rogchap.com/v8go"
+)
+
+func InjectTo(ctx *v8go.Context) error {
+	if ctx == nil {
+		return errors.New("v8go-polyfills/abort: ctx is required")
+	}
+
+	val, err := ctx.RunScript(abortPolyfill, "abort-polyfill.js")
+	if err != nil {
+		return fmt.Errorf("v8go-polyfills/abort: %w", err)
+	}
+
+	factory, err := val.AsFunction()
+	if err != nil {
+		return fmt.Errorf("v8go-polyfills/abort: %w", err)
+	}
+
+	natives, err := newNativeObject(ctx)
+	if err != nil {
+		return fmt.Errorf("v8go-polyfills/abort: %w", err)
+	}
+
+	if _, err := factory.Call(v8go.Undefined(ctx.Isolate()), natives); err != nil {
+		return fmt.Errorf("v8go-polyfills/abort: %w", err)
+	}
+
+	return nil
+}
+
+/*
+newNativeObject creates the object passed to the JS side of the polyfill,
+setTimeout(ms, callback) calls back once, driving AbortSignal.timeout.
+*/
+func newNativeObject(ctx *v8go.Context) (*v8go.Object, error) {
+	iso := ctx.Isolate()
+
+	setTimeoutFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		args := info.Args()
+		if len(args) < 2 {
+			return nil
+		}
+
+		callback, err := args[1].AsFunction()
+		if err != nil {
+			return nil
+		}
+
+		delay := time.Duration(args[0].Integer()) * time.Millisecond
+
+		time.AfterFunc(delay, func() {
+			_, _ = callback.Call(v8go.Undefined(iso))
+		})
+
+		return nil
+	})
+
+	nativeTmp := v8go.NewObjectTemplate(iso)
+
+	if err := nativeTmp.Set("setTimeout", setTimeoutFnTmp, v8go.ReadOnly); err != nil {
+		return nil, err
+	}
+
+	return nativeTmp.NewInstance(ctx)
+}
@@ -23,8 +23,9 @@
 package base64
 
 import (
-	stdBase64 "encoding/base64"
+	"errors"
 
+	"github.com/weese/v8go-polyfills/internal"
 	"rogchap.com/v8go"
 )
 
@@ -49,21 +50,31 @@ func (b *base64) GetAtobFunctionCallback() v8go.FunctionCallback {
 		ctx := info.Context()
 
 		if len(args) <= 0 {
-			// TODO: v8go can't throw a error now, so we return an empty string
-			return newStringValue(ctx, "")
+			return throwError(ctx, "TypeError", "Failed to execute 'atob': 1 argument required, but only 0 present.")
 		}
 
-		encoded := args[0].String()
+		if isEmptyString(args[0]) {
+			return args[0]
+		}
 
-		byts, err := stdBase64.StdEncoding.DecodeString(encoded)
+		encoded, err := internal.ValueString(ctx, args[0])
 		if err != nil {
-			return newStringValue(ctx, "")
+			return throwError(ctx, "TypeError", err.Error())
 		}
 
-		return newStringValue(ctx, string(byts))
+		// forgiving-base64, https://infra.spec.whatwg.org/#forgiving-base64-decode
+		byts, err := decode(encoded, alphabetBase64, lastChunkLoose)
+		if err != nil {
+			return throwInvalidCharacterError(ctx, errInvalidEncoding.Error())
+		}
+
+		val, _ := internal.NewLatin1Value(ctx, byts)
+		return val
 	}
 }
 
+var errInvalidEncoding = errors.New("The string to be decoded is not correctly encoded.")
+
 /*
  https://developer.mozilla.org/en-US/docs/Web/API/WindowOrWorkerGlobalScope/btoa
 */
@@ -73,18 +84,81 @@ func (b *base64) GetBtoaFunctionCallback() v8go.FunctionCallback {
 		ctx := info.Context()
 
 		if len(args) <= 0 {
-			return newStringValue(ctx, "")
+			return throwError(ctx, "TypeError", "Failed to execute 'btoa': 1 argument required, but only 0 present.")
 		}
 
-		str := args[0].String()
+		if isEmptyString(args[0]) {
+			return args[0]
+		}
+
+		str, err := internal.ValueString(ctx, args[0])
+		if err != nil {
+			return throwError(ctx, "TypeError", err.Error())
+		}
 
-		encoded := stdBase64.StdEncoding.EncodeToString([]byte(str))
-		return newStringValue(ctx, encoded)
+		// Latin-1, v8go gives a lone surrogate as U+FFFD, above 0xFF too
+		encoded, ok := encodeString(str, alphabetBase64, false, 0xFF)
+		if !ok {
+			return throwInvalidCharacterError(ctx, "The string to be encoded contains characters outside of the Latin1 range.")
+		}
+
+		// no NUL in base64, it goes to v8go as it is
+		val, _ := v8go.NewValue(ctx.Isolate(), encoded)
+		return val
 	}
 }
 
-func newStringValue(ctx *v8go.Context, str string) *v8go.Value {
+// isEmptyString tells if val is "", which is returned as it is, it's the only falsy string
+func isEmptyString(val *v8go.Value) bool {
+	return val.IsString() && !val.Boolean()
+}
+
+/*
+throwInvalidCharacterError throws an InvalidCharacterError DOMException, the abort polyfill
+defines DOMException, without it it's an Error named InvalidCharacterError.
+*/
+func throwInvalidCharacterError(ctx *v8go.Context, msg string) *v8go.Value {
+	if val, err := ctx.Global().Get("DOMException"); err == nil && val.IsFunction() {
+		ctor, _ := val.AsFunction()
+		if e, err := ctor.NewInstance(newStringValue(ctx, msg), newStringValue(ctx, "InvalidCharacterError")); err == nil {
+			return ctx.Isolate().ThrowException(e.Value)
+		}
+	}
+
+	return throwError(ctx, "InvalidCharacterError", msg)
+}
+
+/*
+throwError throws an error of the named global constructor, like SyntaxError,
+an Error with the name if there is none.
+*/
+func throwError(ctx *v8go.Context, name, msg string) *v8go.Value {
 	iso := ctx.Isolate()
-	val, _ := v8go.NewValue(iso, str)
+	msgVal := newStringValue(ctx, msg)
+
+	ctorName := "Error"
+	if val, err := ctx.Global().Get(name); err == nil && val.IsFunction() {
+		ctorName = name
+	}
+
+	val, err := ctx.Global().Get(ctorName)
+	if err != nil {
+		return iso.ThrowException(msgVal)
+	}
+
+	ctor, _ := val.AsFunction()
+	e, err := ctor.NewInstance(msgVal)
+	if err != nil {
+		return iso.ThrowException(msgVal)
+	}
+
+	if ctorName != name {
+		_ = e.Set("name", name)
+	}
+	return iso.ThrowException(e.Value)
+}
+
+func newStringValue(ctx *v8go.Context, str string) *v8go.Value {
+	val, _ := internal.NewStringValue(ctx, str)
 	return val
 }
@@ -23,8 +23,13 @@
 package base64
 
 import (
+	stdBase64 "encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/weese/v8go-polyfills/abort"
 	"rogchap.com/v8go"
 )
 
@@ -35,14 +40,15 @@ func TestAtob(t *testing.T) {
 		return
 	}
 
-	val, err := ctx.RunScript("atob()", "atob_undefined.js")
+	// an argument is required, like in browsers
+	val, err := ctx.RunScript("try { atob() } catch (e) { [e instanceof TypeError, e.message].join() }", "atob_undefined.js")
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if s := val.String(); s != "" {
-		t.Errorf("assert '' but got '%s'", s)
+	if s, expected := val.String(), "true,Failed to execute 'atob': 1 argument required, but only 0 present."; s != expected {
+		t.Errorf("assert '%s' but got '%s'", expected, s)
 		return
 	}
 
@@ -68,7 +74,8 @@ func TestAtob(t *testing.T) {
 		return
 	}
 
-	val, err = ctx.RunScript("atob('5rGJ5a2X')", "atob_unicode.js")
+	// the binary string of the UTF-8 bytes
+	val, err = ctx.RunScript("decodeURIComponent(escape(atob('5rGJ5a2X')))", "atob_unicode.js")
 	if err != nil {
 		t.Error(err)
 		return
@@ -80,6 +87,143 @@ func TestAtob(t *testing.T) {
 	}
 }
 
+func TestAtobForgiving(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8goContext()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// from the atob tests of web-platform-tests, html/webappapis/atob/base64.any.js
+	cases := [][2]string{
+		{`""`, ""},
+		{`"YQ"`, "a"},
+		{`"YQ=="`, "a"},
+		{`"YWI"`, "ab"},
+		{`"YWI="`, "ab"},
+		{`"YWJj"`, "abc"},
+		{`"YWJjZA"`, "abcd"},
+		// the bits after the last byte are discarded
+		{`"YR"`, "a"},
+		{`"YR=="`, "a"},
+		// ASCII whitespace is removed first, anywhere
+		{`" YQ=="`, "a"},
+		{`"YQ== "`, "a"},
+		{`"Y Q = ="`, "a"},
+		{`"\tYQ\n==\f\r"`, "a"},
+		{`"YW\nJj\r\nZA=="`, "abcd"},
+		{`" \t\n\f\r"`, ""},
+		// not ASCII whitespace
+		{`"YQ\v=="`, "InvalidCharacterError"},
+		{`"YQ\u00a0=="`, "InvalidCharacterError"},
+		// length and padding
+		{`"Y"`, "InvalidCharacterError"},
+		{`"YWJjZ"`, "InvalidCharacterError"},
+		{`"YQ="`, "InvalidCharacterError"},
+		{`"YQ==="`, "InvalidCharacterError"},
+		{`"YWI=="`, "InvalidCharacterError"},
+		{`"=YQ"`, "InvalidCharacterError"},
+		{`"YQ=a"`, "InvalidCharacterError"},
+		{`"YQ==YQ=="`, "InvalidCharacterError"},
+		{`"===="`, "InvalidCharacterError"},
+		// outside the alphabet
+		{`"YQ-_"`, "InvalidCharacterError"},
+		{`"YQ%3D"`, "InvalidCharacterError"},
+		{`"YQ\u00ff"`, "InvalidCharacterError"},
+		{`"\u5750"`, "InvalidCharacterError"},
+		{`"YQ==\u0000"`, "InvalidCharacterError"},
+		{`"YQ\u0000=="`, "InvalidCharacterError"},
+		// converted to a string first
+		{`undefined`, "InvalidCharacterError"},
+		{`{ toString: () => "YWJj" }`, "abc"},
+	}
+
+	for i, c := range cases {
+		val, err := ctx.RunScript("try { atob("+c[0]+") } catch (e) { e.name }", "atob_forgiving.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
+
+func TestAtobInvalidCharacterError(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8goContext()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// without the abort polyfill it's an Error named InvalidCharacterError
+	script := `try { atob("Y") } catch (e) { [e instanceof Error, e.name, e.message].join() }`
+	expected := "true,InvalidCharacterError,The string to be decoded is not correctly encoded."
+
+	if val, err := ctx.RunScript(script, "atob_error.js"); err != nil {
+		t.Error(err)
+	} else if val.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, val.String())
+	}
+
+	if err := abort.InjectTo(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+
+	script = `try { atob("Y") } catch (e) { [e instanceof DOMException, e.name, e.code].join() }`
+	expected = "true,InvalidCharacterError,5"
+
+	if val, err := ctx.RunScript(script, "atob_dom_exception.js"); err != nil {
+		t.Error(err)
+	} else if val.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, val.String())
+	}
+}
+
+func TestAtobBytes(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8goContext()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// each byte is one code unit of the result, NUL and those above 0x7F included
+	byts := make([]byte, 0, 512)
+	for i := 0; i < 256; i++ {
+		byts = append(byts, byte(i))
+	}
+	for i := 255; i >= 0; i-- {
+		byts = append(byts, byte(i))
+	}
+
+	script := fmt.Sprintf(`
+		const decoded = atob(%q);
+		const codes = Array.from(decoded, (c) => c.charCodeAt(0));
+		decoded.length + " " + codes.join();
+	`, stdBase64.StdEncoding.EncodeToString(byts))
+
+	codes := make([]string, len(byts))
+	for i, b := range byts {
+		codes[i] = strconv.Itoa(int(b))
+	}
+	expected := fmt.Sprintf("%d %s", len(byts), strings.Join(codes, ","))
+
+	if val, err := ctx.RunScript(script, "atob_bytes.js"); err != nil {
+		t.Error(err)
+	} else if val.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, val.String())
+	}
+}
+
 func TestBtoa(t *testing.T) {
 	ctx, err := newV8goContext()
 	if err != nil {
@@ -87,14 +231,15 @@ func TestBtoa(t *testing.T) {
 		return
 	}
 
-	val, err := ctx.RunScript("btoa()", "btoa_undefined.js")
+	// an argument is required, like in browsers
+	val, err := ctx.RunScript("try { btoa() } catch (e) { [e instanceof TypeError, e.message].join() }", "btoa_undefined.js")
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if s := val.String(); s != "" {
-		t.Errorf("assert '' but got '%s'", s)
+	if s, expected := val.String(), "true,Failed to execute 'btoa': 1 argument required, but only 0 present."; s != expected {
+		t.Errorf("assert '%s' but got '%s'", expected, s)
 		return
 	}
 
@@ -120,7 +265,8 @@ func TestBtoa(t *testing.T) {
 		return
 	}
 
-	val, err = ctx.RunScript("btoa('汉字')", "btoa_unicode.js")
+	// code points above 0xFF go as UTF-8 bytes
+	val, err = ctx.RunScript("btoa(unescape(encodeURIComponent('汉字')))", "btoa_unicode.js")
 	if err != nil {
 		t.Error(err)
 		return
@@ -143,6 +289,70 @@ func TestBtoa(t *testing.T) {
 	}
 }
 
+func TestBtoaLatin1(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8goContext()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	cases := [][2]string{
+		{`btoa("\x00")`, "AA=="},
+		{`btoa("\xff\xfe")`, "//4="},
+		{`btoa("\u00e9")`, "6Q=="},
+		{`btoa(String.fromCharCode(0x80, 0x81, 0xa0))`, "gIGg"},
+		// each code unit is one byte, nothing is UTF-8 encoded
+		{`btoa("\u2603")`, "InvalidCharacterError"},
+		{`btoa("a\u0100")`, "InvalidCharacterError"},
+		{`btoa("\ud83d\ude00")`, "InvalidCharacterError"},
+		{`btoa("\ud800")`, "InvalidCharacterError"},
+		{`btoa("\ufffd")`, "InvalidCharacterError"},
+		// the characters JSON escapes on the way to Go
+		{`btoa("\"\\\n\t\b\f\r\x00/\x1f\x7f")`, "IlwKCQgMDQAvH38="},
+		{`btoa("\u00e9\x00\u00ff")`, "6QD/"},
+		{`btoa("\x00\ud800")`, "InvalidCharacterError"},
+		{`btoa(null)`, "bnVsbA=="},
+		{`btoa(12)`, "MTI="},
+	}
+
+	for i, c := range cases {
+		val, err := ctx.RunScript("try { "+c[0]+" } catch (e) { e.name }", "btoa_latin1.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+
+	// every byte value goes through btoa and back
+	script := `
+		const s = Array.from({ length: 256 }, (_, i) => String.fromCharCode(i)).join("");
+		const encoded = btoa(s);
+		[atob(encoded) === s, encoded.length, encoded.slice(0, 8)].join();
+	`
+	expected := "true,344,AAECAwQF"
+
+	if val, err := ctx.RunScript(script, "base64_round_trip.js"); err != nil {
+		t.Error(err)
+	} else if val.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, val.String())
+	}
+
+	for i := 0; i < 256; i++ {
+		script := fmt.Sprintf("atob(btoa(String.fromCharCode(%d))).charCodeAt(0)", i)
+		if val, err := ctx.RunScript(script, "base64_round_trip_byte.js"); err != nil {
+			t.Error(err)
+		} else if val.Integer() != int64(i) {
+			t.Errorf("expected %d but got %s", i, val.String())
+		}
+	}
+}
+
 func newV8goContext() (*v8go.Context, error) {
 	iso := v8go.NewIsolate()
 	global := v8go.NewObjectTemplate(iso)
@@ -153,3 +363,68 @@ func newV8goContext() (*v8go.Context, error) {
 
 	return v8go.NewContext(iso, global), nil
 }
+
+func BenchmarkBtoa(b *testing.B) {
+	for _, size := range []int{0, 1024, 1024 * 1024} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			ctx, err := newV8goContext()
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			script := fmt.Sprintf("globalThis.s = String.fromCharCode(...Array.from({ length: 256 }, (_, i) => i)).repeat(%d).slice(0, %d)", size/256+1, size)
+			if _, err := ctx.RunScript(script, "btoa_bench_input.js"); err != nil {
+				b.Fatal(err)
+			}
+
+			global := ctx.Global()
+			btoa, _ := global.Get("btoa")
+			fn, _ := btoa.AsFunction()
+			s, _ := global.Get("s")
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := fn.Call(v8go.Undefined(ctx.Isolate()), s); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAtob(b *testing.B) {
+	for _, size := range []int{0, 1024, 1024 * 1024} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			ctx, err := newV8goContext()
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			byts := make([]byte, size)
+			for i := range byts {
+				byts[i] = byte(i * 31)
+			}
+
+			val, err := v8go.NewValue(ctx.Isolate(), stdBase64.StdEncoding.EncodeToString(byts))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			atob, _ := ctx.Global().Get("atob")
+			fn, _ := atob.AsFunction()
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := fn.Call(v8go.Undefined(ctx.Isolate()), val); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
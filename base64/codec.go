@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package base64
+
+import (
+	stdBase64 "encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// the alphabets and last chunk handlings of https://tc39.es/proposal-arraybuffer-base64/
+const (
+	alphabetBase64    = "base64"
+	alphabetBase64URL = "base64url"
+
+	lastChunkLoose             = "loose"
+	lastChunkStrict            = "strict"
+	lastChunkStopBeforePartial = "stop-before-partial"
+)
+
+var (
+	errInvalidCharacter = errors.New("invalid character")
+	errPartialChunk     = errors.New("incomplete last chunk")
+	errExtraBits        = errors.New("non-zero padding bits")
+	errMisplacedPadding = errors.New("misplaced padding")
+)
+
+// the value of each character of the standard alphabet, 0xFF for the others
+var decodeMap = func() (m [256]byte) {
+	for i := range m {
+		m[i] = 0xFF
+	}
+	for i, c := range "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/" {
+		m[c] = byte(i)
+	}
+
+	return m
+}()
+
+func isASCIIWhitespace(c byte) bool {
+	return c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func skipASCIIWhitespace(s string, i int) int {
+	for i < len(s) && isASCIIWhitespace(s[i]) {
+		i++
+	}
+
+	return i
+}
+
+// the encodings of the alphabets, with and without padding
+var encodings = map[string][2]*stdBase64.Encoding{
+	alphabetBase64:    {stdBase64.StdEncoding, stdBase64.RawStdEncoding},
+	alphabetBase64URL: {stdBase64.URLEncoding, stdBase64.RawURLEncoding},
+}
+
+func encoding(alphabet string, omitPadding bool) *stdBase64.Encoding {
+	encs := encodings[alphabet]
+	if omitPadding {
+		return encs[1]
+	}
+
+	return encs[0]
+}
+
+// the bytes read and encoded at once by encodeString, a multiple of 3
+const chunkSize = 3 * 1024
+
+type chunkBuffer struct {
+	in  [chunkSize]byte
+	out [chunkSize / 3 * 4]byte
+}
+
+var chunkBuffers = sync.Pool{
+	New: func() interface{} { return new(chunkBuffer) },
+}
+
+/*
+encodeString encodes the string of bytes s, one byte per code point, with the
+alphabet, without the = padding if omitPadding. The bytes go through a pooled
+buffer in chunks and the result is built once, no copy of the bytes of s is made.
+A code point above max fails, byte(r) is the byte of one up to max, so a byte
+string of internal.EncodeBytes has 0x1FF.
+*/
+func encodeString(s string, alphabet string, omitPadding bool, max rune) (string, bool) {
+	enc := encoding(alphabet, omitPadding)
+
+	n := utf8.RuneCountInString(s)
+
+	buf := chunkBuffers.Get().(*chunkBuffer)
+	defer chunkBuffers.Put(buf)
+
+	var sb strings.Builder
+	sb.Grow(enc.EncodedLen(n))
+
+	// a full chunk has no padding, only the last one
+	flush := func(k int) {
+		enc.Encode(buf.out[:], buf.in[:k])
+		sb.Write(buf.out[:enc.EncodedLen(k)])
+	}
+
+	if n == len(s) {
+		// ASCII, the bytes are the ones of s
+		for off := 0; off < len(s); {
+			k := copy(buf.in[:], s[off:])
+			flush(k)
+			off += k
+		}
+
+		return sb.String(), true
+	}
+
+	i := 0
+	for _, r := range s {
+		if r > max {
+			return "", false
+		}
+
+		buf.in[i] = byte(r)
+		i++
+
+		if i == chunkSize {
+			flush(i)
+			i = 0
+		}
+	}
+
+	if i > 0 {
+		flush(i)
+	}
+
+	return sb.String(), true
+}
+
+/*
+decode decodes s, https://tc39.es/proposal-arraybuffer-base64/#sec-frombase64
+ASCII whitespace is skipped anywhere. A last chunk of 2 or 3 characters without
+padding is decoded if loose, an error if strict and left out if stop-before-partial,
+strict rejects non-zero bits after the last byte too. The forgiving-base64 of atob
+is decode(s, alphabetBase64, lastChunkLoose).
+*/
+func decode(s string, alphabet string, lastChunkHandling string) ([]byte, error) {
+	byts := make([]byte, 0, len(s)/4*3+2)
+
+	var chunk [4]byte
+	chunkLength := 0
+
+	for i := 0; ; {
+		i = skipASCIIWhitespace(s, i)
+
+		if i == len(s) {
+			if chunkLength > 0 {
+				switch {
+				case lastChunkHandling == lastChunkStopBeforePartial:
+					return byts, nil
+				case lastChunkHandling == lastChunkStrict, chunkLength == 1:
+					return nil, errPartialChunk
+				}
+
+				return appendChunk(byts, chunk[:chunkLength], false)
+			}
+
+			return byts, nil
+		}
+
+		c := s[i]
+		i++
+
+		if c == '=' {
+			if chunkLength < 2 {
+				return nil, errMisplacedPadding
+			}
+
+			i = skipASCIIWhitespace(s, i)
+			if chunkLength == 2 {
+				if i == len(s) {
+					if lastChunkHandling == lastChunkStopBeforePartial {
+						return byts, nil
+					}
+					return nil, errPartialChunk
+				}
+
+				if s[i] == '=' {
+					i = skipASCIIWhitespace(s, i+1)
+				}
+			}
+
+			if i < len(s) {
+				return nil, errMisplacedPadding
+			}
+
+			return appendChunk(byts, chunk[:chunkLength], lastChunkHandling == lastChunkStrict)
+		}
+
+		if alphabet == alphabetBase64URL {
+			switch c {
+			case '+', '/':
+				return nil, errInvalidCharacter
+			case '-':
+				c = '+'
+			case '_':
+				c = '/'
+			}
+		}
+
+		v := decodeMap[c]
+		if v == 0xFF {
+			return nil, errInvalidCharacter
+		}
+
+		chunk[chunkLength] = v
+		chunkLength++
+
+		if chunkLength == 4 {
+			byts = append(byts, chunk[0]<<2|chunk[1]>>4, chunk[1]<<4|chunk[2]>>2, chunk[2]<<6|chunk[3])
+			chunkLength = 0
+		}
+	}
+}
+
+// appendChunk appends the bytes of a last chunk of 2 or 3 characters
+func appendChunk(byts []byte, chunk []byte, throwOnExtraBits bool) ([]byte, error) {
+	if len(chunk) == 2 {
+		if throwOnExtraBits && chunk[1]&0x0F != 0 {
+			return nil, errExtraBits
+		}
+		return append(byts, chunk[0]<<2|chunk[1]>>4), nil
+	}
+
+	if throwOnExtraBits && chunk[2]&0x03 != 0 {
+		return nil, errExtraBits
+	}
+	return append(byts, chunk[0]<<2|chunk[1]>>4, chunk[1]<<4|chunk[2]>>2), nil
+}
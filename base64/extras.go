@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package base64
+
+import (
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/weese/v8go-polyfills/internal"
+	"rogchap.com/v8go"
+)
+
+//go:embed extras.js
+var extrasPolyfill string
+
+/*
+InjectExtras adds Uint8Array.fromBase64, Uint8Array.fromHex and the toBase64 and toHex
+methods of Uint8Array.prototype to ctx, https://tc39.es/proposal-arraybuffer-base64/
+with the alphabet, lastChunkHandling and omitPadding options. They use the codec of
+atob and btoa, which InjectTo adds, InjectExtras works without them as well.
+*/
+func InjectExtras(ctx *v8go.Context) error {
+	if ctx == nil {
+		return errors.New("v8go-polyfills/base64: ctx is required")
+	}
+
+	val, err := ctx.RunScript(extrasPolyfill, "base64-extras.js")
+	if err != nil {
+		return fmt.Errorf("v8go-polyfills/base64: %w", err)
+	}
+
+	factory, err := val.AsFunction()
+	if err != nil {
+		return fmt.Errorf("v8go-polyfills/base64: %w", err)
+	}
+
+	natives, err := newNativeObject(ctx)
+	if err != nil {
+		return fmt.Errorf("v8go-polyfills/base64: %w", err)
+	}
+
+	if _, err := factory.Call(v8go.Undefined(ctx.Isolate()), natives); err != nil {
+		return fmt.Errorf("v8go-polyfills/base64: %w", err)
+	}
+
+	return nil
+}
+
+/*
+newNativeObject creates the object passed to extras.js, the bytes of a Uint8Array
+cross as byte strings, see internal.EncodeBytes. The JS side checks the arguments.
+*/
+func newNativeObject(ctx *v8go.Context) (*v8go.Object, error) {
+	iso := ctx.Isolate()
+
+	nativeTmp := v8go.NewObjectTemplate(iso)
+
+	for _, f := range []struct {
+		Name string
+		Func v8go.FunctionCallback
+	}{
+		{Name: "fromBase64", Func: fromBase64Callback},
+		{Name: "toBase64", Func: toBase64Callback},
+		{Name: "fromHex", Func: fromHexCallback},
+		{Name: "toHex", Func: toHexCallback},
+	} {
+		fn := v8go.NewFunctionTemplate(iso, f.Func)
+		if err := nativeTmp.Set(f.Name, fn, v8go.ReadOnly); err != nil {
+			return nil, err
+		}
+	}
+
+	return nativeTmp.NewInstance(ctx)
+}
+
+// fromBase64Callback decodes (string, alphabet, lastChunkHandling) to a byte string
+func fromBase64Callback(info *v8go.FunctionCallbackInfo) *v8go.Value {
+	ctx := info.Context()
+	args := info.Args()
+	if len(args) < 3 {
+		return nil
+	}
+
+	s, err := internal.ValueString(ctx, args[0])
+	if err != nil {
+		return throwError(ctx, "SyntaxError", err.Error())
+	}
+
+	byts, err := decode(s, args[1].String(), args[2].String())
+	if err != nil {
+		return throwError(ctx, "SyntaxError", "The string is not valid base64, "+err.Error())
+	}
+
+	return newStringValue(ctx, internal.EncodeBytes(byts))
+}
+
+// toBase64Callback encodes (byteString, alphabet, omitPadding)
+func toBase64Callback(info *v8go.FunctionCallbackInfo) *v8go.Value {
+	ctx := info.Context()
+	args := info.Args()
+	if len(args) < 3 {
+		return nil
+	}
+
+	encoded, _ := encodeString(args[0].String(), args[1].String(), args[2].Boolean(), 0x1FF)
+	return newStringValue(ctx, encoded)
+}
+
+// fromHexCallback decodes a string of hex digits to a byte string
+func fromHexCallback(info *v8go.FunctionCallbackInfo) *v8go.Value {
+	ctx := info.Context()
+	args := info.Args()
+	if len(args) < 1 {
+		return nil
+	}
+
+	s, err := internal.ValueString(ctx, args[0])
+	if err != nil {
+		return throwError(ctx, "SyntaxError", err.Error())
+	}
+
+	byts, err := hex.DecodeString(s)
+	if err != nil {
+		return throwError(ctx, "SyntaxError", "The string is not valid hex, "+err.Error())
+	}
+
+	return newStringValue(ctx, internal.EncodeBytes(byts))
+}
+
+// toHexCallback encodes a byte string with lowercase hex digits
+func toHexCallback(info *v8go.FunctionCallbackInfo) *v8go.Value {
+	ctx := info.Context()
+	args := info.Args()
+	if len(args) < 1 {
+		return nil
+	}
+
+	return newStringValue(ctx, hex.EncodeToString(internal.DecodeBytes(args[0].String())))
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Uint8Array.fromBase64, Uint8Array.fromHex, and toBase64 and toHex of
+// Uint8Array.prototype, https://tc39.es/proposal-arraybuffer-base64/
+// native holds the codec of atob and btoa, implemented in Go
+(function (native) {
+  "use strict";
+
+  if (typeof Uint8Array.fromBase64 === "function") {
+    return;
+  }
+
+  // byte strings carry one byte per code unit, shifted by 0x100
+  function byteStringToUint8Array(str) {
+    const bytes = new Uint8Array(str.length);
+    for (let i = 0; i < str.length; i++) {
+      bytes[i] = str.charCodeAt(i) & 0xff;
+    }
+
+    return bytes;
+  }
+
+  // the reverse of byteStringToUint8Array, in chunks for large arrays
+  function uint8ArrayToByteString(bytes) {
+    const chunkSize = 8192;
+    const units = new Uint16Array(Math.min(bytes.length, chunkSize));
+
+    let str = "";
+    for (let i = 0; i < bytes.length; i += chunkSize) {
+      const chunk = bytes.subarray(i, i + chunkSize);
+      for (let j = 0; j < chunk.length; j++) {
+        units[j] = chunk[j] | 0x100;
+      }
+
+      str += String.fromCharCode.apply(null, units.subarray(0, chunk.length));
+    }
+
+    return str;
+  }
+
+  const getTypedArrayName = Object.getOwnPropertyDescriptor(
+    Object.getPrototypeOf(Uint8Array.prototype),
+    Symbol.toStringTag
+  ).get;
+
+  function validateUint8Array(value, method) {
+    if (getTypedArrayName.call(value) !== "Uint8Array") {
+      throw new TypeError(
+        `Uint8Array.prototype.${method} called on an incompatible receiver`
+      );
+    }
+  }
+
+  function getOptionsObject(options) {
+    if (options === undefined) {
+      return {};
+    }
+    if (
+      options === null ||
+      (typeof options !== "object" && typeof options !== "function")
+    ) {
+      throw new TypeError("The options are not an object");
+    }
+
+    return options;
+  }
+
+  // the option is a string, one of values, the first of them if undefined
+  function getStringOption(options, name, values) {
+    const value = options[name];
+    if (value === undefined) {
+      return values[0];
+    }
+    if (!values.includes(value)) {
+      throw new TypeError(`The ${name} option is not valid`);
+    }
+
+    return value;
+  }
+
+  function checkString(value) {
+    if (typeof value !== "string") {
+      throw new TypeError("The input is not a string");
+    }
+  }
+
+  const methods = {
+    fromBase64(string, options = undefined) {
+      checkString(string);
+      options = getOptionsObject(options);
+
+      const alphabet = getStringOption(options, "alphabet", [
+        "base64",
+        "base64url",
+      ]);
+      const lastChunkHandling = getStringOption(options, "lastChunkHandling", [
+        "loose",
+        "strict",
+        "stop-before-partial",
+      ]);
+
+      return byteStringToUint8Array(
+        native.fromBase64(string, alphabet, lastChunkHandling)
+      );
+    },
+    fromHex(string) {
+      checkString(string);
+
+      return byteStringToUint8Array(native.fromHex(string));
+    },
+    toBase64(options = undefined) {
+      validateUint8Array(this, "toBase64");
+      options = getOptionsObject(options);
+
+      const alphabet = getStringOption(options, "alphabet", [
+        "base64",
+        "base64url",
+      ]);
+      const omitPadding = Boolean(options.omitPadding);
+
+      return native.toBase64(
+        uint8ArrayToByteString(this),
+        alphabet,
+        omitPadding
+      );
+    },
+    toHex() {
+      validateUint8Array(this, "toHex");
+
+      return native.toHex(uint8ArrayToByteString(this));
+    },
+  };
+
+  // like built-in methods, not enumerable
+  for (const [target, names] of [
+    [Uint8Array, ["fromBase64", "fromHex"]],
+    [Uint8Array.prototype, ["toBase64", "toHex"]],
+  ]) {
+    for (const name of names) {
+      Object.defineProperty(target, name, {
+        value: methods[name],
+        writable: true,
+        configurable: true,
+      });
+    }
+  }
+});
@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package base64
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"rogchap.com/v8go"
+)
+
+func TestInjectExtras(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8goContextWithExtras()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// the bytes of an array as a list of numbers
+	if _, err := ctx.RunScript("const bytes = (a) => Array.from(a).join()", "bytes.js"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	cases := [][2]string{
+		// JWT-style, unpadded base64url
+		{`String.fromCharCode(...Uint8Array.fromBase64("eyJhbGciOiJIUzI1NiJ9", { alphabet: "base64url" }))`, `{"alg":"HS256"}`},
+		{`bytes(Uint8Array.fromBase64("-_8", { alphabet: "base64url" }))`, "251,255"},
+		{`new Uint8Array([251, 255]).toBase64({ alphabet: "base64url", omitPadding: true })`, "-_8"},
+		{`new Uint8Array([251, 255]).toBase64({ alphabet: "base64url" })`, "-_8="},
+		{`new Uint8Array([251, 255]).toBase64()`, "+/8="},
+		{`new Uint8Array([251, 255]).toBase64({ omitPadding: true })`, "+/8"},
+		{`Uint8Array.fromBase64("+/8", { alphabet: "base64url" })`, "SyntaxError"},
+		{`Uint8Array.fromBase64("-_8")`, "SyntaxError"},
+		{`new Uint8Array([]).toBase64()`, ""},
+		{`bytes(Uint8Array.fromBase64(""))`, ""},
+		{`new Uint8Array([1, 2, 3, 4]).subarray(1, 3).toBase64()`, "AgM="},
+		// the last chunk
+		{`bytes(Uint8Array.fromBase64("YWJjZA"))`, "97,98,99,100"},
+		{`bytes(Uint8Array.fromBase64("YWJjZA", { lastChunkHandling: "loose" }))`, "97,98,99,100"},
+		{`bytes(Uint8Array.fromBase64("YWJjZA==", { lastChunkHandling: "strict" }))`, "97,98,99,100"},
+		{`Uint8Array.fromBase64("YWJjZA", { lastChunkHandling: "strict" })`, "SyntaxError"},
+		{`Uint8Array.fromBase64("YWJjZB==", { lastChunkHandling: "strict" })`, "SyntaxError"},
+		{`bytes(Uint8Array.fromBase64("YWJjZB=="))`, "97,98,99,100"},
+		{`bytes(Uint8Array.fromBase64("YWJjZA", { lastChunkHandling: "stop-before-partial" }))`, "97,98,99"},
+		{`bytes(Uint8Array.fromBase64("YWJjZA=", { lastChunkHandling: "stop-before-partial" }))`, "97,98,99"},
+		{`bytes(Uint8Array.fromBase64("YWJjZA==", { lastChunkHandling: "stop-before-partial" }))`, "97,98,99,100"},
+		{`Uint8Array.fromBase64("YWJjZA=")`, "SyntaxError"},
+		{`Uint8Array.fromBase64("YWJjZ")`, "SyntaxError"},
+		{`Uint8Array.fromBase64("YQ==YQ==")`, "SyntaxError"},
+		{`bytes(Uint8Array.fromBase64(" YW\n Jj ZA = = "))`, "97,98,99,100"},
+		{`Uint8Array.fromBase64("YWJj\u0000")`, "SyntaxError"},
+		// hex
+		{`new Uint8Array([0, 15, 16, 255]).toHex()`, "000f10ff"},
+		{`bytes(Uint8Array.fromHex("000f10FF"))`, "0,15,16,255"},
+		{`bytes(Uint8Array.fromHex(""))`, ""},
+		{`Uint8Array.fromHex("abc")`, "SyntaxError"},
+		{`Uint8Array.fromHex("zz")`, "SyntaxError"},
+		{`Uint8Array.fromHex("ab cd")`, "SyntaxError"},
+		// arguments
+		{`Uint8Array.fromBase64(1)`, "TypeError"},
+		{`Uint8Array.fromHex(new String("ab"))`, "TypeError"},
+		{`Uint8Array.fromBase64("", null)`, "TypeError"},
+		{`Uint8Array.fromBase64("", { alphabet: "url" })`, "TypeError"},
+		{`Uint8Array.fromBase64("", { lastChunkHandling: "lenient" })`, "TypeError"},
+		{`new Uint8Array(1).toBase64({ alphabet: new String("base64") })`, "TypeError"},
+		{`Uint8Array.prototype.toBase64.call(new Uint16Array(1))`, "TypeError"},
+		{`Uint8Array.prototype.toHex.call([1])`, "TypeError"},
+		{`[Uint8Array.fromBase64.length, Uint8Array.fromHex.length, Uint8Array.prototype.toBase64.length, Uint8Array.prototype.toHex.length].join()`, "1,1,0,0"},
+		{`Object.keys(Uint8Array).concat(Object.keys(Uint8Array.prototype)).length`, "0"},
+		{`"prototype" in Uint8Array.fromBase64`, "false"},
+		// the same codec as atob and btoa
+		{`new Uint8Array([0, 128, 255]).toBase64() === btoa("\x00\x80\xff")`, "true"},
+		{`bytes(Uint8Array.fromBase64(btoa("\x00\x80\xff")))`, "0,128,255"},
+		{`atob(new Uint8Array([104, 105]).toBase64())`, "hi"},
+	}
+
+	for i, c := range cases {
+		val, err := ctx.RunScript("try { "+c[0]+" } catch (e) { e.name }", "base64_extras.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
+
+func TestInjectExtrasLargeArray(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8goContextWithExtras()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	script := `
+		const n = 8 * 1024 * 1024;
+		const a = new Uint8Array(n);
+		for (let i = 0; i < n; i++) {
+			a[i] = (i * 31) & 0xff;
+		}
+		const encoded = a.toBase64();
+		const decoded = Uint8Array.fromBase64(encoded);
+		const hex = a.subarray(0, 1024 * 1024).toHex();
+		[
+			encoded.length,
+			decoded.length === n && decoded.every((b, i) => b === a[i]),
+			Uint8Array.fromHex(hex).length,
+		].join();
+	`
+
+	start := time.Now()
+
+	val, err := ctx.RunScript(script, "base64_extras_large.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if expected := "11184812,true,1048576"; val.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, val.String())
+	}
+
+	if d := time.Since(start); d > 30*time.Second {
+		t.Errorf("8MB took %s", d)
+	}
+}
+
+func TestInjectExtrasTwice(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8goContextWithExtras()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := InjectExtras(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := InjectExtras(nil); err == nil || !strings.Contains(err.Error(), "ctx is required") {
+		t.Errorf("expected an error for a nil ctx but got %v", err)
+	}
+
+	val, err := ctx.RunScript("new Uint8Array([1]).toBase64()", "base64_extras_twice.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if val.String() != "AQ==" {
+		t.Errorf("expected 'AQ==' but got '%s'", val.String())
+	}
+}
+
+func newV8goContextWithExtras() (*v8go.Context, error) {
+	ctx, err := newV8goContext()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := InjectExtras(ctx); err != nil {
+		return nil, err
+	}
+
+	return ctx, nil
+}
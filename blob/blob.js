@@ -0,0 +1,267 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+(function () {
+  "use strict";
+
+  // the fetch and formdata polyfills read the bytes of a blob through this,
+  // they must not be changed
+  const kBlobBytes = Symbol.for("v8go-polyfills.Blob.bytes");
+
+  // a Blob injected before, like by the fetch polyfill, is kept
+  if (
+    typeof globalThis.Blob === "function" &&
+    kBlobBytes in globalThis.Blob.prototype
+  ) {
+    return;
+  }
+
+  const kBytes = Symbol("bytes");
+  const kType = Symbol("type");
+
+  // there is no TextEncoder in v8go, lone surrogates become U+FFFD like it does
+  function utf8Encode(str) {
+    const bytes = [];
+
+    for (const ch of str) {
+      let c = ch.codePointAt(0);
+      if (c >= 0xd800 && c <= 0xdfff) {
+        c = 0xfffd;
+      }
+
+      if (c < 0x80) {
+        bytes.push(c);
+      } else if (c < 0x800) {
+        bytes.push(0xc0 | (c >> 6), 0x80 | (c & 0x3f));
+      } else if (c < 0x10000) {
+        bytes.push(
+          0xe0 | (c >> 12),
+          0x80 | ((c >> 6) & 0x3f),
+          0x80 | (c & 0x3f)
+        );
+      } else {
+        bytes.push(
+          0xf0 | (c >> 18),
+          0x80 | ((c >> 12) & 0x3f),
+          0x80 | ((c >> 6) & 0x3f),
+          0x80 | (c & 0x3f)
+        );
+      }
+    }
+
+    return new Uint8Array(bytes);
+  }
+
+  /*
+   * utf8Decode decodes like TextDecoder, a BOM is skipped and
+   * each invalid sequence becomes U+FFFD.
+   * https://encoding.spec.whatwg.org/#utf-8-decoder
+   */
+  function utf8Decode(bytes) {
+    const points = [];
+    let i =
+      bytes[0] === 0xef && bytes[1] === 0xbb && bytes[2] === 0xbf ? 3 : 0;
+
+    while (i < bytes.length) {
+      const b = bytes[i++];
+      if (b < 0x80) {
+        points.push(b);
+        continue;
+      }
+
+      let needed, c;
+      let lower = 0x80,
+        upper = 0xbf;
+
+      if (b >= 0xc2 && b <= 0xdf) {
+        needed = 1;
+        c = b & 0x1f;
+      } else if (b >= 0xe0 && b <= 0xef) {
+        lower = b === 0xe0 ? 0xa0 : lower;
+        upper = b === 0xed ? 0x9f : upper;
+        needed = 2;
+        c = b & 0x0f;
+      } else if (b >= 0xf0 && b <= 0xf4) {
+        lower = b === 0xf0 ? 0x90 : lower;
+        upper = b === 0xf4 ? 0x8f : upper;
+        needed = 3;
+        c = b & 0x07;
+      } else {
+        points.push(0xfffd);
+        continue;
+      }
+
+      for (; needed > 0; needed--) {
+        // the byte that doesn't fit starts the next sequence
+        if (i >= bytes.length || bytes[i] < lower || bytes[i] > upper) {
+          c = 0xfffd;
+          break;
+        }
+
+        c = (c << 6) | (bytes[i++] & 0x3f);
+        lower = 0x80;
+        upper = 0xbf;
+      }
+
+      points.push(c);
+    }
+
+    const chunkSize = 8192;
+
+    let str = "";
+    for (let j = 0; j < points.length; j += chunkSize) {
+      str += String.fromCodePoint.apply(null, points.slice(j, j + chunkSize));
+    }
+
+    return str;
+  }
+
+  // https://w3c.github.io/FileAPI/#dom-blob-type, a type which isn't printable ASCII is dropped
+  function normalizeType(type) {
+    if (type === undefined) {
+      return "";
+    }
+
+    type = String(type);
+    return /^[\x20-\x7e]*$/.test(type) ? type.toLowerCase() : "";
+  }
+
+  // https://w3c.github.io/FileAPI/#process-blob-parts
+  function processParts(parts) {
+    const chunks = [];
+    let size = 0;
+
+    for (const part of parts) {
+      let chunk;
+      if (part instanceof Blob) {
+        chunk = part[kBytes];
+      } else if (part instanceof ArrayBuffer) {
+        chunk = new Uint8Array(part);
+      } else if (ArrayBuffer.isView(part)) {
+        chunk = new Uint8Array(part.buffer, part.byteOffset, part.byteLength);
+      } else {
+        chunk = utf8Encode(String(part));
+      }
+
+      chunks.push(chunk);
+      size += chunk.length;
+    }
+
+    // the parts are copied, changing them later doesn't change the blob
+    const bytes = new Uint8Array(size);
+    let offset = 0;
+    for (const chunk of chunks) {
+      bytes.set(chunk, offset);
+      offset += chunk.length;
+    }
+
+    return bytes;
+  }
+
+  // relativeIndex resolves a negative index from the end, and clamps it to [0, size]
+  function relativeIndex(index, size, fallback) {
+    if (index === undefined) {
+      return fallback;
+    }
+
+    index = Math.trunc(Number(index)) || 0;
+    return index < 0 ? Math.max(size + index, 0) : Math.min(index, size);
+  }
+
+  function createBlob(bytes, type) {
+    const blob = Object.create(Blob.prototype);
+    blob[kBytes] = bytes;
+    blob[kType] = type;
+
+    return blob;
+  }
+
+  /*
+   * https://w3c.github.io/FileAPI/#blob-section
+   * The bytes are kept in memory, stream() and the endings option are not supported.
+   */
+  class Blob {
+    constructor(parts = [], options = {}) {
+      if (
+        parts === null ||
+        typeof parts !== "object" ||
+        typeof parts[Symbol.iterator] !== "function"
+      ) {
+        throw new TypeError(
+          "Failed to construct 'Blob': The provided value cannot be converted to a sequence."
+        );
+      }
+
+      if (options === null) {
+        options = {};
+      } else if (typeof options !== "object") {
+        throw new TypeError(
+          "Failed to construct 'Blob': parameter 2 is not of type 'BlobPropertyBag'."
+        );
+      }
+
+      this[kBytes] = processParts(parts);
+      this[kType] = normalizeType(options.type);
+    }
+
+    get size() {
+      return this[kBytes].length;
+    }
+
+    get type() {
+      return this[kType];
+    }
+
+    slice(start, end, contentType) {
+      const size = this[kBytes].length;
+      const from = relativeIndex(start, size, 0);
+      const to = relativeIndex(end, size, size);
+
+      return createBlob(
+        this[kBytes].slice(from, Math.max(to, from)),
+        normalizeType(contentType)
+      );
+    }
+
+    text() {
+      return Promise.resolve(utf8Decode(this[kBytes]));
+    }
+
+    arrayBuffer() {
+      return Promise.resolve(this[kBytes].slice().buffer);
+    }
+
+    get [Symbol.toStringTag]() {
+      return "Blob";
+    }
+
+    [kBlobBytes]() {
+      return this[kBytes];
+    }
+  }
+
+  Object.defineProperty(globalThis, "Blob", {
+    value: Blob,
+    writable: true,
+    configurable: true,
+  });
+})();
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package blob
+
+import (
+	"testing"
+
+	"rogchap.com/v8go"
+)
+
+func TestInject(t *testing.T) {
+	t.Parallel()
+
+	ctx := v8go.NewContext()
+
+	if err := InjectTo(ctx); err != nil {
+		t.Errorf("inject blob polyfill: %v", err)
+	}
+
+	if val, _ := ctx.RunScript("typeof Blob", ""); val.String() != "function" {
+		t.Error("inject Blob failed")
+	}
+
+	// injecting again keeps the Blob, and instanceof of the blobs made with it
+	if _, err := ctx.RunScript("globalThis.b = new Blob()", ""); err != nil {
+		t.Error(err)
+	}
+
+	if err := InjectTo(ctx); err != nil {
+		t.Errorf("inject blob polyfill again: %v", err)
+	}
+
+	if val, _ := ctx.RunScript("b instanceof Blob", ""); val.String() != "true" {
+		t.Error("the Blob was replaced")
+	}
+}
+
+func TestBlob(t *testing.T) {
+	t.Parallel()
+
+	ctx := v8go.NewContext()
+
+	if err := InjectTo(ctx); err != nil {
+		t.Errorf("inject blob polyfill: %v", err)
+		return
+	}
+
+	// bytes resolves the list of the bytes of a blob
+	if _, err := ctx.RunScript(`globalThis.bytes = (b) => b.arrayBuffer().then((buf) => [...new Uint8Array(buf)].join())`, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][2]string{
+		{`new Blob().size + "," + JSON.stringify(new Blob().type)`, `0,""`},
+		{`new Blob(["abc", "😀"]).size`, "7"},
+		{`new Blob([new Uint8Array([1, 2, 3]).buffer, new Uint16Array([1])]).size`, "5"},
+		{`new Blob([new Blob(["ab"]), "c"], { type: "Text/Plain" }).type`, "text/plain"},
+		{`JSON.stringify(new Blob([], { type: "text/plainé" }).type)`, `""`},
+		{`new Blob([1, null]).size`, "5"},
+		{`try { new Blob("abc"); "no error" } catch (e) { e instanceof TypeError }`, "true"},
+		{`new Blob(["abcdef"]).slice(1, 3).size`, "2"},
+		{`new Blob(["abcdef"], { type: "a/b" }).slice(1).type === ""`, "true"},
+		{`new Blob(["abcdef"]).slice(0, 1, "X/Y").type`, "x/y"},
+		{`Object.prototype.toString.call(new Blob())`, "[object Blob]"},
+		{`const u = new Uint8Array([1, 2]); const b = new Blob([u]); u[0] = 9; bytes(b)`, "1,2"},
+		{`bytes(new Blob(["abcdef"]).slice(-4, -1))`, "99,100,101"},
+		{`bytes(new Blob(["abcdef"]).slice(-100, 100))`, "97,98,99,100,101,102"},
+		{`bytes(new Blob(["abcdef"]).slice(4, 2))`, ""},
+		{`bytes(new Blob(["abcdef"]).slice(2, -2).slice(1))`, "100"},
+		{`new Blob(["a", new Uint8Array([0xf0, 0x9f, 0x98, 0x80])]).text()`, "a😀"},
+		{`new Blob([new Uint8Array([0xef, 0xbb, 0xbf, 0x61, 0xff])]).text()`, "a�"},
+	}
+
+	for i, c := range cases {
+		// a block scopes the declarations, and keeps the completion value
+		val, err := ctx.RunScript("{"+c[0]+"}", "")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		// the microtasks have run by the end of the script
+		if val.IsPromise() {
+			p, _ := val.AsPromise()
+			val = p.Result()
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
@@ -63,12 +63,7 @@ func NewConsole(opt ...Option) Console {
 func (c *consoleMethod) GetLogFunctionCallback() v8go.FunctionCallback {
 	return func(info *v8go.FunctionCallbackInfo) *v8go.Value {
 		if args := info.Args(); len(args) > 0 {
-			inputs := make([]interface{}, len(args))
-			for i, input := range args {
-				inputs[i] = input
-			}
-
-			fmt.Fprintln(c.Output, inputs...)
+			fmt.Fprintln(c.Output, format(info.Context(), args))
 		}
 
 		return nil
@@ -23,6 +23,7 @@
 package console
 
 import (
+	"bytes"
 	"os"
 	"testing"
 
@@ -43,3 +44,56 @@ func TestInject(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestFormat(t *testing.T) {
+	t.Parallel()
+
+	cases := [][2]string{
+		{`console.log("loaded %d items for %s", 42, "user")`, "loaded 42 items for user\n"},
+		{`console.log("no specifiers", 1, "a")`, "no specifiers 1 a\n"},
+		{`console.log("%s %s", "a")`, "a %s\n"},
+		{`console.log("%d%%", 50, "extra", 1)`, "50% extra 1\n"},
+		{`console.log("100%% %x %", 1)`, "100% %x % 1\n"},
+		{`console.log("%%")`, "%%\n"},
+		{`console.log("%d")`, "%d\n"},
+		{`console.log(1, "%d", 2)`, "1 %d 2\n"},
+		{`console.log("%d %i %d %d %d %d", 3.9, "42px", "abc", {}, -7.5, 10n)`, "3 42 NaN NaN -7 10\n"},
+		{`console.log("%d %d %i", Symbol("s"), " 12 ", null)`, "NaN 12 NaN\n"},
+		{`console.log("%f %f %f %f", "1.5e3x", ".5", "Infinity", "x")`, "1500 0.5 Infinity NaN\n"},
+		{`console.log("%s|%s|%s|%s", 1.5, null, undefined, Symbol("s"))`, "1.5|null|undefined|Symbol(s)\n"},
+		{`console.log("%o %O %o", { a: [1, "b"] }, [1, 2], "s")`, `{"a":[1,"b"]} [1,2] "s"` + "\n"},
+		{`console.log("%O %o", 1, undefined)`, "1 undefined\n"},
+		{`console.log("%j %j", { a: 1 }, undefined)`, `{"a":1} undefined` + "\n"},
+		{`console.log("%cstyled%c text", "color: red", "")`, "styled text\n"},
+		{`console.info("%s=%d", "n", 1)`, "n=1\n"},
+		{`console.warn("%s=%d", "n", 2)`, "n=2\n"},
+		{`console.error("%s=%d", "n", 3)`, "n=3\n"},
+		{`console.debug("%s=%d", "n", 4)`, "n=4\n"},
+	}
+
+	iso := v8go.NewIsolate()
+	ctx := v8go.NewContext(iso)
+
+	var out bytes.Buffer
+	var consoles []Console
+	for _, name := range []string{"log", "info", "warn", "error", "debug"} {
+		consoles = append(consoles, NewConsole(WithOutput(&out), WithMethodName(name)))
+	}
+
+	if err := InjectMultipleTo(ctx, consoles...); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range cases {
+		out.Reset()
+
+		if _, err := ctx.RunScript(c[0], "format.js"); err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if out.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], out.String())
+		}
+	}
+}
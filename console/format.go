@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package console
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"rogchap.com/v8go"
+)
+
+var (
+	intPrefix   = regexp.MustCompile(`^[+-]?\d+`)
+	floatPrefix = regexp.MustCompile(`^[+-]?(Infinity|(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?)`)
+)
+
+/*
+format prints the arguments of a console method like the formatter of the Console Standard,
+https://console.spec.whatwg.org/#formatter
+When there are arguments after a first string, its specifiers take them in order:
+%s a string, %d and %i an integer like parseInt, %f a number like parseFloat,
+%o and %O an inspection of the object, %j its JSON like in Node.js, %c a CSS style
+which is dropped, and %% a literal %. A specifier without an argument left is printed
+as is, the arguments left over are appended, separated by spaces.
+*/
+func format(ctx *v8go.Context, args []*v8go.Value) string {
+	var b strings.Builder
+
+	rest := args
+	if len(args) > 1 && args[0].IsString() {
+		rest = args[1:]
+		s := args[0].String()
+
+		for i := 0; i < len(s); i++ {
+			if s[i] != '%' || i+1 >= len(s) {
+				b.WriteByte(s[i])
+				continue
+			}
+
+			verb := s[i+1]
+			if verb == '%' {
+				b.WriteByte('%')
+				i++
+				continue
+			}
+
+			if !strings.ContainsRune("sdifoOjc", rune(verb)) || len(rest) == 0 {
+				b.WriteByte(s[i])
+				continue
+			}
+
+			b.WriteString(formatValue(ctx, verb, rest[0]))
+			rest = rest[1:]
+			i++
+		}
+	} else {
+		b.WriteString(rest[0].String())
+		rest = rest[1:]
+	}
+
+	for _, arg := range rest {
+		b.WriteByte(' ')
+		b.WriteString(arg.String())
+	}
+
+	return b.String()
+}
+
+// formatValue formats val for the specifier verb
+func formatValue(ctx *v8go.Context, verb byte, val *v8go.Value) string {
+	switch verb {
+	case 's':
+		if val.IsSymbol() {
+			return val.DetailString()
+		}
+
+		return val.String()
+	case 'd', 'i':
+		return parseNumber(ctx, val, intPrefix)
+	case 'f':
+		return parseNumber(ctx, val, floatPrefix)
+	case 'o', 'O':
+		return inspect(ctx, val)
+	case 'j':
+		if s, err := v8go.JSONStringify(ctx, val); err == nil && s != "" {
+			return s
+		}
+
+		return val.DetailString()
+	}
+
+	// %c
+	return ""
+}
+
+/*
+parseNumber is parseInt or parseFloat of val, the number at the start of the string of val
+matched by prefix. It's NaN when there's none, and for symbols, which have no string.
+*/
+func parseNumber(ctx *v8go.Context, val *v8go.Value, prefix *regexp.Regexp) string {
+	s := "NaN"
+	if !val.IsSymbol() {
+		if m := prefix.FindString(strings.TrimLeftFunc(val.String(), isWhiteSpace)); m != "" {
+			s = m
+		}
+	}
+
+	// out of range it's the infinity or 0 JS has
+	f, _ := strconv.ParseFloat(s, 64)
+
+	// the number is printed like JS does
+	num, err := v8go.NewValue(ctx.Isolate(), f)
+	if err != nil {
+		return s
+	}
+
+	return num.String()
+}
+
+// isWhiteSpace tells if r is skipped by parseInt and parseFloat, the BOM included
+func isWhiteSpace(r rune) bool {
+	return unicode.IsSpace(r) || r == '\ufeff'
+}
+
+/*
+inspect is an optimally useful formatting of val: the JSON of objects, arrays and strings,
+the detail string of the other values, and of the objects without JSON, like functions.
+*/
+func inspect(ctx *v8go.Context, val *v8go.Value) string {
+	if val.IsString() || (val.IsObject() && !val.IsFunction()) {
+		if s, err := v8go.JSONStringify(ctx, val); err == nil && s != "" {
+			return s
+		}
+	}
+
+	return val.DetailString()
+}
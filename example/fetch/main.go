@@ -23,6 +23,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
@@ -50,20 +51,15 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	done := make(chan bool, 1)
 
-	go func() {
-		for proms.State() == v8go.Pending {
-			continue
-		}
-		done <- true
-	}()
+	// settle the promises of the fetches on this goroutine
+	runCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
 
-	select {
-	case <-time.After(time.Second * 10):
+	if err := fetch.RunUntilIdle(runCtx, iso); err != nil {
 		panic(errors.New("request timeout"))
-	case <-done:
-		html := proms.Result().String()
-		fmt.Println(html)
 	}
+
+	html := proms.Result().String()
+	fmt.Println(html)
 }
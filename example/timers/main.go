@@ -23,6 +23,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -53,24 +55,17 @@ func main() {
 		panic(err)
 	}
 
-	done := make(chan bool, 1)
+	// run the timers on this goroutine
+	runCtx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
 
-	go func() {
-		for proms.State() == v8go.Pending {
-			continue
-		}
-
-		done <- proms.State() == v8go.Fulfilled
-	}()
-
-	select {
-	case succ := <-done:
-		if !succ {
-			panic("except success but not")
-		}
+	if err := timers.RunUntilIdle(runCtx, iso); err != nil {
+		panic(errors.New("timeout"))
+	}
 
-		fmt.Println(proms.Result().String())
-	case <-time.After(time.Second * 2):
-		panic("timeout")
+	if proms.State() != v8go.Fulfilled {
+		panic("except success but not")
 	}
+
+	fmt.Println(proms.Result().String())
 }
@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+
+	"github.com/weese/v8go-polyfills/fetch/internal"
+)
+
+// size of the chunks handed to a body stream reader
+const bodyChunkSize = 64 * 1024
+
+/*
+responseBody reads the body of a response either in chunks for the JS stream,
+or as a whole for text(), json() and arrayBuffer(). A cloned response gets its
+own bodyCursor on the same responseBody.
+*/
+type responseBody struct {
+	mu  sync.Mutex
+	res *internal.Response
+
+	// reader is nil once the body has been fully read
+	reader io.ReadCloser
+	// cursors not cancelled yet, read chunks are kept in res.Body
+	// as long as there is more than one. It's atomic so cancel doesn't
+	// wait for mu, which a pending read holds
+	cursors int32
+}
+
+type bodyCursor struct {
+	body *responseBody
+	// offset of the next chunk in res.Body
+	offset    int
+	cancelled int32
+}
+
+func newResponseBody(res *internal.Response) *bodyCursor {
+	b := &responseBody{
+		res:    res,
+		reader: res.BodyReader,
+	}
+
+	return b.newCursor()
+}
+
+func (b *responseBody) newCursor() *bodyCursor {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	atomic.AddInt32(&b.cursors, 1)
+
+	return &bodyCursor{body: b}
+}
+
+/*
+clone returns a cursor reading the same body from the current position.
+*/
+func (c *bodyCursor) clone() *bodyCursor {
+	clone := c.body.newCursor()
+	clone.offset = c.offset
+
+	return clone
+}
+
+/*
+read returns the next chunk of the body, or io.EOF at the end.
+*/
+func (c *bodyCursor) read() ([]byte, error) {
+	b := c.body
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c.offset < len(b.res.Body) {
+		chunk := b.res.Body[c.offset:]
+		if len(chunk) > bodyChunkSize {
+			chunk = chunk[:bodyChunkSize]
+		}
+		c.offset += len(chunk)
+
+		return chunk, nil
+	}
+
+	if b.reader == nil {
+		return nil, io.EOF
+	}
+
+	buf := make([]byte, bodyChunkSize)
+	for {
+		n, err := b.reader.Read(buf)
+		if n > 0 {
+			if atomic.LoadInt32(&b.cursors) > 1 {
+				b.res.Body = append(b.res.Body, buf[:n]...)
+				c.offset += n
+			}
+
+			// an error along with data shows up again on the next read
+			return buf[:n], nil
+		}
+
+		if err != nil {
+			b.close()
+			return nil, err
+		}
+	}
+}
+
+/*
+readAll buffers the rest of the body into res.Body.
+*/
+func (c *bodyCursor) readAll() error {
+	b := c.body
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.reader == nil {
+		return nil
+	}
+	defer b.close()
+
+	rest, err := ioutil.ReadAll(b.reader)
+	if err != nil {
+		return err
+	}
+
+	b.res.Body = append(b.res.Body, rest...)
+
+	return nil
+}
+
+/*
+cancel stops reading the body, once all the cursors are cancelled the body is
+closed and a pending read returns with an error.
+It runs on the isolate's goroutine, so it never takes mu: a pending read holds it
+until the server sends more data.
+*/
+func (c *bodyCursor) cancel() {
+	b := c.body
+
+	if !atomic.CompareAndSwapInt32(&c.cancelled, 0, 1) {
+		return
+	}
+
+	// res.BodyReader never changes, closing it makes a blocked Read return,
+	// which then clears b.reader under the lock
+	if atomic.AddInt32(&b.cursors, -1) == 0 && b.res.BodyReader != nil {
+		_ = b.res.BodyReader.Close()
+	}
+}
+
+func (b *responseBody) close() {
+	_ = b.reader.Close()
+	b.reader = nil
+}
+
+// bodyCloser calls onClose once after closing the body, releasing what the request holds
+type bodyCloser struct {
+	io.ReadCloser
+	onClose func()
+	once    sync.Once
+}
+
+func (b *bodyCloser) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.onClose)
+
+	return err
+}
@@ -0,0 +1,416 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weese/v8go-polyfills/fetch/internal"
+)
+
+/*
+Cache stores the responses of WithCache by url, it must be safe for concurrent use.
+An entry isn't changed once it's set, a revalidated response is set as a new entry.
+*/
+type Cache interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, entry *CacheEntry)
+	Delete(key string)
+}
+
+// CacheEntry is a response of a Cache, its body is decoded
+type CacheEntry struct {
+	URL        string
+	Redirected bool
+	Status     int32
+	StatusText string
+	RawStatus  string
+	Header     http.Header
+	Body       []byte
+
+	// the request headers named by the Vary header of the response
+	Vary http.Header
+
+	// when the response was received or last revalidated
+	Stored time.Time
+}
+
+func (e *CacheEntry) size() int64 {
+	n := int64(len(e.URL) + len(e.Body))
+	for name, v := range e.Header {
+		n += int64(len(name))
+		for _, s := range v {
+			n += int64(len(s))
+		}
+	}
+
+	return n
+}
+
+/*
+NewMemoryCache creates a Cache for WithCache which keeps at most maxBytes of
+responses in memory, the least recently used ones are dropped first.
+A response larger than maxBytes isn't kept.
+*/
+func NewMemoryCache(maxBytes int64) Cache {
+	return &memoryCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+type memoryCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	entries  map[string]*list.Element
+	// the most recently used entry is at the front
+	lru *list.List
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *CacheEntry
+	size  int64
+}
+
+func (c *memoryCache) Get(key string) (*CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	return el.Value.(*memoryCacheItem).entry, true
+}
+
+func (c *memoryCache) Set(key string, entry *CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+
+	size := entry.size()
+	if size > c.maxBytes {
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&memoryCacheItem{key: key, entry: entry, size: size})
+	c.size += size
+
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back().Value.(*memoryCacheItem).key)
+	}
+}
+
+func (c *memoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+}
+
+func (c *memoryCache) remove(key string) {
+	el, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	item := c.lru.Remove(el).(*memoryCacheItem)
+	delete(c.entries, key)
+	c.size -= item.size
+}
+
+// cacheControl is the directives of a Cache-Control header, by lowercase name
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := make(cacheControl)
+	for _, v := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+/*
+freshFor is how long a response stays fresh after it's received, of max-age,
+or else of Expires. Without either, it's stale right away.
+*/
+func freshFor(h http.Header) time.Duration {
+	cc := parseCacheControl(h)
+	if cc.has("no-cache") {
+		return 0
+	}
+
+	if v, ok := cc["max-age"]; ok {
+		secs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || secs <= 0 {
+			return 0
+		}
+		age, _ := strconv.ParseInt(h.Get("Age"), 10, 64)
+		return time.Duration(secs-age) * time.Second
+	}
+
+	expires, err := http.ParseTime(h.Get("Expires"))
+	if err != nil {
+		return 0
+	}
+	date, err := http.ParseTime(h.Get("Date"))
+	if err != nil {
+		date = time.Now()
+	}
+
+	return expires.Sub(date)
+}
+
+// cacheKey is the key of a request in the cache, the url without its fragment
+func cacheKey(r *internal.Request) string {
+	u := *r.URL
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String()
+}
+
+// cacheable tells if the request may be served from the cache or stored in it
+func cacheable(r *internal.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+
+	// a script which sends its own conditions wants to see the 304 itself
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "Range"} {
+		if r.Header.Get(name) != "" {
+			return false
+		}
+	}
+
+	return !parseCacheControl(r.Header).has("no-store")
+}
+
+/*
+credentialed tells if the request carries credentials, its own or the cookies of the jar
+unless it omits them. The cache is shared by the contexts, so its response is only stored
+and served if it's public.
+*/
+func (f *fetcher) credentialed(r *internal.Request) bool {
+	if r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+		return true
+	}
+
+	jar := f.CookieJar
+	if f.HTTPClient != nil {
+		jar = f.HTTPClient.Jar
+	}
+
+	return jar != nil && r.Credentials != internal.RequestCredentialsOmit && len(jar.Cookies(r.URL)) > 0
+}
+
+/*
+storable tells if the response may be stored, it may not if it's private to a user,
+or to the credentials of its request. The cache of WithCache is a shared cache.
+*/
+func storable(res *internal.Response, credentialed bool) bool {
+	cc := parseCacheControl(res.Header)
+	if res.Status != http.StatusOK || cc.has("no-store") || cc.has("private") || res.Header.Get("Vary") == "*" {
+		return false
+	}
+
+	return !credentialed || cc.has("public")
+}
+
+/*
+matches tells if the entry may answer r. Besides the credentials and the Vary headers,
+a response which followed a redirect is only served to a request which follows them too.
+*/
+func (f *fetcher) matches(e *CacheEntry, r *internal.Request, credentialed bool) bool {
+	if credentialed && !parseCacheControl(e.Header).has("public") {
+		return false
+	}
+
+	if e.Redirected && (r.Redirect != internal.RequestRedirectFollow || f.MaxRedirects == 0) {
+		return false
+	}
+
+	for name := range e.Vary {
+		if strings.Join(r.Header.Values(name), ",") != strings.Join(e.Vary.Values(name), ",") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (e *CacheEntry) response(r *internal.Request) *internal.Response {
+	header := e.Header.Clone()
+
+	return &internal.Response{
+		Header:     header,
+		Status:     e.Status,
+		StatusText: e.StatusText,
+		RawStatus:  e.RawStatus,
+		OK:         true,
+		Redirected: e.Redirected,
+		URL:        e.URL,
+		NullBody:   internal.HasNullBody(r.Method, int(e.Status)),
+		Body:       e.Body,
+		Trailer:    make(http.Header),
+	}
+}
+
+/*
+fetchRemoteCached serves a GET request from the cache of WithCache while its
+response is fresh. A stale one is revalidated with its ETag and Last-Modified,
+a 304 turns into the cached response.
+*/
+func (f *fetcher) fetchRemoteCached(ctx context.Context, r *internal.Request) (*internal.Response, error) {
+	if f.Cache == nil || !cacheable(r) {
+		return f.fetchRemoteFixture(ctx, r)
+	}
+
+	// a host blocked after the response was stored isn't served from the cache either
+	if err := f.checkHost(r.URL); err != nil {
+		return nil, err
+	}
+
+	log := loggerFrom(ctx)
+	key := cacheKey(r)
+	// before the request, by then the jar added its cookies to the headers
+	credentialed := f.credentialed(r)
+
+	entry, ok := f.Cache.Get(key)
+	if ok && !f.matches(entry, r, credentialed) {
+		entry, ok = nil, false
+	}
+
+	conditional := r
+	if ok {
+		if !parseCacheControl(r.Header).has("no-cache") && time.Since(entry.Stored) < freshFor(entry.Header) {
+			log.Debug("cache hit", "url", key)
+			return entry.response(r), nil
+		}
+
+		etag, lastModified := entry.Header.Get("ETag"), entry.Header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			c := *r
+			c.Header = r.Header.Clone()
+			if etag != "" {
+				c.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				c.Header.Set("If-Modified-Since", lastModified)
+			}
+			conditional = &c
+		}
+	}
+
+	res, err := f.fetchRemoteFixture(ctx, conditional)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && conditional != r && res.Status == http.StatusNotModified {
+		if res.BodyReader != nil {
+			res.BodyReader.Close()
+		}
+
+		// the headers of the 304 update the stored ones, like a new max-age
+		updated := *entry
+		updated.Header = entry.Header.Clone()
+		for name, v := range res.Header {
+			switch http.CanonicalHeaderKey(name) {
+			case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Set-Cookie":
+			default:
+				updated.Header[name] = v
+			}
+		}
+		updated.Stored = time.Now()
+		f.Cache.Set(key, &updated)
+
+		log.Debug("cache revalidated", "url", key)
+		return updated.response(r), nil
+	}
+
+	if !storable(res, credentialed) {
+		if ok {
+			f.Cache.Delete(key)
+		}
+		return res, nil
+	}
+
+	if res.Header.Get("ETag") == "" && res.Header.Get("Last-Modified") == "" && freshFor(res.Header) <= 0 {
+		return res, nil
+	}
+
+	if err := res.ReadBody(); err != nil {
+		return nil, err
+	}
+
+	header := res.Header.Clone()
+	// the body is stored decoded, the cookies aren't replayed to other contexts
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	header.Del("Set-Cookie")
+
+	stored := &CacheEntry{
+		URL:        res.URL,
+		Redirected: res.Redirected,
+		Status:     res.Status,
+		StatusText: res.StatusText,
+		RawStatus:  res.RawStatus,
+		Header:     header,
+		Body:       res.Body,
+		Vary:       make(http.Header),
+		Stored:     time.Now(),
+	}
+	for _, v := range res.Header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				stored.Vary[http.CanonicalHeaderKey(name)] = r.Header.Values(name)
+			}
+		}
+	}
+
+	f.Cache.Set(key, stored)
+	return res, nil
+}
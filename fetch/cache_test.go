@@ -0,0 +1,435 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFetchCache(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var requests []string
+
+	// each request is logged with its conditions, only the body of a 200 tells the count
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, fmt.Sprintf("%s %s %s|%s", r.Method, r.URL.Path, r.Header.Get("If-None-Match"), r.Header.Get("If-Modified-Since")))
+		n := len(requests)
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/etag":
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Cache-Control", "no-cache")
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/last-modified":
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			if r.Header.Get("If-Modified-Since") != "" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/no-store":
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Cache-Control", "no-store")
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+		}
+
+		_, _ = fmt.Fprintf(w, "body %d", n)
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		Name     string
+		Script   string
+		Expected string
+		Requests []string
+	}{
+		{
+			Name:     "etag",
+			Script:   "fetch('%[1]s/etag').then(res => res.text()).then(() => fetch('%[1]s/etag'))",
+			Expected: "200,body 1",
+			Requests: []string{"GET /etag |", `GET /etag "v1"|`},
+		},
+		{
+			Name:     "last modified",
+			Script:   "fetch('%[1]s/last-modified').then(res => res.text()).then(() => fetch('%[1]s/last-modified'))",
+			Expected: "200,body 1",
+			Requests: []string{"GET /last-modified |", "GET /last-modified |Mon, 02 Jan 2006 15:04:05 GMT"},
+		},
+		{
+			Name:     "fresh",
+			Script:   "fetch('%[1]s/fresh').then(res => res.text()).then(() => fetch('%[1]s/fresh'))",
+			Expected: "200,body 1",
+			Requests: []string{"GET /fresh |"},
+		},
+		{
+			Name:     "no-store response",
+			Script:   "fetch('%[1]s/no-store').then(res => res.text()).then(() => fetch('%[1]s/no-store'))",
+			Expected: "200,body 2",
+			Requests: []string{"GET /no-store |", "GET /no-store |"},
+		},
+		{
+			Name:     "no-store request",
+			Script:   "fetch('%[1]s/fresh').then(res => res.text()).then(() => fetch('%[1]s/fresh', {headers: {'Cache-Control': 'no-store'}}))",
+			Expected: "200,body 2",
+			Requests: []string{"GET /fresh |", "GET /fresh |"},
+		},
+		{
+			Name:     "post",
+			Script:   "fetch('%[1]s/fresh', {method: 'POST'}).then(res => res.text()).then(() => fetch('%[1]s/fresh', {method: 'POST'}))",
+			Expected: "200,body 2",
+			Requests: []string{"POST /fresh |", "POST /fresh |"},
+		},
+		{
+			Name:     "vary",
+			Script:   "fetch('%[1]s/vary', {headers: {'Accept-Language': 'en'}}).then(res => res.text()).then(() => fetch('%[1]s/vary', {headers: {'Accept-Language': 'de'}}))",
+			Expected: "200,body 2",
+			Requests: []string{"GET /vary |", "GET /vary |"},
+		},
+		{
+			Name:     "own conditions",
+			Script:   `fetch('%[1]s/etag').then(res => res.text()).then(() => fetch('%[1]s/etag', {headers: {'If-None-Match': '"v1"'}}))`,
+			Expected: "304,",
+			Requests: []string{"GET /etag |", `GET /etag "v1"|`},
+		},
+	} {
+		mu.Lock()
+		requests = nil
+		mu.Unlock()
+
+		ctx, err := newV8ContextWithFetch(WithCache(NewMemoryCache(1 << 20)))
+		if err != nil {
+			t.Fatalf("create v8: %s", err)
+		}
+
+		script := fmt.Sprintf(tc.Script, srv.URL) + ".then(async res => [res.status, await res.text()].join())"
+		val, err := ctx.RunScript(script, "fetch_cache.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %s", tc.Name, err)
+			continue
+		}
+
+		if res.String() != tc.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", tc.Name, tc.Expected, res.String())
+		}
+
+		mu.Lock()
+		if strings.Join(requests, "\n") != strings.Join(tc.Requests, "\n") {
+			t.Errorf("%s: expected the requests %q but got %q", tc.Name, tc.Requests, requests)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestFetchCacheCredentials(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var requests []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path+" "+r.Header.Get("Authorization")+r.Header.Get("Cookie"))
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/user", "/cookie":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/public":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		}
+		w.Header().Set("Set-Cookie", "session=1")
+
+		_, _ = fmt.Fprintf(w, "%s%s", r.Header.Get("Authorization"), r.Header.Get("Cookie"))
+	}))
+	defer srv.Close()
+
+	// the contexts share the cache, each has its own credentials
+	cache := NewMemoryCache(1 << 20)
+
+	for _, tc := range []struct {
+		Name     string
+		Path     string
+		Headers  [2]string
+		Expected [2]string
+		Requests []string
+	}{
+		{
+			Name:     "authorization",
+			Path:     "/user",
+			Headers:  [2]string{"{Authorization: 'a'}", "{Authorization: 'b'}"},
+			Expected: [2]string{"a,1", "b,1"},
+			Requests: []string{"/user a", "/user b"},
+		},
+		{
+			Name:     "cookie",
+			Path:     "/cookie",
+			Headers:  [2]string{"{Cookie: 'id=a'}", "{Cookie: 'id=b'}"},
+			Expected: [2]string{"id=a,1", "id=b,1"},
+			Requests: []string{"/cookie id=a", "/cookie id=b"},
+		},
+		{
+			Name:     "public",
+			Path:     "/public",
+			Headers:  [2]string{"{Authorization: 'a'}", "{Authorization: 'b'}"},
+			Expected: [2]string{"a,1", "a,0"},
+			Requests: []string{"/public a"},
+		},
+		{
+			Name:     "private",
+			Path:     "/private",
+			Headers:  [2]string{"{}", "{}"},
+			Expected: [2]string{",1", ",1"},
+			Requests: []string{"/private ", "/private "},
+		},
+		{
+			// stored without the cookie
+			Name:     "set-cookie",
+			Path:     "/cookie",
+			Headers:  [2]string{"{}", "{}"},
+			Expected: [2]string{",1", ",0"},
+			Requests: []string{"/cookie "},
+		},
+	} {
+		mu.Lock()
+		requests = nil
+		mu.Unlock()
+
+		for i, headers := range tc.Headers {
+			ctx, err := newV8ContextWithFetch(WithCache(cache), WithExposeSetCookie())
+			if err != nil {
+				t.Fatalf("create v8: %s", err)
+			}
+
+			script := fmt.Sprintf("fetch('%s%s', {headers: %s}).then(async res => [await res.text(), res.headers.getSetCookie().length].join())", srv.URL, tc.Path, headers)
+			val, err := ctx.RunScript(script, "fetch_cache_credentials.js")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := waitForPromise(ctx, val)
+			if err != nil {
+				t.Errorf("%s: context %d: %s", tc.Name, i, err)
+				continue
+			}
+
+			if res.String() != tc.Expected[i] {
+				t.Errorf("%s: context %d: expected '%s' but got '%s'", tc.Name, i, tc.Expected[i], res.String())
+			}
+		}
+
+		mu.Lock()
+		if strings.Join(requests, "\n") != strings.Join(tc.Requests, "\n") {
+			t.Errorf("%s: expected the requests %q but got %q", tc.Name, tc.Requests, requests)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestFetchCacheRequestModes(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var requests []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path+" "+r.Header.Get("Cookie"))
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/target", http.StatusFound)
+			return
+		case "/login":
+			w.Header().Set("Set-Cookie", "id=1")
+			return
+		}
+
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = fmt.Fprint(w, r.Header.Get("Cookie"))
+	}))
+	defer srv.Close()
+
+	// the first context stores the response, the second one asks for it in its own way
+	for _, tc := range []struct {
+		Name     string
+		Store    string
+		Script   string
+		Options  []Option
+		Expected string
+		Requests []string
+	}{
+		{
+			Name:     "redirect follow",
+			Store:    "fetch('%[1]s/old')",
+			Script:   "fetch('%[1]s/old').then(res => res.redirected)",
+			Expected: "true",
+			Requests: []string{"/old ", "/target "},
+		},
+		{
+			Name:     "redirect error",
+			Store:    "fetch('%[1]s/old')",
+			Script:   "fetch('%[1]s/old', {redirect: 'error'}).catch(e => e.name)",
+			Expected: "TypeError",
+			Requests: []string{"/old ", "/target ", "/old "},
+		},
+		{
+			Name:     "redirect manual",
+			Store:    "fetch('%[1]s/old')",
+			Script:   "fetch('%[1]s/old', {redirect: 'manual'}).then(res => res.status)",
+			Expected: "302",
+			Requests: []string{"/old ", "/target ", "/old "},
+		},
+		{
+			Name:     "blocked host",
+			Store:    "fetch('%[1]s/target')",
+			Script:   "fetch('%[1]s/target').catch(e => e.name)",
+			Options:  []Option{WithBlockedHosts("127.0.0.1")},
+			Expected: "TypeError",
+			Requests: []string{"/target "},
+		},
+		{
+			Name:     "jar cookies",
+			Store:    "fetch('%[1]s/login').then(() => fetch('%[1]s/target'))",
+			Script:   "fetch('%[1]s/target').then(res => res.text())",
+			Expected: "id=1",
+			Requests: []string{"/login ", "/target id=1", "/target id=1"},
+		},
+		{
+			Name:     "credentials omit",
+			Store:    "fetch('%[1]s/login').then(() => fetch('%[1]s/target'))",
+			Script:   "fetch('%[1]s/target', {credentials: 'omit'}).then(res => res.text())",
+			Expected: "",
+			Requests: []string{"/login ", "/target id=1", "/target "},
+		},
+	} {
+		mu.Lock()
+		requests = nil
+		mu.Unlock()
+
+		cache, jar := NewMemoryCache(1<<20), NewCookieJar()
+
+		for i, script := range []string{tc.Store + ".then(res => res.text())", tc.Script} {
+			opts := []Option{WithCache(cache), WithCookieJar(jar)}
+			if i == 1 {
+				opts = append(opts, tc.Options...)
+			}
+
+			ctx, err := newV8ContextWithFetch(opts...)
+			if err != nil {
+				t.Fatalf("create v8: %s", err)
+			}
+
+			val, err := ctx.RunScript(fmt.Sprintf(script, srv.URL), "fetch_cache_request_modes.js")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := waitForPromise(ctx, val)
+			if err != nil {
+				t.Errorf("%s: context %d: %s", tc.Name, i, err)
+				continue
+			}
+
+			if i == 1 && res.String() != tc.Expected {
+				t.Errorf("%s: expected '%s' but got '%s'", tc.Name, tc.Expected, res.String())
+			}
+		}
+
+		mu.Lock()
+		if strings.Join(requests, "\n") != strings.Join(tc.Requests, "\n") {
+			t.Errorf("%s: expected the requests %q but got %q", tc.Name, tc.Requests, requests)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	t.Parallel()
+
+	cache := NewMemoryCache(100)
+	entry := func(body string) *CacheEntry {
+		return &CacheEntry{Body: []byte(body), Header: http.Header{}, Stored: time.Now()}
+	}
+
+	cache.Set("a", entry(strings.Repeat("a", 40)))
+	cache.Set("b", entry(strings.Repeat("b", 40)))
+
+	// a is used more recently, so b goes
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("expected a in the cache")
+	}
+	cache.Set("c", entry(strings.Repeat("c", 40)))
+
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := cache.Get(key); ok != expected {
+			t.Errorf("%s: expected %v but got %v", key, expected, ok)
+		}
+	}
+
+	cache.Set("d", entry(strings.Repeat("d", 101)))
+	if _, ok := cache.Get("d"); ok {
+		t.Error("expected an entry larger than the cache to be dropped")
+	}
+
+	cache.Delete("a")
+	if _, ok := cache.Get("a"); ok {
+		t.Error("expected a to be deleted")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprint(i % 3)
+			cache.Set(key, entry("x"))
+			cache.Get(key)
+			cache.Delete(key)
+		}(i)
+	}
+	wg.Wait()
+}
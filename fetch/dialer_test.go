@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r[host]
+	if !ok {
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+
+	addrs := make([]net.IPAddr, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.IPAddr{IP: net.ParseIP(ip)}
+	}
+
+	return addrs, nil
+}
+
+func TestPrivateIPDialer(t *testing.T) {
+	t.Parallel()
+
+	errDialed := errors.New("dialed")
+
+	var dialed []string
+	d := &privateIPDialer{
+		resolver: fakeResolver{
+			"public.example.com":  {"93.184.216.34"},
+			"rebind.example.com":  {"10.0.0.5"},
+			"mixed.example.com":   {"192.168.1.1", "2606:2800:220:1:248:1893:25c8:1946"},
+			"private.example.com": {"fd00::1", "127.0.0.1"},
+		},
+		dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed = append(dialed, address)
+			return nil, errDialed
+		},
+	}
+
+	cases := []struct {
+		Address string
+		Dialed  string
+	}{
+		{"93.184.216.34:80", "93.184.216.34:80"},
+		{"public.example.com:443", "93.184.216.34:443"},
+		{"mixed.example.com:80", "[2606:2800:220:1:248:1893:25c8:1946]:80"},
+		{"[2001:4860:4860::8888]:53", "[2001:4860:4860::8888]:53"},
+		{"100.64.0.1:80", "100.64.0.1:80"},
+		{"127.0.0.1:80", ""},
+		{"127.255.0.1:80", ""},
+		{"10.1.2.3:80", ""},
+		{"172.16.0.1:80", ""},
+		{"172.31.255.255:80", ""},
+		{"192.168.0.1:80", ""},
+		{"169.254.169.254:80", ""},
+		{"0.0.0.0:80", ""},
+		{"[::1]:80", ""},
+		{"[::]:80", ""},
+		{"[fe80::1]:80", ""},
+		{"[fc00::1]:80", ""},
+		{"[fd12:3456::1]:80", ""},
+		{"[::ffff:10.0.0.1]:80", ""},
+		{"rebind.example.com:80", ""},
+		{"private.example.com:80", ""},
+	}
+
+	for _, c := range cases {
+		dialed = nil
+		_, err := d.DialContext(context.Background(), "tcp", c.Address)
+
+		if c.Dialed == "" {
+			var tErr *typeError
+			if !errors.As(err, &tErr) || !strings.Contains(err.Error(), "is blocked") {
+				t.Errorf("%s: expected to be blocked, but got %v", c.Address, err)
+			}
+
+			if len(dialed) > 0 {
+				t.Errorf("%s: should not dial, but dialed %v", c.Address, dialed)
+			}
+			continue
+		}
+
+		if !errors.Is(err, errDialed) || len(dialed) != 1 || dialed[0] != c.Dialed {
+			t.Errorf("%s: expected to dial %s, but dialed %v, %v", c.Address, c.Dialed, dialed, err)
+		}
+	}
+}
+
+func TestFetchBlockPrivateIPs(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	ctx, err := newV8ContextWithFetch(WithBlockPrivateIPs())
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(() => 'resolved', e => e instanceof TypeError ? e.message : String(e))`,
+		strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)), "fetch_block_private_ips.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !strings.Contains(res.String(), "of localhost is blocked") {
+		t.Errorf("expected the request to be blocked, but got '%s'", res.String())
+	}
+}
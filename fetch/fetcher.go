@@ -23,14 +23,22 @@
 package fetch
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/weese/v8go-polyfills/fetch/internal"
@@ -42,8 +50,15 @@ import (
 const (
 	UserAgentLocal = "<local>"
 	AddrLocal      = "0.0.0.0:0"
+
+	DefaultMaxRedirects = 10
+
+	// the Accept-Encoding header sent without WithAcceptEncodings
+	DefaultAcceptEncoding = "gzip, deflate, br, zstd"
 )
 
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
 var defaultLocalHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
 })
@@ -56,32 +71,237 @@ var defaultUserAgentProvider = UserAgentProviderFunc(func(u *url.URL) string {
 	return UserAgent()
 })
 
+/*
+Fetcher is safe for concurrent use once NewFetcher returns, its settings
+don't change after that. The callbacks can be used by any number of contexts
+and isolates together, the state of a fetch lives in its call and its promise
+is settled in the context which called it. The hooks, the progress functions,
+the Cache, the CookieJar and the Logger of the options are called concurrently.
+*/
 type Fetcher interface {
 	GetLocalHandler() http.Handler
 
 	GetFetchFunctionCallback() v8go.FunctionCallback
+
+	GetHeadersFunctionCallback() v8go.FunctionCallback
+
+	GetRequestFunctionCallback() v8go.FunctionCallback
+
+	GetResponseFunctionCallback() v8go.FunctionCallback
+
+	GetResponseStaticCallback(name string) v8go.FunctionCallback
+
+	CloseIdleConnections()
+
+	ClearContext(ctx *v8go.Context)
+
+	Close() error
+
+	Shutdown(ctx context.Context) error
+
+	Fetch(ctx context.Context, url string, init RequestInit) (*Response, error)
 }
 
+/*
+RequestInit is the init of Fetcher.Fetch, like the one of a script's fetch.
+A body is text unless its BodyEncoding is "bytes", then it's a byte string,
+a string with one byte per char.
+*/
+type RequestInit = internal.RequestInit
+
+// HeadersInit is the headers of a RequestInit as [name, value] pairs, repeated names are kept
+type HeadersInit = internal.HeadersInit
+
+/*
+Response is the response of Fetcher.Fetch, with the body decoded. Its Body
+is buffered, or streamed by its BodyReader, which ReadBody reads into Body.
+*/
+type Response = internal.Response
+
 type fetcher struct {
 	// Use local handler to handle the relative path (starts with "/") request
 	LocalHandler http.Handler
 
+	// the handlers of WithLocalHandlerFor, by lowercase host with an optional port
+	LocalHandlers map[string]http.Handler
+
+	// relative urls are resolved against it instead of being local requests
+	BaseURL *url.URL
+
 	UserAgentProvider UserAgentProvider
 	AddrLocal         string
+
+	// the headers of WithDefaultHeaders, by canonical name
+	DefaultHeaders http.Header
+
+	// the Accept-Encoding header of WithAcceptEncodings
+	AcceptEncoding string
+
+	MaxRedirects int
+
+	CookieJar http.CookieJar
+
+	MaxBodySize int64
+
+	StrictContentEncoding bool
+
+	ExposeSetCookie bool
+
+	DefaultTimeout time.Duration
+
+	AllowedHosts hostPatterns
+	BlockedHosts hostPatterns
+
+	// serves the file: urls, they are unsupported without it
+	FileSystem     fs.FS
+	FileSystemRoot string
+
+	HTTPClient *http.Client
+
+	// nothing is logged without it
+	Logger *slog.Logger
+
+	HAR *HARRecorder
+
+	// the settings of WithRecording, WithReplay and WithFixtureIgnoreHeaders
+	RecordDir            string
+	ReplayDir            string
+	FixtureIgnoreHeaders []string
+
+	RequestHooks  []func(*http.Request) error
+	ResponseHooks []func(*http.Request, *http.Response, time.Duration)
+
+	// the responses of WithCache, nothing is cached without it
+	Cache Cache
+
+	// observes the traffic of the fetches, see WithMetrics
+	Metrics Metrics
+
+	UploadProgress   func(url string, sent, total int64)
+	DownloadProgress func(url string, received, total int64)
+
+	// the settings of WithExpectContinue and WithEarlyHints
+	ExpectContinue int64
+	EarlyHints     func(url string, header http.Header)
+
+	// the settings of WithRetry and WithRetryMethods
+	RetryMax     int
+	RetryBackoff func(attempt int) time.Duration
+	RetryMethods []string
+
+	// the settings of the transport, built by NewFetcher
+	Proxy              func(*http.Request) (*url.URL, error)
+	TLSConfig          *tls.Config
+	InsecureSkipVerify bool
+	BlockPrivateIPs    bool
+	Transport          http.RoundTripper
+
+	// the addresses of WithResolveOverride, by lowercase host with an optional port
+	ResolveOverrides map[string]string
+
+	// the socket paths of WithUnixSocket, by lowercase host with an optional port
+	UnixSockets map[string]string
+
+	// the protocols of WithForceHTTP1 and WithHTTP2PriorKnowledge
+	ForceHTTP1          bool
+	HTTP2PriorKnowledge bool
+
+	// the connection pool settings of the transport, nil keeps the one of http.DefaultTransport
+	MaxIdleConns        *int
+	MaxIdleConnsPerHost *int
+	MaxConnsPerHost     *int
+	IdleConnTimeout     *time.Duration
+
+	// the settings of WithMaxConcurrent and WithQueueLimit, built by NewFetcher
+	MaxConcurrent int
+	QueueLimit    int
+	Limiter       *limiter
+
+	// the settings of WithRateLimit and WithRateLimitMaxDelay, built by NewFetcher
+	RateLimits        map[string]rateLimit
+	RateLimitMaxDelay time.Duration
+	RateLimiter       *rateLimiter
+
+	// the goroutines of the fetches and body reads, Close cancels closeCtx and waits for them
+	mu       sync.Mutex
+	closed   bool
+	drop     atomic.Bool
+	inflight sync.WaitGroup
+	closeCtx context.Context
+	closeAll context.CancelFunc
+
+	// the exports of the JS side by context, until ClearContext drops them
+	exports map[*v8go.Context]*v8go.Object
+
+	// the first invalid option, returned by NewFetcher
+	err error
 }
 
-func NewFetcher(opt ...Option) Fetcher {
+/*
+NewFetcher creates a fetcher with the options, it fails if they conflict,
+like two local handlers for the same host.
+*/
+func NewFetcher(opt ...Option) (Fetcher, error) {
 	ft := &fetcher{
 		LocalHandler:      defaultLocalHandler,
 		UserAgentProvider: defaultUserAgentProvider,
 		AddrLocal:         AddrLocal,
+		MaxRedirects:      DefaultMaxRedirects,
+		QueueLimit:        -1,
+		AcceptEncoding:    DefaultAcceptEncoding,
+		RateLimitMaxDelay: DefaultRateLimitMaxDelay,
 	}
+	ft.closeCtx, ft.closeAll = context.WithCancel(context.Background())
 
 	for _, o := range opt {
 		o.apply(ft)
 	}
 
-	return ft
+	if ft.RecordDir != "" && ft.ReplayDir != "" {
+		ft.fail(errors.New("v8go-polyfills/fetch: WithRecording and WithReplay can't be used together"))
+	}
+
+	if ft.ForceHTTP1 && ft.HTTP2PriorKnowledge {
+		ft.fail(errors.New("v8go-polyfills/fetch: WithForceHTTP1 and WithHTTP2PriorKnowledge can't be used together"))
+	}
+
+	if ft.err != nil {
+		return nil, ft.err
+	}
+
+	ft.Transport = ft.newTransport()
+
+	if ft.MaxConcurrent > 0 {
+		ft.Limiter = newLimiter(ft.MaxConcurrent, ft.QueueLimit)
+	}
+
+	if len(ft.RateLimits) > 0 {
+		ft.RateLimiter = newRateLimiter(ft.RateLimits, ft.RateLimitMaxDelay)
+	}
+
+	return ft, nil
+}
+
+// fail keeps the first error of the options for NewFetcher
+func (f *fetcher) fail(err error) {
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+/*
+CloseIdleConnections closes the idle connections of the remote requests, like when
+the contexts of the fetcher are disposed. http.DefaultTransport, used without the
+transport options, and the client of WithHTTPClient are shared, they are left alone.
+*/
+func (f *fetcher) CloseIdleConnections() {
+	if f.HTTPClient != nil || f.Transport == http.DefaultTransport {
+		return
+	}
+
+	if t, ok := f.Transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
 }
 
 func (f *fetcher) GetLocalHandler() http.Handler {
@@ -89,82 +309,378 @@ func (f *fetcher) GetLocalHandler() http.Handler {
 }
 
 func (f *fetcher) GetFetchFunctionCallback() v8go.FunctionCallback {
+	return f.polyfillFunctionCallback("fetch")
+}
+
+func (f *fetcher) GetHeadersFunctionCallback() v8go.FunctionCallback {
+	return f.polyfillConstructorCallback("Headers")
+}
+
+func (f *fetcher) GetRequestFunctionCallback() v8go.FunctionCallback {
+	return f.polyfillConstructorCallback("Request")
+}
+
+func (f *fetcher) GetResponseFunctionCallback() v8go.FunctionCallback {
+	return f.polyfillConstructorCallback("Response")
+}
+
+// GetResponseStaticCallback returns the static method name of Response, like "json"
+func (f *fetcher) GetResponseStaticCallback(name string) v8go.FunctionCallback {
+	return f.polyfillStaticCallback("Response", name)
+}
+
+/*
+fetchFunctionCallback starts a request, it returns an object holding
+the response promise, an abort function cancelling the request,
+and timedOut telling if the timeout of the request cancelled it.
+*/
+func (f *fetcher) fetchFunctionCallback() v8go.FunctionCallback {
 	return func(info *v8go.FunctionCallbackInfo) *v8go.Value {
 		ctx := info.Context()
+		iso := ctx.Isolate()
 		args := info.Args()
 
 		resolver, _ := v8go.NewPromiseResolver(ctx)
 
-		go func() {
-			if len(args) <= 0 {
-				err := errors.New("1 argument required, but only 0 present")
-				resolver.Reject(newErrorValue(ctx, err))
-				return
+		reqCtx, cancel := context.WithCancel(f.closeCtx)
+
+		c := f.newFetchCall()
+
+		abortFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+			cancel()
+			return nil
+		})
+
+		timedOutFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+			val, _ := v8go.NewValue(iso, c.hasTimedOut())
+			return val
+		})
+
+		callTmp := v8go.NewObjectTemplate(iso)
+		for _, fn := range []struct {
+			Name string
+			Tmp  interface{}
+		}{
+			{Name: "abort", Tmp: abortFnTmp},
+			{Name: "timedOut", Tmp: timedOutFnTmp},
+		} {
+			if err := callTmp.Set(fn.Name, fn.Tmp, v8go.ReadOnly); err != nil {
+				cancel()
+				return throwError(ctx, err)
 			}
+		}
+
+		call, err := callTmp.NewInstance(ctx)
+		if err != nil {
+			cancel()
+			return throwError(ctx, err)
+		}
+
+		if err := call.Set("response", resolver.GetPromise()); err != nil {
+			cancel()
+			return throwError(ctx, err)
+		}
 
-			var reqInit internal.RequestInit
+		if !f.begin() {
+			cancel()
+			resolver.Reject(newErrorValue(ctx, errFetcherClosed))
+			return call.Value
+		}
+
+		// the arguments are read here, only this goroutine may use the isolate
+		var reqURL, initJSON string
+		var argsErr error
+		if len(args) <= 0 {
+			argsErr = errors.New("1 argument required, but only 0 present")
+		} else {
+			reqURL = args[0].String()
 			if len(args) > 1 {
-				str, err := v8go.JSONStringify(ctx, args[1])
-				if err != nil {
+				initJSON, argsErr = v8go.JSONStringify(ctx, args[1])
+			}
+		}
+
+		// the promise is settled by a task on the goroutine of the isolate
+		post := BeginTask(iso)
+
+		go func() {
+			defer f.end()
+
+			reject := func(err error) {
+				f.settle(post, func() {
 					resolver.Reject(newErrorValue(ctx, err))
-					return
-				}
+				})
+			}
+
+			if argsErr != nil {
+				f.fetchFailed(reqCtx, c, argsErr)
+				reject(argsErr)
+				return
+			}
 
-				reader := strings.NewReader(str)
+			var reqInit RequestInit
+			if initJSON != "" {
+				reader := strings.NewReader(initJSON)
 				if err := json.NewDecoder(reader).Decode(&reqInit); err != nil {
-					resolver.Reject(newErrorValue(ctx, err))
+					f.fetchFailed(reqCtx, c, err)
+					reject(err)
 					return
 				}
 			}
 
-			r, err := f.initRequest(args[0].String(), reqInit)
+			res, err := f.fetch(reqCtx, c, reqURL, reqInit)
 			if err != nil {
-				resolver.Reject(newErrorValue(ctx, err))
+				reject(err)
 				return
 			}
 
-			var res *internal.Response
-
-			// do local request
-			if !r.URL.IsAbs() {
-				res, err = f.fetchLocal(r)
-			} else {
-				res, err = f.fetchRemote(r)
-			}
-			if err != nil {
-				resolver.Reject(newErrorValue(ctx, err))
-				return
+			closeBody := func() {
+				if res.BodyReader != nil {
+					res.BodyReader.Close()
+				}
 			}
 
-			resObj, err := newResponseObject(ctx, res)
-			if err != nil {
-				resolver.Reject(newErrorValue(ctx, err))
+			// the context may be disposed once the fetcher is closed
+			if f.dropped() {
+				closeBody()
+				post(nil)
 				return
 			}
 
-			resolver.Resolve(resObj)
+			post(func() {
+				if f.dropped() {
+					closeBody()
+					return
+				}
+
+				resObj, err := f.newResponseObject(ctx, res)
+				if err != nil {
+					closeBody()
+					resolver.Reject(newErrorValue(ctx, err))
+					return
+				}
+
+				resolver.Resolve(resObj)
+			})
 		}()
 
-		return resolver.GetPromise().Value
+		return call.Value
+	}
+}
+
+/*
+Fetch fetches url like a script does, with the same routing, checks, cookies and
+decoding, but without an isolate. Cancelling ctx aborts the request and the read
+of the body. Unless it's buffered in Body, the body is streamed by BodyReader,
+which must be closed, it holds a slot of WithMaxConcurrent and the default timeout.
+*/
+func (f *fetcher) Fetch(ctx context.Context, url string, init RequestInit) (*Response, error) {
+	c := f.newFetchCall()
+
+	if !f.begin() {
+		f.fetchFailed(ctx, c, errFetcherClosed)
+		return nil, errFetcherClosed
+	}
+	defer f.end()
+
+	return f.fetch(ctx, c, url, init)
+}
+
+// fetchCall is the state of a fetch of a script or of Fetch
+type fetchCall struct {
+	log       *slog.Logger
+	requestID string
+	start     time.Time
+
+	// set once the timeout of the init cancelled the request
+	timedOut int32
+
+	// the fetch doesn't go to the network, and the bytes received
+	// before decoding, for Metrics
+	local bool
+	wire  atomic.Int64
+}
+
+func (f *fetcher) newFetchCall() *fetchCall {
+	c := &fetchCall{log: discardLogger, start: time.Now()}
+
+	if f.Logger != nil {
+		c.requestID = newRequestID()
+		c.log = f.Logger.With("request_id", c.requestID)
+	}
+
+	return c
+}
+
+func (c *fetchCall) hasTimedOut() bool {
+	return atomic.LoadInt32(&c.timedOut) == 1
+}
+
+// fetchFailed logs and observes the error of c, ctx is the one c was started with
+func (f *fetcher) fetchFailed(ctx context.Context, c *fetchCall, err error) {
+	c.log.Error("fetch failed", "error", err, "duration", time.Since(c.start))
+
+	if f.Metrics != nil {
+		f.Metrics.ObserveError(f.errorKind(ctx, err, c.hasTimedOut()), c.local)
 	}
 }
 
-func (f *fetcher) initRequest(reqUrl string, reqInit internal.RequestInit) (*internal.Request, error) {
+/*
+fetch runs c from the request to the response, the pipeline shared by the
+scripts and Fetch. What the request holds is released once the body is closed.
+*/
+func (f *fetcher) fetch(ctx context.Context, c *fetchCall, rawURL string, reqInit RequestInit) (*Response, error) {
+	res, err := f.runFetch(ctx, c, rawURL, reqInit)
+	if err != nil {
+		f.fetchFailed(ctx, c, err)
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (f *fetcher) runFetch(ctx context.Context, c *fetchCall, rawURL string, reqInit RequestInit) (*Response, error) {
+	log := c.log
+
+	r, err := f.initRequest(rawURL, reqInit, log)
+	if err != nil {
+		return nil, err
+	}
+
+	route := f.route(r.URL)
+	log.Info("fetch started", "method", r.Method, "url", internal.ResponseURL(r.URL), "route", route)
+
+	c.local = route != routeRemote
+	if f.Metrics != nil {
+		f.Metrics.ObserveRequest(r.Method, r.URL.Hostname(), c.local)
+	}
+
+	// the request and the body end with the fetcher too, Close cancels them
+	reqCtx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(f.closeCtx, cancel)
+
+	// the dialer logs with the request id of the fetch
+	reqCtx = contextWithLogger(reqCtx, log)
+
+	if f.Metrics != nil {
+		reqCtx = contextWithWireCount(reqCtx, &c.wire)
+	}
+
+	// the default timeout also covers reading the body,
+	// the deadline is released once the body is closed
+	cancelTimeout := context.CancelFunc(func() {})
+	if f.DefaultTimeout > 0 {
+		reqCtx, cancelTimeout = context.WithTimeout(reqCtx, f.DefaultTimeout)
+	}
+
+	// the timer only runs until the response is there
+	if r.Timeout > 0 {
+		timer := time.AfterFunc(r.Timeout, func() {
+			atomic.StoreInt32(&c.timedOut, 1)
+			cancel()
+		})
+		defer timer.Stop()
+	}
+
+	// the slot of WithMaxConcurrent is only held until the response headers are in,
+	// an unread body must not block the next fetch
+	release, err := f.acquire(reqCtx)
+	if err != nil {
+		cancelTimeout()
+		stop()
+		cancel()
+		return nil, err
+	}
+
+	done := func() {
+		cancelTimeout()
+		stop()
+		cancel()
+	}
+
+	var res *Response
+
+	switch route {
+	case routeLocal:
+		res, err = f.fetchLocal(reqCtx, r, f.LocalHandler)
+	case routeData:
+		res, err = fetchData(r)
+	case routeFile:
+		res, err = f.fetchFile(r)
+	case routeLocalHost:
+		res, err = f.fetchLocal(reqCtx, r, f.localHandlerFor(r.URL))
+	default:
+		res, err = f.fetchRemoteCached(reqCtx, r)
+	}
+	release()
+	if err != nil {
+		done()
+		return nil, err
+	}
+
+	finished := func(n int64) {
+		log.Info("fetch finished", "status", res.Status, "bytes", n, "duration", time.Since(c.start),
+			"encodings", res.Header.Get("Content-Encoding"))
+		if f.Metrics != nil {
+			f.Metrics.ObserveResponse(int(res.Status), n, c.wire.Load(), time.Since(c.start), c.local)
+		}
+	}
+
+	if res.BodyReader != nil {
+		body := &countingReader{ReadCloser: res.BodyReader}
+		res.BodyReader = &bodyCloser{ReadCloser: body, onClose: func() {
+			done()
+			finished(body.n)
+		}}
+	} else {
+		done()
+		finished(int64(len(res.Body)))
+	}
+
+	// the script only gets the body once it's verified, so it's read whole here
+	if r.Integrity != "" {
+		if err := res.ReadBody(); err != nil {
+			return nil, err
+		}
+
+		if err := checkIntegrity(r.Integrity, res); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.requestID != "" {
+		res.Header.Set(RequestIDHeader, c.requestID)
+	}
+
+	return res, nil
+}
+
+func (f *fetcher) initRequest(reqUrl string, reqInit RequestInit, log *slog.Logger) (*internal.Request, error) {
+	reqUrl, err := f.resolveURL(reqUrl)
+	if err != nil {
+		return nil, err
+	}
+
 	u, err := internal.ParseRequestURL(reqUrl)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := normalizeHost(u); err != nil {
+		return nil, err
+	}
+
 	req := &internal.Request{
-		URL:  u,
-		Body: reqInit.Body,
+		URL: u,
 		Header: http.Header{
 			"Accept":          []string{"*/*"},
-			"Accept-Encoding": []string{"gzip, deflate, br"},
-			"Connection":      []string{"close"},
+			"Accept-Encoding": []string{f.AcceptEncoding},
 		},
 	}
 
+	if !f.keepsAlive(u) {
+		req.Header.Set("Connection", "close")
+	}
+
 	var ua string
 	if f.UserAgentProvider != nil {
 		ua = f.UserAgentProvider.GetUserAgent(u)
@@ -174,14 +690,31 @@ func (f *fetcher) initRequest(reqUrl string, reqInit internal.RequestInit) (*int
 
 	req.Header.Set("User-Agent", ua)
 
-	// url has no scheme, its a local request
-	if !u.IsAbs() {
+	for name, v := range f.DefaultHeaders {
+		req.Header[name] = v
+	}
+
+	// url has no scheme or a host of a local handler, its a local request
+	if !u.IsAbs() || f.localHandlerFor(u) != nil {
 		req.RemoteAddr = f.AddrLocal
 	}
 
-	for h, v := range reqInit.Headers {
-		headerName := http.CanonicalHeaderKey(h)
-		req.Header.Set(headerName, v)
+	// the user's headers replace the defaults, but repeated ones are all kept
+	userHeader := make(http.Header)
+	for _, h := range reqInit.Headers {
+		if isForbiddenHeader(h[0]) {
+			log.Debug("forbidden header ignored", "header", h[0])
+			continue
+		}
+		userHeader.Add(h[0], h[1])
+	}
+
+	for name, v := range userHeader {
+		req.Header[name] = v
+	}
+
+	if reqInit.Timeout > 0 {
+		req.Timeout = time.Duration(reqInit.Timeout * float64(time.Millisecond))
 	}
 
 	if reqInit.Method != "" {
@@ -190,6 +723,58 @@ func (f *fetcher) initRequest(reqUrl string, reqInit internal.RequestInit) (*int
 		req.Method = "GET"
 	}
 
+	if (reqInit.Body != nil || reqInit.FormData != nil) && (req.Method == "GET" || req.Method == "HEAD") {
+		return nil, fmt.Errorf("request with %s method cannot have body", req.Method)
+	}
+
+	if reqInit.FormData != nil {
+		entries := make([]internal.FormDataEntry, len(reqInit.FormData))
+		for i, entry := range reqInit.FormData {
+			if entry.Filename != nil {
+				entry.Value = string(DecodeBytes(entry.Value))
+			}
+			entries[i] = entry
+		}
+
+		body, contentType, err := internal.EncodeMultipart(entries)
+		if err != nil {
+			return nil, err
+		}
+
+		// the boundary is generated here, so the user's content type can't be right
+		req.Body = body
+		req.Header.Set("Content-Type", contentType)
+	} else if reqInit.Body != nil {
+		if reqInit.BodyEncoding == internal.BodyEncodingBytes {
+			req.Body = DecodeBytes(*reqInit.Body)
+		} else {
+			req.Body = []byte(*reqInit.Body)
+		}
+
+		if reqInit.BodyType != "" && req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", reqInit.BodyType)
+		}
+	}
+
+	// the bytes of an ArrayBuffer or a Blob are taken as already encoded
+	if enc := strings.Join(req.Header.Values("Content-Encoding"), ","); enc != "" && req.Body != nil &&
+		reqInit.BodyEncoding != internal.BodyEncodingBytes {
+		body, err := internal.EncodeBody(req.Body, enc)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+
+	switch c := reqInit.Credentials; c {
+	case internal.RequestCredentialsOmit, internal.RequestCredentialsSameOrigin, internal.RequestCredentialsInclude:
+		req.Credentials = c
+	case "":
+		req.Credentials = internal.RequestCredentialsSameOrigin
+	default:
+		return nil, &typeError{fmt.Errorf("unsupported credentials: %s", c)}
+	}
+
 	switch r := strings.ToLower(reqInit.Redirect); r {
 	case "error", "follow", "manual":
 		req.Redirect = r
@@ -199,116 +784,428 @@ func (f *fetcher) initRequest(reqUrl string, reqInit internal.RequestInit) (*int
 		return nil, fmt.Errorf("unsupported redirect: %s", reqInit.Redirect)
 	}
 
+	req.Integrity = reqInit.Integrity
+
+	if err := f.initReferrer(req, reqInit); err != nil {
+		return nil, err
+	}
+	setReferer(req.Header, req, req.URL)
+
 	return req, nil
 }
 
-func (f *fetcher) fetchLocal(r *internal.Request) (*internal.Response, error) {
-	if f.LocalHandler == nil {
-		return nil, errors.New("no local handler present")
+// the headers of the connection and the message framing, the fetcher sets them and scripts can't
+var forbiddenHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Expect":            true,
+	"Host":              true,
+	"Keep-Alive":        true,
+	"Te":                true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+func isForbiddenHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	return forbiddenHeaders[name] || strings.HasPrefix(name, "Proxy-")
+}
+
+// the ways a request is served
+const (
+	routeLocal     = "local"
+	routeLocalHost = "local host"
+	routeData      = "data"
+	routeFile      = "file"
+	routeRemote    = "remote"
+)
+
+func (f *fetcher) route(u *url.URL) string {
+	switch {
+	case !u.IsAbs():
+		return routeLocal
+	case u.Scheme == "data":
+		return routeData
+	case u.Scheme == "file":
+		return routeFile
+	case f.localHandlerFor(u) != nil:
+		return routeLocalHost
+	default:
+		return routeRemote
+	}
+}
+
+/*
+resolveURL resolves a relative url against the base url of WithBaseURL,
+absolute urls and all urls without a base url are returned as they are.
+*/
+func (f *fetcher) resolveURL(rawURL string) (string, error) {
+	if f.BaseURL == nil {
+		return rawURL, nil
+	}
+
+	ref, err := url.Parse(rawURL)
+	if err != nil {
+		return "", &typeError{fmt.Errorf("invalid url %s: %w", rawURL, err)}
 	}
 
-	var body io.Reader
-	if r.Method != "GET" {
-		body = strings.NewReader(r.Body)
+	if ref.IsAbs() {
+		return rawURL, nil
+	}
+
+	return f.BaseURL.ResolveReference(ref).String(), nil
+}
+
+func (f *fetcher) fetchLocal(ctx context.Context, r *internal.Request, handler http.Handler) (*internal.Response, error) {
+	if handler == nil {
+		return nil, errors.New("no local handler present")
 	}
 
-	req, err := http.NewRequest(r.Method, r.URL.String(), body)
+	req, err := http.NewRequestWithContext(ctx, r.Method, internal.WireURL(r.URL), newRequestBody(r))
 	if err != nil {
 		return nil, err
 	}
+	// make it look like a request received by a server
 	req.RemoteAddr = r.RemoteAddr
-	req.Header = r.Header
+	req.RequestURI = r.URL.RequestURI()
+	req.Header = r.Header.Clone()
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	req.Header.Del("Host")
+	req.Close = req.Header.Get("Connection") == "close"
 
-	rcd := httptest.NewRecorder()
+	if err := f.runRequestHooks(req); err != nil {
+		return nil, err
+	}
 
-	f.LocalHandler.ServeHTTP(rcd, req)
+	rcd := &localResponseWriter{ResponseRecorder: httptest.NewRecorder(), url: r.URL.String(), hints: f.EarlyHints}
 
-	return internal.HandleHttpResponse(rcd.Result(), r.URL.String(), false)
-}
+	start := time.Now()
+	handler.ServeHTTP(rcd, req)
+	result := rcd.Result()
+	result.Request = req
+	f.runResponseHooks(req, result, time.Since(start))
 
-func (f *fetcher) fetchRemote(r *internal.Request) (*internal.Response, error) {
-	var body io.Reader
-	if r.Method != "GET" {
-		body = strings.NewReader(r.Body)
+	if f.HAR != nil {
+		f.HAR.record(req, r.Body, result, start, nil)
 	}
 
-	req, err := http.NewRequest(r.Method, r.URL.String(), body)
+	countWire(ctx, result)
+
+	res, err := internal.HandleHttpResponseStream(result, internal.ResponseURL(r.URL), false, f.StrictContentEncoding)
 	if err != nil {
 		return nil, err
 	}
-	req.Header = r.Header
+
+	if f.MaxBodySize > 0 {
+		res.LimitBody(f.MaxBodySize)
+	}
+
+	if err := res.ReadBody(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func fetchData(r *internal.Request) (*internal.Response, error) {
+	res, err := internal.HandleDataURL(internal.ResponseURL(r.URL))
+	if err != nil {
+		return nil, &typeError{err}
+	}
+
+	return res, nil
+}
+
+func (f *fetcher) fetchRemote(ctx context.Context, r *internal.Request) (*internal.Response, error) {
+	if err := f.checkHost(r.URL); err != nil {
+		return nil, err
+	}
 
 	redirected := false
-	client := &http.Client{
-		Transport: http.DefaultTransport,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			switch r.Redirect {
-			case internal.RequestRedirectError:
-				return errors.New("redirects are not allowed")
-			default:
-				if len(via) >= 10 {
-					return errors.New("stopped after 10 redirects")
+
+	// a shallow copy, the client of WithHTTPClient is shared by all requests
+	var client http.Client
+	if f.HTTPClient != nil {
+		client = *f.HTTPClient
+	} else {
+		client = http.Client{
+			Transport: f.Transport,
+			Jar:       f.CookieJar,
+		}
+	}
+
+	if f.HAR != nil {
+		client.Transport = f.HAR.wrap(client.Transport)
+	}
+
+	// omit neither sends stored cookies nor stores new ones
+	if r.Credentials == internal.RequestCredentialsOmit {
+		client.Jar = nil
+	}
+
+	// the url of the request the informational responses answer
+	var hop atomic.Pointer[url.URL]
+	ctx = f.traceEarlyHints(ctx, &hop)
+
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		switch {
+		case r.Redirect == internal.RequestRedirectManual:
+			// resolve with the redirect response itself
+			return http.ErrUseLastResponse
+		case r.Redirect == internal.RequestRedirectError, f.MaxRedirects == 0:
+			return &typeError{errors.New("redirects are not allowed")}
+		case len(via) > f.MaxRedirects:
+			return &typeError{fmt.Errorf("stopped after %d redirects, last url %s", len(via)-1, req.URL)}
+		}
+
+		if err := normalizeHost(req.URL); err != nil {
+			return err
+		}
+
+		if err := f.checkHost(req.URL); err != nil {
+			return err
+		}
+
+		// a hop is a request to its host too
+		if err := f.waitRateLimit(req.Context(), req.URL); err != nil {
+			return err
+		}
+
+		hop.Store(req.URL)
+
+		// the policy applies to each hop, replacing the Referer the client sets
+		setReferer(req.Header, r, req.URL)
+
+		redirected = true
+		return nil
+	}
+
+	var res *http.Response
+
+	maxAttempts := f.maxAttempts(r)
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, r.Method, internal.WireURL(r.URL), newRequestBody(r))
+		if err != nil {
+			return nil, err
+		}
+		req.Header = r.Header
+
+		// the body waits for the 100 Continue of the server, up to the ExpectContinueTimeout of the transport
+		if f.ExpectContinue > 0 && int64(len(r.Body)) >= f.ExpectContinue {
+			req.Header.Set("Expect", "100-continue")
+		}
+
+		if err := f.runRequestHooks(req); err != nil {
+			return nil, err
+		}
+		f.trackUpload(req)
+		hop.Store(req.URL)
+
+		// every attempt counts against the limit of the host
+		if err := f.waitRateLimit(ctx, req.URL); err != nil {
+			return nil, err
+		}
+
+		// without the header the transport may ask for gzip and decode it on its own,
+		// each response is decoded by HandleHttpResponseStream only
+		if req.Header.Get("Accept-Encoding") == "" {
+			req.Header.Set("Accept-Encoding", "identity")
+		}
+
+		redirected = false
+
+		start := time.Now()
+		res, err = client.Do(req)
+		f.runResponseHooks(req, res, time.Since(start))
+		if attempt < maxAttempts && shouldRetry(ctx, res, err) {
+			if delay, ok := f.retryDelay(ctx, attempt, res); ok {
+				discardResponse(res)
+
+				if err := sleepContext(ctx, delay); err != nil {
+					return nil, err
 				}
+				continue
 			}
+		}
 
-			redirected = true
-			return nil
-		},
-		Timeout: 20 * time.Second,
+		if err != nil {
+			if attempt > 1 {
+				err = fmt.Errorf("%w (after %d attempts)", err, attempt)
+			}
+			return nil, newNetworkError(ctx, r.URL, err)
+		}
+
+		break
+	}
+
+	// the client resolves each Location against the url before it,
+	// so the url of the last request is the final absolute one
+	finalURL := r.URL
+	if res.Request != nil && res.Request.URL != nil {
+		finalURL = res.Request.URL
 	}
 
-	res, err := client.Do(req)
+	f.trackDownload(res)
+	countWire(ctx, res)
+
+	resp, err := internal.HandleHttpResponseStream(res, internal.ResponseURL(finalURL), redirected, f.StrictContentEncoding)
 	if err != nil {
 		return nil, err
 	}
 
-	return internal.HandleHttpResponse(res, r.URL.String(), redirected)
+	if f.MaxBodySize > 0 {
+		resp.LimitBody(f.MaxBodySize)
+	}
+
+	return resp, nil
+}
+
+// the body reader of the outgoing request, Content-Length
+// is taken from it by http.NewRequest
+func newRequestBody(r *internal.Request) io.Reader {
+	if r.Body == nil {
+		return nil
+	}
+
+	return bytes.NewReader(r.Body)
 }
 
-func newResponseObject(ctx *v8go.Context, res *internal.Response) (*v8go.Object, error) {
+func (f *fetcher) newResponseObject(ctx *v8go.Context, res *internal.Response) (*v8go.Object, error) {
+	return f.newResponseObjectWithBody(ctx, res, newResponseBody(res))
+}
+
+func (f *fetcher) newResponseObjectWithBody(ctx *v8go.Context, res *internal.Response, body *bodyCursor) (*v8go.Object, error) {
 	iso := ctx.Isolate()
 
-	headers, err := newHeadersObject(ctx, res.Header)
+	headers, err := newHeaderList(ctx, res.Header)
 	if err != nil {
 		return nil, err
 	}
 
-	textFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+	setCookies, err := newStringList(ctx, res.SetCookie)
+	if err != nil {
+		return nil, err
+	}
+
+	// https://developer.mozilla.org/en-US/docs/Web/API/ReadableStreamDefaultReader/read
+	readFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
 		ctx := info.Context()
 		resolver, _ := v8go.NewPromiseResolver(ctx)
 
+		if !f.begin() {
+			resolver.Reject(newErrorValue(ctx, errFetcherClosed))
+			return resolver.GetPromise().Value
+		}
+
+		post := BeginTask(iso)
+
 		go func() {
-			v, _ := v8go.NewValue(iso, res.Body)
-			resolver.Resolve(v)
+			defer f.end()
+
+			chunk, err := body.read()
+			f.settle(post, func() {
+				switch {
+				case err == io.EOF:
+					resolver.Resolve(v8go.Undefined(iso))
+				case err != nil:
+					resolver.Reject(newErrorValue(ctx, err))
+				default:
+					v, _ := v8go.NewValue(iso, EncodeBytes(chunk))
+					resolver.Resolve(v)
+				}
+			})
 		}()
 
 		return resolver.GetPromise().Value
 	})
-	if err != nil {
-		return nil, err
-	}
 
-	jsonFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+	readAllFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
 		ctx := info.Context()
-
 		resolver, _ := v8go.NewPromiseResolver(ctx)
 
+		if !f.begin() {
+			resolver.Reject(newErrorValue(ctx, errFetcherClosed))
+			return resolver.GetPromise().Value
+		}
+
+		post := BeginTask(iso)
+
 		go func() {
-			val, err := v8go.JSONParse(ctx, res.Body)
-			if err != nil {
-				rejectVal, _ := v8go.NewValue(iso, err.Error())
-				resolver.Reject(rejectVal)
-				return
-			}
+			defer f.end()
 
-			resolver.Resolve(val)
+			err := body.readAll()
+			f.settle(post, func() {
+				if err != nil {
+					resolver.Reject(newErrorValue(ctx, err))
+					return
+				}
+
+				resolver.Resolve(v8go.Undefined(iso))
+			})
 		}()
 
 		return resolver.GetPromise().Value
 	})
-	if err != nil {
-		return nil, err
-	}
+
+	cancelFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		body.cancel()
+		return nil
+	})
+
+	// https://developer.mozilla.org/en-US/docs/Web/API/Response/clone
+	cloneFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		clone, err := f.newResponseObjectWithBody(info.Context(), res, body.clone())
+		if err != nil {
+			msg, _ := v8go.NewValue(iso, err.Error())
+			return iso.ThrowException(msg)
+		}
+
+		return clone.Value
+	})
+
+	// the functions below work on the buffered body, after readAll()
+	textFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		v, _ := NewStringValue(info.Context(), internal.DecodeText(res.Header, res.Body))
+		return v
+	})
+
+	// v8go can't create an ArrayBuffer, so the bytes are handed over as
+	// a byte string which the JS side turns into one
+	bytesFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		v, _ := v8go.NewValue(iso, EncodeBytes(res.Body))
+		return v
+	})
+
+	// parse the body straight from Go rather than through a JS string
+	jsonFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		body := bytes.TrimPrefix(res.Body, utf8BOM)
+
+		val, err := v8go.JSONParse(info.Context(), string(body))
+		if err != nil {
+			// the JS side rethrows this as a SyntaxError
+			msg, _ := v8go.NewValue(iso, strings.TrimPrefix(err.Error(), "SyntaxError: "))
+			return iso.ThrowException(msg)
+		}
+
+		return val
+	})
+
+	// the trailers are only there once the body is read, so they're looked up each time
+	trailersFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		v, err := newHeaderList(info.Context(), res.Trailer)
+		if err != nil {
+			msg, _ := v8go.NewValue(iso, err.Error())
+			return iso.ThrowException(msg)
+		}
+
+		return v
+	})
 
 	resTmp := v8go.NewObjectTemplate(iso)
 
@@ -316,8 +1213,14 @@ func newResponseObject(ctx *v8go.Context, res *internal.Response) (*v8go.Object,
 		Name string
 		Tmp  interface{}
 	}{
+		{Name: "read", Tmp: readFnTmp},
+		{Name: "readAll", Tmp: readAllFnTmp},
+		{Name: "cancel", Tmp: cancelFnTmp},
+		{Name: "clone", Tmp: cloneFnTmp},
 		{Name: "text", Tmp: textFnTmp},
+		{Name: "bytes", Tmp: bytesFnTmp},
 		{Name: "json", Tmp: jsonFnTmp},
+		{Name: "trailers", Tmp: trailersFnTmp},
 	} {
 		if err := resTmp.Set(f.Name, f.Tmp, v8go.ReadOnly); err != nil {
 			return nil, err
@@ -334,12 +1237,14 @@ func newResponseObject(ctx *v8go.Context, res *internal.Response) (*v8go.Object,
 		Val interface{}
 	}{
 		{Key: "headers", Val: headers},
+		{Key: "setCookies", Val: setCookies},
 		{Key: "ok", Val: res.OK},
 		{Key: "redirected", Val: res.Redirected},
 		{Key: "status", Val: res.Status},
 		{Key: "statusText", Val: res.StatusText},
+		{Key: "rawStatus", Val: res.RawStatus},
 		{Key: "url", Val: res.URL},
-		{Key: "body", Val: res.Body},
+		{Key: "nullBody", Val: res.NullBody},
 	} {
 		if err := resObj.Set(v.Key, v.Val); err != nil {
 			return nil, err
@@ -349,80 +1254,159 @@ func newResponseObject(ctx *v8go.Context, res *internal.Response) (*v8go.Object,
 	return resObj, nil
 }
 
-func newHeadersObject(ctx *v8go.Context, h http.Header) (*v8go.Object, error) {
-	iso := ctx.Isolate()
+/*
+newHeaderList converts h to the [name, value] pairs read by the JS Headers,
+names are lowercased and sorted like browsers do, repeated headers keep their order.
+Set-Cookie is left out, the JS side gets those from Response.SetCookie.
+*/
+func newHeaderList(ctx *v8go.Context, h http.Header) (*v8go.Value, error) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		if http.CanonicalHeaderKey(name) == "Set-Cookie" {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	// https://developer.mozilla.org/en-US/docs/Web/API/Headers/get
-	getFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
-		args := info.Args()
-		if len(args) <= 0 {
-			// TODO: this should return an error, but v8go not supported now
-			val, _ := v8go.NewValue(iso, "")
-			return val
+	list := make([][2]string, 0, len(h))
+	for _, name := range names {
+		for _, v := range h[name] {
+			list = append(list, [2]string{strings.ToLower(name), v})
 		}
+	}
 
-		key := http.CanonicalHeaderKey(args[0].String())
-		val, _ := v8go.NewValue(iso, h.Get(key))
-		return val
-	})
+	b, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
 
-	// https://developer.mozilla.org/en-US/docs/Web/API/Headers/has
-	hasFnTmp := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
-		args := info.Args()
-		if len(args) <= 0 {
-			val, _ := v8go.NewValue(iso, false)
-			return val
-		}
-		key := http.CanonicalHeaderKey(args[0].String())
+	return v8go.JSONParse(ctx, string(b))
+}
 
-		val, _ := v8go.NewValue(iso, h.Get(key) != "")
-		return val
-	})
+// newStringList converts list to a JS array
+func newStringList(ctx *v8go.Context, list []string) (*v8go.Value, error) {
+	if list == nil {
+		list = []string{}
+	}
 
-	// create a header template,
-	// TODO: if v8go supports Map in the future, change this to a Map Object
-	headersTmp := v8go.NewObjectTemplate(iso)
+	b, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, f := range []struct {
-		Name string
-		Tmp  interface{}
-	}{
-		{Name: "get", Tmp: getFnTmp},
-		{Name: "has", Tmp: hasFnTmp},
-	} {
-		if err := headersTmp.Set(f.Name, f.Tmp, v8go.ReadOnly); err != nil {
-			return nil, err
-		}
+	return v8go.JSONParse(ctx, string(b))
+}
+
+// typeError is rejected as a JS TypeError, instead of an error string
+type typeError struct {
+	err error
+}
+
+func (e *typeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *typeError) Unwrap() error {
+	return e.err
+}
+
+/*
+networkError is a request which failed on the network, like a refused connection,
+it's rejected as a TypeError "Failed to fetch", with err as its cause
+*/
+type networkError struct {
+	url string
+	err error
+}
+
+func (e *networkError) Error() string {
+	return e.err.Error()
+}
+
+func (e *networkError) Unwrap() error {
+	return e.err
+}
+
+// newNetworkError wraps err of the client, unless it's an abort, a timeout or already a TypeError
+func newNetworkError(ctx context.Context, u *url.URL, err error) error {
+	var tErr *typeError
+	if ctx.Err() != nil || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &tErr) {
+		return err
 	}
 
-	headers, err := headersTmp.NewInstance(ctx)
+	return &networkError{url: internal.ResponseURL(u), err: err}
+}
+
+// newNetworkErrorValue creates the "Failed to fetch" TypeError of err
+func newNetworkErrorValue(ctx *v8go.Context, nErr *networkError) (*v8go.Value, error) {
+	e, err := newJSError(ctx, "TypeError", "Failed to fetch")
 	if err != nil {
 		return nil, err
 	}
 
-	for k, v := range h {
-		var vv string
-		if len(v) > 0 {
-			// get the first element, like http.Header.Get
-			vv = v[0]
-		}
+	cause, err := newJSError(ctx, "Error", nErr.Error())
+	if err != nil {
+		return nil, err
+	}
 
-		if err := headers.Set(k, vv); err != nil {
+	obj, err := e.AsObject()
+	if err != nil {
+		return nil, err
+	}
+
+	// url is not standard, it tells the failed request apart from others
+	for _, v := range []struct {
+		Key string
+		Val interface{}
+	}{
+		{Key: "cause", Val: cause},
+		{Key: "url", Val: nErr.url},
+	} {
+		if err := obj.Set(v.Key, v.Val); err != nil {
 			return nil, err
 		}
 	}
 
-	return headers, nil
+	return e, nil
 }
 
 // v8go currently not support reject a *v8go.Object,
 // so we should new *v8go.Value here
 func newErrorValue(ctx *v8go.Context, err error) *v8go.Value {
 	iso := ctx.Isolate()
-	e, _ := v8go.NewValue(iso, fmt.Sprintf("fetch: %v", err))
+	msg := fmt.Sprintf("fetch: %v", err)
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		if e, err := newTimeoutError(ctx, msg); err == nil {
+			return e
+		}
+	}
+
+	var nErr *networkError
+	if errors.As(err, &nErr) {
+		if e, err := newNetworkErrorValue(ctx, nErr); err == nil {
+			return e
+		}
+	}
+
+	var tErr *typeError
+	if errors.As(err, &tErr) {
+		if e, err := newJSError(ctx, "TypeError", msg); err == nil {
+			return e
+		}
+	}
+
+	e, _ := v8go.NewValue(iso, msg)
 	return e
 }
 
+func newRejectedPromise(ctx *v8go.Context, err error) *v8go.Value {
+	resolver, _ := v8go.NewPromiseResolver(ctx)
+	resolver.Reject(newErrorValue(ctx, err))
+	return resolver.GetPromise().Value
+}
+
 func UserAgent() string {
 	return fmt.Sprintf("v8go-polyfills/%s (v8go/%s)", Version, v8go.Version())
 }
@@ -23,10 +23,47 @@
 package fetch
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	stdurl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/weese/v8go-polyfills/abort"
+	"github.com/weese/v8go-polyfills/formdata"
+	"github.com/weese/v8go-polyfills/url"
+	"go.uber.org/goleak"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
 
 	"rogchap.com/v8go"
 )
@@ -34,8 +71,8 @@ import (
 func TestNewFetcher(t *testing.T) {
 	t.Parallel()
 
-	f1 := NewFetcher()
-	if f1 == nil {
+	f1, err := NewFetcher()
+	if err != nil || f1 == nil {
 		t.Error("create fetcher failed")
 		return
 	}
@@ -44,8 +81,8 @@ func TestNewFetcher(t *testing.T) {
 		t.Error("local handler is <nil>")
 	}
 
-	f2 := NewFetcher(WithLocalHandler(nil))
-	if f2 == nil {
+	f2, err := NewFetcher(WithLocalHandler(nil))
+	if err != nil || f2 == nil {
 		t.Error("create fetcher with local handler failed")
 		return
 	}
@@ -54,6 +91,14 @@ func TestNewFetcher(t *testing.T) {
 		t.Error("set fetcher local handler to <nil> failed")
 		return
 	}
+
+	_, err = NewFetcher(
+		WithLocalHandlerFor("api.local", http.NotFoundHandler()),
+		WithLocalHandlerFor("API.local", http.NotFoundHandler()),
+	)
+	if err == nil || err.Error() != "v8go-polyfills/fetch: local handler for api.local is registered twice" {
+		t.Errorf("expected an error for the conflicting local handlers, got %v", err)
+	}
 }
 
 func TestFetchJSON(t *testing.T) {
@@ -84,7 +129,7 @@ func TestFetchJSON(t *testing.T) {
 	}
 
 	for proms.State() == v8go.Pending {
-		continue
+		ProcessTasks(ctx.Isolate())
 	}
 
 	res, err := proms.Result().AsObject()
@@ -104,43 +149,3847 @@ func TestFetchJSON(t *testing.T) {
 	}
 }
 
-func TestHeaders(t *testing.T) {
+func TestFetchJSONParse(t *testing.T) {
 	t.Parallel()
 
-	iso := v8go.NewIsolate()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 
-	ctx := v8go.NewContext(iso)
+		switch r.URL.Path {
+		case "/bom":
+			_, _ = w.Write([]byte("\xef\xbb\xbf{\"bom\": true}"))
+		case "/invalid":
+			_, _ = w.Write([]byte(`{"a": 1,}`))
+		default:
+			_, _ = w.Write([]byte(`{"a": 1}`))
+		}
+	}))
+	defer srv.Close()
 
-	obj, err := newHeadersObject(ctx, http.Header{
-		"AA": []string{"aa"},
-		"BB": []string{"bb"},
-	})
+	for _, c := range []struct {
+		Name     string
+		Script   string
+		Expected string
+	}{
+		{
+			Name:     "bom",
+			Script:   "fetch('%s/bom').then(res => res.json()).then(v => String(v.bom))",
+			Expected: "true",
+		},
+		{
+			Name:     "invalid",
+			Script:   "fetch('%s/invalid').then(res => res.json()).catch(e => `${e.name}: ${e.message}`)",
+			Expected: "SyntaxError: Unexpected token } in JSON at position 8",
+		},
+		{
+			Name:     "consumed",
+			Script:   "fetch('%s/').then(res => res.json().then(() => res.json())).catch(e => e.name)",
+			Expected: "TypeError",
+		},
+	} {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(c.Script, srv.URL), "fetch_json_"+c.Name+".js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %s", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res)
+		}
+	}
+}
+
+func TestFetchJSONLargeBody(t *testing.T) {
+	t.Parallel()
+
+	// about 10MB of JSON
+	body := []byte("[" + strings.Repeat(`{"key":"value","list":[1,2,3]},`, 320*1024) + "{}]")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	heapSize := func(script string) uint64 {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return 0
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(script, srv.URL), "fetch_json_large.js")
+		if err != nil {
+			t.Error(err)
+			return 0
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Error(err)
+			return 0
+		}
+
+		if !res.IsInt32() || res.Int32() != 320*1024+1 {
+			t.Errorf("expected %d items, but got %s", 320*1024+1, res)
+		}
+
+		return ctx.Isolate().GetHeapStatistics().TotalHeapSize
+	}
+
+	jsonSize := heapSize("fetch('%s').then(res => res.json()).then(v => v.length)")
+	textSize := heapSize("fetch('%s').then(res => res.text()).then(JSON.parse).then(v => v.length)")
+
+	// the heap size depends on when the GC ran, allow some noise
+	if jsonSize > textSize+textSize/4 {
+		t.Errorf("json() should not use more heap than text(), but %d > %d", jsonSize, textSize)
+	}
+}
+
+func TestFetchBodyStream(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			_, _ = w.Write(bytes.Repeat([]byte{byte(i)}, 1024))
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(async res => {
+		const reader = res.body.getReader();
+		let total = 0, chunks = 0, last;
+		for (;;) {
+			const { value, done } = await reader.read();
+			if (done) {
+				break;
+			}
+
+			if (!(value instanceof Uint8Array)) {
+				throw new Error("chunk is not a Uint8Array");
+			}
+
+			total += value.length;
+			chunks++;
+			last = value[value.length - 1];
+		}
+
+		return [total, chunks > 1, last].join();
+	})`, srv.URL), "fetch_body_stream.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if s := res.String(); s != "3072,true,2" {
+		t.Errorf("expected '3072,true,2' but got '%s'", s)
+	}
+}
+
+func TestFetchBodyStreamCancel(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	closed := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(closed)
+
+		for {
+			if _, err := w.Write(make([]byte, 1024)); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer srv.Close()
+
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(async res => {
+		const reader = res.body.getReader();
+		await reader.read();
+		await reader.cancel();
+
+		const { done } = await reader.read();
+		return done;
+	})`, srv.URL), "fetch_body_stream_cancel.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !res.Boolean() {
+		t.Error("stream should be done after cancel")
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Error("body should be closed after cancel")
+	}
+}
+
+func TestFetchBodyStreamCancelPending(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	// the first chunk comes right away, the next one only after 5s
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 1024))
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(async res => {
+		globalThis.reader = res.body.getReader();
+		await reader.read();
+		globalThis.pending = reader.read();
+	})`, srv.URL), "fetch_body_stream_cancel_pending.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := waitForPromise(ctx, val); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// give the pending read time to block on the body
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := ctx.RunScript(`reader.cancel()`, "fetch_body_stream_cancel_pending.js"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected cancel to return right away, but took %s", elapsed)
+	}
+
+	val, err = ctx.RunScript(`pending.then(({ done }) => done, () => true)`, "fetch_body_stream_cancel_pending.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !res.Boolean() {
+		t.Error("pending read should end after cancel")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the pending read to end right away, but took %s", elapsed)
+	}
+}
+
+func TestFetchBodyStreamLocked(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("locked"))
+	}))
+	defer srv.Close()
+
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => {
+		res.body.getReader();
+		return res.text();
+	}).catch(e => e.name)`, srv.URL), "fetch_body_stream_locked.js")
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	aa, err := obj.Get("AA")
+	res, err := waitForPromise(ctx, val)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if aa.String() != "aa" {
-		t.Errorf("should be 'aa' but is '%s'", aa.String())
+	if s := res.String(); s != "TypeError" {
+		t.Errorf("expected 'TypeError' but got '%s'", s)
+	}
+}
+
+func TestFetchBodyUsed(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"body": "used"}`))
+	}))
+	defer srv.Close()
+
+	for _, c := range []struct {
+		Name     string
+		Script   string
+		Expected string
+	}{
+		{
+			Name:     "used",
+			Script:   "fetch('%s').then(res => { const before = res.bodyUsed; return res.text().then(() => [before, res.bodyUsed].join()) })",
+			Expected: "false,true",
+		},
+		{
+			Name:     "twice",
+			Script:   "fetch('%s').then(res => res.text().then(() => res.text())).catch(e => e.name)",
+			Expected: "TypeError",
+		},
+		{
+			Name:     "clone",
+			Script:   "fetch('%s').then(res => { const clone = res.clone(); return Promise.all([res.text(), clone.json()]) }).then(([text, json]) => text + ' ' + json.body)",
+			Expected: `{"body": "used"} used`,
+		},
+		{
+			Name: "clone_stream",
+			Script: `fetch('%s').then(async res => {
+				const clone = res.clone();
+				const { value } = await res.body.getReader().read();
+				return [value.length, await clone.text(), clone.bodyUsed, res.bodyUsed].join();
+			})`,
+			Expected: `16,{"body": "used"},true,true`,
+		},
+		{
+			Name:     "clone_used",
+			Script:   "fetch('%s').then(res => res.text().then(() => res.clone())).catch(e => e.name)",
+			Expected: "TypeError",
+		},
+	} {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(c.Script, srv.URL), "fetch_body_"+c.Name+".js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %s", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res)
+		}
+	}
+}
+
+func TestFetchRequestBody(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(newEchoHandler())
+	defer srv.Close()
+
+	for _, c := range []struct {
+		Name     string
+		Script   string
+		Expected string
+	}{
+		{
+			Name:     "post",
+			Script:   "fetch('%s', {method: 'POST', body: 'hello, 世界'}).then(res => res.json()).then(v => [v.method, v.body, v.header['Content-Type'], v.contentLength].join())",
+			Expected: "POST,hello, 世界,text/plain;charset=UTF-8,13",
+		},
+		{
+			Name:     "content_type",
+			Script:   "fetch('%s', {method: 'PUT', body: '{}', headers: {'content-type': 'application/json'}}).then(res => res.json()).then(v => [v.method, v.body, v.header['Content-Type']].join())",
+			Expected: "PUT,{},application/json",
+		},
+		{
+			Name:     "empty",
+			Script:   "fetch('%s', {method: 'POST', body: ''}).then(res => res.json()).then(v => [v.body, v.contentLength].join())",
+			Expected: ",0",
+		},
+		{
+			Name:     "get",
+			Script:   "fetch('%s', {body: 'body'}).catch(e => e.name)",
+			Expected: "TypeError",
+		},
+		{
+			Name:     "head",
+			Script:   "fetch('%s', {method: 'head', body: 'body'}).catch(e => e.name)",
+			Expected: "TypeError",
+		},
+	} {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(c.Script, srv.URL), "fetch_request_body_"+c.Name+".js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %s", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res)
+		}
+	}
+}
+
+func TestFetchBinaryRequestBody(t *testing.T) {
+	t.Parallel()
+
+	random := make([]byte, 5*1024*1024)
+	_, _ = rand.Read(random)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			_, _ = w.Write(random)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+
+		_, _ = fmt.Fprintf(w, "%x %q", sum, r.Header.Get("Content-Type"))
+	}))
+	defer srv.Close()
+
+	sumOf := func(b []byte) string {
+		return fmt.Sprintf(`%x ""`, sha256.Sum256(b))
+	}
+
+	for _, c := range []struct {
+		Name     string
+		Body     string
+		Expected string
+	}{
+		{
+			Name:     "array_buffer",
+			Body:     "buf",
+			Expected: sumOf(random),
+		},
+		{
+			Name:     "uint8_array",
+			Body:     "new Uint8Array(buf, 16, buf.byteLength - 32)",
+			Expected: sumOf(random[16 : len(random)-16]),
+		},
+		{
+			Name:     "data_view",
+			Body:     "new DataView(buf, 1, 7)",
+			Expected: sumOf(random[1:8]),
+		},
+		{
+			Name:     "int32_array",
+			Body:     "new Int32Array(buf, 4, 2)",
+			Expected: sumOf(random[4:12]),
+		},
+	} {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%[1]s')
+			.then(res => res.arrayBuffer())
+			.then(buf => fetch('%[1]s', {method: 'POST', body: %[2]s}))
+			.then(res => res.text())`, srv.URL, c.Body), "fetch_binary_body_"+c.Name+".js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %s", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res)
+		}
+	}
+}
+
+func TestFetchURLSearchParamsBody(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	if err := url.InjectTo(ctx); err != nil {
+		t.Error(err)
 		return
 	}
 
-	fn, err := obj.Get("get")
+	received := make(chan *http.Request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		received <- r
+	}))
+	defer srv.Close()
+
+	val, err := ctx.RunScript(fmt.Sprintf(`const params = new URLSearchParams();
+		params.append("a key", "a value");
+		params.append("x=y", "1+1&2");
+		params.append("emoji", "😀");
+		params.append("a key", "again");
+		fetch('%s', {method: 'POST', body: params}).then(res => res.ok)`, srv.URL), "fetch_url_search_params.js")
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if !fn.IsFunction() {
-		t.Error("should be function")
+	if _, err := waitForPromise(ctx, val); err != nil {
+		t.Error(err)
 		return
 	}
+
+	r := <-received
+	b, _ := ioutil.ReadAll(r.Body)
+	body, contentType := string(b), r.Header.Get("Content-Type")
+	form, _ := stdurl.ParseQuery(body)
+
+	if expected := "a+key=a+value&x%3Dy=1%2B1%262&emoji=%F0%9F%98%80&a+key=again"; body != expected {
+		t.Errorf("expected body '%s' but got '%s'", expected, body)
+	}
+
+	if expected := "application/x-www-form-urlencoded;charset=UTF-8"; contentType != expected {
+		t.Errorf("expected content type '%s' but got '%s'", expected, contentType)
+	}
+
+	if v := form["a key"]; len(v) != 2 || v[0] != "a value" || v[1] != "again" {
+		t.Errorf("unexpected 'a key' values %v", v)
+	}
+
+	if v := form.Get("x=y"); v != "1+1&2" {
+		t.Errorf("expected '1+1&2' but got '%s'", v)
+	}
+
+	if v := form.Get("emoji"); v != "😀" {
+		t.Errorf("expected '😀' but got '%s'", v)
+	}
+}
+
+func TestFetchRequestLikeInput(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := url.InjectTo(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s %s", r.Method, r.URL.RequestURI(), r.Header.Get("X-Test"), b)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Name     string
+		Script   string
+		Expected string
+	}{
+		{
+			"object", fmt.Sprintf("fetch({ url: '%s/obj', method: 'POST', headers: { 'X-Test': 'a' }, body: 'b' }).then(res => res.text())", srv.URL),
+			"POST /obj a b",
+		},
+		{
+			"object and init", fmt.Sprintf("fetch({ url: '%s/obj', method: 'POST', body: 'b' }, { method: 'PUT' }).then(res => res.text())", srv.URL),
+			"PUT /obj  b",
+		},
+		{
+			"url instance", fmt.Sprintf("fetch(new URL('/url?q=1', '%s')).then(res => res.text())", srv.URL),
+			"GET /url?q=1  ",
+		},
+		{
+			// stringified to "[object Object]", a relative url for the local handler
+			"garbage object", "fetch({ href: 'http://example.com', method: 'POST' }).catch(e => String(e))",
+			"fetch: unsupported relatve path [object Object]",
+		},
+	}
+
+	for _, tc := range cases {
+		val, err := ctx.RunScript(tc.Script, "fetch_request_like.js")
+		if err != nil {
+			t.Errorf("%s: %s", tc.Name, err)
+			continue
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %s", tc.Name, err)
+			continue
+		}
+
+		if res.String() != tc.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", tc.Name, tc.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchBlob(t *testing.T) {
+	t.Parallel()
+
+	data := make([]byte, 256)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	received := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			b, _ := ioutil.ReadAll(r.Body)
+			received <- b
+			w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "Application/Octet-Stream")
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name     string
+		Slice    string
+		Expected []byte
+	}{
+		{"whole", "blob.slice()", data},
+		{"range", "blob.slice(16, 32)", data[16:32]},
+		{"negative", "blob.slice(-10, -2)", data[246:254]},
+		{"out of range", "blob.slice(250, 1000)", data[250:]},
+		{"reversed", "blob.slice(32, 16)", []byte{}},
+	}
+
+	for _, tc := range cases {
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => res.blob()).then(blob => {
+			const slice = %s;
+			return slice.arrayBuffer().then(buf => JSON.stringify({ size: blob.size, type: blob.type, bytes: [...new Uint8Array(buf)] }));
+		})`, srv.URL, tc.Slice), "fetch_blob.js")
+		if err != nil {
+			t.Errorf("%s: %s", tc.Name, err)
+			continue
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %s", tc.Name, err)
+			continue
+		}
+
+		var got struct {
+			Size  int
+			Type  string
+			Bytes []int
+		}
+		if err := json.Unmarshal([]byte(res.String()), &got); err != nil {
+			t.Errorf("%s: unexpected result %s", tc.Name, res.String())
+			continue
+		}
+
+		b := make([]byte, len(got.Bytes))
+		for i, v := range got.Bytes {
+			b[i] = byte(v)
+		}
+
+		if got.Size != len(data) || got.Type != "application/octet-stream" {
+			t.Errorf("%s: expected a blob of %d bytes with type application/octet-stream but got %d bytes with type '%s'",
+				tc.Name, len(data), got.Size, got.Type)
+		}
+
+		if !bytes.Equal(b, tc.Expected) {
+			t.Errorf("%s: expected the bytes %v but got %v", tc.Name, tc.Expected, b)
+		}
+	}
+
+	// a blob is sent as its bytes, with its type
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s', {
+		method: 'POST',
+		body: new Blob([new Uint8Array([0, 1, 255]), 'a'], { type: 'application/x-test' }),
+	}).then(res => res.headers.get('content-type'))`, srv.URL), "fetch_blob_body.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "application/x-test"; res.String() != expected {
+		t.Errorf("expected the content type '%s' but got '%s'", expected, res.String())
+	}
+
+	if b, expected := <-received, []byte{0, 1, 255, 'a'}; !bytes.Equal(b, expected) {
+		t.Errorf("expected the body %v but got %v", expected, b)
+	}
+}
+
+func TestFetchFormDataBody(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	if err := formdata.InjectTo(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+
+	type upload struct {
+		contentType string
+		form        *multipart.Form
+		err         error
+	}
+
+	received := make(chan upload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseMultipartForm(1 << 20)
+		received <- upload{r.Header.Get("Content-Type"), r.MultipartForm, err}
+	}))
+	defer srv.Close()
+
+	val, err := ctx.RunScript(fmt.Sprintf(`const form = new FormData();
+		form.append("name", "v8go");
+		form.append("emoji", "😀");
+		form.append("file", new Uint8Array([0, 1, 2, 255]), "data.bin");
+		form.append("blob", new Blob(["text"], { type: "text/plain" }));
+		fetch('%s', {
+			method: 'POST',
+			body: form,
+			headers: {'Content-Type': 'multipart/form-data'},
+		}).then(res => res.ok)`, srv.URL), "fetch_form_data.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := waitForPromise(ctx, val); err != nil {
+		t.Error(err)
+		return
+	}
+
+	u := <-received
+	if u.err != nil {
+		t.Errorf("parse multipart form: %v, content type '%s'", u.err, u.contentType)
+		return
+	}
+
+	if !strings.HasPrefix(u.contentType, "multipart/form-data; boundary=") {
+		t.Errorf("expected a multipart content type with boundary but got '%s'", u.contentType)
+	}
+
+	if v := u.form.Value["name"]; len(v) != 1 || v[0] != "v8go" {
+		t.Errorf("unexpected 'name' values %v", v)
+	}
+
+	if v := u.form.Value["emoji"]; len(v) != 1 || v[0] != "😀" {
+		t.Errorf("unexpected 'emoji' values %v", v)
+	}
+
+	files := u.form.File["file"]
+	if len(files) != 1 {
+		t.Errorf("expected 1 file but got %d", len(files))
+		return
+	}
+
+	if files[0].Filename != "data.bin" {
+		t.Errorf("expected filename 'data.bin' but got '%s'", files[0].Filename)
+	}
+
+	if ct := files[0].Header.Get("Content-Type"); ct != "application/octet-stream" {
+		t.Errorf("expected file content type 'application/octet-stream' but got '%s'", ct)
+	}
+
+	f, err := files[0].Open()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer f.Close()
+
+	if b, _ := ioutil.ReadAll(f); !bytes.Equal(b, []byte{0, 1, 2, 255}) {
+		t.Errorf("unexpected file content %v", b)
+	}
+
+	// a blob is a file named "blob", with the type of the blob
+	blobs := u.form.File["blob"]
+	if len(blobs) != 1 {
+		t.Errorf("expected 1 blob but got %d", len(blobs))
+		return
+	}
+
+	if blobs[0].Filename != "blob" || blobs[0].Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("expected the file 'blob' of type 'text/plain' but got '%s' of type '%s'",
+			blobs[0].Filename, blobs[0].Header.Get("Content-Type"))
+	}
+
+	bf, err := blobs[0].Open()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer bf.Close()
+
+	if b, _ := ioutil.ReadAll(bf); string(b) != "text" {
+		t.Errorf("unexpected blob content '%s'", b)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	t.Parallel()
+
+	iso := v8go.NewIsolate()
+
+	ctx := v8go.NewContext(iso)
+
+	val, err := newHeaderList(ctx, http.Header{
+		"Content-Type": []string{"text/plain"},
+		"X-Bb":         []string{"1", "2"},
+		"X-Aa":         []string{"aa"},
+		"Set-Cookie":   []string{"a=1"},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	b, err := val.MarshalJSON()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if expected := `[["content-type","text/plain"],["x-aa","aa"],["x-bb","1"],["x-bb","2"]]`; string(b) != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, b)
+	}
+}
+
+func TestHeadersClass(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	// the first construction goes through the injected function
+	val, err := ctx.RunScript(`try { new Headers(1); "no error" } catch (e) { e instanceof TypeError }`, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if val.String() != "true" {
+		t.Errorf("expected a TypeError but got '%s'", val.String())
+	}
+
+	cases := [][2]string{
+		{`const h = new Headers({"Content-Type": "text/plain"}); h.get("content-type")`, "text/plain"},
+		{`const h = new Headers([["X-A", "1"], ["x-a", "2"]]); h.get("X-A")`, "1, 2"},
+		{`const h = new Headers(new Headers({a: "1"})); h.get("a")`, "1"},
+		{`const h = new Headers(); h.get("a")`, "null"},
+		{`const h = new Headers(); h.append("a", " 1 "); h.has("A") + "," + h.has("b") + "," + h.get("a")`, "true,false,1"},
+		{`const h = new Headers([["a", "1"], ["b", "2"], ["a", "3"]]); h.set("A", "4"); h.get("a")`, "4"},
+		{`const h = new Headers({a: "1", b: "2"}); h.delete("A"); [...h.keys()].join()`, "b"},
+		{`const h = new Headers({"X-B": "1", "x-a": "2", "X-C": "3"}); h.append("x-b", "4"); JSON.stringify([...h])`, `[["x-a","2"],["x-b","1, 4"],["x-c","3"]]`},
+		{`const h = new Headers([["Set-Cookie", "a=1"], ["set-cookie", "b=2"]]); JSON.stringify([...h.entries()])`, `[["set-cookie","a=1"],["set-cookie","b=2"]]`},
+		{`const h = new Headers([["Set-Cookie", "a=1, b"], ["set-cookie", "c=2"]]); JSON.stringify(h.getSetCookie())`, `["a=1, b","c=2"]`},
+		{`const h = new Headers({a: "1"}); h.getSetCookie().length`, "0"},
+		{`const h = new Headers({b: "2", a: "1"}); const r = []; h.forEach((v, k) => r.push(k + "=" + v)); r.join("&")`, "a=1&b=2"},
+		{`try { new Headers({"a b": "1"}); "no error" } catch (e) { e instanceof TypeError }`, "true"},
+		{`try { new Headers({a: "1\n2"}); "no error" } catch (e) { e instanceof TypeError }`, "true"},
+		{`try { new Headers([["a"]]); "no error" } catch (e) { e instanceof TypeError }`, "true"},
+		{`new Headers() instanceof Headers`, "true"},
+		{`Object.prototype.toString.call(new Headers())`, "[object Headers]"},
+	}
+
+	for i, c := range cases {
+		// a block scopes the declarations, and keeps the completion value
+		val, err := ctx.RunScript("{"+c[0]+"}", "")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
+
+func TestPolyfillExportsHidden(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Fatalf("create v8: %s", err)
+	}
+
+	// the exports of the polyfill aren't a global a script could see or replace
+	val, err := ctx.RunScript(`
+	new Headers();
+	const hidden = !Object.getOwnPropertyNames(globalThis).some((k) => k.startsWith("__v8go"));
+	globalThis.__v8goPolyfillsFetch = { Response: function () { return { text: () => "fake" } } };
+	new Response("real").text().then((text) => [hidden, text].join())`, "polyfill_exports_hidden.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "true,real"; res.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, res.String())
+	}
+}
+
+func TestResponseClass(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Script   string
+		Expected string
+	}{
+		// the statics work before anything loaded the polyfill
+		{`Response.json({a: 1}, {status: 201}).text().then(t => t)`, `{"a":1}`},
+		{`const r = Response.error(); [r.type, r.status, r.ok, r.body].join()`, "error,0,false,"},
+		{`new Response(JSON.stringify({a: [1]}), {status: 200, headers: {"X-A": "1"}}).json().then(v => v.a[0])`, "1"},
+		{`const r = new Response("hi"); [r.status, r.ok, r.statusText, r.type, r.url, r.redirected, r.headers.get("content-type")].join()`, "200,true,,default,,false,text/plain;charset=UTF-8"},
+		{`const r = new Response("x", {status: 404, statusText: "Nope"}); [r.status, r.ok, r.statusText].join()`, "404,false,Nope"},
+		{`new Response(new Uint8Array([0xe2, 0x82, 0xac, 0xff])).text()`, "€�"},
+		{`new Response(new Uint16Array([1, 2]).subarray(1)).arrayBuffer().then(b => [...new Uint8Array(b)].join())`, "2,0"},
+		{`new Response("é").arrayBuffer().then(b => [...new Uint8Array(b)].join())`, "195,169"},
+		{`new Response(new ArrayBuffer(2), {headers: {"Content-Type": "x/y"}}).headers.get("content-type")`, "x/y"},
+		{`const r = new Response(null); [r.body, r.headers.has("content-type")].join()`, ",false"},
+		{`new Response(null, {status: 204}).text().then(t => t === "")`, "true"},
+		{`new Response().json().catch(e => e instanceof SyntaxError)`, "true"},
+		{`new Response("x").json().catch(e => [e instanceof SyntaxError, e.message.startsWith("SyntaxError")].join())`, "true,false"},
+		{`const r = new Response("ab"); const c = r.clone(); r.headers.set("x", "1"); Promise.all([r.text(), c.text()]).then(t => t.join() + "," + c.headers.has("x"))`, "ab,ab,false"},
+		{`const r = new Response("ab"); r.text().then(() => { try { r.clone(); return "no error" } catch (e) { return e instanceof TypeError } })`, "true"},
+		{`const r = new Response("abc"); r.body.getReader().read().then(({value}) => [...value].join())`, "97,98,99"},
+		{`const r = new Response("ab"); r.text().then(() => r.text()).catch(e => e instanceof TypeError)`, "true"},
+		{`Response.json("x").headers.get("content-type")`, "application/json"},
+		{`Response.json(1, {headers: {"content-type": "text/json"}}).headers.get("content-type")`, "text/json"},
+		{`try { Response.json(undefined); "no error" } catch (e) { e instanceof TypeError }`, "true"},
+		{`try { new Response("", {status: 199}); "no error" } catch (e) { e instanceof RangeError }`, "true"},
+		{`try { new Response("", {status: 600}); "no error" } catch (e) { e instanceof RangeError }`, "true"},
+		{`try { new Response("x", {status: 204}); "no error" } catch (e) { e instanceof TypeError }`, "true"},
+		{`try { new Response("", {statusText: "a\nb"}); "no error" } catch (e) { e instanceof TypeError }`, "true"},
+		{`new Response() instanceof Response`, "true"},
+		{`Object.prototype.toString.call(new Response())`, "[object Response]"},
+	}
+
+	for i, c := range cases {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		// a block scopes the declarations, and keeps the completion value
+		val, err := ctx.RunScript("{"+c.Script+"}", "response_class.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.IsPromise() {
+			if val, err = waitForPromise(ctx, val); err != nil {
+				t.Errorf("case %d: %v", i, err)
+				continue
+			}
+		}
+
+		if val.String() != c.Expected {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c.Expected, val.String())
+		}
+	}
+}
+
+func TestRequestClass(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Script   string
+		Expected string
+	}{
+		{`const r = new Request("/a"); [r.url, r.method, r.redirect, r.credentials, r.signal, r.bodyUsed].join()`, "/a,GET,follow,same-origin,,false"},
+		{`const r = new Request("/a", {method: "post", body: "x", headers: {"X-A": "1"}}); [r.method, r.headers.get("x-a"), r.headers.get("content-type")].join()`, "POST,1,text/plain;charset=UTF-8"},
+		{`const r = new Request("/a", {method: "patch"}); r.method`, "patch"},
+		{`new Request("/a", {method: "POST", body: new Uint8Array([104, 105])}).text()`, "hi"},
+		{`new Request("/a", {method: "POST", body: '{"a":1}'}).json().then(v => v.a)`, "1"},
+		{`new Request("/a").arrayBuffer().then(b => b.byteLength)`, "0"},
+		{`const r = new Request("/a", {method: "POST", body: "x"}); r.text().then(() => [r.bodyUsed, r.text().catch(e => e instanceof TypeError)]).then(([used, p]) => p.then(e => used + "," + e))`, "true,true"},
+		{`const r = new Request("/a", {method: "POST", body: "xy", redirect: "manual"}); const c = r.clone(); c.headers.set("x", "1"); Promise.all([r.text(), c.text()]).then(t => [t.join(), c.redirect, r.headers.has("x")].join())`, "xy,xy,manual,false"},
+		{`const r = new Request("/a", {method: "PUT", body: "x"}); const r2 = new Request(r, {headers: {b: "2"}}); [r.bodyUsed, r2.method, r2.url, r2.headers.get("b"), r2.headers.has("content-type")].join()`, "true,PUT,/a,2,false"},
+		{`const r = new Request(new Request("/a", {method: "DELETE", headers: {a: "1"}}), {method: "HEAD"}); [r.method, r.headers.get("a")].join()`, "HEAD,1"},
+		{`const r = new Request("/a", {method: "POST", body: "x"}); r.text().then(() => { try { new Request(r); return "no error" } catch (e) { return e instanceof TypeError } })`, "true"},
+		{`try { new Request("/a", {body: "x"}); "no error" } catch (e) { e instanceof TypeError }`, "true"},
+		{`try { new Request(new Request("/a", {method: "POST", body: "x"}), {method: "GET"}); "no error" } catch (e) { e instanceof TypeError }`, "true"},
+		{`try { new Request("/a", {method: "a b"}); "no error" } catch (e) { e instanceof TypeError }`, "true"},
+		{`try { new Request("/a", {signal: {}}); "no error" } catch (e) { e instanceof TypeError }`, "true"},
+		{`try { new Request(); "no error" } catch (e) { e instanceof TypeError }`, "true"},
+		{`new Request("/a") instanceof Request`, "true"},
+		{`Object.prototype.toString.call(new Request("/a"))`, "[object Request]"},
+	}
+
+	for i, c := range cases {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		// a block scopes the declarations, and keeps the completion value
+		val, err := ctx.RunScript("{"+c.Script+"}", "request_class.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.IsPromise() {
+			if val, err = waitForPromise(ctx, val); err != nil {
+				t.Errorf("case %d: %v", i, err)
+				continue
+			}
+		}
+
+		if val.String() != c.Expected {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c.Expected, val.String())
+		}
+	}
+}
+
+func TestFetchRequest(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		w.Header().Set("X-Echo", fmt.Sprintf("%s|%s|%s|%s", r.Method, r.Header.Get("X-Token"), r.Header.Get("Content-Type"), body))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Script   string
+		Expected string
+	}{
+		{`fetch(new Request('%s/a', {method: 'POST', body: 'one', headers: {'X-Token': 't'}}))`, "POST|t|text/plain;charset=UTF-8|one"},
+		{`fetch(new Request('%s/a', {headers: {'X-Token': 't'}}), {method: 'HEAD'})`, "HEAD|t||"},
+		{`fetch(new Request('%s/a', {method: 'PUT', body: 'two'}), {headers: {'X-Token': 'u', 'Content-Type': 'x/y'}})`, "PUT|u|x/y|two"},
+		{`const r = new Request('%s/a', {method: 'POST', body: 'three', headers: {'X-Token': 't'}}); const c = r.clone(); c.headers.set('X-Token', 'c'); fetch(c)`, "POST|c|text/plain;charset=UTF-8|three"},
+		{`fetch(new Request('%s/a', {method: 'POST', body: new Uint8Array([52])}), {body: 'five'})`, "POST||text/plain;charset=UTF-8|five"},
+	}
+
+	for i, c := range cases {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf("{"+c.Script+".then(res => res.headers.get('x-echo'))}", srv.URL), "fetch_request.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c.Expected, res.String())
+		}
+	}
+
+	// a fetch takes the body of the request, like a new Request does
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	val, err := ctx.RunScript(fmt.Sprintf(`const req = new Request('%s/a', {method: 'POST', body: 'x'});
+		fetch(req).then(() => [req.bodyUsed, fetch(req).catch(e => e instanceof TypeError)])
+			.then(([used, p]) => p.then(e => used + ',' + e))`, srv.URL), "fetch_request_used.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if expected := "true,true"; res.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, res.String())
+	}
+}
+
+func TestFetchSyntheticResponse(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"items":[1,2]}`))
+	}))
+	defer srv.Close()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	// a service worker like handler, answering either from the network or by itself
+	val, err := ctx.RunScript(fmt.Sprintf(`
+		const handle = (path) => path === "/local"
+			? Promise.resolve(new Response(JSON.stringify({items: [3]}), {status: 200, headers: {"Content-Type": "application/json"}}))
+			: fetch('%s' + path);
+
+		Promise.all([handle("/remote"), handle("/local")])
+			.then(responses => Promise.all(responses.map(res => res.clone().json().then(v => [res instanceof Response, res.ok, v.items.join("+")].join()))))
+			.then(results => results.join("|"))`, srv.URL), "fetch_synthetic_response.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if expected := "true,true,1+2|true,true,3"; res.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, res.String())
+	}
+}
+
+func TestFetchResponseHeaders(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("X-Multi", "1")
+		w.Header().Add("X-Multi", "2")
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => {
+			const h = res.headers;
+			return [
+				h instanceof Headers,
+				h === res.headers,
+				h.get("CONTENT-TYPE"),
+				h.get("x-multi"),
+				h.has("X-Missing"),
+				[...h.keys()].join(),
+			].join("|");
+		})`, srv.URL), "fetch_response_headers.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if expected := "true|true|application/json|1, 2|false|content-length,content-type,date,x-multi"; res.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, res.String())
+	}
+}
+
+func TestFetchRequestHeaders(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Name    string
+		Headers string
+		XA      []string
+		Accept  []string
+	}{
+		{"object", `{"X-A": "1", "accept": "text/html"}`, []string{"1"}, []string{"text/html"}},
+		{"pairs", `[["x-a", "1"], ["X-A", "2"]]`, []string{"1", "2"}, []string{"*/*"}},
+		{"headers", `new Headers([["x-a", "1"], ["x-a", "2"], ["Accept", "application/json"]])`, []string{"1", "2"}, []string{"application/json"}},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s', {headers: %s}).then(res => res.ok)`, srv.URL, c.Headers), "fetch_request_headers.js")
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		if _, err := waitForPromise(ctx, val); err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		h := <-received
+
+		if xa := h["X-A"]; fmt.Sprint(xa) != fmt.Sprint(c.XA) {
+			t.Errorf("%s: expected X-A %v but got %v", c.Name, c.XA, xa)
+		}
+
+		if accept := h["Accept"]; fmt.Sprint(accept) != fmt.Sprint(c.Accept) {
+			t.Errorf("%s: expected Accept %v but got %v", c.Name, c.Accept, accept)
+		}
+	}
+}
+
+func TestFetchInvalidRequestHeaders(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	val, err := ctx.RunScript(`fetch('/', {headers: [["x-a"]]}).catch(e => e instanceof TypeError)`, "fetch_invalid_headers.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !res.Boolean() {
+		t.Errorf("expected a TypeError but got '%s'", res.String())
+	}
+}
+
+func TestFetchAbort(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	if err := abort.InjectTo(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(10 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	val, err := ctx.RunScript(fmt.Sprintf(`const controller = new AbortController();
+		fetch('%s', {signal: controller.signal})
+			.then(() => "resolved", e => e instanceof DOMException && e.name)`, srv.URL), "fetch_abort.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := ctx.RunScript("controller.abort()", "fetch_abort.js"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("fetch should return soon after abort, but took %s", elapsed)
+	}
+
+	if res.String() != "AbortError" {
+		t.Errorf("expected an AbortError but got '%s'", res.String())
+	}
+}
+
+func TestFetchAbortedSignal(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	if err := abort.InjectTo(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer srv.Close()
+
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s', {signal: AbortSignal.abort("stop")})
+		.then(() => "resolved", e => e)`, srv.URL), "fetch_aborted_signal.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res.String() != "stop" {
+		t.Errorf("expected the abort reason 'stop' but got '%s'", res.String())
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("expected no request but got %d", n)
+	}
+}
+
+func TestFetchAbortAfterResponse(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	if err := abort.InjectTo(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("done"))
+	}))
+	defer srv.Close()
+
+	val, err := ctx.RunScript(fmt.Sprintf(`const controller = new AbortController();
+		fetch('%s', {signal: controller.signal}).then(res => {
+			controller.abort();
+			return res.text();
+		})`, srv.URL), "fetch_abort_after_response.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res.String() != "done" {
+		t.Errorf("expected 'done' but got '%s'", res.String())
+	}
+}
+
+func TestFetchAbortSignalTimeout(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-time.After(2 * time.Second):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		_, _ = w.Write([]byte("done"))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Path     string
+		Timeout  int
+		Expected string
+		Elapsed  time.Duration
+	}{
+		{"/slow", 100, "TimeoutError", time.Second},
+		{"/fast", 1000, "done", time.Second},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		if err := abort.InjectTo(ctx); err != nil {
+			t.Error(err)
+			return
+		}
+
+		start := time.Now()
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s%s', {signal: AbortSignal.timeout(%d)})
+			.then(res => res.text(), e => e instanceof DOMException && e.name)`, srv.URL, c.Path, c.Timeout), "fetch_abort_signal_timeout.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Path, err)
+			continue
+		}
+
+		if elapsed := time.Since(start); elapsed > c.Elapsed {
+			t.Errorf("%s: expected to return in %s, but took %s", c.Path, c.Elapsed, elapsed)
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Path, c.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchRedirect(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/redirect/")); err == nil {
+			http.Redirect(w, r, "/target", code)
+			return
+		}
+
+		b, _ := ioutil.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, b)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Code     int
+		Redirect string
+		Expected string
+	}{
+		{301, "follow", "true 200 GET /target "},
+		{302, "follow", "true 200 GET /target "},
+		{303, "follow", "true 200 GET /target "},
+		{307, "follow", "true 200 POST /target data"},
+		{308, "follow", "true 200 POST /target data"},
+		{302, "manual", "false 302 /target"},
+		{308, "manual", "false 308 /target"},
+		{301, "error", "TypeError"},
+		{307, "error", "TypeError"},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s/redirect/%d', {method: 'POST', body: 'data', redirect: '%s'})
+			.then(async res => res.status >= 300
+				? [res.redirected, res.status, res.headers.get('location')].join(' ')
+				: [res.redirected, res.status, await res.text()].join(' '),
+			e => e instanceof TypeError ? 'TypeError' : String(e))`, srv.URL, c.Code, c.Redirect), "fetch_redirect.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%d %s: %v", c.Code, c.Redirect, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%d %s: expected '%s' but got '%s'", c.Code, c.Redirect, c.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchMaxRedirects(t *testing.T) {
+	t.Parallel()
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Max      int
+		Expected string
+		Requests int32
+	}{
+		{3, "TypeError: fetch: Get \"/loop\": stopped after 3 redirects, last url %s/loop", 4},
+		{0, "TypeError: fetch: Get \"/loop\": redirects are not allowed", 1},
+	}
+
+	for _, c := range cases {
+		atomic.StoreInt32(&requests, 0)
+
+		ctx, err := newV8ContextWithFetch(WithMaxRedirects(c.Max))
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s/loop')
+			.then(() => 'resolved', e => e instanceof TypeError ? 'TypeError: ' + e.message : String(e))`, srv.URL), "fetch_max_redirects.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("max %d: %v", c.Max, err)
+			continue
+		}
+
+		expected := c.Expected
+		if strings.Contains(expected, "%s") {
+			expected = fmt.Sprintf(expected, srv.URL)
+		}
+
+		if res.String() != expected {
+			t.Errorf("max %d: expected '%s' but got '%s'", c.Max, expected, res.String())
+		}
+
+		if n := atomic.LoadInt32(&requests); n != c.Requests {
+			t.Errorf("max %d: expected %d requests but got %d", c.Max, c.Requests, n)
+		}
+	}
+}
+
+func TestFetchRedirectURL(t *testing.T) {
+	t.Parallel()
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hop2":
+			// relative to the absolute url of this hop
+			w.Header().Set("Location", "final?x=1")
+			w.WriteHeader(http.StatusFound)
+		default:
+			_, _ = w.Write([]byte("done"))
+		}
+	}))
+	defer target.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/start":
+			w.Header().Set("Location", "/hop1")
+			w.WriteHeader(http.StatusMovedPermanently)
+		case "/hop1":
+			w.Header().Set("Location", target.URL+"/hop2")
+			w.WriteHeader(http.StatusTemporaryRedirect)
+		default:
+			_, _ = w.Write([]byte("no redirect"))
+		}
+	}))
+	defer srv.Close()
+
+	u, _ := stdurl.Parse(srv.URL)
+
+	cases := []struct {
+		URL      string
+		Expected string
+	}{
+		{srv.URL + "/start", fmt.Sprintf("true %s/final?x=1", target.URL)},
+		{fmt.Sprintf("http://user:pass@%s/plain#fragment", u.Host), fmt.Sprintf("false http://%s/plain", u.Host)},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => res.redirected + ' ' + res.url)`, c.URL), "fetch_redirect_url.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.URL, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.URL, c.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchCookieJar(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t", Path: "/"})
+			return
+		}
+
+		_, _ = w.Write([]byte(r.Header.Get("Cookie")))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Name     string
+		Opts     []Option
+		Expected string
+	}{
+		{"jar", []Option{WithCookieJar(NewCookieJar())}, "session=s3cr3t"},
+		{"no jar", nil, ""},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch(c.Opts...)
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%[1]s/login', {method: 'POST'})
+			.then(() => Promise.all([fetch('%[1]s/echo'), fetch('%[1]s/echo')]))
+			.then(all => Promise.all(all.map(res => res.text())))
+			.then(texts => texts[0] === texts[1] ? texts[0] : texts.join())`, srv.URL), "fetch_cookie_jar.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchCredentials(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch(WithCookieJar(NewCookieJar()))
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name := strings.TrimPrefix(r.URL.Path, "/set/"); name != r.URL.Path {
+			http.SetCookie(w, &http.Cookie{Name: name, Value: "1", Path: "/"})
+		}
+
+		_, _ = w.Write([]byte(r.Header.Get("Cookie")))
+	}))
+	defer srv.Close()
+
+	val, err := ctx.RunScript(fmt.Sprintf(`(async () => {
+			const text = (path, credentials) => fetch('%s' + path, {credentials}).then(res => res.text());
+			return [
+				await text('/set/session'),
+				await text('/echo'),
+				await text('/echo', 'omit'),
+				await text('/set/omitted', 'omit'),
+				await text('/echo', 'include'),
+			].join('|');
+		})()`, srv.URL), "fetch_credentials.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if expected := "|session=1|||session=1"; res.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, res.String())
+	}
+
+	val, err = ctx.RunScript(fmt.Sprintf(`fetch('%s', {credentials: 'always'}).then(() => 'resolved', e => e instanceof TypeError)`, srv.URL), "fetch_credentials.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := waitForPromise(ctx, val); err != nil || !res.Boolean() {
+		t.Errorf("expected a TypeError but got %v, %v", res, err)
+	}
+}
+
+type recordingTransport struct {
+	mu   sync.Mutex
+	urls []string
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.urls = append(t.urls, req.URL.Path)
+	t.mu.Unlock()
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestFetchWithHTTPClient(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/target", http.StatusFound)
+			return
+		}
+
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	transport := &recordingTransport{}
+	client := &http.Client{Transport: transport}
+
+	ctx, err := newV8ContextWithFetch(WithHTTPClient(client), WithMaxRedirects(0))
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	val, err := ctx.RunScript(fmt.Sprintf(`Promise.all([
+			fetch('%[1]s/a').then(res => res.text()),
+			fetch('%[1]s/b', {method: 'POST', body: 'b'}).then(res => res.text()),
+			fetch('%[1]s/redirect').then(() => 'resolved', e => e instanceof TypeError ? 'TypeError' : String(e)),
+		]).then(all => all.join())`, srv.URL), "fetch_with_http_client.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if expected := "/a,/b,TypeError"; res.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, res.String())
+	}
+
+	transport.mu.Lock()
+	urls := append([]string(nil), transport.urls...)
+	transport.mu.Unlock()
+	sort.Strings(urls)
+
+	if expected := "[/a /b /redirect]"; fmt.Sprint(urls) != expected {
+		t.Errorf("expected requests %s but got %v", expected, urls)
+	}
+
+	if client.CheckRedirect != nil {
+		t.Error("the client should not be modified")
+	}
+}
+
+// newForwardProxy records the requests it gets, it forwards absolute-URI
+// requests and tunnels CONNECT ones
+func newForwardProxy(received chan<- *http.Request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+
+		if r.Method != http.MethodConnect {
+			out := r.Clone(r.Context())
+			out.RequestURI = ""
+			out.Header.Del("Proxy-Authorization")
+
+			res, err := http.DefaultTransport.RoundTrip(out)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer res.Body.Close()
+
+			w.WriteHeader(res.StatusCode)
+			_, _ = io.Copy(w, res.Body)
+			return
+		}
+
+		dst, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer dst.Close()
+
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+		go func() { _, _ = io.Copy(dst, buf) }()
+		_, _ = io.Copy(conn, dst)
+	}))
+}
+
+func TestFetchWithProxy(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan *http.Request, 1)
+	proxy := newForwardProxy(received)
+	defer proxy.Close()
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("proxied"))
+	}))
+	defer target.Close()
+
+	tlsTarget := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsTarget.Close()
+
+	proxyURL, _ := stdurl.Parse(proxy.URL)
+	proxyURL.User = stdurl.UserPassword("user", "pass")
+
+	auth := "Basic dXNlcjpwYXNz"
+
+	cases := []struct {
+		Name     string
+		Proxy    interface{}
+		URL      string
+		Method   string
+		Expected string
+	}{
+		{"string", proxyURL.String(), target.URL + "/path", http.MethodGet, "proxied"},
+		{"func", func(*http.Request) (*stdurl.URL, error) { return proxyURL, nil }, target.URL + "/path", http.MethodGet, "proxied"},
+		// the test CA isn't trusted, but the tunnel is there before that fails
+		{"connect", proxyURL, tlsTarget.URL + "/path", http.MethodConnect, "rejected"},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch(WithProxy(c.Proxy))
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => res.text(), () => 'rejected')`, c.URL), "fetch_with_proxy.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res.String())
+		}
+
+		r := <-received
+
+		if r.Method != c.Method {
+			t.Errorf("%s: expected a %s request but got %s", c.Name, c.Method, r.Method)
+		}
+
+		if c.Method == http.MethodConnect {
+			if u, _ := stdurl.Parse(tlsTarget.URL); r.Host != u.Host {
+				t.Errorf("%s: expected CONNECT to %s but got %s", c.Name, u.Host, r.Host)
+			}
+		} else if r.RequestURI != c.URL {
+			t.Errorf("%s: expected the absolute uri %s but got %s", c.Name, c.URL, r.RequestURI)
+		}
+
+		if v := r.Header.Get("Proxy-Authorization"); v != auth {
+			t.Errorf("%s: expected Proxy-Authorization '%s' but got '%s'", c.Name, auth, v)
+		}
+	}
+}
+
+func TestWithProxyInvalid(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Proxy    interface{}
+		Expected string
+	}{
+		{"http://proxy.local:bad", "v8go-polyfills/fetch: invalid proxy url http://proxy.local:bad: "},
+		{42, "v8go-polyfills/fetch: unsupported proxy type int"},
+		{http.ProxyFromEnvironment, ""},
+	}
+
+	for i, c := range cases {
+		_, err := NewFetcher(WithProxy(c.Proxy))
+
+		switch {
+		case c.Expected == "" && err != nil:
+			t.Errorf("case %d: expected no error but got '%v'", i, err)
+		case c.Expected != "" && (err == nil || !strings.HasPrefix(err.Error(), c.Expected)):
+			t.Errorf("case %d: expected '%s' but got '%v'", i, c.Expected, err)
+		}
+	}
+}
+
+// newTLSServerWithCA starts a TLS server with a certificate of a generated CA
+func newTLSServerWithCA(t *testing.T, handler http.Handler, dnsNames ...string) (*httptest.Server, *x509.CertPool) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "v8go-polyfills test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     dnsNames,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(handler)
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	srv.StartTLS()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	return srv, pool
+}
+
+func TestFetchWithTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	srv, pool := newTLSServerWithCA(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("secure"))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Name     string
+		Opts     []Option
+		Expected string
+	}{
+		{"pool", []Option{WithTLSConfig(&tls.Config{RootCAs: pool})}, "secure"},
+		{"insecure", []Option{WithInsecureSkipVerify()}, "secure"},
+		{"default", nil, "certificate signed by unknown authority"},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch(c.Opts...)
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => res.text(), e => { throw String(e.cause) })`, srv.URL), "fetch_with_tls_config.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+
+		var got string
+		switch {
+		case err != nil:
+			got = err.Error()
+		default:
+			got = res.String()
+		}
+
+		if !strings.Contains(got, c.Expected) {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, got)
+		}
+	}
+}
+
+func TestFetchMaxBodySize(t *testing.T) {
+	t.Parallel()
+
+	// 16MB of zeros, about 16KB on the wire
+	var bomb bytes.Buffer
+	gw := gzip.NewWriter(&bomb)
+	_, _ = gw.Write(make([]byte, 16<<20))
+	_ = gw.Close()
+
+	const limit = 1 << 20
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bomb":
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(bomb.Bytes())
+		default:
+			_, _ = w.Write(make([]byte, limit))
+		}
+	})
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	ctx, err := newV8ContextWithFetch(WithMaxBodySize(limit), WithLocalHandler(handler))
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	cases := []struct {
+		URL      string
+		Expected string
+	}{
+		{srv.URL + "/bomb", fmt.Sprintf("fetch: response body of %s/bomb exceeds the limit of %d bytes", srv.URL, limit)},
+		{srv.URL + "/exact", fmt.Sprint(limit)},
+		{"/bomb", fmt.Sprintf("fetch: response body of /bomb exceeds the limit of %d bytes", limit)},
+	}
+
+	for _, c := range cases {
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => res.arrayBuffer()).then(buf => String(buf.byteLength), e => String(e))`, c.URL), "fetch_max_body_size.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.URL, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.URL, c.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchZstd(t *testing.T) {
+	t.Parallel()
+
+	const text = "hello, zstd"
+
+	var zstdBody bytes.Buffer
+	zw, _ := zstd.NewWriter(&zstdBody)
+	_, _ = zw.Write([]byte(text))
+	_ = zw.Close()
+
+	// zstd is applied first, so it's decoded last
+	var zstdGzipBody bytes.Buffer
+	gw := gzip.NewWriter(&zstdGzipBody)
+	_, _ = gw.Write(zstdBody.Bytes())
+	_ = gw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Accept-Encoding", r.Header.Get("Accept-Encoding"))
+
+		switch r.URL.Path {
+		case "/zstd":
+			w.Header().Set("Content-Encoding", "zstd")
+			_, _ = w.Write(zstdBody.Bytes())
+		case "/zstd-gzip":
+			w.Header().Set("Content-Encoding", "zstd, gzip")
+			_, _ = w.Write(zstdGzipBody.Bytes())
+		}
+	}))
+	defer srv.Close()
+
+	for _, path := range []string{"/zstd", "/zstd-gzip"} {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s%s').then(res => res.text().then(text => [res.headers.get('x-accept-encoding'), text].join('|')))`, srv.URL, path), "fetch_zstd.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+
+		if expected := "gzip, deflate, br, zstd|" + text; res.String() != expected {
+			t.Errorf("%s: expected '%s' but got '%s'", path, expected, res.String())
+		}
+	}
+}
+
+func TestFetchContentEncoding(t *testing.T) {
+	t.Parallel()
+
+	var gzipBody bytes.Buffer
+	gw := gzip.NewWriter(&gzipBody)
+	_, _ = gw.Write([]byte("decoded"))
+	_ = gw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", r.URL.Query().Get("encoding"))
+
+		if r.URL.Query().Get("encoding") == "compress" {
+			_, _ = w.Write([]byte("raw"))
+			return
+		}
+		_, _ = w.Write(gzipBody.Bytes())
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Name     string
+		Opts     []Option
+		Encoding string
+		Expected string
+	}{
+		{"x-gzip", nil, "x-gzip", "decoded"},
+		{"parameters", nil, "GZIP;q=1.0", "decoded"},
+		{"quoted", nil, `"gzip"`, "decoded"},
+		{"unknown", nil, "compress", "raw"},
+		{"strict x-gzip", []Option{WithStrictContentEncoding()}, "x-gzip", "decoded"},
+		{"strict unknown", []Option{WithStrictContentEncoding()}, "compress", fmt.Sprintf("fetch: unsupported content encoding compress of %s/?encoding=compress", srv.URL)},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch(c.Opts...)
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s/?encoding=' + encodeURIComponent('%s')).then(res => res.text(), e => String(e))`, srv.URL, c.Encoding), "fetch_content_encoding.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchAcceptEncoding(t *testing.T) {
+	t.Parallel()
+
+	var gzipBody bytes.Buffer
+	gw := gzip.NewWriter(&gzipBody)
+	_, _ = gw.Write([]byte("decoded"))
+	_ = gw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept := r.Header.Get("Accept-Encoding")
+		w.Header().Set("X-Accept-Encoding", accept)
+
+		switch {
+		case r.URL.Path == "/compress":
+			w.Header().Set("Content-Encoding", "compress")
+			_, _ = w.Write([]byte("raw"))
+		case strings.Contains(accept, "gzip"):
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(gzipBody.Bytes())
+		default:
+			_, _ = w.Write([]byte("decoded"))
+		}
+	}))
+	defer srv.Close()
+
+	dropHeader := WithRequestHook(func(req *http.Request) error {
+		req.Header.Del("Accept-Encoding")
+		return nil
+	})
+
+	cases := []struct {
+		Name     string
+		Opts     []Option
+		Path     string
+		Init     string
+		Expected string
+	}{
+		{"implicit", nil, "/", "{}", DefaultAcceptEncoding + "|decoded"},
+		{"option", []Option{WithAcceptEncodings("br", "GZIP")}, "/", "{}", "br, gzip|decoded"},
+		{"no encodings", []Option{WithAcceptEncodings()}, "/", "{}", "identity|decoded"},
+		{"explicit", nil, "/", "{ headers: { 'Accept-Encoding': 'gzip' } }", "gzip|decoded"},
+		{"explicit identity", nil, "/", "{ headers: { 'Accept-Encoding': 'identity' } }", "identity|decoded"},
+		// the transport isn't asked for gzip, so it doesn't decode
+		{"removed by a hook", []Option{dropHeader}, "/", "{}", "identity|decoded"},
+		{"removed with a transport", []Option{dropHeader, WithMaxIdleConns(1)}, "/", "{}", "identity|decoded"},
+		{"unsupported", nil, "/compress", "{}", DefaultAcceptEncoding + "|raw"},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch(c.Opts...)
+		if err != nil {
+			t.Errorf("%s: %s", c.Name, err)
+			continue
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s%s', %s).then(res => res.text().then(text => res.headers.get('x-accept-encoding') + '|' + text))`,
+			srv.URL, c.Path, c.Init), "fetch_accept_encoding.js")
+		if err != nil {
+			t.Errorf("%s: %s", c.Name, err)
+			continue
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res.String())
+		}
+	}
+
+	if _, err := NewFetcher(WithAcceptEncodings("gzip", "compress")); err == nil || !strings.Contains(err.Error(), `"compress"`) {
+		t.Errorf("expected an unsupported accept encoding error but got %v", err)
+	}
+}
+
+func TestFetchCharset(t *testing.T) {
+	t.Parallel()
+
+	shiftJIS, _ := japanese.ShiftJIS.NewEncoder().Bytes([]byte("日本語"))
+	utf16, _ := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().Bytes([]byte("hi €"))
+
+	fixtures := map[string]struct {
+		ContentType string
+		Body        []byte
+	}{
+		"/latin1":    {"text/plain; charset=ISO-8859-1", []byte{'c', 'a', 'f', 0xe9}},
+		"/shift_jis": {"text/html; charset=Shift_JIS", shiftJIS},
+		"/bom":       {"text/plain", utf16},
+		"/bom-wins":  {"text/plain; charset=Shift_JIS", append([]byte{0xef, 0xbb, 0xbf}, "ok"...)},
+		"/unknown":   {"text/plain; charset=x-unknown", []byte("caf\xc3\xa9")},
+		"/utf8":      {"text/plain", []byte("caf\xc3\xa9")},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := fixtures[r.URL.Path]
+		w.Header().Set("Content-Type", f.ContentType)
+		_, _ = w.Write(f.Body)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Path     string
+		Expected string
+	}{
+		{"/latin1", "café|4"},
+		{"/shift_jis", "日本語|6"},
+		{"/bom", "hi €|10"},
+		{"/bom-wins", "ok|5"},
+		{"/unknown", "café|5"},
+		{"/utf8", "café|5"},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s%s').then(res => res.clone().arrayBuffer().then(buf => res.text().then(text => text + '|' + buf.byteLength)))`, srv.URL, c.Path), "fetch_charset.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Path, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Path, c.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchDeflate(t *testing.T) {
+	t.Parallel()
+
+	const text = "deflated body, deflated body, deflated body"
+
+	var zlibBody, rawBody bytes.Buffer
+	zw := zlib.NewWriter(&zlibBody)
+	_, _ = zw.Write([]byte(text))
+	_ = zw.Close()
+
+	fw, _ := flate.NewWriter(&rawBody, flate.BestCompression)
+	_, _ = fw.Write([]byte(text))
+	_ = fw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "deflate")
+
+		if r.URL.Path == "/zlib" {
+			_, _ = w.Write(zlibBody.Bytes())
+			return
+		}
+		_, _ = w.Write(rawBody.Bytes())
+	}))
+	defer srv.Close()
+
+	for _, path := range []string{"/zlib", "/raw"} {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s%s').then(res => res.text(), e => String(e))`, srv.URL, path), "fetch_deflate.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+
+		if res.String() != text {
+			t.Errorf("%s: expected '%s' but got '%s'", path, text, res.String())
+		}
+	}
+}
+
+func TestFetchStatusText(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// the status lines are written by hand, net/http always sends the standard phrase
+	statusLines := map[string]string{
+		"/custom": "HTTP/1.1 200 Everything Fine",
+		"/empty":  "HTTP/1.1 404",
+		"/space":  "HTTP/1.1 503 ",
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+
+				_, _ = fmt.Fprintf(conn, "%s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", statusLines[req.URL.Path])
+			}()
+		}
+	}()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		URL      string
+		Expected string
+	}{
+		{"http://" + ln.Addr().String() + "/custom", "200|Everything Fine|200 Everything Fine"},
+		{"http://" + ln.Addr().String() + "/empty", "404|Not Found|404"},
+		{"http://" + ln.Addr().String() + "/space", "503|Service Unavailable|503 "},
+		{srv.URL, "418|I'm a teapot|418 I'm a teapot"},
+		{"data:,", "200|OK|200 OK"},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => [
+			res.status, res.statusText, res[Symbol.for('v8go-polyfills.Response.rawStatus')],
+		].join('|'))`, c.URL), "fetch_status_text.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.URL, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.URL, c.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchSetCookie(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "a=1; Path=/")
+		w.Header().Add("Set-Cookie", "b=2; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+		w.Header().Set("X-Other", "1")
+	}))
+	defer srv.Close()
+
+	script := fmt.Sprintf(`fetch('%s').then(res => JSON.stringify([
+		res.headers.getSetCookie(), res.headers.get('set-cookie'), [...res.headers.keys()],
+	]))`, srv.URL)
+
+	cases := []struct {
+		Options  []Option
+		Expected string
+	}{
+		{nil, `[["a=1; Path=/","b=2; Expires=Wed, 21 Oct 2026 07:28:00 GMT"],null,["content-length","date","x-other"]]`},
+		{
+			[]Option{WithExposeSetCookie()},
+			`[["a=1; Path=/","b=2; Expires=Wed, 21 Oct 2026 07:28:00 GMT"],"a=1; Path=/, b=2; Expires=Wed, 21 Oct 2026 07:28:00 GMT",["content-length","date","set-cookie","set-cookie","x-other"]]`,
+		},
+	}
+
+	for i, c := range cases {
+		ctx, err := newV8ContextWithFetch(c.Options...)
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(script, "fetch_set_cookie.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchNullBody(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/not-modified":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.WriteHeader(http.StatusNotModified)
+		case "/no-content":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Content-Encoding", "gzip")
+			gw := gzip.NewWriter(w)
+			_, _ = gw.Write([]byte("1"))
+			_ = gw.Close()
+		}
+	})
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	// unlike a server, the recorder of a local handler keeps the stray bytes
+	local := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		if r.URL.Path == "/not-modified" {
+			w.WriteHeader(http.StatusNotModified)
+		}
+		_, _ = w.Write([]byte("stray"))
+	})
+
+	cases := []struct {
+		URL      string
+		Method   string
+		Expected string
+	}{
+		{srv.URL + "/not-modified", "GET", `304,true,"",true`},
+		{srv.URL + "/no-content", "GET", `204,true,"",true`},
+		{srv.URL + "/ok", "HEAD", `200,true,"",true`},
+		{srv.URL + "/ok", "GET", `200,false,"1",false`},
+		{"/not-modified", "GET", `304,true,"",true`},
+		{"/ok", "HEAD", `200,true,"",true`},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch(WithLocalHandler(local))
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s', {method: '%s'}).then(res => {
+			const isNull = res.body === null;
+			return res.clone().text().then(text => res.json().then(() => false, e => e instanceof SyntaxError)
+				.then(failed => [res.status, isNull, JSON.stringify(text), failed].join()));
+		})`, c.URL, c.Method), "fetch_null_body.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s %s: %v", c.Method, c.URL, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s %s: expected '%s' but got '%s'", c.Method, c.URL, c.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchNetworkError(t *testing.T) {
+	t.Parallel()
+
+	// nothing listens on the address of a closed server
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(10 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	cases := []struct {
+		Name     string
+		Opts     []Option
+		URL      string
+		Expected string
+	}{
+		{"refused", nil, closed.URL + "/a", fmt.Sprintf("TypeError|Failed to fetch|true|true|%s/a", closed.URL)},
+		{"timeout", []Option{WithDefaultTimeout(50 * time.Millisecond)}, slow.URL, "TimeoutError|fetch: Get \"" + slow.URL + "\": context deadline exceeded|false|false|"},
+		{"blocked", []Option{WithBlockedHosts("127.0.0.1")}, slow.URL, "TypeError|fetch: host not allowed: 127.0.0.1|false|false|"},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch(c.Opts...)
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(() => "resolved", e => [
+			e.name, e.message, e instanceof TypeError && e.cause instanceof Error,
+			String(e.cause).includes("connect: connection refused"), e.url || "",
+		].join("|"))`, c.URL), "fetch_network_error.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchHeaderPrecedence(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%s|%s|%s|%s|%d", r.Header.Get("User-Agent"), r.Header.Get("Accept"),
+			r.Header.Get("Authorization"), r.Host, r.ContentLength)
+	}))
+	defer srv.Close()
+
+	host := srv.Listener.Addr().String()
+	provider := WithUserAgentProvider(UserAgentProviderFunc(func(*stdurl.URL) string { return "provider/1.0" }))
+	defaults := WithDefaultHeaders(http.Header{"user-agent": {"default/1.0"}, "Authorization": {"Bearer default"}})
+
+	for i, tc := range []struct {
+		Options  []Option
+		Init     string
+		Expected string
+	}{
+		{
+			Expected: fmt.Sprintf("%s|*/*||%s|0", UserAgent(), host),
+		},
+		{
+			Init:     "{headers: {'User-Agent': 'my-bot/2.0', accept: 'text/plain'}}",
+			Expected: fmt.Sprintf("my-bot/2.0|text/plain||%s|0", host),
+		},
+		{
+			Options:  []Option{provider},
+			Expected: fmt.Sprintf("provider/1.0|*/*||%s|0", host),
+		},
+		{
+			Options:  []Option{provider},
+			Init:     "{headers: {'User-Agent': 'my-bot/2.0'}}",
+			Expected: fmt.Sprintf("my-bot/2.0|*/*||%s|0", host),
+		},
+		{
+			Options:  []Option{provider, defaults},
+			Expected: fmt.Sprintf("default/1.0|*/*|Bearer default|%s|0", host),
+		},
+		{
+			Options:  []Option{provider, defaults},
+			Init:     "{headers: {'User-Agent': 'my-bot/2.0', Authorization: 'Bearer init'}}",
+			Expected: fmt.Sprintf("my-bot/2.0|*/*|Bearer init|%s|0", host),
+		},
+		{
+			Init:     "{headers: {Host: 'api.example.com', 'Content-Length': '10', 'Transfer-Encoding': 'chunked', 'Proxy-Authorization': 'Basic eA=='}}",
+			Expected: fmt.Sprintf("%s|*/*||%s|0", UserAgent(), host),
+		},
+	} {
+		records := &logRecords{}
+		opts := append([]Option{WithLogger(slog.New(recordHandler{records: records}))}, tc.Options...)
+
+		ctx, err := newV8ContextWithFetch(opts...)
+		if err != nil {
+			t.Fatalf("create v8: %s", err)
+		}
+
+		init := tc.Init
+		if init == "" {
+			init = "{}"
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf("fetch('%s', %s).then(res => res.text())", srv.URL, init), "fetch_header_precedence.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("case %d: %s", i, err)
+			continue
+		}
+
+		if res.String() != tc.Expected {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, tc.Expected, res.String())
+		}
+
+		var ignored []string
+		records.mu.Lock()
+		for _, r := range records.records {
+			if r["msg"] == "forbidden header ignored" && r["level"] == "DEBUG" {
+				ignored = append(ignored, r["header"])
+			}
+		}
+		records.mu.Unlock()
+
+		if strings.Contains(tc.Init, "Host") && len(ignored) != 4 {
+			t.Errorf("case %d: expected 4 ignored headers but got %v", i, ignored)
+		} else if !strings.Contains(tc.Init, "Host") && len(ignored) != 0 {
+			t.Errorf("case %d: expected no ignored headers but got %v", i, ignored)
+		}
+	}
+}
+
+func TestFetchRequestContentEncoding(t *testing.T) {
+	t.Parallel()
+
+	// the server decodes the body once, and tells how it was sent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		switch enc := r.Header.Get("Content-Encoding"); enc {
+		case "gzip":
+			gr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = gr
+		case "br":
+			body = brotli.NewReader(r.Body)
+		case "deflate":
+			zr, err := zlib.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = zr
+		case "zstd":
+			zr, err := zstd.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer zr.Close()
+			body = zr
+		}
+
+		b, err := io.ReadAll(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		_, _ = fmt.Fprintf(w, "%x|%d|%s", sha256.Sum256(b), r.ContentLength, r.Header.Get("Content-Encoding"))
+	}))
+	defer srv.Close()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Fatalf("create v8: %s", err)
+	}
+
+	const size = 1 << 20
+	sum := sha256.Sum256(bytes.Repeat([]byte("a"), size))
+
+	for _, enc := range []string{"gzip", "br", "deflate", "zstd"} {
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s', {
+			method: 'POST',
+			headers: {'Content-Encoding': '%s'},
+			body: 'a'.repeat(%d),
+		}).then(res => res.text())`, srv.URL, enc, size), "fetch_request_content_encoding.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %s", enc, err)
+			continue
+		}
+
+		parts := strings.Split(res.String(), "|")
+		if len(parts) != 3 {
+			t.Errorf("%s: unexpected response '%s'", enc, res.String())
+			continue
+		}
+
+		if parts[0] != fmt.Sprintf("%x", sum) {
+			t.Errorf("%s: the decoded body differs", enc)
+		}
+		if n, _ := strconv.Atoi(parts[1]); n <= 0 || n >= size {
+			t.Errorf("%s: expected the compressed content length but got %s", enc, parts[1])
+		}
+		if parts[2] != enc {
+			t.Errorf("%s: expected the content encoding '%s' but got '%s'", enc, enc, parts[2])
+		}
+	}
+
+	// bytes the script compressed itself are sent as they are
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	_, _ = gw.Write([]byte("hello"))
+	_ = gw.Close()
+
+	compressed := make([]string, buf.Len())
+	for i, b := range buf.Bytes() {
+		compressed[i] = strconv.Itoa(int(b))
+	}
+
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s', {
+		method: 'POST',
+		headers: {'Content-Encoding': 'gzip'},
+		body: new Uint8Array([%s]).buffer,
+	}).then(res => res.text())`, srv.URL, strings.Join(compressed, ",")), "fetch_request_precompressed.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := fmt.Sprintf("%x|%d|gzip", sha256.Sum256([]byte("hello")), buf.Len()); res.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, res.String())
+	}
+
+	// without the header the body is sent unchanged
+	val, err = ctx.RunScript(fmt.Sprintf("fetch('%s', {method: 'POST', body: 'hello'}).then(res => res.text())", srv.URL), "fetch_request_identity.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = waitForPromise(ctx, val)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := fmt.Sprintf("%x|5|", sha256.Sum256([]byte("hello"))); res.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, res.String())
+	}
+}
+
+func TestFetchTrailers(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		if r.URL.Path == "/gzip" {
+			w.Header().Set("Content-Encoding", "gzip")
+			gw := gzip.NewWriter(w)
+			_, _ = gw.Write([]byte("hello"))
+			_ = gw.Close()
+		} else {
+			_, _ = w.Write([]byte("hello"))
+			w.(http.Flusher).Flush()
+		}
+
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "OK")
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		Name     string
+		Script   string
+		Expected string
+	}{
+		{
+			Name: "after the body",
+			Script: fmt.Sprintf(`fetch('%s').then(async res => {
+				const before = [...res.trailers].length;
+				const text = await res.text();
+				return [before, text, res.trailers.get('grpc-status'), res.trailers.get('grpc-message')].join();
+			})`, srv.URL),
+			Expected: "0,hello,0,OK",
+		},
+		{
+			Name:     "decoded body",
+			Script:   fmt.Sprintf("fetch('%s/gzip').then(async res => [await res.text(), ...res.trailers].join())", srv.URL),
+			Expected: "hello,grpc-message,OK,grpc-status,0",
+		},
+		{
+			Name: "streamed body",
+			Script: fmt.Sprintf(`fetch('%s').then(async res => {
+				const reader = res.body.getReader();
+				while (!(await reader.read()).done) {}
+				return res.trailers.get('grpc-status');
+			})`, srv.URL),
+			Expected: "0",
+		},
+		{
+			Name:     "no trailers",
+			Script:   "Promise.resolve(new Response('x').trailers instanceof Headers && [...new Response('x').trailers].length)",
+			Expected: "0",
+		},
+	} {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Fatalf("create v8: %s", err)
+		}
+
+		val, err := ctx.RunScript(tc.Script, "fetch_trailers.js")
+		if err != nil {
+			t.Fatalf("%s: %s", tc.Name, err)
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %s", tc.Name, err)
+			continue
+		}
+
+		if res.String() != tc.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", tc.Name, tc.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchTaskQueue(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Query().Get("n")))
+	}))
+	defer srv.Close()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Isolate().Dispose()
+	defer ctx.Close()
+
+	const fetches = 200
+
+	script := fmt.Sprintf(`
+		globalThis.sum = 0;
+		globalThis.proms = Promise.all(Array.from({ length: %d }, (_, i) =>
+			fetch('%s/?n=' + i).then(res => res.text()).then(text => { sum += Number(text) })));
+	`, fetches, srv.URL)
+	if _, err := ctx.RunScript(script, "fetch_task_queue.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := ctx.RunScript("proms", "fetch_task_queue.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := val.AsPromise()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the scripts run while the responses land, they're settled in between
+	deadline := time.Now().Add(10 * time.Second)
+	for p.State() == v8go.Pending && time.Now().Before(deadline) {
+		if _, err := ctx.RunScript("JSON.stringify(Array.from({ length: 100 }, (_, i) => ({ i, sum })))", "busy.js"); err != nil {
+			t.Fatal(err)
+		}
+
+		ProcessTasks(ctx.Isolate())
+	}
+
+	if p.State() != v8go.Fulfilled {
+		t.Fatalf("expected the fetches to be fulfilled but got %v", p.State())
+	}
+
+	sum, err := ctx.RunScript("sum", "fetch_task_queue.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := int64(fetches * (fetches - 1) / 2); sum.Integer() != expected {
+		t.Errorf("expected the sum %d but got %d", expected, sum.Integer())
+	}
+}
+
+func TestFetcherFetch(t *testing.T) {
+	t.Parallel()
+
+	var gzipBody bytes.Buffer
+	gw := gzip.NewWriter(&gzipBody)
+	_, _ = gw.Write([]byte("decoded"))
+	_ = gw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/target", http.StatusFound)
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(gzipBody.Bytes())
+		default:
+			b, _ := ioutil.ReadAll(r.Body)
+			fmt.Fprintf(w, "%s %s %s %s", r.Method, r.URL.Path, r.Header.Get("X-Test"), b)
+		}
+	}))
+	defer srv.Close()
+
+	local := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "local %s %s", r.Host, r.URL.Path)
+	})
+
+	f, err := NewFetcher(WithLocalHandler(local), WithLocalHandlerFor("api.internal", local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	body := "payload"
+
+	cases := []struct {
+		Name       string
+		URL        string
+		Init       RequestInit
+		Status     int32
+		Redirected bool
+		URLAfter   string
+		Body       string
+		Err        string
+	}{
+		{Name: "get", URL: srv.URL + "/get", Status: 200, URLAfter: srv.URL + "/get", Body: "GET /get  "},
+		{
+			Name: "post", URL: srv.URL + "/post",
+			Init:   RequestInit{Method: "post", Headers: HeadersInit{{"X-Test", "1"}}, Body: &body},
+			Status: 200, URLAfter: srv.URL + "/post", Body: "POST /post 1 payload",
+		},
+		{Name: "redirect", URL: srv.URL + "/redirect", Status: 200, Redirected: true, URLAfter: srv.URL + "/target", Body: "GET /target  "},
+		{Name: "manual redirect", URL: srv.URL + "/redirect", Init: RequestInit{Redirect: "manual"}, Status: 302, URLAfter: srv.URL + "/redirect"},
+		{Name: "redirect error", URL: srv.URL + "/redirect", Init: RequestInit{Redirect: "error"}, Err: "redirects are not allowed"},
+		{Name: "gzip", URL: srv.URL + "/gzip", Status: 200, URLAfter: srv.URL + "/gzip", Body: "decoded"},
+		{Name: "local", URL: "/path", Status: 200, URLAfter: "/path", Body: "local  /path"},
+		{Name: "local host", URL: "http://api.internal/path", Status: 200, URLAfter: "http://api.internal/path", Body: "local api.internal /path"},
+		{Name: "data", URL: "data:,abc", Status: 200, URLAfter: "data:,abc", Body: "abc"},
+		{Name: "invalid url", URL: "ftp://example.com", Err: "ftp"},
+	}
+
+	for _, tc := range cases {
+		res, err := f.Fetch(context.Background(), tc.URL, tc.Init)
+		if tc.Err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.Err) {
+				t.Errorf("%s: expected an error with '%s' but got %v", tc.Name, tc.Err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.Name, err)
+			continue
+		}
+
+		if err := res.ReadBody(); err != nil {
+			t.Errorf("%s: %s", tc.Name, err)
+			continue
+		}
+
+		if res.Status != tc.Status || res.Redirected != tc.Redirected || res.URL != tc.URLAfter {
+			t.Errorf("%s: expected %d, redirected %v, url %s but got %d, redirected %v, url %s",
+				tc.Name, tc.Status, tc.Redirected, tc.URLAfter, res.Status, res.Redirected, res.URL)
+		}
+
+		if tc.Body != "" && string(res.Body) != tc.Body {
+			t.Errorf("%s: expected the body '%s' but got '%s'", tc.Name, tc.Body, res.Body)
+		}
+	}
+
+	// cancelling ctx aborts the request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := f.Fetch(ctx, srv.URL, RequestInit{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the request to be cancelled but got %v", err)
+	}
+
+	_ = f.Close()
+
+	if _, err := f.Fetch(context.Background(), srv.URL, RequestInit{}); err == nil || !strings.Contains(err.Error(), "the fetcher is closed") {
+		t.Errorf("expected the fetcher to be closed but got %v", err)
+	}
+}
+
+func TestFetchDefaultTimeout(t *testing.T) {
+	// not parallel, goleak would see the goroutines of the other tests
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	torndown := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fast":
+			_, _ = w.Write([]byte("fast"))
+			return
+		case "/body":
+			_, _ = w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			torndown <- r.URL.Path
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Path     string
+		Script   string
+		Abort    bool
+		Expected string
+		Torndown bool
+	}{
+		{"/hang", `fetch('%s/hang').then(res => res.text(), e => e.name)`, false, "TimeoutError", true},
+		{"/hang", `fetch('%s/hang').then(res => res.text(), e => e instanceof DOMException && e.name)`, true, "TimeoutError", true},
+		{"/body", `fetch('%s/body').then(res => res.text()).then(text => text, e => e.name)`, true, "TimeoutError", true},
+		{"/fast", `fetch('%s/fast').then(res => res.text())`, false, "fast", false},
+		{"/hang", `fetch('%s/hang', {signal: AbortSignal.timeout(50)}).then(res => res.text(), e => e.name + ' ' + e.message)`, true, "TimeoutError signal timed out", true},
+	}
+
+	for i, c := range cases {
+		ctx, err := newV8ContextWithFetch(WithDefaultTimeout(300 * time.Millisecond))
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		if c.Abort {
+			if err := abort.InjectTo(ctx); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(c.Script, srv.URL), "fetch_default_timeout.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		start := time.Now()
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("case %d: expected to return in 2s but took %s", i, elapsed)
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c.Expected, res.String())
+		}
+
+		if c.Torndown {
+			select {
+			case path := <-torndown:
+				if path != c.Path {
+					t.Errorf("case %d: expected %s to be torn down but got %s", i, c.Path, path)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("case %d: the connection should be torn down", i)
+			}
+		}
+	}
+}
+
+func TestFetchHostPatterns(t *testing.T) {
+	t.Parallel()
+
+	var blockedRequests int32
+	blocked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&blockedRequests, 1)
+	}))
+	defer blocked.Close()
+
+	// the same server, by another name
+	blockedURL := strings.Replace(blocked.URL, "127.0.0.1", "localhost", 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, blockedURL+"/target", http.StatusFound)
+			return
+		}
+
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Name     string
+		Opts     []Option
+		URL      string
+		Expected string
+	}{
+		{"allowed", []Option{WithAllowedHosts("127.0.0.1")}, srv.URL, "ok"},
+		{"not allowed", []Option{WithAllowedHosts("*.example.com", "example.com")}, srv.URL, "TypeError: fetch: host not allowed: 127.0.0.1"},
+		{"blocked", []Option{WithBlockedHosts("LOCALHOST")}, blockedURL, "TypeError: fetch: host not allowed: localhost"},
+		{"blocked wins", []Option{WithAllowedHosts("localhost"), WithBlockedHosts("localhost")}, blockedURL, "TypeError: fetch: host not allowed: localhost"},
+		{"redirect", []Option{WithBlockedHosts("localhost")}, srv.URL + "/redirect", fmt.Sprintf("TypeError: fetch: Get \"%s/target\": host not allowed: localhost", blockedURL)},
+		{"redirect not allowed", []Option{WithAllowedHosts("127.0.0.1")}, srv.URL + "/redirect", fmt.Sprintf("TypeError: fetch: Get \"%s/target\": host not allowed: localhost", blockedURL)},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch(c.Opts...)
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => res.text(), e => e instanceof TypeError ? 'TypeError: ' + e.message : String(e))`, c.URL), "fetch_host_patterns.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res.String())
+		}
+	}
+
+	if n := atomic.LoadInt32(&blockedRequests); n != 0 {
+		t.Errorf("expected no request to the blocked host but got %d", n)
+	}
+}
+
+func TestHostPatterns(t *testing.T) {
+	t.Parallel()
+
+	patterns := hostPatterns{"example.com", "*.internal.example.com"}
+
+	for host, expected := range map[string]bool{
+		"example.com":                  true,
+		"EXAMPLE.com.":                 true,
+		"www.example.com":              false,
+		"internal.example.com":         false,
+		"admin.internal.example.com":   true,
+		"a.b.internal.example.com":     true,
+		"evilinternal.example.com":     false,
+		"admin.internal.example.com.x": false,
+	} {
+		if patterns.match(host) != expected {
+			t.Errorf("%s: expected match %v", host, expected)
+		}
+	}
+}
+
+func TestFetchDataURL(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name     string
+		URL      string
+		Expected string
+	}{
+		{"base64", "data:text/plain;base64,SGVsbG8sIFdvcmxkIQ==", "200|text/plain|Hello, World!"},
+		{"base64 without padding", "data:text/plain; BASE64,SGVsbG8sIFdvcmxkIQ", "200|text/plain|Hello, World!"},
+		{"percent encoded", "data:text/html;charset=utf-8,%3Cp%3Ea%20b%3C%2Fp%3E#x", "200|text/html;charset=utf-8|<p>a b</p>"},
+		{"default type", "data:,Hello%2C%20World", "200|text/plain;charset=US-ASCII|Hello, World"},
+		{"type parameters only", "data:;charset=utf-8,a", "200|text/plain;charset=utf-8|a"},
+		{"empty body", "data:,", "200|text/plain;charset=US-ASCII|"},
+		{"malformed base64", "data:;base64,SGVsbG8$", "TypeError: fetch: invalid base64 in data url"},
+		{"no comma", "data:text/plain", "TypeError: fetch: data url has no comma"},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(
+			res => res.text().then(text => [res.status, res.headers.get('content-type'), text].join('|')),
+			e => e instanceof TypeError ? 'TypeError: ' + e.message : String(e)
+		)`, c.URL), "fetch_data_url.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchLocalHandlerFor(t *testing.T) {
+	t.Parallel()
+
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, "%s %s %s", name, r.Host, r.RemoteAddr)
+		})
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("remote"))
+	}))
+	defer srv.Close()
+
+	ctx, err := newV8ContextWithFetch(
+		WithLocalHandlerFor("api.local", handler("api")),
+		WithLocalHandlerFor("assets.local", handler("assets")),
+		WithLocalHandlerFor("assets.local:8080", handler("assets 8080")),
+	)
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	val, err := ctx.RunScript(fmt.Sprintf(`Promise.all([
+		'http://api.local/users',
+		'https://ASSETS.local:9000/app.js',
+		'http://assets.local:8080/app.js',
+		'%s',
+	].map(u => fetch(u).then(res => res.text()))).then(texts => texts.join('|'))`, srv.URL), "fetch_local_handler_for.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// the host is lowercased like a browser does, before the handler is looked up
+	expected := "api api.local 0.0.0.0:0|assets assets.local:9000 0.0.0.0:0|assets 8080 assets.local:8080 0.0.0.0:0|remote"
+	if res.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, res.String())
+	}
+}
+
+func TestFetchFragment(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%s %s", r.RequestURI, r.URL.String())
+	})
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	// the fragment never reaches hooks, handlers or the server, nor the url of the response
+	noFragment := WithRequestHook(func(req *http.Request) error {
+		if req.URL.Fragment != "" || strings.Contains(req.URL.String(), "#") {
+			return fmt.Errorf("fragment in %s", req.URL)
+		}
+		return nil
+	})
+
+	cases := []struct {
+		URL      string
+		Expected string
+	}{
+		{srv.URL + "/a?q=1#frag", fmt.Sprintf("%s/a?q=1 /a?q=1 /a?q=1", srv.URL)},
+		{srv.URL + "/a#", fmt.Sprintf("%s/a /a /a", srv.URL)},
+		{srv.URL + "/a?#x?y", fmt.Sprintf("%s/a? /a? /a?", srv.URL)},
+		{"/local?q=1#frag", "/local?q=1 /local?q=1 /local?q=1"},
+		{"/local#", "/local /local /local"},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch(WithLocalHandler(handler), noFragment)
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(async res => res.url + ' ' + await res.text())`, c.URL), "fetch_fragment.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.URL, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.URL, c.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchLocalRequest(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		w.Header().Add("X-Reply", "1")
+		w.Header().Add("X-Reply", "2")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusCreated)
+
+		gw := gzip.NewWriter(w)
+		_ = json.NewEncoder(gw).Encode(map[string]interface{}{
+			"method":        r.Method,
+			"host":          r.Host,
+			"requestURI":    r.RequestURI,
+			"remoteAddr":    r.RemoteAddr,
+			"header":        r.Header,
+			"body":          string(body),
+			"contentLength": r.ContentLength,
+		})
+		_ = gw.Close()
+	})
+
+	for _, c := range []struct {
+		Name     string
+		Script   string
+		Expected string
+	}{
+		{
+			Name:     "get",
+			Script:   "fetch('/path?q=1').then(res => res.json()).then(v => [v.method, v.host, v.requestURI, v.remoteAddr, v.body, v.contentLength].join())",
+			Expected: "GET,,/path?q=1,0.0.0.0:0,,0",
+		},
+		{
+			Name:     "post json",
+			Script:   "fetch('/api', {method: 'POST', body: JSON.stringify({a: 1}), headers: {'content-type': 'application/json'}}).then(res => res.json()).then(v => [v.method, v.body, v.header['Content-Type'], v.contentLength].join())",
+			Expected: "POST,{\"a\":1},application/json,7",
+		},
+		{
+			Name:     "put bytes",
+			Script:   "fetch('/api', {method: 'PUT', body: new Uint8Array([0, 1, 255])}).then(res => res.json()).then(v => [v.method, v.body.length, v.contentLength].join())",
+			Expected: "PUT,3,3",
+		},
+		{
+			Name:     "delete",
+			Script:   "fetch('/api/1', {method: 'DELETE'}).then(res => res.json()).then(v => [v.method, v.contentLength].join())",
+			Expected: "DELETE,0",
+		},
+		{
+			Name:     "repeated headers",
+			Script:   "fetch('/api', {headers: [['x-a', '1'], ['x-a', '2'], ['accept', 'text/html']]}).then(res => res.json()).then(v => [v.header['X-A'].join(';'), v.header['Accept']].join())",
+			Expected: "1;2,text/html",
+		},
+		{
+			Name:     "host header",
+			Script:   "fetch('/api', {headers: {host: 'api.example.com'}}).then(res => res.json()).then(v => [v.host, v.header['Host']].join())",
+			Expected: ",",
+		},
+		{
+			Name:     "response",
+			Script:   "fetch('/api').then(res => [res.status, res.headers.get('x-reply'), res.headers.get('content-encoding')].join())",
+			Expected: "201,1, 2,gzip",
+		},
+	} {
+		ctx, err := newV8ContextWithFetch(WithLocalHandler(handler))
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(c.Script, "fetch_local_request_"+c.Name+".js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %s", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res)
+		}
+	}
+}
+
+func TestFetchBaseURL(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	cases := []struct {
+		Name     string
+		URL      string
+		Expected string
+	}{
+		{"absolute path", "/api/items", "https://app.example.com/api/items"},
+		{"relative path", "items", "https://app.example.com/dir/items"},
+		{"parent", "../up?a=1", "https://app.example.com/up?a=1"},
+		{"query only", "?q=2", "https://app.example.com/dir/page?q=2"},
+		{"fragment only", "#top", "https://app.example.com/dir/page?q=1"},
+		{"scheme relative", "//other.example.com/x", "TypeError: fetch: host not allowed: other.example.com"},
+		{"absolute", srv.URL + "/x", srv.URL + "/x"},
+		{"invalid", "%zz", "TypeError: fetch: invalid url %zz: parse \"%zz\": invalid URL escape \"%zz\""},
+	}
+
+	ctx, err := newV8ContextWithFetch(
+		WithBaseURL("https://app.example.com/dir/page?q=1"),
+		WithLocalHandlerFor("app.example.com", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})),
+		// nothing else is local, keep the other hosts from the network
+		WithBlockedHosts("other.example.com"),
+	)
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	for _, c := range cases {
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => res.url, e => e instanceof TypeError ? 'TypeError: ' + e.message : String(e))`, c.URL), "fetch_base_url.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res.String())
+		}
+	}
+
+	for _, base := range []string{"/relative", "file:///x", "http://[::1"} {
+		if _, err := NewFetcher(WithBaseURL(base)); err == nil {
+			t.Errorf("expected an error for the base url %s", base)
+		}
+	}
+}
+
+func TestFetchURLInput(t *testing.T) {
+	t.Parallel()
+
+	const base = "https://app.example.com/dir/page?q=1"
+
+	ctx, err := newV8ContextWithFetch(
+		WithBaseURL(base),
+		WithLocalHandlerFor("app.example.com", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, "%s %s", r.Method, r.RequestURI)
+		})),
+	)
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	// the polyfills are developed apart, fetch takes the URL instances of the url polyfill
+	if err := url.InjectTo(ctx); err != nil {
+		t.Errorf("inject url polyfill: %s", err)
+		return
+	}
+
+	if _, err := ctx.RunScript(fmt.Sprintf("const base = %q", base), "fetch_url_input_base.js"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	cases := []struct {
+		Name     string
+		Script   string
+		Expected string
+	}{
+		{"absolute path", "return fetch(new URL('/api/items', base))", "https://app.example.com/api/items GET /api/items"},
+		{"relative path", "return fetch(new URL('items?a=1', base))", "https://app.example.com/dir/items?a=1 GET /dir/items?a=1"},
+		{"parent", "return fetch(new URL('../up', new URL(base)))", "https://app.example.com/up GET /up"},
+		{"fragment", "return fetch(new URL('#top', base))", "https://app.example.com/dir/page?q=1 GET /dir/page?q=1"},
+		{"search params", "const u = new URL('/s', base); u.searchParams.set('k', 'a b'); return fetch(u)", "https://app.example.com/s?k=a+b GET /s?k=a+b"},
+		{"parse", "return fetch(URL.parse('/p', base))", "https://app.example.com/p GET /p"},
+		{"subclass", "return fetch(new (class extends URL {})('/sub', base))", "https://app.example.com/sub GET /sub"},
+		{"no toString", "const u = new URL('/href', base); u.toString = () => '[object Object]'; return fetch(u)", "https://app.example.com/href GET /href"},
+		{"request", "return fetch(new Request(new URL('/req', base), { method: 'POST' }))", "https://app.example.com/req POST /req"},
+		{"method init", "return fetch(new URL('/put', base), { method: 'PUT' })", "https://app.example.com/put PUT /put"},
+		// a relative url string resolves like the URL constructor does
+		{"same as string", "return Promise.all([fetch('../x?y'), fetch(new URL('../x?y', base))]).then(([a, b]) => a.url === b.url && a)", "https://app.example.com/x?y GET /x?y"},
+	}
+
+	for _, c := range cases {
+		val, err := ctx.RunScript(fmt.Sprintf("(() => { %s })().then(async res => res.url + ' ' + await res.text(), e => String(e))", c.Script), "fetch_url_input.js")
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res.String())
+		}
+	}
+}
+
+func TestFetchArrayBuffer(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	// PNG signature and the start of an IHDR chunk, not valid UTF-8
+	png := []byte{0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x00, 0x00, 0x0d, 0x49, 0x48, 0x44, 0x52, 0xff, 0xfe}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(png)
+	}))
+	defer srv.Close()
+
+	ints := make([]int, len(png))
+	for i, b := range png {
+		ints[i] = int(b)
+	}
+	expected, _ := json.Marshal(ints)
+
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s/image.png')
+		.then(res => res.arrayBuffer())
+		.then(buf => {
+			const expected = %s;
+			const bytes = new Uint8Array(buf);
+			return buf instanceof ArrayBuffer && bytes.length === expected.length && expected.every((b, i) => bytes[i] === b);
+		})`, srv.URL, expected), "fetch_array_buffer.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !res.Boolean() {
+		t.Error("array buffer should equal to the served bytes")
+	}
+
+	val, err = ctx.RunScript(fmt.Sprintf("fetch('%s/empty').then(res => res.arrayBuffer()).then(buf => buf.byteLength)", srv.URL), "fetch_array_buffer_empty.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err = waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !res.IsInt32() || res.Int32() != 0 {
+		t.Errorf("empty body should be a zero-length ArrayBuffer, but got %s", res)
+	}
+}
+
+// echoes the received request back as JSON
+func newEchoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"method":        r.Method,
+			"url":           r.URL.String(),
+			"header":        r.Header,
+			"body":          string(body),
+			"contentLength": r.ContentLength,
+		})
+	})
+}
+
+// waitForPromise runs the tasks of the isolate of ctx until the promise val is settled
+func waitForPromise(ctx *v8go.Context, val *v8go.Value) (*v8go.Value, error) {
+	proms, err := val.AsPromise()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.After(10 * time.Second)
+	for ProcessTasks(ctx.Isolate()); proms.State() == v8go.Pending; ProcessTasks(ctx.Isolate()) {
+		select {
+		case <-timeout:
+			return nil, errors.New("promise timeout")
+		// don't keep the isolate busy, the requests resolve in it
+		case <-time.After(100 * time.Microsecond):
+		}
+	}
+
+	if proms.State() == v8go.Rejected {
+		return nil, fmt.Errorf("promise rejected: %s", proms.Result())
+	}
+
+	return proms.Result(), nil
 }
 
 func newV8ContextWithFetch(opt ...Option) (*v8go.Context, error) {
@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/weese/v8go-polyfills/fetch/internal"
+)
+
+/*
+fetchFile reads a file: url from the file system of WithFileSystem,
+without one file urls are unsupported like any other scheme.
+*/
+func (f *fetcher) fetchFile(r *internal.Request) (*internal.Response, error) {
+	if f.FileSystem == nil {
+		return nil, fmt.Errorf("unsupported scheme %s", r.URL.Scheme)
+	}
+
+	if r.URL.Host != "" && r.URL.Host != "localhost" {
+		return nil, &typeError{fmt.Errorf("unsupported file host %s", r.URL.Host)}
+	}
+
+	name, err := f.fileName(r.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := fs.ReadFile(f.FileSystem, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, &typeError{fmt.Errorf("file not found: %s", r.URL.Path)}
+		}
+		return nil, &typeError{err}
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return &internal.Response{
+		Header:     http.Header{"Content-Type": []string{contentType}},
+		Status:     http.StatusOK,
+		StatusText: "OK",
+		RawStatus:  "200 OK",
+		OK:         true,
+		URL:        internal.ResponseURL(r.URL),
+		Body:       body,
+	}, nil
+}
+
+/*
+fileName maps the path of a file url to a name in the file system,
+below the root. Any ".." segment is rejected instead of being resolved,
+so a request can't leave the root.
+*/
+func (f *fetcher) fileName(p string) (string, error) {
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return "", &typeError{fmt.Errorf("invalid file path: %s", p)}
+		}
+	}
+
+	name := path.Join(strings.Trim(f.FileSystemRoot, "/"), strings.TrimPrefix(p, "/"))
+	if name == "" {
+		name = "."
+	}
+
+	if !fs.ValidPath(name) {
+		return "", &typeError{fmt.Errorf("invalid file path: %s", p)}
+	}
+
+	return name, nil
+}
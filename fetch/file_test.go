@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"embed"
+	"fmt"
+	"testing"
+)
+
+//go:embed testdata
+var testFiles embed.FS
+
+func TestFetchFileSystem(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name     string
+		Opts     []Option
+		URL      string
+		Expected string
+	}{
+		{"html", []Option{WithFileSystem(testFiles, "testdata/static")}, "file:///templates/page.html", "200|text/html; charset=utf-8|<h1>page</h1>\n"},
+		{"json", []Option{WithFileSystem(testFiles, "testdata/static")}, "file://localhost/data.json", "200|application/json|{\"ok\":true}\n"},
+		{"no root", []Option{WithFileSystem(testFiles, "")}, "file:///testdata/static/data.json", "200|application/json|{\"ok\":true}\n"},
+		{"missing", []Option{WithFileSystem(testFiles, "testdata/static")}, "file:///missing.html", "TypeError: fetch: file not found: /missing.html"},
+		{"directory", []Option{WithFileSystem(testFiles, "testdata/static")}, "file:///templates", "TypeError: fetch: read testdata/static/templates: is a directory"},
+		{"traversal", []Option{WithFileSystem(testFiles, "testdata/static")}, "file:///../secret.txt", "TypeError: fetch: invalid file path: /../secret.txt"},
+		{"encoded traversal", []Option{WithFileSystem(testFiles, "testdata/static")}, "file:///templates/%2e%2e/%2e%2e/secret.txt", "TypeError: fetch: invalid file path: /templates/../../secret.txt"},
+		{"other host", []Option{WithFileSystem(testFiles, "testdata/static")}, "file://example.com/data.json", "TypeError: fetch: unsupported file host example.com"},
+		{"without file system", nil, "file:///data.json", "fetch: unsupported scheme file"},
+	}
+
+	for _, c := range cases {
+		ctx, err := newV8ContextWithFetch(c.Opts...)
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(
+			res => res.text().then(text => [res.status, res.headers.get('content-type'), text].join('|')),
+			e => e instanceof TypeError ? 'TypeError: ' + e.message : String(e)
+		)`, c.URL), "fetch_file_system.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res.String())
+		}
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/weese/v8go-polyfills/fetch/internal"
+)
+
+/*
+fixture is a recorded response in a file of WithRecording, the body is decoded,
+so it has no Content-Encoding anymore.
+*/
+type fixture struct {
+	Key        string      `json:"key"`
+	URL        string      `json:"url"`
+	Redirected bool        `json:"redirected"`
+	Status     int32       `json:"status"`
+	StatusText string      `json:"statusText"`
+	RawStatus  string      `json:"rawStatus"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+// fixtureKey identifies a request by its method, url and body
+func fixtureKey(r *internal.Request) string {
+	sum := sha256.Sum256(r.Body)
+
+	return fmt.Sprintf("%s %s sha256:%s", r.Method, internal.ResponseURL(r.URL), hex.EncodeToString(sum[:]))
+}
+
+func fixturePath(dir, key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return filepath.Join(dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+/*
+fetchRemoteFixture serves a remote request from the fixtures of WithReplay,
+or records its response for WithRecording. Without both it's fetchRemote.
+*/
+func (f *fetcher) fetchRemoteFixture(ctx context.Context, r *internal.Request) (*internal.Response, error) {
+	if f.ReplayDir != "" {
+		return f.replay(r)
+	}
+
+	res, err := f.fetchRemote(ctx, r)
+	if err != nil || f.RecordDir == "" {
+		return res, err
+	}
+
+	if err := res.ReadBody(); err != nil {
+		return nil, err
+	}
+
+	if err := f.record(r, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (f *fetcher) replay(r *internal.Request) (*internal.Response, error) {
+	key := fixtureKey(r)
+
+	b, err := os.ReadFile(fixturePath(f.ReplayDir, key))
+	if os.IsNotExist(err) {
+		return nil, &typeError{fmt.Errorf("no fixture for %s in %s", key, f.ReplayDir)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fx fixture
+	if err := json.Unmarshal(b, &fx); err != nil {
+		return nil, fmt.Errorf("invalid fixture for %s: %w", key, err)
+	}
+
+	return &internal.Response{
+		Header:     fx.Header,
+		SetCookie:  fx.Header.Values("Set-Cookie"),
+		Status:     fx.Status,
+		StatusText: fx.StatusText,
+		RawStatus:  fx.RawStatus,
+		OK:         fx.Status >= 200 && fx.Status < 300,
+		Redirected: fx.Redirected,
+		URL:        fx.URL,
+		NullBody:   internal.HasNullBody(r.Method, int(fx.Status)),
+		Body:       fx.Body,
+	}, nil
+}
+
+func (f *fetcher) record(r *internal.Request, res *internal.Response) error {
+	key := fixtureKey(r)
+
+	header := res.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	// the body is stored decoded
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	for _, name := range f.FixtureIgnoreHeaders {
+		header.Del(name)
+	}
+
+	b, err := json.MarshalIndent(&fixture{
+		Key:        key,
+		URL:        res.URL,
+		Redirected: res.Redirected,
+		Status:     res.Status,
+		StatusText: res.StatusText,
+		RawStatus:  res.RawStatus,
+		Header:     header,
+		Body:       res.Body,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(f.RecordDir, 0o755); err != nil {
+		return err
+	}
+
+	// a replay never reads a half written file
+	tmp, err := os.CreateTemp(f.RecordDir, ".fixture-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fixturePath(f.RecordDir, key))
+}
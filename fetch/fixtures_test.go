@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFetchRecordReplay(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "1"})
+		w.Header().Set("X-Server", "test")
+
+		if r.URL.Path == "/gzip" {
+			w.Header().Set("Content-Encoding", "gzip")
+			gw := gzip.NewWriter(w)
+			_, _ = gw.Write([]byte("compressed"))
+			_ = gw.Close()
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+	}))
+
+	script := fmt.Sprintf(`Promise.all([
+		fetch('%[1]s/a'),
+		fetch('%[1]s/b', {method: 'POST', body: 'one'}),
+		fetch('%[1]s/b', {method: 'POST', body: 'two'}),
+		fetch('%[1]s/gzip'),
+	].map(p => p.then(res => res.text().then(text => [
+		res.status, text, res.headers.get('x-server'), res.headers.getSetCookie().join(), res.headers.has('date'),
+	].join()))))
+		.then(texts => texts.join('|'))`, srv.URL)
+
+	run := func(name string, opt ...Option) string {
+		ctx, err := newV8ContextWithFetch(opt...)
+		if err != nil {
+			t.Fatalf("create v8: %s", err)
+		}
+
+		val, err := ctx.RunScript(script, name+".js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		return res.String()
+	}
+
+	recorded := run("record", WithRecording(dir), WithFixtureIgnoreHeaders("Date", "Set-Cookie"))
+
+	expected := "201,GET /a ,test,session=1,true|201,POST /b one,test,session=1,true|201,POST /b two,test,session=1,true|200,compressed,test,session=1,true"
+	if recorded != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, recorded)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 {
+		t.Errorf("expected 4 fixtures but got %d", len(files))
+	}
+
+	srv.Close()
+
+	replayed := run("replay", WithReplay(dir))
+
+	expected = "201,GET /a ,test,,false|201,POST /b one,test,,false|201,POST /b two,test,,false|200,compressed,test,,false"
+	if replayed != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, replayed)
+	}
+
+	ctx, err := newV8ContextWithFetch(WithReplay(dir))
+	if err != nil {
+		t.Fatalf("create v8: %s", err)
+	}
+
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s/a', {method: 'PUT', body: 'x'}).catch(e => e instanceof TypeError && e.message)`, srv.URL), "replay_missing.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the key is the method, the url and the sha256 of "x"
+	missing := fmt.Sprintf("fetch: no fixture for PUT %s/a sha256:2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881 in %s", srv.URL, dir)
+	if res.String() != missing {
+		t.Errorf("expected '%s' but got '%s'", missing, res.String())
+	}
+
+	if _, err := NewFetcher(WithRecording(dir), WithReplay(dir)); err == nil || !strings.Contains(err.Error(), "can't be used together") {
+		t.Errorf("expected an error using both, but got %v", err)
+	}
+}
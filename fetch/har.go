@@ -0,0 +1,399 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/weese/v8go-polyfills/fetch/internal"
+	. "github.com/weese/v8go-polyfills/internal"
+)
+
+/*
+HARRecorder records the requests of a fetcher in the HTTP Archive 1.2 format,
+see WithHARRecorder. Every redirect and retry is an entry of its own.
+It's safe for concurrent use.
+*/
+type HARRecorder struct {
+	maxBodySize int64
+
+	mu      sync.Mutex
+	entries []*harEntry
+}
+
+/*
+NewHARRecorder creates a recorder keeping the first maxBodySize bytes
+of the request and response bodies, with 0 no bodies are kept.
+*/
+func NewHARRecorder(maxBodySize int64) *HARRecorder {
+	return &HARRecorder{maxBodySize: maxBodySize}
+}
+
+type harLog struct {
+	Log struct {
+		Version string      `json:"version"`
+		Creator harCreator  `json:"creator"`
+		Entries []*harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// the phases not measured are -1, as the format asks for
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+/*
+HAR returns the recorded entries as HAR JSON, ordered by their start.
+The body of a response is complete once it's read or cancelled.
+*/
+func (rec *HARRecorder) HAR() []byte {
+	var har harLog
+	har.Log.Version = "1.2"
+	har.Log.Creator = harCreator{Name: "v8go-polyfills", Version: Version}
+
+	rec.mu.Lock()
+	// marshal while locked, the bodies still being read update their entries
+	har.Log.Entries = append([]*harEntry{}, rec.entries...)
+	sort.SliceStable(har.Log.Entries, func(i, j int) bool {
+		return har.Log.Entries[i].StartedDateTime.Before(har.Log.Entries[j].StartedDateTime)
+	})
+	b, _ := json.MarshalIndent(&har, "", "  ")
+	rec.mu.Unlock()
+
+	return b
+}
+
+// WriteTo writes HAR to w
+func (rec *HARRecorder) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(rec.HAR())
+	return int64(n), err
+}
+
+/*
+record adds an entry for a request and its response, the body of res is replaced
+by one recording it. err is the error of the round trip, then res is nil.
+*/
+func (rec *HARRecorder) record(req *http.Request, body []byte, res *http.Response, start time.Time, err error) {
+	wait := time.Since(start)
+
+	entry := &harEntry{
+		StartedDateTime: start,
+		Time:            milliseconds(wait),
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         internal.ResponseURL(req.URL),
+			HTTPVersion: req.Proto,
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harHeaders(req.Header),
+			QueryString: harQuery(req.URL.Query()),
+			HeadersSize: -1,
+			BodySize:    int64(len(body)),
+		},
+		Timings: harTimings{Blocked: -1, DNS: -1, Connect: -1, Send: 0, Wait: milliseconds(wait), Receive: 0},
+	}
+
+	if req.Host != "" {
+		entry.Request.Headers = append([]harNameValue{{Name: "Host", Value: req.Host}}, entry.Request.Headers...)
+	}
+
+	if body != nil {
+		text, encoding, comment := rec.bodyText(body)
+		entry.Request.PostData = &harPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+			Comment:  comment,
+		}
+	}
+
+	if err != nil {
+		// the format has no place for errors, so it's like a browser's failed request
+		entry.Response = harResponse{StatusText: err.Error(), HeadersSize: -1, BodySize: -1}
+	} else {
+		entry.Response = harResponse{
+			Status:      res.StatusCode,
+			StatusText:  internal.StatusText(res),
+			HTTPVersion: res.Proto,
+			Cookies:     harCookies(res.Cookies()),
+			Headers:     harHeaders(res.Header),
+			Content:     harContent{MimeType: res.Header.Get("Content-Type")},
+			HeadersSize: -1,
+			BodySize:    -1,
+		}
+
+		if location, err := res.Location(); err == nil {
+			entry.Response.RedirectURL = location.String()
+		}
+
+		res.Body = &harBody{ReadCloser: res.Body, rec: rec, entry: entry, header: res.Header, start: time.Now()}
+	}
+
+	rec.mu.Lock()
+	rec.entries = append(rec.entries, entry)
+	rec.mu.Unlock()
+}
+
+// bodyText returns body for the HAR, base64 encoded unless it's UTF-8
+func (rec *HARRecorder) bodyText(body []byte) (text, encoding, comment string) {
+	if int64(len(body)) > rec.maxBodySize {
+		body = body[:rec.maxBodySize]
+		comment = "truncated"
+	}
+
+	if utf8.Valid(body) {
+		return string(body), "", comment
+	}
+
+	return base64.StdEncoding.EncodeToString(body), "base64", comment
+}
+
+// harBody keeps the first bytes of a response body, it completes the entry when it's closed
+type harBody struct {
+	io.ReadCloser
+	rec    *HARRecorder
+	entry  *harEntry
+	header http.Header
+	start  time.Time
+
+	buf  bytes.Buffer
+	size int64
+	once sync.Once
+}
+
+func (b *harBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+
+	if keep := b.rec.maxBodySize + 1 - int64(b.buf.Len()); keep > 0 {
+		if int64(n) < keep {
+			keep = int64(n)
+		}
+		b.buf.Write(p[:keep])
+	}
+
+	if err == io.EOF {
+		b.finish()
+	}
+
+	return n, err
+}
+
+func (b *harBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish()
+
+	return err
+}
+
+func (b *harBody) finish() {
+	b.once.Do(func() {
+		body := b.buf.Bytes()
+		size := b.size
+		comment := ""
+
+		// the content is the decoded body, as a browser shows it
+		if b.header.Get("Content-Encoding") != "" {
+			if decoded, err := decodeBody(b.header, body); err == nil {
+				body = decoded
+				size = int64(len(decoded))
+			} else {
+				comment = "not decoded: " + err.Error()
+			}
+		}
+
+		text, encoding, truncated := b.rec.bodyText(body)
+		if comment == "" {
+			comment = truncated
+		}
+		receive := time.Since(b.start)
+
+		b.rec.mu.Lock()
+		defer b.rec.mu.Unlock()
+
+		b.entry.Response.BodySize = b.size
+		b.entry.Response.Content.Size = size
+		b.entry.Response.Content.Text = text
+		b.entry.Response.Content.Encoding = encoding
+		b.entry.Response.Content.Comment = comment
+		b.entry.Timings.Receive = milliseconds(receive)
+		b.entry.Time += milliseconds(receive)
+	})
+}
+
+func decodeBody(header http.Header, body []byte) ([]byte, error) {
+	res, err := internal.HandleHttpResponse(&http.Response{
+		Header: header,
+		Body:   io.NopCloser(bytes.NewReader(body)),
+	}, "", false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// harTransport records the round trips of transport
+type harTransport struct {
+	rec       *HARRecorder
+	transport http.RoundTripper
+}
+
+func (t *harTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.GetBody != nil {
+		if r, err := req.GetBody(); err == nil {
+			body, _ = io.ReadAll(r)
+		}
+	}
+
+	start := time.Now()
+	res, err := t.transport.RoundTrip(req)
+	t.rec.record(req, body, res, start, err)
+
+	return res, err
+}
+
+func (rec *HARRecorder) wrap(transport http.RoundTripper) http.RoundTripper {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return &harTransport{rec: rec, transport: transport}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	list := []harNameValue{}
+	for _, name := range sortedKeys(h) {
+		for _, value := range h[name] {
+			list = append(list, harNameValue{Name: name, Value: value})
+		}
+	}
+
+	return list
+}
+
+func harQuery(q url.Values) []harNameValue {
+	list := []harNameValue{}
+	for _, name := range sortedKeys(q) {
+		for _, value := range q[name] {
+			list = append(list, harNameValue{Name: name, Value: value})
+		}
+	}
+
+	return list
+}
+
+func harCookies(cookies []*http.Cookie) []harNameValue {
+	list := []harNameValue{}
+	for _, c := range cookies {
+		list = append(list, harNameValue{Name: c.Name, Value: c.Value})
+	}
+
+	return list
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
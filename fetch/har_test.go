@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// checkHARSchema checks the fields the HAR 1.2 format requires
+func checkHARSchema(t *testing.T, har []byte) {
+	var v map[string]interface{}
+	if err := json.Unmarshal(har, &v); err != nil {
+		t.Fatalf("invalid HAR JSON: %s", err)
+	}
+
+	required := func(name string, obj interface{}, keys ...string) map[string]interface{} {
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			t.Fatalf("%s is not an object", name)
+		}
+		for _, k := range keys {
+			if _, ok := m[k]; !ok {
+				t.Errorf("%s has no %s", name, k)
+			}
+		}
+		return m
+	}
+
+	log := required("har", v, "log")["log"]
+	l := required("log", log, "version", "creator", "entries")
+	if l["version"] != "1.2" {
+		t.Errorf("expected version 1.2 but got %v", l["version"])
+	}
+	required("creator", l["creator"], "name", "version")
+
+	entries, ok := l["entries"].([]interface{})
+	if !ok {
+		t.Fatal("entries is not an array")
+	}
+
+	for i, e := range entries {
+		name := fmt.Sprintf("entries[%d]", i)
+		entry := required(name, e, "startedDateTime", "time", "request", "response", "cache", "timings")
+		required(name+".request", entry["request"], "method", "url", "httpVersion", "cookies", "headers", "queryString", "headersSize", "bodySize")
+		res := required(name+".response", entry["response"], "status", "statusText", "httpVersion", "cookies", "headers", "content", "redirectURL", "headersSize", "bodySize")
+		required(name+".response.content", res["content"], "size", "mimeType")
+		required(name+".timings", entry["timings"], "send", "wait", "receive")
+	}
+}
+
+func TestFetchHARRecorder(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/json?a=1", http.StatusFound)
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			gw := gzip.NewWriter(w)
+			_, _ = gw.Write([]byte(`{"ok":true}`))
+			_ = gw.Close()
+		case "/binary":
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(append(body, 0xfe))
+		}
+	}))
+	defer srv.Close()
+
+	rec := NewHARRecorder(1024)
+
+	ctx, err := newV8ContextWithFetch(WithHARRecorder(rec))
+	if err != nil {
+		t.Fatalf("create v8: %s", err)
+	}
+
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%[1]s/redirect').then(res => res.json())
+		.then(() => fetch('%[1]s/binary', {method: 'POST', body: new Uint8Array([0xff, 0x00])}))
+		.then(res => res.arrayBuffer())
+		.then(b => b.byteLength)`, srv.URL), "fetch_har_recorder.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res, err := waitForPromise(ctx, val); err != nil || res.Integer() != 3 {
+		t.Fatalf("expected 3 bytes, but got %v %v", res, err)
+	}
+
+	har := rec.HAR()
+	checkHARSchema(t, har)
+
+	var decoded harLog
+	if err := json.Unmarshal(har, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := decoded.Log.Entries
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries but got %d", len(entries))
+	}
+
+	redirect, gzipped, binary := entries[0], entries[1], entries[2]
+
+	if redirect.Response.Status != http.StatusFound || redirect.Response.RedirectURL != srv.URL+"/json?a=1" {
+		t.Errorf("expected a redirect to /json?a=1, but got %d %s", redirect.Response.Status, redirect.Response.RedirectURL)
+	}
+
+	if gzipped.Request.URL != srv.URL+"/json?a=1" || fmt.Sprint(gzipped.Request.QueryString) != "[{a 1}]" {
+		t.Errorf("unexpected request %s %v", gzipped.Request.URL, gzipped.Request.QueryString)
+	}
+
+	if c := gzipped.Response.Content; c.Text != `{"ok":true}` || c.Size != 11 || c.MimeType != "application/json" || c.Encoding != "" {
+		t.Errorf("expected the decoded json, but got %+v", c)
+	}
+
+	if gzipped.Response.BodySize == 11 || gzipped.Response.BodySize <= 0 {
+		t.Errorf("expected the compressed size, but got %d", gzipped.Response.BodySize)
+	}
+
+	if p := binary.Request.PostData; p == nil || p.Encoding != "base64" || p.Text != base64.StdEncoding.EncodeToString([]byte{0xff, 0x00}) {
+		t.Errorf("expected the base64 encoded request body, but got %+v", p)
+	}
+
+	if c := binary.Response.Content; c.Encoding != "base64" || c.Text != base64.StdEncoding.EncodeToString([]byte{0xff, 0x00, 0xfe}) {
+		t.Errorf("expected the base64 encoded response body, but got %+v", c)
+	}
+
+	for _, e := range entries {
+		if e.StartedDateTime.IsZero() || e.Time < 0 || e.Timings.Wait < 0 {
+			t.Errorf("expected the timings of %s", e.Request.URL)
+		}
+	}
+}
+
+func TestHARRecorderTruncate(t *testing.T) {
+	t.Parallel()
+
+	rec := NewHARRecorder(4)
+
+	ctx, err := newV8ContextWithFetch(WithHARRecorder(rec), WithLocalHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("long body"))
+	})))
+	if err != nil {
+		t.Fatalf("create v8: %s", err)
+	}
+
+	val, err := ctx.RunScript(`Promise.all([fetch('/a'), fetch('/b')].map(p => p.then(res => res.text())))`, "har_recorder_truncate.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := waitForPromise(ctx, val); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded harLog
+	if err := json.Unmarshal(rec.HAR(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded.Log.Entries) != 2 {
+		t.Fatalf("expected 2 entries but got %d", len(decoded.Log.Entries))
+	}
+
+	for _, e := range decoded.Log.Entries {
+		if c := e.Response.Content; c.Text != "long" || c.Size != 9 || c.Comment != "truncated" {
+			t.Errorf("expected a truncated body, but got %+v", c)
+		}
+	}
+}
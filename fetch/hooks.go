@@ -0,0 +1,46 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"net/http"
+	"time"
+)
+
+// runRequestHooks calls the hooks of WithRequestHook in order, the first error stops the request
+func (f *fetcher) runRequestHooks(req *http.Request) error {
+	for _, hook := range f.RequestHooks {
+		if err := hook(req); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runResponseHooks calls the hooks of WithResponseHook in order
+func (f *fetcher) runResponseHooks(req *http.Request, res *http.Response, d time.Duration) {
+	for _, hook := range f.ResponseHooks {
+		hook(req, res, d)
+	}
+}
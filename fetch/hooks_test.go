@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookRecorder records the calls of the hooks, they run concurrently
+type hookRecorder struct {
+	mu        sync.Mutex
+	requests  []string
+	responses []string
+	durations []time.Duration
+}
+
+func (h *hookRecorder) options(requestErr error) []Option {
+	return []Option{
+		WithRequestHook(func(req *http.Request) error {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			h.requests = append(h.requests, req.Method+" "+req.URL.Path)
+			req.Header.Set("Authorization", "Bearer token")
+
+			return requestErr
+		}),
+		WithResponseHook(func(req *http.Request, res *http.Response, d time.Duration) {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			status := "error"
+			if res != nil {
+				status = res.Status
+			}
+			h.responses = append(h.responses, req.URL.Path+" "+status)
+			h.durations = append(h.durations, d)
+		}),
+	}
+}
+
+func TestFetchHooks(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	})
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	flaky, _ := newFlakyServer(2, nil)
+	defer flaky.Close()
+
+	cases := []struct {
+		Name       string
+		URL        string
+		Opts       []Option
+		RequestErr error
+		Expected   string
+		Requests   string
+		Responses  string
+	}{
+		{"remote", srv.URL + "/remote", nil, nil, "Bearer token", "[GET /remote]", "[/remote 200 OK]"},
+		{"local", "/local", []Option{WithLocalHandler(handler)}, nil, "Bearer token", "[GET /local]", "[/local 200 OK]"},
+		{"local host", "http://api.local/local", []Option{WithLocalHandlerFor("api.local", handler)}, nil, "Bearer token", "[GET /local]", "[/local 200 OK]"},
+		{"error", srv.URL + "/remote", nil, errors.New("no token"), "fetch: no token", "[GET /remote]", "[]"},
+		{"local error", "/local", []Option{WithLocalHandler(handler)}, errors.New("no token"), "fetch: no token", "[GET /local]", "[]"},
+		{
+			"retry", flaky.URL + "/retry", []Option{WithRetry(3, func(int) time.Duration { return time.Millisecond })}, nil, "ok",
+			"[GET /retry GET /retry GET /retry]", "[/retry 503 Service Unavailable /retry 503 Service Unavailable /retry 200 OK]",
+		},
+	}
+
+	for _, c := range cases {
+		var hooks hookRecorder
+
+		ctx, err := newV8ContextWithFetch(append(c.Opts, hooks.options(c.RequestErr)...)...)
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => res.text(), e => String(e))`, c.URL), "fetch_hooks.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res.String())
+		}
+
+		hooks.mu.Lock()
+		if s := fmt.Sprint(hooks.requests); s != c.Requests {
+			t.Errorf("%s: expected the requests %s but got %s", c.Name, c.Requests, s)
+		}
+		if s := fmt.Sprint(hooks.responses); s != c.Responses {
+			t.Errorf("%s: expected the responses %s but got %s", c.Name, c.Responses, s)
+		}
+		if c.Name != "retry" {
+			for _, d := range hooks.durations {
+				if d < 10*time.Millisecond {
+					t.Errorf("%s: expected a duration of at least 10ms, but got %s", c.Name, d)
+				}
+			}
+		}
+		hooks.mu.Unlock()
+	}
+}
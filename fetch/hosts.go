@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	. "github.com/weese/v8go-polyfills/internal"
+)
+
+/*
+hostPatterns matches hosts exactly, or by a wildcard suffix like "*.example.com",
+which matches the subdomains but not example.com itself. Matching ignores case
+and a trailing dot, a unicode domain matches its punycode.
+*/
+type hostPatterns []string
+
+func (p hostPatterns) match(host string) bool {
+	host = canonicalHost(host)
+
+	for _, pattern := range p {
+		pattern = canonicalHost(pattern)
+
+		if suffix := strings.TrimPrefix(pattern, "*"); suffix != pattern {
+			if strings.HasPrefix(suffix, ".") && strings.HasSuffix(host, suffix) {
+				return true
+			}
+			continue
+		}
+
+		if host == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+canonicalHost is host the way normalizeHost leaves it in urls, lowercase ASCII
+without a trailing dot, so options naming a unicode host match it too.
+*/
+func canonicalHost(host string) string {
+	host = strings.TrimSuffix(host, ".")
+
+	if ascii, err := DomainToASCII(host); err == nil {
+		return ascii
+	}
+
+	return strings.ToLower(host)
+}
+
+/*
+normalizeHost converts the domain of an http or https u to the ASCII form the
+URL polyfill shows and net/http dials, lowercase and without a trailing dot.
+An invalid domain is a TypeError, IP literals are kept as they are.
+*/
+func normalizeHost(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil
+	}
+
+	host := u.Hostname()
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	ascii, err := DomainToASCII(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return &typeError{fmt.Errorf("invalid host %s, %w", host, err)}
+	}
+
+	if port := u.Port(); port != "" {
+		ascii = net.JoinHostPort(ascii, port)
+	}
+
+	u.Host = ascii
+	return nil
+}
+
+/*
+checkHost returns a TypeError if the host of u is not allowed,
+it runs before the request and for every redirect.
+*/
+func (f *fetcher) checkHost(u *url.URL) error {
+	host := u.Hostname()
+
+	if (len(f.AllowedHosts) > 0 && !f.AllowedHosts.match(host)) || f.BlockedHosts.match(host) {
+		return &typeError{fmt.Errorf("host not allowed: %s", host)}
+	}
+
+	return nil
+}
+
+/*
+localHandlerFor returns the handler of WithLocalHandlerFor for the host of u,
+one registered with its port wins over one for any port.
+*/
+func (f *fetcher) localHandlerFor(u *url.URL) http.Handler {
+	h, _ := lookupHost(f.LocalHandlers, u.Hostname(), u.Port())
+	return h
+}
+
+// hostKey is the key of a host of WithLocalHandlerFor or WithUnixSocket, with an optional port
+func hostKey(host string) string {
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		return net.JoinHostPort(canonicalHost(h), port)
+	}
+
+	return canonicalHost(host)
+}
+
+// lookupHost finds host in m by its hostKey, with port first
+func lookupHost[T any](m map[string]T, host, port string) (T, bool) {
+	host = canonicalHost(host)
+
+	if port != "" {
+		if v, ok := m[net.JoinHostPort(host, port)]; ok {
+			return v, true
+		}
+	}
+
+	v, ok := m[host]
+	return v, ok
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"net/url"
+	"sync/atomic"
+)
+
+/*
+isInformational tells a 1xx status which comes before the final response,
+101 Switching Protocols is final, nothing follows it.
+*/
+func isInformational(code int) bool {
+	return code >= 100 && code <= 199 && code != http.StatusSwitchingProtocols
+}
+
+/*
+traceEarlyHints calls the function of WithEarlyHints with the 103 responses the client
+reads for the requests of ctx, hop is the url of the request they answer.
+The client skips the informational responses itself, resolving with the final one.
+*/
+func (f *fetcher) traceEarlyHints(ctx context.Context, hop *atomic.Pointer[url.URL]) context.Context {
+	if f.EarlyHints == nil {
+		return ctx
+	}
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				f.EarlyHints(hop.Load().String(), http.Header(header).Clone())
+			}
+			return nil
+		},
+	})
+}
+
+/*
+localResponseWriter records the response of a local handler, without the informational
+responses a handler may send first, like a 103 Early Hints, the recorder would keep
+the first status written. Early hints go to the function of WithEarlyHints.
+*/
+type localResponseWriter struct {
+	*httptest.ResponseRecorder
+	url   string
+	hints func(url string, header http.Header)
+}
+
+func (w *localResponseWriter) WriteHeader(code int) {
+	if isInformational(code) {
+		if code == http.StatusEarlyHints && w.hints != nil {
+			w.hints(w.url, w.Header().Clone())
+		}
+		return
+	}
+
+	w.ResponseRecorder.WriteHeader(code)
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// earlyHintsHandler sends a 103 Early Hints with a Link header before the final response
+var earlyHintsHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	// the server writes the 103 right away, a flush before the final status would send a 200
+	w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+	w.WriteHeader(http.StatusEarlyHints)
+
+	w.Header().Del("Link")
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("final"))
+	_ = rc.Flush()
+})
+
+func TestFetchEarlyHints(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(earlyHintsHandler)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		Name string
+		URL  string
+	}{
+		{"remote", srv.URL + "/page"},
+		{"local", "/page"},
+	} {
+		var mu sync.Mutex
+		var hints []string
+
+		ctx, err := newV8ContextWithFetch(WithLocalHandler(earlyHintsHandler), WithEarlyHints(func(url string, header http.Header) {
+			mu.Lock()
+			defer mu.Unlock()
+			hints = append(hints, url+" "+header.Get("Link"))
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => res.text().then(text => res.status + ' ' + text + ' ' + res.headers.has('link')))`, tc.URL), "fetch_early_hints.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %s", tc.Name, err)
+			continue
+		}
+
+		if expected := "200 final false"; res.String() != expected {
+			t.Errorf("%s: expected '%s' but got '%s'", tc.Name, expected, res.String())
+		}
+
+		mu.Lock()
+		if expected := []string{tc.URL + " </style.css>; rel=preload; as=style"}; fmt.Sprint(hints) != fmt.Sprint(expected) {
+			t.Errorf("%s: expected the early hints %q but got %q", tc.Name, expected, hints)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestFetchExpectContinue(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// rejected before the body is read, the server doesn't send a 100 Continue then
+		if r.URL.Path == "/reject" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		b, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s|%d", r.Header.Get("Expect"), len(b))
+	}))
+	defer srv.Close()
+
+	var sent int64
+	ctx, err := newV8ContextWithFetch(WithExpectContinue(1024), WithUploadProgress(func(url string, n, total int64) {
+		atomic.StoreInt64(&sent, n)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := strings.Repeat("x", 64*1024)
+
+	for _, tc := range []struct {
+		Name     string
+		Path     string
+		Body     string
+		Expected string
+	}{
+		{"small", "/", "small", "200 |5"},
+		{"large", "/", large, fmt.Sprintf("200 100-continue|%d", len(large))},
+		{"rejected", "/reject", large, "401 "},
+	} {
+		atomic.StoreInt64(&sent, 0)
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s%s', { method: 'POST', body: '%s' }).then(res => res.text().then(text => res.status + ' ' + text))`,
+			srv.URL, tc.Path, tc.Body), "fetch_expect_continue.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %s", tc.Name, err)
+			continue
+		}
+
+		if res.String() != tc.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", tc.Name, tc.Expected, res.String())
+		}
+
+		// the body of the rejected request is never sent
+		if n := atomic.LoadInt64(&sent); tc.Path == "/reject" && n != 0 {
+			t.Errorf("%s: expected no body to be sent but %d bytes were", tc.Name, n)
+		}
+	}
+}
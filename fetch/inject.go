@@ -28,14 +28,75 @@ import (
 	"rogchap.com/v8go"
 )
 
+// InjectTo injects fetch, Headers, Request, Response and Blob like Inject, without the fetcher to close
 func InjectTo(iso *v8go.Isolate, global *v8go.ObjectTemplate, opt ...Option) error {
-	f := NewFetcher(opt...)
+	_, err := Inject(iso, global, opt...)
+	return err
+}
+
+/*
+Inject injects fetch, Headers, Request, Response and Blob into global, and returns their
+fetcher. Closing it before the contexts are disposed cancels the fetches in flight.
+*/
+func Inject(iso *v8go.Isolate, global *v8go.ObjectTemplate, opt ...Option) (Fetcher, error) {
+	f, err := NewFetcher(opt...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := InjectFetcherTo(iso, global, f); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
 
+/*
+InjectFetcherTo injects fetch, Headers, Request and Response of f into global, and Blob.
+A fetcher can be injected into the globals of any number of isolates, and used by
+their contexts concurrently, each fetch keeps its state to itself. Call
+f.ClearContext before closing one of them.
+*/
+func InjectFetcherTo(iso *v8go.Isolate, global *v8go.ObjectTemplate, f Fetcher) error {
 	fetchFn := v8go.NewFunctionTemplate(iso, f.GetFetchFunctionCallback())
 
 	if err := global.Set("fetch", fetchFn, v8go.ReadOnly); err != nil {
 		return fmt.Errorf("v8go-polyfills/fetch: %w", err)
 	}
 
+	headersFn := v8go.NewFunctionTemplate(iso, f.GetHeadersFunctionCallback())
+
+	if err := global.Set("Headers", headersFn, v8go.ReadOnly); err != nil {
+		return fmt.Errorf("v8go-polyfills/fetch: %w", err)
+	}
+
+	requestFn := v8go.NewFunctionTemplate(iso, f.GetRequestFunctionCallback())
+
+	if err := global.Set("Request", requestFn, v8go.ReadOnly); err != nil {
+		return fmt.Errorf("v8go-polyfills/fetch: %w", err)
+	}
+
+	responseFn := v8go.NewFunctionTemplate(iso, f.GetResponseFunctionCallback())
+
+	// the statics are there before the JS side replaces the constructor
+	for _, name := range []string{"json", "error"} {
+		staticFn := v8go.NewFunctionTemplate(iso, f.GetResponseStaticCallback(name))
+
+		if err := responseFn.Set(name, staticFn, v8go.ReadOnly); err != nil {
+			return fmt.Errorf("v8go-polyfills/fetch: %w", err)
+		}
+	}
+
+	if err := global.Set("Response", responseFn, v8go.ReadOnly); err != nil {
+		return fmt.Errorf("v8go-polyfills/fetch: %w", err)
+	}
+
+	// the Blob of response.blob(), until the blob polyfill replaces it
+	blobFn := v8go.NewFunctionTemplate(iso, blobConstructorCallback)
+
+	if err := global.Set("Blob", blobFn, v8go.ReadOnly); err != nil {
+		return fmt.Errorf("v8go-polyfills/fetch: %w", err)
+	}
+
 	return nil
 }
@@ -23,7 +23,14 @@
 package fetch
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -55,29 +62,22 @@ func TestInjectTo(t *testing.T) {
 		return
 	}
 
-	done := make(chan bool, 1)
-	go func() {
-		for pro.State() == v8go.Pending {
-			continue
-		}
-
-		done <- true
-	}()
+	runCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	select {
-	case <-time.After(time.Second * 10):
+	if err := RunUntilIdle(runCtx, iso); err != nil {
 		t.Errorf("request timeout")
 		return
-	case <-done:
-		stat := pro.State()
-		if stat == v8go.Rejected {
-			fmt.Printf("reject with error: %s\n", pro.Result().String())
-		}
+	}
 
-		if pro.State() != v8go.Fulfilled {
-			t.Errorf("should fetch success, but not")
-			return
-		}
+	stat := pro.State()
+	if stat == v8go.Rejected {
+		fmt.Printf("reject with error: %s\n", pro.Result().String())
+	}
+
+	if pro.State() != v8go.Fulfilled {
+		t.Errorf("should fetch success, but not")
+		return
 	}
 
 	obj, err := pro.Result().AsObject()
@@ -96,3 +96,138 @@ func TestInjectTo(t *testing.T) {
 		t.Error("should be ok, but not")
 	}
 }
+
+func TestInjectFetcherToConcurrent(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, r.URL.Query().Get("n"))
+	}))
+	defer srv.Close()
+
+	// one fetcher for every isolate, with the shared parts in use
+	f, err := NewFetcher(
+		WithLocalHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, r.URL.Query().Get("n"))
+		})),
+		WithCookieJar(NewCookieJar()),
+		WithCache(NewMemoryCache(1<<20)),
+		WithMaxConcurrent(16),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	const contexts, fetches = 50, 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, contexts)
+
+	for i := 0; i < contexts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			iso := v8go.NewIsolate()
+			defer iso.Dispose()
+			global := v8go.NewObjectTemplate(iso)
+
+			if err := InjectFetcherTo(iso, global, f); err != nil {
+				errs <- err
+				return
+			}
+
+			ctx := v8go.NewContext(iso, global)
+			defer ctx.Close()
+
+			// half of the fetches are local, each one's result tells it apart
+			val, err := ctx.RunScript(fmt.Sprintf(`Promise.all(Array.from({length: %d}, (_, n) =>
+				fetch((n %% 2 ? '%s' : '') + '/?n=%d-' + n).then(res => res.text())
+			)).then(texts => texts.join())`, fetches, srv.URL, i), "fetch_concurrent.js")
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			res, err := waitForPromise(ctx, val)
+			if err != nil {
+				errs <- fmt.Errorf("context %d: %w", i, err)
+				return
+			}
+
+			expected := make([]string, fetches)
+			for n := range expected {
+				expected[n] = fmt.Sprintf("%d-%d", i, n)
+			}
+			if res.String() != strings.Join(expected, ",") {
+				errs <- fmt.Errorf("context %d: expected '%s' but got '%s'", i, strings.Join(expected, ","), res.String())
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestInjectFetcherToClearContext(t *testing.T) {
+	t.Parallel()
+
+	f, err := NewFetcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	iso := v8go.NewIsolate()
+	defer iso.Dispose()
+	global := v8go.NewObjectTemplate(iso)
+
+	if err := InjectFetcherTo(iso, global, f); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx1 := v8go.NewContext(iso, global)
+	ctx2 := v8go.NewContext(iso, global)
+	defer ctx2.Close()
+
+	for _, ctx := range []*v8go.Context{ctx1, ctx2} {
+		if _, err := ctx.RunScript(`new Headers({a: "1"}).get("a")`, "clear_context.js"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ft := f.(*fetcher)
+	count := func() int {
+		ft.mu.Lock()
+		defer ft.mu.Unlock()
+
+		return len(ft.exports)
+	}
+
+	if n := count(); n != 2 {
+		t.Errorf("expected the exports of 2 contexts but got %d", n)
+	}
+
+	f.ClearContext(ctx1)
+	ctx1.Close()
+
+	if n := count(); n != 1 {
+		t.Errorf("expected the exports of 1 context but got %d", n)
+	}
+
+	// the other context keeps its exports
+	val, err := ctx2.RunScript(`new Headers({a: "1"}).get("a")`, "clear_context.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val.String() != "1" {
+		t.Errorf("expected '1' but got '%s'", val.String())
+	}
+}
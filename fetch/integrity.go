@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/weese/v8go-polyfills/fetch/internal"
+)
+
+// the hash algorithms of subresource integrity, by increasing strength
+var integrityAlgorithms = []struct {
+	name string
+	hash func() hash.Hash
+}{
+	{"sha256", sha256.New},
+	{"sha384", sha512.New384},
+	{"sha512", sha512.New},
+}
+
+type integrityToken struct {
+	strength int
+	digest   []byte
+}
+
+/*
+parseIntegrity parses the tokens of integrity metadata like "sha384-<base64>",
+the options after a "?" are dropped. Malformed tokens and unknown algorithms
+are ignored, as the spec says.
+*/
+func parseIntegrity(metadata string) []integrityToken {
+	var tokens []integrityToken
+
+	for _, token := range strings.Fields(metadata) {
+		token, _, _ = strings.Cut(token, "?")
+
+		name, value, ok := strings.Cut(token, "-")
+		if !ok {
+			continue
+		}
+
+		strength := -1
+		for i, a := range integrityAlgorithms {
+			if strings.EqualFold(name, a.name) {
+				strength = i
+			}
+		}
+		if strength < 0 {
+			continue
+		}
+
+		// base64url is taken as well, like browsers do
+		value = strings.NewReplacer("-", "+", "_", "/").Replace(value)
+		digest, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			digest, err = base64.RawStdEncoding.DecodeString(value)
+		}
+		if err != nil {
+			continue
+		}
+
+		tokens = append(tokens, integrityToken{strength: strength, digest: digest})
+	}
+
+	return tokens
+}
+
+/*
+checkIntegrity checks the decoded body of res against the integrity metadata,
+only the tokens of the strongest algorithm count and one of them has to match.
+Metadata without a valid token matches any body.
+*/
+func checkIntegrity(metadata string, res *internal.Response) error {
+	tokens := parseIntegrity(metadata)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	strongest := 0
+	for _, t := range tokens {
+		if t.strength > strongest {
+			strongest = t.strength
+		}
+	}
+
+	algorithm := integrityAlgorithms[strongest]
+	h := algorithm.hash()
+	h.Write(res.Body)
+	digest := h.Sum(nil)
+
+	for _, t := range tokens {
+		if t.strength == strongest && subtle.ConstantTimeCompare(t.digest, digest) == 1 {
+			return nil
+		}
+	}
+
+	return &typeError{fmt.Errorf("integrity check failed for %s, the %s digest of the body is %s",
+		res.URL, algorithm.name, base64.StdEncoding.EncodeToString(digest))}
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetchIntegrity(t *testing.T) {
+	t.Parallel()
+
+	const body = "console.log('asset')"
+
+	// the digest is of the decoded body, not of the gzip bytes
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		_, _ = gw.Write([]byte(body))
+		_ = gw.Close()
+	}))
+	defer srv.Close()
+
+	sum256 := sha256.Sum256([]byte(body))
+	sum512 := sha512.Sum512([]byte(body))
+	sha256Token := "sha256-" + base64.StdEncoding.EncodeToString(sum256[:])
+	sha512Token := "sha512-" + base64.StdEncoding.EncodeToString(sum512[:])
+	wrong256 := "sha256-" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	wrong512 := "sha512-" + base64.StdEncoding.EncodeToString(make([]byte, sha512.Size))
+
+	for _, tc := range []struct {
+		Name      string
+		Integrity string
+		Err       string
+	}{
+		{Name: "match", Integrity: sha256Token},
+		{Name: "mismatch", Integrity: wrong256, Err: "TypeError: fetch: integrity check failed"},
+		{Name: "strongest matches", Integrity: wrong256 + " " + sha512Token},
+		{Name: "strongest mismatches", Integrity: sha256Token + " " + wrong512, Err: "the sha512 digest of the body is"},
+		{Name: "any of the strongest", Integrity: wrong512 + " " + sha512Token + "?ct=application/javascript"},
+		{Name: "base64url", Integrity: strings.NewReplacer("+", "-", "/", "_").Replace(sha512Token)},
+		{Name: "malformed", Integrity: "md5-AAAA sha256 sha256-!!!"},
+		{Name: "malformed and mismatch", Integrity: "md5-AAAA " + wrong256, Err: "integrity check failed"},
+	} {
+		ctx, err := newV8ContextWithFetch()
+		if err != nil {
+			t.Fatalf("create v8: %s", err)
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf("fetch('%s', {integrity: '%s'}).then(res => res.text())", srv.URL, tc.Integrity), "fetch_integrity.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if tc.Err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.Err) {
+				t.Errorf("%s: expected error '%s' but got %v", tc.Name, tc.Err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", tc.Name, err)
+			continue
+		}
+
+		if res.String() != body {
+			t.Errorf("%s: expected '%s' but got '%s'", tc.Name, body, res.String())
+		}
+	}
+
+	ctx, err := newV8ContextWithFetch()
+	if err != nil {
+		t.Fatalf("create v8: %s", err)
+	}
+
+	val, err := ctx.RunScript(fmt.Sprintf("new Request('/', {integrity: '%s'}).clone().integrity", sha256Token), "request_integrity.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val.String() != sha256Token {
+		t.Errorf("expected '%s' but got '%s'", sha256Token, val.String())
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+/*
+ DecodeText decodes a body to a UTF-8 string, a BOM wins over the charset
+ of the Content-Type, like browsers decode it. Without either, or with
+ an unknown charset, the body is taken as UTF-8 as it is.
+*/
+func DecodeText(header http.Header, body []byte) string {
+	enc, bom := sniffBOM(body)
+	if enc == nil {
+		enc = contentTypeEncoding(header.Get("Content-Type"))
+	}
+
+	if enc == nil {
+		return string(body)
+	}
+
+	if enc == unicode.UTF8 {
+		return string(body[bom:])
+	}
+
+	text, err := enc.NewDecoder().Bytes(body[bom:])
+	if err != nil {
+		return string(body)
+	}
+
+	return string(text)
+}
+
+// sniffBOM returns the encoding of the BOM body starts with, and its length
+func sniffBOM(body []byte) (encoding.Encoding, int) {
+	switch {
+	case bytes.HasPrefix(body, []byte{0xef, 0xbb, 0xbf}):
+		return unicode.UTF8, 3
+	case bytes.HasPrefix(body, []byte{0xfe, 0xff}):
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), 2
+	case bytes.HasPrefix(body, []byte{0xff, 0xfe}):
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), 2
+	}
+
+	return nil, 0
+}
+
+// contentTypeEncoding looks up the charset parameter by its WHATWG label
+func contentTypeEncoding(contentType string) encoding.Encoding {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+
+	charset := strings.TrimSpace(params["charset"])
+	if charset == "" || !utf8.ValidString(charset) {
+		return nil
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil
+	}
+
+	if name, _ := htmlindex.Name(enc); name == "utf-8" {
+		return unicode.UTF8
+	}
+
+	return enc
+}
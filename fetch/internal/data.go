@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internal
+
+import (
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const defaultDataURLType = "text/plain;charset=US-ASCII"
+
+/*
+ HandleDataURL decodes a data: url into a response, without its fragment it
+ should be the url of the response.
+ https://fetch.spec.whatwg.org/#data-url-processor
+*/
+func HandleDataURL(rawURL string) (*Response, error) {
+	i := strings.Index(rawURL, ":")
+	if i < 0 || !strings.EqualFold(rawURL[:i], "data") {
+		return nil, errors.New("not a data url")
+	}
+
+	data := rawURL[i+1:]
+
+	comma := strings.Index(data, ",")
+	if comma < 0 {
+		return nil, errors.New("data url has no comma")
+	}
+
+	mimeType := strings.Trim(data[:comma], " \t\n\f\r")
+	body := percentDecode(data[comma+1:])
+
+	// ;base64 at the end, the space before base64 is allowed
+	if semicolon := strings.LastIndex(mimeType, ";"); semicolon >= 0 &&
+		strings.EqualFold(strings.TrimLeft(mimeType[semicolon+1:], " "), "base64") {
+		decoded, err := forgivingBase64Decode(body)
+		if err != nil {
+			return nil, err
+		}
+
+		body = decoded
+		mimeType = mimeType[:semicolon]
+	}
+
+	if strings.HasPrefix(mimeType, ";") {
+		mimeType = "text/plain" + mimeType
+	}
+
+	if _, _, err := mime.ParseMediaType(mimeType); err != nil {
+		mimeType = defaultDataURLType
+	}
+
+	return &Response{
+		Header:     http.Header{"Content-Type": []string{mimeType}},
+		Status:     http.StatusOK,
+		StatusText: "OK",
+		RawStatus:  "200 OK",
+		OK:         true,
+		URL:        rawURL,
+		Body:       body,
+	}, nil
+}
+
+// percentDecode decodes %XX sequences, anything else is kept as it is
+func percentDecode(s string) []byte {
+	b := make([]byte, 0, len(s))
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			b = append(b, unhex(s[i+1])<<4|unhex(s[i+2]))
+			i += 2
+			continue
+		}
+
+		b = append(b, s[i])
+	}
+
+	return b
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+/*
+ forgivingBase64Decode ignores ASCII whitespace and allows the padding to be left out
+ https://infra.spec.whatwg.org/#forgiving-base64-decode
+*/
+func forgivingBase64Decode(data []byte) ([]byte, error) {
+	s := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', '\f', '\r':
+			return -1
+		}
+		return r
+	}, string(data))
+
+	if len(s)%4 == 0 {
+		s = strings.TrimSuffix(s, "=")
+		s = strings.TrimSuffix(s, "=")
+	}
+
+	if len(s)%4 == 1 {
+		return nil, errors.New("invalid base64 in data url")
+	}
+
+	b, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid base64 in data url")
+	}
+
+	return b, nil
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+EncodeBody applies the codings of a Content-Encoding header to body, in the listed order.
+The body is left as is if the header has a coding it doesn't know.
+*/
+func EncodeBody(body []byte, contentEncoding string) ([]byte, error) {
+	var encodings []string
+	for _, enc := range strings.Split(contentEncoding, ",") {
+		enc = strings.ToLower(strings.Trim(enc, " \t\""))
+		switch enc {
+		case "identity", "":
+		case "gzip", "x-gzip", "br", "deflate", "zstd":
+			encodings = append(encodings, enc)
+		default:
+			return body, nil
+		}
+	}
+
+	for _, enc := range encodings {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+
+		switch enc {
+		case "gzip", "x-gzip":
+			w = gzip.NewWriter(&buf)
+		case "br":
+			w = brotli.NewWriter(&buf)
+		case "deflate":
+			// the zlib format, like HTTP defines deflate
+			w = zlib.NewWriter(&buf)
+		case "zstd":
+			zw, err := zstd.NewWriter(&buf, zstd.WithEncoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			w = zw
+		}
+
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+
+		body = buf.Bytes()
+	}
+
+	return body, nil
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+/*
+ FormDataEntry is an entry of a FormData sent as the request body,
+ Filename is only set for file entries.
+*/
+type FormDataEntry struct {
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+	Filename *string `json:"filename"`
+	Type     string  `json:"type"`
+}
+
+// https://html.spec.whatwg.org/multipage/form-control-infrastructure.html#multipart-form-data
+var multipartEscaper = strings.NewReplacer("\n", "%0A", "\r", "%0D", `"`, "%22")
+
+/*
+ EncodeMultipart encodes the entries as multipart/form-data with a random boundary,
+ returns the body and the content type including the boundary.
+*/
+func EncodeMultipart(entries []FormDataEntry) ([]byte, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	for _, entry := range entries {
+		disposition := fmt.Sprintf(`form-data; name="%s"`, multipartEscaper.Replace(entry.Name))
+
+		h := make(textproto.MIMEHeader)
+		if entry.Filename != nil {
+			disposition += fmt.Sprintf(`; filename="%s"`, multipartEscaper.Replace(*entry.Filename))
+
+			contentType := entry.Type
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			h.Set("Content-Type", contentType)
+		}
+		h.Set("Content-Disposition", disposition)
+
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if _, err := part.Write([]byte(entry.Value)); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
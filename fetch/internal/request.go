@@ -23,10 +23,17 @@
 package internal
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
+)
+
+const (
+	// BodyEncodingBytes marks a request body sent as a byte string by the JS polyfill
+	BodyEncodingBytes = "bytes"
 )
 
 const (
@@ -35,25 +42,90 @@ const (
 	RequestRedirectManual = "manual"
 )
 
+const (
+	RequestCredentialsOmit       = "omit"
+	RequestCredentialsSameOrigin = "same-origin"
+	RequestCredentialsInclude    = "include"
+)
+
 /*
  RequestInit is the fetch API defined object.
  Only supports raw request now.
 */
 type RequestInit struct {
-	Body     string            `json:"body"`
-	Headers  map[string]string `json:"headers"`
+	Body     *string           `json:"body"`
+	Headers  HeadersInit       `json:"headers"`
 	Method   string            `json:"method"`
 	Redirect string            `json:"redirect"`
+
+	Credentials string `json:"credentials"`
+
+	// nil is "about:client", an empty string sends no referrer
+	Referrer       *string `json:"referrer"`
+	ReferrerPolicy string  `json:"referrerPolicy"`
+
+	Integrity string `json:"integrity"`
+
+	// set by the JS polyfill along with the body
+	BodyEncoding string `json:"bodyEncoding"`
+	BodyType     string `json:"bodyType"`
+
+	// set by the JS polyfill for AbortSignal.timeout, in milliseconds
+	Timeout float64 `json:"timeout"`
+
+	// set by the JS polyfill instead of the body for a FormData,
+	// the values of file entries are byte strings
+	FormData []FormDataEntry `json:"formData"`
+}
+
+/*
+ HeadersInit is the headers of RequestInit as [name, value] pairs,
+ it's decoded from an object or an array of pairs, which keeps repeated names.
+*/
+type HeadersInit [][2]string
+
+func (h *HeadersInit) UnmarshalJSON(b []byte) error {
+	var pairs [][2]string
+	if err := json.Unmarshal(b, &pairs); err == nil {
+		*h = pairs
+		return nil
+	}
+
+	var record map[string]string
+	if err := json.Unmarshal(b, &record); err != nil {
+		return fmt.Errorf("headers should be an object or an array of pairs, %w", err)
+	}
+
+	pairs = make([][2]string, 0, len(record))
+	for k, v := range record {
+		pairs = append(pairs, [2]string{k, v})
+	}
+	*h = pairs
+
+	return nil
 }
 
 /*
  Request is the request object used by fetch
 */
 type Request struct {
-	Body     string
+	Body     []byte
 	Method   string
 	Redirect string
 
+	// there is no origin, so "same-origin" and "include" both use the cookie jar
+	Credentials string
+
+	// the Referer is computed of them for every hop, no Referrer sends none
+	Referrer       *url.URL
+	ReferrerPolicy string
+
+	// the subresource integrity metadata the body is checked against, empty checks nothing
+	Integrity string
+
+	// cancels the request if the response isn't there in time, 0 means no timeout
+	Timeout time.Duration
+
 	Header     http.Header
 	URL        *url.URL
 	RemoteAddr string
@@ -69,10 +141,10 @@ func ParseRequestURL(rawURL string) (*url.URL, error) {
 	}
 
 	/**
-	 * Check the scheme, we only support http and https at this time
+	 * Check the scheme, we only support http, https, data and file at this time
 	 */
 	switch u.Scheme {
-	case "http", "https":
+	case "http", "https", "data", "file":
 	case "": // then scheme is empty, it's a local request
 		if !strings.HasPrefix(u.Path, "/") {
 			return nil, fmt.Errorf("unsupported relatve path %s", u.Path)
@@ -83,3 +155,15 @@ func ParseRequestURL(rawURL string) (*url.URL, error) {
 
 	return u, nil
 }
+
+/*
+WireURL is the url a request goes out with, the fragment stays on the client side,
+https://fetch.spec.whatwg.org/#http-network-fetch serializes it with exclude fragment
+*/
+func WireURL(u *url.URL) string {
+	wu := *u
+	wu.Fragment = ""
+	wu.RawFragment = ""
+
+	return wu.String()
+}
@@ -23,15 +23,21 @@
 package internal
 
 import (
+	"bufio"
 	"compress/flate"
 	"compress/gzip"
 	"compress/zlib"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 
 	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 )
 
 /*
@@ -41,37 +47,220 @@ type Response struct {
 	Header     http.Header
 	Status     int32
 	StatusText string
+	// the status line as received, like "200 OK"
+	RawStatus  string
 	OK         bool
 	Redirected bool
 	URL        string
-	Body       string
+	Body       []byte
+
+	// NullBody is set when the response can't have a body,
+	// Body is empty then whatever the server sent
+	NullBody bool
+
+	// SetCookie keeps the Set-Cookie headers one per cookie, they can't be
+	// combined like the others as a cookie may contain a comma
+	SetCookie []string
+
+	// Trailer is filled in once BodyReader is read to its end,
+	// it stays empty until then and for a buffered Body
+	Trailer http.Header
+
+	// BodyReader reads the decoded body when it is streamed instead of
+	// being buffered into Body, the consumer must close it
+	BodyReader io.ReadCloser
+}
+
+/*
+Handle the *http.Response, return *Response with the whole body read into Body
+*/
+func HandleHttpResponse(res *http.Response, url string, redirected, strictEncoding bool) (*Response, error) {
+	r, err := HandleHttpResponseStream(res, url, redirected, strictEncoding)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.ReadBody(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+/*
+ReadBody reads the rest of BodyReader into Body, and closes it
+*/
+func (r *Response) ReadBody() error {
+	if r.BodyReader == nil {
+		return nil
+	}
+	defer r.BodyReader.Close()
+
+	resBody, err := ioutil.ReadAll(r.BodyReader)
+	if err != nil {
+		return err
+	}
+
+	r.Body = resBody
+	r.BodyReader = nil
+
+	return nil
+}
+
+/*
+BodyTooLargeError is returned reading a body longer than the limit of LimitBody
+*/
+type BodyTooLargeError struct {
+	Limit int64
+	URL   string
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("response body of %s exceeds the limit of %d bytes", e.URL, e.Limit)
+}
+
+/*
+StatusText returns the reason phrase of res, without the status code of res.Status.
+Without one, like in HTTP/2, it's the standard phrase of the code.
+*/
+func StatusText(res *http.Response) string {
+	phrase := strings.TrimSpace(strings.TrimLeft(res.Status, "0123456789"))
+	if phrase == "" {
+		return http.StatusText(res.StatusCode)
+	}
+
+	return phrase
+}
+
+/*
+UnsupportedEncodingError is returned for an unknown Content-Encoding in strict mode
+*/
+type UnsupportedEncodingError struct {
+	Encoding string
+	URL      string
+}
+
+func (e *UnsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported content encoding %s of %s", e.Encoding, e.URL)
+}
+
+/*
+LimitBody makes reading BodyReader fail with a *BodyTooLargeError after n bytes,
+the limit applies to the decoded body, and the body is closed once it's hit.
+*/
+func (r *Response) LimitBody(n int64) {
+	if r.BodyReader == nil {
+		return
+	}
+
+	r.BodyReader = &limitedBody{
+		body:      r.BodyReader,
+		remaining: n,
+		err:       &BodyTooLargeError{Limit: n, URL: r.URL},
+	}
+}
+
+type limitedBody struct {
+	body      io.ReadCloser
+	remaining int64
+	err       error
+	exceeded  bool
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, l.err
+	}
+
+	n, err := l.body.Read(p)
+	l.remaining -= int64(n)
+
+	if l.remaining < 0 {
+		// drop what is over the limit
+		n += int(l.remaining)
+		l.remaining = 0
+		l.exceeded = true
+		_ = l.body.Close()
+
+		return n, l.err
+	}
+
+	return n, err
+}
+
+func (l *limitedBody) Close() error {
+	return l.body.Close()
+}
+
+/*
+ResponseURL is the url exposed by a response, without userinfo and fragment
+https://fetch.spec.whatwg.org/#concept-response-url
+*/
+func ResponseURL(u *url.URL) string {
+	ru := *u
+	ru.User = nil
+	ru.Fragment = ""
+	ru.RawFragment = ""
+
+	return ru.String()
 }
 
 /*
-Handle the *http.Response, return *Response
+HasNullBody tells if the response with status to a request of method has no body,
+https://fetch.spec.whatwg.org/#null-body-status and a HEAD response never has one
 */
-func HandleHttpResponse(res *http.Response, url string, redirected bool) (*Response, error) {
-	defer res.Body.Close()
+func HasNullBody(method string, status int) bool {
+	switch status {
+	case http.StatusNoContent, http.StatusResetContent, http.StatusNotModified:
+		return true
+	}
+
+	return method == http.MethodHead
+}
+
+/*
+Handle the *http.Response, return *Response with the decoded body left in BodyReader,
+with strictEncoding an unknown Content-Encoding fails with an *UnsupportedEncodingError
+instead of leaving the body as it is
+*/
+func HandleHttpResponseStream(res *http.Response, url string, redirected, strictEncoding bool) (*Response, error) {
 	var reader io.Reader = res.Body
 
-	// Support gzip, br (brotli), and deflate encodings
-	if encHeader := res.Header.Get("Content-Encoding"); encHeader != "" {
+	// Track closers for readers that require closing (e.g., gzip/zlib/flate)
+	var closers []io.Closer
+
+	var method string
+	if res.Request != nil {
+		method = res.Request.Method
+	}
+
+	nullBody := HasNullBody(method, res.StatusCode)
+
+	if nullBody {
+		// stray bytes are dropped, and there is nothing to decode even with a Content-Encoding
+		reader = http.NoBody
+	} else if encHeader := strings.Join(res.Header.Values("Content-Encoding"), ","); encHeader != "" {
+		// Support gzip, br (brotli), deflate and zstd encodings
 		// Multiple encodings are applied in the order listed; we must decode in reverse
 		encodings := strings.Split(encHeader, ",")
-		// Trim spaces
+		// Trim spaces, quotes and parameters like ";q=1"
 		for i := range encodings {
-			encodings[i] = strings.TrimSpace(strings.ToLower(encodings[i]))
+			enc := encodings[i]
+			if j := strings.Index(enc, ";"); j >= 0 {
+				enc = enc[:j]
+			}
+			encodings[i] = strings.ToLower(strings.Trim(enc, " \t\""))
 		}
 
-		// Track closers for readers that require closing (e.g., gzip/zlib/flate)
-		var closers []io.Closer
 		// Decode in reverse order
 		for i := len(encodings) - 1; i >= 0; i-- {
 			switch enc := encodings[i]; enc {
-			case "gzip":
+			case "gzip", "x-gzip":
 				gr, err := gzip.NewReader(reader)
 				if err != nil {
 					// If we fail to create a gzip reader, stop and return the error
+					closeAll(closers)
+					res.Body.Close()
 					return nil, err
 				}
 				reader = gr
@@ -80,44 +269,149 @@ func HandleHttpResponse(res *http.Response, url string, redirected bool) (*Respo
 				// brotli reader does not implement io.Closer
 				reader = brotli.NewReader(reader)
 			case "deflate":
-				// Try zlib-wrapped first (RFC1950), then raw deflate (RFC1951) as fallback
-				zr, err := zlib.NewReader(reader)
-				if err != nil {
-					fr := flate.NewReader(reader)
-					reader = fr
-					closers = append(closers, fr)
-				} else {
+				// Either zlib-wrapped (RFC1950), or raw deflate (RFC1951) as some servers send,
+				// the header is peeked so nothing is consumed before choosing
+				br := bufio.NewReader(reader)
+				if header, _ := br.Peek(2); isZlibHeader(header) {
+					zr, err := zlib.NewReader(br)
+					if err != nil {
+						closeAll(closers)
+						res.Body.Close()
+						return nil, err
+					}
 					reader = zr
 					closers = append(closers, zr)
+				} else {
+					fr := flate.NewReader(br)
+					reader = fr
+					closers = append(closers, fr)
+				}
+			case "zstd":
+				zr, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+				if err != nil {
+					closeAll(closers)
+					res.Body.Close()
+					return nil, err
 				}
+				reader = zr
+				closers = append(closers, zstdCloser{zr})
 			case "identity", "":
 				// no-op
 			default:
+				if strictEncoding {
+					closeAll(closers)
+					res.Body.Close()
+					return nil, &UnsupportedEncodingError{Encoding: enc, URL: url}
+				}
 				// Unknown encoding; leave as-is
 			}
 		}
-		// Ensure we close any layered readers after we are done reading
-		if len(closers) > 0 {
-			defer func() {
-				for _, c := range closers {
-					_ = c.Close()
-				}
-			}()
-		}
 	}
 
-	resBody, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return nil, err
-	}
-
-	return &Response{
+	r := &Response{
 		Header:     res.Header,
+		SetCookie:  append([]string(nil), res.Header.Values("Set-Cookie")...),
 		Status:     int32(res.StatusCode), // int type is not support by v8go
-		StatusText: res.Status,
+		StatusText: StatusText(res),
+		RawStatus:  res.Status,
 		OK:         res.StatusCode >= 200 && res.StatusCode < 300,
 		Redirected: redirected,
 		URL:        url,
-		Body:       string(resBody),
-	}, nil
+		NullBody:   nullBody,
+		Trailer:    make(http.Header),
+	}
+
+	r.BodyReader = &bodyReader{
+		reader:  reader,
+		body:    res.Body,
+		closers: closers,
+		onEOF: func() {
+			// the trailers are only there once the raw body is read to its end too,
+			// a decoder may stop before it
+			_, _ = io.Copy(io.Discard, res.Body)
+			for name, v := range res.Trailer {
+				if len(v) > 0 {
+					r.Trailer[name] = append([]string(nil), v...)
+				}
+			}
+		},
+	}
+
+	return r, nil
+}
+
+var errBodyClosed = errors.New("body closed")
+
+/*
+bodyReader reads the decoded body, it may be closed while a Read is blocked
+*/
+type bodyReader struct {
+	mu      sync.Mutex
+	reader  io.Reader
+	body    io.Closer
+	closers []io.Closer
+	closed  bool
+
+	// called once when reader is at its end
+	onEOF func()
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return 0, errBodyClosed
+	}
+
+	n, err := b.reader.Read(p)
+	if err == io.EOF && b.onEOF != nil {
+		b.onEOF()
+		b.onEOF = nil
+	}
+
+	return n, err
+}
+
+func (b *bodyReader) Close() error {
+	// closing the underlying body first makes a blocked Read return,
+	// the decoders are only closed once no Read is using them
+	err := b.body.Close()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		closeAll(b.closers)
+	}
+
+	return err
+}
+
+// isZlibHeader checks the compression method and the check bits of a zlib header
+func isZlibHeader(b []byte) bool {
+	if len(b) < 2 {
+		return false
+	}
+
+	cmf, flg := b[0], b[1]
+
+	return cmf&0x0f == 8 && cmf>>4 <= 7 && (uint16(cmf)<<8|uint16(flg))%31 == 0
+}
+
+// zstdCloser releases the decoder, its Close returns no error
+type zstdCloser struct {
+	d *zstd.Decoder
+}
+
+func (c zstdCloser) Close() error {
+	c.d.Close()
+	return nil
+}
+
+func closeAll(closers []io.Closer) {
+	for _, c := range closers {
+		_ = c.Close()
+	}
 }
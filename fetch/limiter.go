@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+/*
+limiter allows n requests in flight at once, the others wait in
+the order they came, up to queueLimit of them, or any number if it's negative.
+*/
+type limiter struct {
+	slots      chan struct{}
+	queueLimit int
+
+	mu     sync.Mutex
+	queued int
+}
+
+func newLimiter(n, queueLimit int) *limiter {
+	return &limiter{
+		slots:      make(chan struct{}, n),
+		queueLimit: queueLimit,
+	}
+}
+
+/*
+acquire waits for a free slot until ctx is done, a full queue rejects with a TypeError.
+The returned func frees the slot, it may be called more than once.
+*/
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.releaseFunc(), nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.queueLimit >= 0 && l.queued >= l.queueLimit {
+		l.mu.Unlock()
+		return nil, &typeError{errors.New("too many requests queued")}
+	}
+	l.queued++
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
+
+	// blocked senders of a channel are woken in order
+	select {
+	case l.slots <- struct{}{}:
+		return l.releaseFunc(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *limiter) releaseFunc() func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			<-l.slots
+		})
+	}
+}
+
+// acquire takes a slot of WithMaxConcurrent, without a limit it returns right away
+func (f *fetcher) acquire(ctx context.Context) (func(), error) {
+	if f.Limiter == nil {
+		return func() {}, nil
+	}
+
+	return f.Limiter.acquire(ctx)
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/weese/v8go-polyfills/abort"
+)
+
+// newConcurrencyServer counts the requests handled at once, each takes delay
+func newConcurrencyServer(delay time.Duration) (*httptest.Server, *int32) {
+	var current, max int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&current, 1)
+		defer atomic.AddInt32(&current, -1)
+
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+
+	return srv, &max
+}
+
+func TestFetchMaxConcurrent(t *testing.T) {
+	t.Parallel()
+
+	srv, max := newConcurrencyServer(5 * time.Millisecond)
+	defer srv.Close()
+
+	ctx, err := newV8ContextWithFetch(WithMaxConcurrent(8))
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	val, err := ctx.RunScript(fmt.Sprintf(`Promise.all(
+		Array.from({length: 1000}, (_, i) => fetch('%s/' + i).then(res => res.text()))
+	).then(texts => texts.every((text, i) => text === '/' + i))`, srv.URL), "fetch_max_concurrent.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !res.Boolean() {
+		t.Error("expected every fetch to get its own response")
+	}
+
+	if n := atomic.LoadInt32(max); n > 8 {
+		t.Errorf("expected at most 8 requests at once, but got %d", n)
+	}
+}
+
+func TestFetchQueueLimit(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := newConcurrencyServer(200 * time.Millisecond)
+	defer srv.Close()
+
+	ctx, err := newV8ContextWithFetch(WithMaxConcurrent(1), WithQueueLimit(1))
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	// one request is sent, one waits, and which one is left over depends on the order they start
+	val, err := ctx.RunScript(fmt.Sprintf(`Promise.all(['%[1]s/1', '%[1]s/2', '%[1]s/3'].map(u => fetch(u).then(
+			res => res.text(),
+			e => e instanceof TypeError ? 'TypeError: ' + e.message : String(e)
+		))).then(texts => texts.sort().join('|'))`, srv.URL), "fetch_queue_limit.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !strings.HasSuffix(res.String(), "|TypeError: fetch: too many requests queued") || strings.Count(res.String(), "|/") != 1 {
+		t.Errorf("expected two responses and a TypeError, but got '%s'", res.String())
+	}
+}
+
+func TestFetchMaxConcurrentAbort(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := newConcurrencyServer(500 * time.Millisecond)
+	defer srv.Close()
+
+	ctx, err := newV8ContextWithFetch(WithMaxConcurrent(1))
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	if err := abort.InjectTo(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+
+	start := time.Now()
+	val, err := ctx.RunScript(fmt.Sprintf(`const first = fetch('%[1]s/1')
+		const aborted = AbortSignal.abort()
+		Promise.race([
+			fetch('%[1]s/2', {signal: AbortSignal.timeout(50)}).catch(e => e.name),
+			fetch('%[1]s/3', {signal: aborted}).catch(e => e.name),
+		])`, srv.URL), "fetch_max_concurrent_abort.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res.String() != "AbortError" {
+		t.Errorf("expected 'AbortError' but got '%s'", res.String())
+	}
+
+	// the queued request leaves at its timeout, while the first one still runs
+	val, err = ctx.RunScript(fmt.Sprintf(`fetch('%s/4', {signal: AbortSignal.timeout(50)}).catch(e => e.name)`, srv.URL), "fetch_max_concurrent_abort.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err = waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res.String() != "TimeoutError" {
+		t.Errorf("expected 'TimeoutError' but got '%s'", res.String())
+	}
+
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("expected the queued requests to leave right away, but took %s", elapsed)
+	}
+}
+
+func TestFetchMaxConcurrentUnreadBody(t *testing.T) {
+	t.Parallel()
+
+	// the body of /unread only ends with the test, just its headers are sent
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/unread" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			select {
+			case <-unblock:
+			case <-r.Context().Done():
+			}
+			return
+		}
+
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+	defer close(unblock)
+
+	ctx, err := newV8ContextWithFetch(WithMaxConcurrent(1))
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	if err := abort.InjectTo(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+
+	val, err := ctx.RunScript(fmt.Sprintf(`(async () => {
+		const first = await fetch('%[1]s/unread')
+		const second = await fetch('%[1]s/2', {signal: AbortSignal.timeout(1000)})
+		return first.status + ' ' + await second.text()
+	})().catch(e => e.name)`, srv.URL), "fetch_max_concurrent_unread_body.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res.String() != "200 /2" {
+		t.Errorf("expected '200 /2' but got '%s'", res.String())
+	}
+}
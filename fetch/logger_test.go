@@ -0,0 +1,177 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// logRecords collects the records of a logger, with the attributes as strings
+type logRecords struct {
+	mu      sync.Mutex
+	records []map[string]string
+}
+
+type recordHandler struct {
+	records *logRecords
+	attrs   []slog.Attr
+}
+
+func (h recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h recordHandler) Handle(_ context.Context, r slog.Record) error {
+	record := map[string]string{"msg": r.Message, "level": r.Level.String()}
+	for _, a := range h.attrs {
+		record[a.Key] = a.Value.String()
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		record[a.Key] = a.Value.String()
+		return true
+	})
+
+	h.records.mu.Lock()
+	defer h.records.mu.Unlock()
+	h.records.records = append(h.records.records, record)
+
+	return nil
+}
+
+func (h recordHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return recordHandler{records: h.records, attrs: append(append([]slog.Attr{}, h.attrs...), attrs...)}
+}
+
+func (h recordHandler) WithGroup(string) slog.Handler { return h }
+
+func TestFetchLogger(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("secret body"))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Name     string
+		URL      string
+		Expected []map[string]string
+	}{
+		{
+			"remote", srv.URL + "/ok", []map[string]string{
+				{"msg": "fetch started", "level": "INFO", "method": "GET", "url": srv.URL + "/ok", "route": "remote"},
+				{"msg": "fetch finished", "level": "INFO", "status": "200", "bytes": "11", "encodings": ""},
+			},
+		},
+		{
+			"not found", srv.URL + "/missing", []map[string]string{
+				{"msg": "fetch started", "route": "remote"},
+				{"msg": "fetch finished", "status": "404", "bytes": "19"},
+			},
+		},
+		{
+			"local", "/local", []map[string]string{
+				{"msg": "fetch started", "url": "/local", "route": "local"},
+				{"msg": "fetch finished", "status": "501"},
+			},
+		},
+		{
+			"data", "data:,abc", []map[string]string{
+				{"msg": "fetch started", "url": "data:,abc", "route": "data"},
+				{"msg": "fetch finished", "status": "200", "bytes": "3"},
+			},
+		},
+		{
+			"failed", "data:;base64,!", []map[string]string{
+				{"msg": "fetch started", "route": "data"},
+				{"msg": "fetch failed", "level": "ERROR", "error": "invalid base64 in data url"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		records := &logRecords{}
+
+		ctx, err := newV8ContextWithFetch(WithLogger(slog.New(recordHandler{records: records})))
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(
+			res => res.text().then(() => res.headers.get('x-v8go-request-id')),
+			e => 'failed'
+		)`, c.URL), "fetch_logger.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		records.mu.Lock()
+		if len(records.records) != len(c.Expected) {
+			t.Errorf("%s: expected %d records but got %v", c.Name, len(c.Expected), records.records)
+			records.mu.Unlock()
+			continue
+		}
+
+		id := records.records[0]["request_id"]
+		if len(id) != 16 || (res.String() != id && res.String() != "failed") {
+			t.Errorf("%s: expected the request id '%s' in the response, but got '%s'", c.Name, id, res.String())
+		}
+
+		for i, expected := range c.Expected {
+			record := records.records[i]
+			if record["request_id"] != id {
+				t.Errorf("%s: expected the request id '%s' in %v", c.Name, id, record)
+			}
+			if record["msg"] != "fetch started" && record["duration"] == "" {
+				t.Errorf("%s: expected a duration in %v", c.Name, record)
+			}
+			for k, v := range expected {
+				if record[k] != v {
+					t.Errorf("%s: expected %s '%s' but got '%s' in %v", c.Name, k, v, record[k], record)
+				}
+			}
+			for _, v := range record {
+				if v == "secret body" {
+					t.Errorf("%s: the body is logged in %v", c.Name, record)
+				}
+			}
+		}
+		records.mu.Unlock()
+	}
+}
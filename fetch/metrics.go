@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Metrics observes the traffic of the fetches, like to export it to Prometheus,
+see WithMetrics. Local is true for the fetches which don't go to the network,
+served by a local handler, a data: or a file: url. The calls come from the
+goroutines of the fetches, concurrently, so it must be safe for concurrent use.
+*/
+type Metrics interface {
+	// ObserveRequest is called when a fetch starts, host is empty for urls without one
+	ObserveRequest(method, host string, local bool)
+
+	/*
+		ObserveResponse is called once the body of a response is read or closed,
+		bytes is the size of the body the script got, wireBytes the size received
+		before it was decoded. WireBytes is 0 if nothing was received, like for a
+		fresh response of WithCache. The duration is from the start of the fetch.
+	*/
+	ObserveResponse(status int, bytes, wireBytes int64, d time.Duration, local bool)
+
+	// ObserveError is called when a fetch is rejected, with one of the ErrorKind values
+	ObserveError(kind string, local bool)
+}
+
+// the kinds of the errors of Metrics.ObserveError
+const (
+	ErrorKindAbort       = "abort"
+	ErrorKindTimeout     = "timeout"
+	ErrorKindClosed      = "closed"
+	ErrorKindRateLimited = "rate_limited"
+	ErrorKindNetwork     = "network"
+	ErrorKindType        = "type"
+	ErrorKindOther       = "other"
+)
+
+// errorKind tells the kind of err, the failure of a fetch with the context ctx
+func (f *fetcher) errorKind(ctx context.Context, err error, timedOut bool) string {
+	var nErr *networkError
+	var tErr *typeError
+	var rErr *rateLimitError
+
+	switch {
+	case errors.Is(err, errFetcherClosed), f.closeCtx.Err() != nil:
+		return ErrorKindClosed
+	case timedOut, errors.Is(err, context.DeadlineExceeded):
+		return ErrorKindTimeout
+	case ctx.Err() != nil:
+		return ErrorKindAbort
+	case errors.As(err, &rErr):
+		return ErrorKindRateLimited
+	case errors.As(err, &nErr):
+		return ErrorKindNetwork
+	case errors.As(err, &tErr):
+		return ErrorKindType
+	default:
+		return ErrorKindOther
+	}
+}
+
+type wireCountKey struct{}
+
+// contextWithWireCount counts the bytes received by the fetch of ctx into n
+func contextWithWireCount(ctx context.Context, n *atomic.Int64) context.Context {
+	return context.WithValue(ctx, wireCountKey{}, n)
+}
+
+/*
+countWire counts the bytes of the body of res into the counter of ctx,
+before the body is decoded. It's a no-op without WithMetrics.
+*/
+func countWire(ctx context.Context, res *http.Response) {
+	n, ok := ctx.Value(wireCountKey{}).(*atomic.Int64)
+	if !ok || res.Body == nil || res.Body == http.NoBody {
+		return
+	}
+
+	res.Body = &wireReader{ReadCloser: res.Body, n: n}
+}
+
+type wireReader struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (r *wireReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+
+	return n, err
+}
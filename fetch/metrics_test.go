@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// metricsRecorder records the calls of Metrics as strings, without the durations
+type metricsRecorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (m *metricsRecorder) record(format string, a ...interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls = append(m.calls, fmt.Sprintf(format, a...))
+}
+
+func (m *metricsRecorder) ObserveRequest(method, host string, local bool) {
+	m.record("request %s %s local=%v", method, host, local)
+}
+
+func (m *metricsRecorder) ObserveResponse(status int, bytes, wireBytes int64, d time.Duration, local bool) {
+	if d <= 0 {
+		m.record("response without a duration")
+	}
+	m.record("response %d %d/%d local=%v", status, bytes, wireBytes, local)
+}
+
+func (m *metricsRecorder) ObserveError(kind string, local bool) {
+	m.record("error %s local=%v", kind, local)
+}
+
+func (m *metricsRecorder) reset() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	calls := m.calls
+	m.calls = nil
+
+	return calls
+}
+
+func TestFetchMetrics(t *testing.T) {
+	t.Parallel()
+
+	body := strings.Repeat("compressible ", 100)
+
+	var gzipBody bytes.Buffer
+	gw := gzip.NewWriter(&gzipBody)
+	_, _ = gw.Write([]byte(body))
+	_ = gw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(gzipBody.Bytes())
+	}))
+	defer srv.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	closedHost := strings.TrimPrefix(closed.URL, "http://")
+
+	localHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("local"))
+	})
+
+	m := &metricsRecorder{}
+
+	ctx, err := newV8ContextWithFetch(WithMetrics(m), WithLocalHandler(localHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Isolate().Dispose()
+	defer ctx.Close()
+
+	cases := []struct {
+		Name     string
+		Script   string
+		Expected []string
+	}{
+		{
+			"remote", fmt.Sprintf("fetch('%s').then(res => res.text())", srv.URL), []string{
+				"request GET " + strings.Split(host, ":")[0] + " local=false",
+				fmt.Sprintf("response 200 %d/%d local=false", len(body), gzipBody.Len()),
+			},
+		},
+		{
+			"local", "fetch('/local', { method: 'POST', body: 'x' }).then(res => res.text())", []string{
+				"request POST  local=true",
+				"response 201 5/5 local=true",
+			},
+		},
+		{
+			"data", "fetch('data:,abc').then(res => res.text())", []string{
+				"request GET  local=true",
+				"response 200 3/0 local=true",
+			},
+		},
+		{
+			"invalid data", "fetch('data:;base64,!').catch(e => e.name)", []string{
+				"request GET  local=true",
+				"error type local=true",
+			},
+		},
+		{
+			"refused", fmt.Sprintf("fetch('%s').catch(e => e.name)", closed.URL), []string{
+				"request GET " + strings.Split(closedHost, ":")[0] + " local=false",
+				"error network local=false",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		val, err := ctx.RunScript(tc.Script, "fetch_metrics.js")
+		if err != nil {
+			t.Fatalf("%s: %s", tc.Name, err)
+		}
+
+		if _, err := waitForPromise(ctx, val); err != nil {
+			t.Errorf("%s: %s", tc.Name, err)
+			continue
+		}
+
+		if calls := m.reset(); !reflect.DeepEqual(calls, tc.Expected) {
+			t.Errorf("%s: expected the calls %q but got %q", tc.Name, tc.Expected, calls)
+		}
+	}
+}
@@ -23,8 +23,17 @@
 package fetch
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
+	"strings"
+	"time"
 )
 
 type UserAgentProvider interface {
@@ -53,14 +62,652 @@ func WithLocalHandler(handler http.Handler) Option {
 	})
 }
 
+/*
+WithLocalHandlerFor routes the requests to host to handler, instead of the network.
+The host matches case-insensitively, without a port it matches any port,
+with one only that port. It can be used for more hosts, registering one twice
+fails NewFetcher.
+*/
+func WithLocalHandlerFor(host string, handler http.Handler) Option {
+	return optionFunc(func(ft *fetcher) {
+		host := hostKey(host)
+
+		if _, ok := ft.LocalHandlers[host]; ok {
+			ft.fail(fmt.Errorf("v8go-polyfills/fetch: local handler for %s is registered twice", host))
+			return
+		}
+
+		if ft.LocalHandlers == nil {
+			ft.LocalHandlers = make(map[string]http.Handler)
+		}
+		ft.LocalHandlers[host] = handler
+	})
+}
+
+/*
+WithResolveOverride dials the "ip:port" of overrides instead of resolving the
+host, keyed by "host" for any port or "host:port". The url keeps its host, so
+the Host header and TLS (SNI and the certificate check) still use it, and the
+overrides apply to redirects as they are dialed too. WithBlockPrivateIPs checks
+the overriding address. It can be used more than once, overriding a host twice
+fails NewFetcher. It doesn't apply through WithProxy, or to the client of WithHTTPClient.
+*/
+func WithResolveOverride(overrides map[string]string) Option {
+	return optionFunc(func(ft *fetcher) {
+		for host, addr := range overrides {
+			key := hostKey(host)
+
+			if ip, _, err := net.SplitHostPort(addr); err != nil || net.ParseIP(ip) == nil {
+				ft.fail(fmt.Errorf("v8go-polyfills/fetch: resolve override for %s is not an ip:port: %s", key, addr))
+				return
+			}
+
+			if _, ok := ft.ResolveOverrides[key]; ok {
+				ft.fail(fmt.Errorf("v8go-polyfills/fetch: resolve override for %s is registered twice", key))
+				return
+			}
+
+			if ft.ResolveOverrides == nil {
+				ft.ResolveOverrides = make(map[string]string)
+			}
+			ft.ResolveOverrides[key] = addr
+		}
+	})
+}
+
+/*
+WithUnixSocket connects to the unix domain socket at socketPath for the requests
+to host, like "http://internal.sock/api" reaching a server of another process.
+The host matches like the one of WithLocalHandlerFor, it can be used for more hosts.
+The connections are kept for reuse, and never go through WithProxy.
+It doesn't apply to the client of WithHTTPClient.
+*/
+func WithUnixSocket(host, socketPath string) Option {
+	return optionFunc(func(ft *fetcher) {
+		host := hostKey(host)
+
+		if _, ok := ft.UnixSockets[host]; ok {
+			ft.fail(fmt.Errorf("v8go-polyfills/fetch: unix socket for %s is registered twice", host))
+			return
+		}
+
+		if ft.UnixSockets == nil {
+			ft.UnixSockets = make(map[string]string)
+		}
+		ft.UnixSockets[host] = socketPath
+	})
+}
+
+/*
+WithBaseURL resolves relative urls against base, like a browser does against
+the url of its document, so "/api" isn't a local request anymore. Resolving
+comes before routing, to serve the base url locally use WithLocalHandlerFor
+with its host. base must be an absolute http or https url.
+*/
+func WithBaseURL(base string) Option {
+	return optionFunc(func(ft *fetcher) {
+		u, err := url.Parse(base)
+		if err == nil && u.Scheme != "http" && u.Scheme != "https" {
+			err = errors.New("not an absolute http or https url")
+		}
+		if err != nil {
+			ft.fail(fmt.Errorf("v8go-polyfills/fetch: invalid base url %s: %w", base, err))
+			return
+		}
+
+		ft.BaseURL = u
+	})
+}
+
 func WithUserAgentProvider(provider UserAgentProvider) Option {
 	return optionFunc(func(ft *fetcher) {
 		ft.UserAgentProvider = provider
 	})
 }
 
+/*
+WithDefaultHeaders sends the headers h with every request, like an Authorization.
+They replace the ones the fetcher sets itself, as the User-Agent, and the headers
+of the fetch init replace them in turn.
+*/
+func WithDefaultHeaders(h http.Header) Option {
+	return optionFunc(func(ft *fetcher) {
+		if ft.DefaultHeaders == nil {
+			ft.DefaultHeaders = make(http.Header)
+		}
+		for name, v := range h {
+			ft.DefaultHeaders[http.CanonicalHeaderKey(name)] = append([]string(nil), v...)
+		}
+	})
+}
+
+/*
+WithAcceptEncodings sets the content codings of the Accept-Encoding header sent
+with every request, DefaultAcceptEncoding without it. Only gzip, deflate, br, zstd
+and identity can be decoded, and no codings at all send identity. A script can set
+its own Accept-Encoding, the response is decoded the same way. The transport never
+asks for gzip itself, so a body is decoded once, by the fetcher.
+*/
+func WithAcceptEncodings(encodings ...string) Option {
+	return optionFunc(func(ft *fetcher) {
+		list := make([]string, 0, len(encodings))
+		for _, enc := range encodings {
+			enc = strings.ToLower(strings.TrimSpace(enc))
+
+			switch enc {
+			case "gzip", "deflate", "br", "zstd", "identity":
+				list = append(list, enc)
+			default:
+				ft.fail(fmt.Errorf("v8go-polyfills/fetch: unsupported accept encoding %q", enc))
+				return
+			}
+		}
+
+		if len(list) == 0 {
+			list = append(list, "identity")
+		}
+
+		ft.AcceptEncoding = strings.Join(list, ", ")
+	})
+}
+
 func WithAddrLocal(addr string) Option {
 	return optionFunc(func(ft *fetcher) {
 		ft.AddrLocal = addr
 	})
 }
+
+/*
+WithMaxRedirects sets how many redirects a request follows, 10 by default,
+one more rejects the fetch. With 0 no redirect is followed, like redirect: "error".
+*/
+func WithMaxRedirects(n int) Option {
+	return optionFunc(func(ft *fetcher) {
+		if n < 0 {
+			n = 0
+		}
+		ft.MaxRedirects = n
+	})
+}
+
+/*
+WithCookieJar stores the cookies of responses in jar, and sends them
+with the following requests of the fetcher. There is no jar by default.
+It's ignored with WithHTTPClient, the Jar of that client is used.
+*/
+func WithCookieJar(jar http.CookieJar) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.CookieJar = jar
+	})
+}
+
+/*
+WithCache keeps the responses of GET requests in cache, like NewMemoryCache.
+A fresh response of max-age or Expires is served without a request, a stale one
+with an ETag or Last-Modified is revalidated, and a 304 gives the cached response.
+Responses and requests with Cache-Control: no-store aren't cached. It's a shared cache,
+private responses aren't stored, and nor are the responses to requests with an
+Authorization or Cookie header or cookies of the jar, unless they're public. Set-Cookie
+isn't stored. A response which followed a redirect only answers requests which follow
+them, and a blocked host isn't served from the cache.
+*/
+func WithCache(cache Cache) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.Cache = cache
+	})
+}
+
+// NewCookieJar creates an in-memory cookie jar for WithCookieJar, it's safe for concurrent use
+func NewCookieJar() http.CookieJar {
+	// cookiejar.New never fails without options
+	jar, _ := cookiejar.New(nil)
+	return jar
+}
+
+/*
+WithHTTPClient sends the remote requests with client, instead of a client
+using http.DefaultTransport. The client isn't modified, each request uses
+a copy with its own CheckRedirect, so WithMaxRedirects and the redirect mode
+still apply. Its Jar and Timeout are used as they are, WithCookieJar is ignored.
+*/
+func WithHTTPClient(client *http.Client) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.HTTPClient = client
+	})
+}
+
+/*
+WithProxy sends the remote requests through a proxy, proxy is either its url
+as a string or *url.URL, or a func(*http.Request) (*url.URL, error) choosing one
+per request like http.Transport.Proxy. Userinfo of the url is sent as the
+Proxy-Authorization header, https urls are tunneled with CONNECT.
+It doesn't apply to the client of WithHTTPClient. An invalid url, or another type,
+makes NewFetcher fail.
+*/
+func WithProxy(proxy interface{}) Option {
+	return optionFunc(func(ft *fetcher) {
+		switch p := proxy.(type) {
+		case string:
+			u, err := url.Parse(p)
+			if err != nil {
+				ft.fail(fmt.Errorf("v8go-polyfills/fetch: invalid proxy url %s: %w", p, err))
+				return
+			}
+			ft.Proxy = http.ProxyURL(u)
+		case *url.URL:
+			ft.Proxy = http.ProxyURL(p)
+		case func(*http.Request) (*url.URL, error):
+			ft.Proxy = p
+		default:
+			ft.fail(fmt.Errorf("v8go-polyfills/fetch: unsupported proxy type %T", proxy))
+		}
+	})
+}
+
+/*
+WithTLSConfig uses a copy of config for the remote https requests,
+like RootCAs for a private CA or Certificates for client certificates.
+It doesn't apply to the local handler, or the client of WithHTTPClient.
+*/
+func WithTLSConfig(config *tls.Config) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.TLSConfig = config
+	})
+}
+
+// WithInsecureSkipVerify accepts any server certificate, only use it for tests
+func WithInsecureSkipVerify() Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.InsecureSkipVerify = true
+	})
+}
+
+/*
+WithForceHTTP1 sends the remote requests with HTTP/1.1 only, https servers can't
+negotiate HTTP/2. The protocol of a response is its ProtoMajor in WithResponseHook.
+It doesn't apply to the client of WithHTTPClient.
+*/
+func WithForceHTTP1() Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.ForceHTTP1 = true
+	})
+}
+
+/*
+WithHTTP2PriorKnowledge speaks HTTP/2 to http urls without negotiating it (h2c),
+so the servers must support it. https urls use HTTP/2 when the server negotiates it,
+like they do by default. WithProxy doesn't apply to the http urls then,
+and it doesn't apply to the client of WithHTTPClient.
+*/
+func WithHTTP2PriorKnowledge() Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.HTTP2PriorKnowledge = true
+	})
+}
+
+/*
+WithMaxIdleConns keeps at most n idle connections over all hosts, 0 means no limit.
+Like the other connection pool options it makes the requests keep their connections
+alive for reuse, instead of sending "Connection: close". None of them apply
+to the client of WithHTTPClient.
+*/
+func WithMaxIdleConns(n int) Option {
+	return optionFunc(func(ft *fetcher) {
+		if n < 0 {
+			ft.fail(fmt.Errorf("v8go-polyfills/fetch: invalid max idle conns %d", n))
+			return
+		}
+		ft.MaxIdleConns = &n
+	})
+}
+
+// WithMaxIdleConnsPerHost keeps at most n idle connections per host, 0 means http.DefaultMaxIdleConnsPerHost
+func WithMaxIdleConnsPerHost(n int) Option {
+	return optionFunc(func(ft *fetcher) {
+		if n < 0 {
+			ft.fail(fmt.Errorf("v8go-polyfills/fetch: invalid max idle conns per host %d", n))
+			return
+		}
+		ft.MaxIdleConnsPerHost = &n
+	})
+}
+
+/*
+WithMaxConnsPerHost opens at most n connections per host, counting the ones
+in use and the idle ones, more requests wait for one. 0 means no limit.
+*/
+func WithMaxConnsPerHost(n int) Option {
+	return optionFunc(func(ft *fetcher) {
+		if n < 0 {
+			ft.fail(fmt.Errorf("v8go-polyfills/fetch: invalid max conns per host %d", n))
+			return
+		}
+		ft.MaxConnsPerHost = &n
+	})
+}
+
+// WithIdleConnTimeout closes the connections idle for longer than d, 0 means no timeout
+func WithIdleConnTimeout(d time.Duration) Option {
+	return optionFunc(func(ft *fetcher) {
+		if d < 0 {
+			ft.fail(fmt.Errorf("v8go-polyfills/fetch: invalid idle conn timeout %s", d))
+			return
+		}
+		ft.IdleConnTimeout = &d
+	})
+}
+
+/*
+WithMaxBodySize limits the response bodies to n bytes after decompression,
+reading a longer body fails, naming the limit and the url. 0 means no limit.
+*/
+func WithMaxBodySize(n int64) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.MaxBodySize = n
+	})
+}
+
+/*
+WithDefaultTimeout gives every request a deadline of d, including reading its body.
+Running out of time rejects with a TimeoutError and closes the connection.
+A shorter AbortSignal.timeout of a fetch still applies. 0 means no deadline,
+which is the default.
+*/
+func WithDefaultTimeout(d time.Duration) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.DefaultTimeout = d
+	})
+}
+
+/*
+WithAllowedHosts only allows remote requests to hosts matching one of the patterns,
+either a host like "api.example.com" or a wildcard suffix like "*.example.com".
+Other hosts reject with a TypeError before anything is resolved or dialed,
+redirects are checked as well. It can be used more than once.
+*/
+func WithAllowedHosts(patterns ...string) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.AllowedHosts = append(ft.AllowedHosts, patterns...)
+	})
+}
+
+/*
+WithBlockedHosts rejects remote requests to hosts matching one of the patterns,
+like WithAllowedHosts does for the others. Blocking wins over allowing.
+*/
+func WithBlockedHosts(patterns ...string) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.BlockedHosts = append(ft.BlockedHosts, patterns...)
+	})
+}
+
+/*
+WithBlockPrivateIPs refuses to connect to loopback, private (RFC 1918),
+link-local and unique local (IPv6) addresses. It's checked when dialing,
+with the address being dialed, so a host resolving to a private address
+is blocked too. A proxy is dialed as well, so it must not be on a private
+address. It doesn't apply to the client of WithHTTPClient.
+*/
+func WithBlockPrivateIPs() Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.BlockPrivateIPs = true
+	})
+}
+
+/*
+WithFileSystem serves file: urls from the directory root of fsys, like
+"file:///templates/page.html" reading "templates/page.html" below root.
+The Content-Type is guessed from the extension. A missing file rejects
+with a TypeError, as does a path with "..". Without it file urls are unsupported,
+so the file system is only reachable when it's given explicitly.
+*/
+func WithFileSystem(fsys fs.FS, root string) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.FileSystem = fsys
+		ft.FileSystemRoot = root
+	})
+}
+
+/*
+WithRetry sends a remote GET or HEAD request up to max times, while it fails
+with a connection error or a 502, 503 or 504 response. The attempt before
+the next one waits for backoff(attempt), or the Retry-After of the response,
+up to a minute. A nil backoff starts with 100ms and doubles. Aborting the request
+or running out of time stops retrying, and so does a wait past the deadline of
+WithDefaultTimeout. A final rejection tells the number of attempts.
+*/
+func WithRetry(max int, backoff func(attempt int) time.Duration) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.RetryMax = max
+		ft.RetryBackoff = backoff
+	})
+}
+
+/*
+WithRetryMethods replaces the methods WithRetry applies to, GET and HEAD by default.
+Only add methods which are safe to send twice.
+*/
+func WithRetryMethods(methods ...string) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.RetryMethods = append([]string{}, methods...)
+	})
+}
+
+/*
+WithMaxConcurrent allows at most n requests of the fetcher in flight, a request
+is in flight until its response headers arrive, so unread bodies don't hold a slot.
+The other fetches wait in order, aborting one takes it out of the queue.
+*/
+func WithMaxConcurrent(n int) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.MaxConcurrent = n
+	})
+}
+
+/*
+WithQueueLimit lets at most m fetches wait for WithMaxConcurrent, the next one
+rejects with a TypeError right away. With 0 nothing waits, by default any number does.
+*/
+func WithQueueLimit(m int) Option {
+	return optionFunc(func(ft *fetcher) {
+		if m < 0 {
+			m = -1
+		}
+		ft.QueueLimit = m
+	})
+}
+
+/*
+WithRateLimit lets at most rps requests a second of the fetcher go to host, with
+bursts of up to burst requests, shared by all the contexts using the fetcher.
+The host matches like the one of WithLocalHandlerFor, and "*" sets the limit of
+every other host, each one counted on its own. Each redirect and retry counts
+against the limit of its host. A request over the limit waits, up to the delay
+of WithRateLimitMaxDelay, or it rejects with a "rate limited" TypeError.
+*/
+func WithRateLimit(host string, rps float64, burst int) Option {
+	return optionFunc(func(ft *fetcher) {
+		if rps <= 0 {
+			ft.fail(fmt.Errorf("v8go-polyfills/fetch: rate limit of %s must be positive, got %v", host, rps))
+			return
+		}
+
+		if burst < 1 {
+			burst = 1
+		}
+
+		if ft.RateLimits == nil {
+			ft.RateLimits = make(map[string]rateLimit)
+		}
+		ft.RateLimits[hostKey(host)] = rateLimit{rps: rps, burst: burst}
+	})
+}
+
+// WithRateLimitMaxDelay sets how long a request waits for WithRateLimit, DefaultRateLimitMaxDelay without it
+func WithRateLimitMaxDelay(d time.Duration) Option {
+	return optionFunc(func(ft *fetcher) {
+		if d < 0 {
+			d = 0
+		}
+		ft.RateLimitMaxDelay = d
+	})
+}
+
+/*
+WithRequestHook calls hook with each request before it's sent to the network
+or a local handler, it may change it, like adding a header. An error rejects
+the fetch with its message. Every attempt of WithRetry calls it again.
+Hooks run in the order they are added, and concurrently for parallel fetches.
+*/
+func WithRequestHook(hook func(*http.Request) error) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.RequestHooks = append(ft.RequestHooks, hook)
+	})
+}
+
+/*
+WithResponseHook calls hook after each attempt with the request, its response,
+and how long the response took, without reading the body. The response is nil
+when the attempt failed. The hook must not read or close the body.
+Hooks run in the order they are added, and concurrently for parallel fetches.
+*/
+func WithResponseHook(hook func(*http.Request, *http.Response, time.Duration)) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.ResponseHooks = append(ft.ResponseHooks, hook)
+	})
+}
+
+/*
+WithUploadProgress calls fn while the body of a remote request is sent, with the
+bytes sent so far and the total, -1 if it's unknown. It's called at most about
+10 times a second per request and a last time with the full size, each redirect
+that sends the body again starts over. It runs on the goroutine of the transfer.
+*/
+func WithUploadProgress(fn func(url string, sent, total int64)) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.UploadProgress = fn
+	})
+}
+
+/*
+WithDownloadProgress calls fn like WithUploadProgress while the body of a remote
+response is received, with the bytes before decoding, as Content-Length counts them.
+The total is -1 for a chunked body. There are no calls after the body is closed.
+*/
+func WithDownloadProgress(fn func(url string, received, total int64)) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.DownloadProgress = fn
+	})
+}
+
+/*
+WithExpectContinue sends the requests with a body of threshold bytes or more with
+Expect: 100-continue, so the body is only sent once the server asks for it with a
+100 Continue, or after the ExpectContinueTimeout of the transport, 1s by default.
+A server rejecting the request right away saves the upload then.
+*/
+func WithExpectContinue(threshold int64) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.ExpectContinue = threshold
+	})
+}
+
+/*
+WithEarlyHints calls fn with the headers of each 103 Early Hints response,
+like the Link headers to preload, and the url of the request it answers.
+The fetch resolves with the final response anyway, skipping the informational
+ones. fn is called concurrently for parallel fetches.
+*/
+func WithEarlyHints(fn func(url string, header http.Header)) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.EarlyHints = fn
+	})
+}
+
+/*
+WithMetrics reports the traffic of every fetch to m, the requests with their
+method and host, the responses with their status, size and duration, and the
+errors by kind. The size is reported as the script got it and as received,
+before it was decoded. Local handler, data: and file: fetches are flagged as local.
+*/
+func WithMetrics(m Metrics) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.Metrics = m
+	})
+}
+
+/*
+WithLogger logs the start, the end and the failure of every fetch to logger,
+with the method, the url without its userinfo, how it was routed, the status,
+the number of body bytes read, and the duration. Bodies are never logged.
+Each fetch gets an id, logged as request_id and sent to the script as the
+X-V8go-Request-Id header of the response. Nothing is logged by default.
+*/
+func WithLogger(logger *slog.Logger) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.Logger = logger
+	})
+}
+
+/*
+WithHARRecorder records every request sent to the network or a local handler
+in rec, including redirects and retries, call rec.HAR() for the HTTP Archive.
+*/
+func WithHARRecorder(rec *HARRecorder) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.HAR = rec
+	})
+}
+
+/*
+WithRecording saves the response of every remote request as a fixture in dir,
+keyed by the method, the url and a hash of the body, for WithReplay.
+The bodies are read completely to save them, they aren't streamed.
+*/
+func WithRecording(dir string) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.RecordDir = dir
+	})
+}
+
+/*
+WithReplay serves the remote requests from the fixtures WithRecording saved in dir,
+nothing is sent to the network. A request without a fixture rejects with a TypeError
+naming its key. It can't be used along with WithRecording.
+*/
+func WithReplay(dir string) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.ReplayDir = dir
+	})
+}
+
+/*
+WithFixtureIgnoreHeaders leaves the response headers of names, like Date or Set-Cookie,
+out of the fixtures of WithRecording, so they don't change with every recording.
+*/
+func WithFixtureIgnoreHeaders(names ...string) Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.FixtureIgnoreHeaders = append(ft.FixtureIgnoreHeaders, names...)
+	})
+}
+
+/*
+WithStrictContentEncoding rejects responses with a Content-Encoding that can't
+be decoded, naming it and the url, instead of passing the body on as it is.
+*/
+func WithStrictContentEncoding() Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.StrictContentEncoding = true
+	})
+}
+
+/*
+WithExposeSetCookie lets scripts read the Set-Cookie headers of responses like
+the others, by default they are hidden like in browsers. headers.getSetCookie()
+returns them either way.
+*/
+func WithExposeSetCookie() Option {
+	return optionFunc(func(ft *fetcher) {
+		ft.ExposeSetCookie = true
+	})
+}
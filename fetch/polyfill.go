@@ -0,0 +1,379 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/weese/v8go-polyfills/blob"
+	. "github.com/weese/v8go-polyfills/internal"
+
+	"rogchap.com/v8go"
+)
+
+//go:embed polyfill.js
+var fetchPolyfill string
+
+/*
+polyfillExports returns the objects defined by the JS side of the polyfill.
+InjectTo only has the global template to work with, so the script is evaluated
+the first time one of the injected functions runs in a context. The exports are
+kept by the fetcher, not in a global, so scripts can't see or replace them.
+*/
+func (f *fetcher) polyfillExports(ctx *v8go.Context) (*v8go.Object, error) {
+	f.mu.Lock()
+	exports, ok := f.exports[ctx]
+	f.mu.Unlock()
+
+	if ok {
+		return exports, nil
+	}
+
+	// response.blob() needs Blob, one injected before is kept
+	if err := blob.InjectTo(ctx); err != nil {
+		return nil, err
+	}
+
+	val, err := ctx.RunScript(fetchPolyfill, "fetch-polyfill.js")
+	if err != nil {
+		return nil, err
+	}
+
+	factory, err := val.AsFunction()
+	if err != nil {
+		return nil, err
+	}
+
+	natives, err := f.newNativeObject(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err = factory.Call(v8go.Undefined(ctx.Isolate()), natives)
+	if err != nil {
+		return nil, err
+	}
+
+	if exports, err = val.AsObject(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	if f.exports == nil {
+		f.exports = make(map[*v8go.Context]*v8go.Object)
+	}
+	f.exports[ctx] = exports
+	f.mu.Unlock()
+
+	return exports, nil
+}
+
+/*
+ClearContext drops what the fetcher keeps of ctx, the exports of the polyfill, so
+ctx can be closed. A fetcher injected into many contexts should get it for each of
+them, once its fetches are done; using the polyfill in ctx again evaluates it anew.
+*/
+func (f *fetcher) ClearContext(ctx *v8go.Context) {
+	f.mu.Lock()
+	delete(f.exports, ctx)
+	f.mu.Unlock()
+}
+
+/*
+newNativeObject creates the object passed to the JS side of the polyfill,
+holding the functions implemented in Go.
+*/
+func (f *fetcher) newNativeObject(ctx *v8go.Context) (*v8go.Object, error) {
+	iso := ctx.Isolate()
+
+	nativeTmp := v8go.NewObjectTemplate(iso)
+
+	for _, fn := range []struct {
+		Name string
+		Tmp  interface{}
+	}{
+		{Name: "fetch", Tmp: v8go.NewFunctionTemplate(iso, f.fetchFunctionCallback())},
+	} {
+		if err := nativeTmp.Set(fn.Name, fn.Tmp, v8go.ReadOnly); err != nil {
+			return nil, err
+		}
+	}
+
+	natives, err := nativeTmp.NewInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := natives.Set("exposeSetCookie", f.ExposeSetCookie); err != nil {
+		return nil, err
+	}
+
+	return natives, nil
+}
+
+/*
+polyfillFunctionCallback calls the named export of the JS side,
+this is what gets injected to the global object.
+*/
+func (f *fetcher) polyfillFunctionCallback(name string) v8go.FunctionCallback {
+	return func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		ctx := info.Context()
+
+		exports, err := f.polyfillExports(ctx)
+		if err != nil {
+			return newRejectedPromise(ctx, fmt.Errorf("init polyfill: %w", err))
+		}
+
+		val, err := exports.Get(name)
+		if err != nil {
+			return newRejectedPromise(ctx, err)
+		}
+
+		fn, err := val.AsFunction()
+		if err != nil {
+			return newRejectedPromise(ctx, err)
+		}
+
+		args := make([]v8go.Valuer, len(info.Args()))
+		for i, arg := range info.Args() {
+			args[i] = arg
+		}
+
+		val, err = fn.Call(info.This(), args...)
+		if err != nil {
+			return newRejectedPromise(ctx, err)
+		}
+
+		return val
+	}
+}
+
+/*
+polyfillConstructorCallback constructs the named class of the JS side.
+v8go can't tell a construct call from a plain one, so both construct.
+*/
+func (f *fetcher) polyfillConstructorCallback(name string) v8go.FunctionCallback {
+	return func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		ctx := info.Context()
+
+		exports, err := f.polyfillExports(ctx)
+		if err != nil {
+			return throwError(ctx, fmt.Errorf("init polyfill: %w", err))
+		}
+
+		val, err := exports.Get(name)
+		if err != nil {
+			return throwError(ctx, err)
+		}
+
+		fn, err := val.AsFunction()
+		if err != nil {
+			return throwError(ctx, err)
+		}
+
+		args := make([]v8go.Valuer, len(info.Args()))
+		for i, arg := range info.Args() {
+			args[i] = arg
+		}
+
+		obj, err := fn.NewInstance(args...)
+		if err != nil {
+			return throwError(ctx, err)
+		}
+
+		return obj.Value
+	}
+}
+
+/*
+blobConstructorCallback defines Blob in the context, like the JS side does
+when it's evaluated, and constructs one. A Blob doesn't need the fetcher.
+*/
+func blobConstructorCallback(info *v8go.FunctionCallbackInfo) *v8go.Value {
+	ctx := info.Context()
+
+	if err := blob.InjectTo(ctx); err != nil {
+		return throwError(ctx, fmt.Errorf("init polyfill: %w", err))
+	}
+
+	val, err := ctx.Global().Get("Blob")
+	if err != nil {
+		return throwError(ctx, err)
+	}
+
+	fn, err := val.AsFunction()
+	if err != nil {
+		return throwError(ctx, err)
+	}
+
+	args := make([]v8go.Valuer, len(info.Args()))
+	for i, arg := range info.Args() {
+		args[i] = arg
+	}
+
+	obj, err := fn.NewInstance(args...)
+	if err != nil {
+		return throwError(ctx, err)
+	}
+
+	return obj.Value
+}
+
+// polyfillStaticCallback calls the named static method of a class of the JS side
+func (f *fetcher) polyfillStaticCallback(class, name string) v8go.FunctionCallback {
+	return func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		ctx := info.Context()
+
+		exports, err := f.polyfillExports(ctx)
+		if err != nil {
+			return throwError(ctx, fmt.Errorf("init polyfill: %w", err))
+		}
+
+		classVal, err := exports.Get(class)
+		if err != nil {
+			return throwError(ctx, err)
+		}
+
+		classObj, err := classVal.AsObject()
+		if err != nil {
+			return throwError(ctx, err)
+		}
+
+		val, err := classObj.Get(name)
+		if err != nil {
+			return throwError(ctx, err)
+		}
+
+		fn, err := val.AsFunction()
+		if err != nil {
+			return throwError(ctx, err)
+		}
+
+		args := make([]v8go.Valuer, len(info.Args()))
+		for i, arg := range info.Args() {
+			args[i] = arg
+		}
+
+		val, err = fn.Call(classVal, args...)
+		if err != nil {
+			return throwError(ctx, err)
+		}
+
+		return val
+	}
+}
+
+/*
+throwError throws err in ctx, a JS error caught by v8go is thrown again
+as an error of the same type, with the same message.
+*/
+func throwError(ctx *v8go.Context, err error) *v8go.Value {
+	iso := ctx.Isolate()
+
+	name, msg := "Error", err.Error()
+
+	var jsErr *v8go.JSError
+	if errors.As(err, &jsErr) {
+		if i := strings.Index(jsErr.Message, ": "); i > 0 {
+			name, msg = jsErr.Message[:i], jsErr.Message[i+2:]
+		}
+	}
+
+	if e, err := newJSError(ctx, name, msg); err == nil {
+		return iso.ThrowException(e)
+	}
+
+	val, _ := NewStringValue(ctx, err.Error())
+	return iso.ThrowException(val)
+}
+
+// newJSError creates an error of the named global constructor, like TypeError
+func newJSError(ctx *v8go.Context, name, msg string) (*v8go.Value, error) {
+	val, err := ctx.Global().Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctor, err := val.AsFunction()
+	if err != nil {
+		return nil, err
+	}
+
+	msgVal, err := NewStringValue(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := ctor.NewInstance(msgVal)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Value, nil
+}
+
+/*
+newTimeoutError creates a TimeoutError DOMException, the abort polyfill
+defines DOMException, without it it's an Error named TimeoutError.
+*/
+func newTimeoutError(ctx *v8go.Context, msg string) (*v8go.Value, error) {
+	if val, err := ctx.Global().Get("DOMException"); err == nil && val.IsFunction() {
+		ctor, _ := val.AsFunction()
+
+		msgVal, err := NewStringValue(ctx, msg)
+		if err != nil {
+			return nil, err
+		}
+
+		nameVal, err := v8go.NewValue(ctx.Isolate(), "TimeoutError")
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := ctor.NewInstance(msgVal, nameVal)
+		if err != nil {
+			return nil, err
+		}
+
+		return e.Value, nil
+	}
+
+	e, err := newJSError(ctx, "Error", msg)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := e.AsObject()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := obj.Set("name", "TimeoutError"); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
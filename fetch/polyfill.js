@@ -0,0 +1,1270 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+(function (native) {
+  "use strict";
+
+  const kNative = Symbol("native");
+  const kBodyUsed = Symbol("bodyUsed");
+  const kStream = Symbol("stream");
+
+  const kSource = Symbol("source");
+  const kReader = Symbol("reader");
+  const kState = Symbol("state");
+  const kError = Symbol("error");
+  const kDisturbed = Symbol("disturbed");
+  const kClosed = Symbol("closed");
+  const kPending = Symbol("pending");
+
+  const kHeaderList = Symbol("headerList");
+  const kHeaders = Symbol("headers");
+  const kSetCookies = Symbol("setCookies");
+
+  const kRequest = Symbol("request");
+
+  // byte strings from Go carry one byte per code unit, shifted by 0x100
+  function byteStringToUint8Array(str) {
+    const bytes = new Uint8Array(str.length);
+    for (let i = 0; i < str.length; i++) {
+      bytes[i] = str.charCodeAt(i) & 0xff;
+    }
+
+    return bytes;
+  }
+
+  // the reverse of byteStringToUint8Array
+  function uint8ArrayToByteString(bytes) {
+    const chunkSize = 8192;
+    const units = new Uint16Array(Math.min(bytes.length, chunkSize));
+
+    let str = "";
+    for (let i = 0; i < bytes.length; i += chunkSize) {
+      const chunk = bytes.subarray(i, i + chunkSize);
+      for (let j = 0; j < chunk.length; j++) {
+        units[j] = chunk[j] | 0x100;
+      }
+
+      str += String.fromCharCode.apply(null, units.subarray(0, chunk.length));
+    }
+
+    return str;
+  }
+
+  // there is no TextEncoder in v8go, lone surrogates become U+FFFD like it does
+  function utf8Encode(str) {
+    const bytes = [];
+
+    for (const ch of str) {
+      let c = ch.codePointAt(0);
+      if (c >= 0xd800 && c <= 0xdfff) {
+        c = 0xfffd;
+      }
+
+      if (c < 0x80) {
+        bytes.push(c);
+      } else if (c < 0x800) {
+        bytes.push(0xc0 | (c >> 6), 0x80 | (c & 0x3f));
+      } else if (c < 0x10000) {
+        bytes.push(
+          0xe0 | (c >> 12),
+          0x80 | ((c >> 6) & 0x3f),
+          0x80 | (c & 0x3f)
+        );
+      } else {
+        bytes.push(
+          0xf0 | (c >> 18),
+          0x80 | ((c >> 12) & 0x3f),
+          0x80 | ((c >> 6) & 0x3f),
+          0x80 | (c & 0x3f)
+        );
+      }
+    }
+
+    return new Uint8Array(bytes);
+  }
+
+  /*
+   * utf8Decode decodes like TextDecoder, a BOM is skipped and
+   * each invalid sequence becomes U+FFFD.
+   * https://encoding.spec.whatwg.org/#utf-8-decoder
+   */
+  function utf8Decode(bytes) {
+    const points = [];
+    let i =
+      bytes[0] === 0xef && bytes[1] === 0xbb && bytes[2] === 0xbf ? 3 : 0;
+
+    while (i < bytes.length) {
+      const b = bytes[i++];
+      if (b < 0x80) {
+        points.push(b);
+        continue;
+      }
+
+      let needed, c;
+      let lower = 0x80,
+        upper = 0xbf;
+
+      if (b >= 0xc2 && b <= 0xdf) {
+        needed = 1;
+        c = b & 0x1f;
+      } else if (b >= 0xe0 && b <= 0xef) {
+        lower = b === 0xe0 ? 0xa0 : lower;
+        upper = b === 0xed ? 0x9f : upper;
+        needed = 2;
+        c = b & 0x0f;
+      } else if (b >= 0xf0 && b <= 0xf4) {
+        lower = b === 0xf0 ? 0x90 : lower;
+        upper = b === 0xf4 ? 0x8f : upper;
+        needed = 3;
+        c = b & 0x07;
+      } else {
+        points.push(0xfffd);
+        continue;
+      }
+
+      for (; needed > 0; needed--) {
+        // the byte that doesn't fit starts the next sequence
+        if (i >= bytes.length || bytes[i] < lower || bytes[i] > upper) {
+          c = 0xfffd;
+          break;
+        }
+
+        c = (c << 6) | (bytes[i++] & 0x3f);
+        lower = 0x80;
+        upper = 0xbf;
+      }
+
+      points.push(c);
+    }
+
+    const chunkSize = 8192;
+
+    let str = "";
+    for (let j = 0; j < points.length; j += chunkSize) {
+      str += String.fromCodePoint.apply(null, points.slice(j, j + chunkSize));
+    }
+
+    return str;
+  }
+
+  // https://fetch.spec.whatwg.org/#header-name
+  const headerNamePattern = /^[!#$%&'*+\-.^_`|~0-9A-Za-z]+$/;
+
+  function checkArgs(className, method, count, required) {
+    if (count < required) {
+      throw new TypeError(
+        `Failed to execute '${method}' on '${className}': ${required} arguments required, but only ${count} present.`
+      );
+    }
+  }
+
+  function normalizeHeaderName(name) {
+    name = String(name);
+    if (!headerNamePattern.test(name)) {
+      throw new TypeError(`Invalid header name: '${name}'.`);
+    }
+
+    return name.toLowerCase();
+  }
+
+  // https://fetch.spec.whatwg.org/#concept-header-value-normalize
+  function normalizeHeaderValue(value) {
+    value = String(value).replace(/^[\t\n\r ]+|[\t\n\r ]+$/g, "");
+    if (/[\0\r\n]/.test(value)) {
+      throw new TypeError(`Invalid header value: '${value}'.`);
+    }
+
+    return value;
+  }
+
+  // https://fetch.spec.whatwg.org/#concept-headers-fill
+  function fillHeaders(headers, init) {
+    if (init instanceof Headers) {
+      for (const [name, value] of init[kHeaderList]) {
+        headers[kHeaderList].push([name, value]);
+      }
+      return;
+    }
+
+    if (
+      init === null ||
+      (typeof init !== "object" && typeof init !== "function")
+    ) {
+      throw new TypeError(
+        "Failed to construct 'Headers': The provided value is not of type '(record<ByteString, ByteString> or sequence<sequence<ByteString>>)'."
+      );
+    }
+
+    if (typeof init[Symbol.iterator] === "function") {
+      for (const pair of init) {
+        const items = typeof pair === "string" ? [] : Array.from(pair);
+        if (items.length !== 2) {
+          throw new TypeError(
+            "Failed to construct 'Headers': Invalid value, header pairs must contain exactly two items."
+          );
+        }
+
+        headers.append(items[0], items[1]);
+      }
+      return;
+    }
+
+    for (const name of Object.keys(init)) {
+      headers.append(name, init[name]);
+    }
+  }
+
+  // https://fetch.spec.whatwg.org/#concept-header-list-sort-and-combine
+  function sortAndCombine(list) {
+    const names = [...new Set(list.map(([name]) => name))].sort();
+    const headers = [];
+
+    for (const name of names) {
+      const values = list.filter(([n]) => n === name).map(([, v]) => v);
+
+      if (name === "set-cookie") {
+        for (const value of values) {
+          headers.push([name, value]);
+        }
+      } else {
+        headers.push([name, values.join(", ")]);
+      }
+    }
+
+    return headers;
+  }
+
+  class Headers {
+    constructor(init) {
+      this[kHeaderList] = [];
+
+      if (init !== undefined) {
+        fillHeaders(this, init);
+      }
+    }
+
+    append(name, value) {
+      checkArgs("Headers", "append", arguments.length, 2);
+
+      this[kHeaderList].push([
+        normalizeHeaderName(name),
+        normalizeHeaderValue(value),
+      ]);
+    }
+
+    delete(name) {
+      checkArgs("Headers", "delete", arguments.length, 1);
+
+      name = normalizeHeaderName(name);
+      this[kHeaderList] = this[kHeaderList].filter(([n]) => n !== name);
+
+      if (name === "set-cookie") {
+        this[kSetCookies] = undefined;
+      }
+    }
+
+    get(name) {
+      checkArgs("Headers", "get", arguments.length, 1);
+
+      name = normalizeHeaderName(name);
+      const values = this[kHeaderList]
+        .filter(([n]) => n === name)
+        .map(([, v]) => v);
+
+      return values.length === 0 ? null : values.join(", ");
+    }
+
+    has(name) {
+      checkArgs("Headers", "has", arguments.length, 1);
+
+      name = normalizeHeaderName(name);
+      return this[kHeaderList].some(([n]) => n === name);
+    }
+
+    set(name, value) {
+      checkArgs("Headers", "set", arguments.length, 2);
+
+      name = normalizeHeaderName(name);
+      value = normalizeHeaderValue(value);
+
+      if (name === "set-cookie") {
+        this[kSetCookies] = undefined;
+      }
+
+      const index = this[kHeaderList].findIndex(([n]) => n === name);
+      if (index < 0) {
+        this[kHeaderList].push([name, value]);
+        return;
+      }
+
+      // replace the first one, and remove the others
+      this[kHeaderList] = this[kHeaderList].filter(
+        ([n], i) => i <= index || n !== name
+      );
+      this[kHeaderList][index] = [name, value];
+    }
+
+    // the Set-Cookie values are never combined, a comma may be part of one
+    getSetCookie() {
+      const values = this[kHeaderList]
+        .filter(([n]) => n === "set-cookie")
+        .map(([, v]) => v);
+
+      return this[kSetCookies] === undefined
+        ? values
+        : [...this[kSetCookies], ...values];
+    }
+
+    forEach(callback, thisArg) {
+      checkArgs("Headers", "forEach", arguments.length, 1);
+
+      for (const [name, value] of this.entries()) {
+        callback.call(thisArg, value, name, this);
+      }
+    }
+
+    *entries() {
+      yield* sortAndCombine(this[kHeaderList]);
+    }
+
+    *keys() {
+      for (const [name] of this.entries()) {
+        yield name;
+      }
+    }
+
+    *values() {
+      for (const [, value] of this.entries()) {
+        yield value;
+      }
+    }
+
+    [Symbol.iterator]() {
+      return this.entries();
+    }
+
+    get [Symbol.toStringTag]() {
+      return "Headers";
+    }
+  }
+
+  /*
+   * list is already normalized by the Go side, as [name, value] pairs,
+   * the setCookies are only returned by getSetCookie(), like browsers
+   * hide them from scripts.
+   */
+  function createHeaders(list, setCookies) {
+    const headers = new Headers();
+    headers[kHeaderList] = list;
+    headers[kSetCookies] = setCookies;
+
+    return headers;
+  }
+
+  // the blob polyfill, injected along with this one, reads the bytes through this
+  const kBlobBytes = Symbol.for("v8go-polyfills.Blob.bytes");
+
+  function isBlob(value) {
+    return (
+      typeof Blob === "function" &&
+      value instanceof Blob &&
+      typeof value[kBlobBytes] === "function"
+    );
+  }
+
+  /*
+   * extractBody converts a request body to what the Go side reads,
+   * v8go can't access the memory of an ArrayBuffer, so bytes are
+   * sent as a byte string.
+   * https://fetch.spec.whatwg.org/#concept-bodyinit-extract
+   */
+  function extractBody(body) {
+    // the formdata polyfill defines FormData, if it's injected
+    if (typeof FormData === "function" && body instanceof FormData) {
+      const entries = body[Symbol.for("v8go-polyfills.FormData.entryList")]();
+
+      return {
+        body: undefined,
+        formData: entries.map(({ name, value, filename, type }) => {
+          if (filename === undefined) {
+            return { name, value };
+          }
+
+          const bytes = isBlob(value)
+            ? value[kBlobBytes]()
+            : ArrayBuffer.isView(value)
+            ? new Uint8Array(value.buffer, value.byteOffset, value.byteLength)
+            : new Uint8Array(value);
+
+          return {
+            name,
+            value: uint8ArrayToByteString(bytes),
+            filename,
+            type,
+          };
+        }),
+      };
+    }
+
+    // the url polyfill defines URLSearchParams, if it's injected
+    if (
+      typeof URLSearchParams === "function" &&
+      body instanceof URLSearchParams
+    ) {
+      return {
+        body: body.toString(),
+        bodyEncoding: "text",
+        bodyType: "application/x-www-form-urlencoded;charset=UTF-8",
+      };
+    }
+
+    if (isBlob(body)) {
+      return {
+        body: uint8ArrayToByteString(body[kBlobBytes]()),
+        bodyEncoding: "bytes",
+        bodyType: body.type,
+      };
+    }
+
+    if (body instanceof ArrayBuffer) {
+      return {
+        body: uint8ArrayToByteString(new Uint8Array(body)),
+        bodyEncoding: "bytes",
+        bodyType: "",
+      };
+    }
+
+    if (ArrayBuffer.isView(body)) {
+      const bytes = new Uint8Array(
+        body.buffer,
+        body.byteOffset,
+        body.byteLength
+      );
+
+      return {
+        body: uint8ArrayToByteString(bytes),
+        bodyEncoding: "bytes",
+        bodyType: "",
+      };
+    }
+
+    return {
+      body: String(body),
+      bodyEncoding: "text",
+      bodyType: "text/plain;charset=UTF-8",
+    };
+  }
+
+  /*
+   * A minimal ReadableStream, just enough to read a response body.
+   * The source has to implement pull(), resolving the next Uint8Array chunk
+   * or undefined at the end, and cancel().
+   */
+  class ReadableStream {
+    constructor(source) {
+      this[kSource] = source;
+      this[kReader] = undefined;
+      this[kState] = "readable";
+      this[kError] = undefined;
+      this[kDisturbed] = false;
+    }
+
+    get locked() {
+      return this[kReader] !== undefined;
+    }
+
+    getReader() {
+      return new ReadableStreamDefaultReader(this);
+    }
+
+    cancel() {
+      if (this.locked) {
+        return Promise.reject(new TypeError("ReadableStream is locked."));
+      }
+
+      cancelStream(this);
+      return Promise.resolve();
+    }
+  }
+
+  function cancelStream(stream) {
+    stream[kDisturbed] = true;
+
+    if (stream[kState] === "readable") {
+      stream[kState] = "closed";
+      stream[kSource].cancel();
+    }
+  }
+
+  class ReadableStreamDefaultReader {
+    constructor(stream) {
+      if (!(stream instanceof ReadableStream)) {
+        throw new TypeError("Argument is not a ReadableStream.");
+      }
+
+      if (stream.locked) {
+        throw new TypeError("ReadableStream is locked.");
+      }
+
+      stream[kReader] = this;
+      this[kStream] = stream;
+
+      let resolveClosed, rejectClosed;
+      const closed = new Promise((resolve, reject) => {
+        resolveClosed = resolve;
+        rejectClosed = reject;
+      });
+      closed.catch(() => {});
+
+      this[kClosed] = {
+        promise: closed,
+        resolve: resolveClosed,
+        reject: rejectClosed,
+      };
+      this[kPending] = Promise.resolve();
+    }
+
+    get closed() {
+      return this[kClosed].promise;
+    }
+
+    read() {
+      const stream = this[kStream];
+      if (stream === undefined) {
+        return Promise.reject(new TypeError("Reader has been released."));
+      }
+      stream[kDisturbed] = true;
+
+      const done = () => {
+        this[kClosed].resolve();
+        return { value: undefined, done: true };
+      };
+
+      // pull one chunk at a time, in the order read() was called
+      const result = this[kPending].then(() => {
+        switch (stream[kState]) {
+          case "closed":
+            return done();
+          case "errored":
+            throw stream[kError];
+        }
+
+        return stream[kSource].pull().then(
+          (chunk) => {
+            if (chunk === undefined) {
+              stream[kState] = "closed";
+              return done();
+            }
+
+            return { value: chunk, done: false };
+          },
+          (e) => {
+            // a pull interrupted by cancel() just ends the stream
+            if (stream[kState] === "closed") {
+              return done();
+            }
+
+            stream[kState] = "errored";
+            stream[kError] = e;
+            this[kClosed].reject(e);
+            throw e;
+          }
+        );
+      });
+      this[kPending] = result.catch(() => {});
+
+      return result;
+    }
+
+    cancel() {
+      const stream = this[kStream];
+      if (stream === undefined) {
+        return Promise.reject(new TypeError("Reader has been released."));
+      }
+
+      cancelStream(stream);
+      this[kClosed].resolve();
+
+      return Promise.resolve();
+    }
+
+    releaseLock() {
+      const stream = this[kStream];
+      if (stream === undefined) {
+        return;
+      }
+
+      stream[kReader] = undefined;
+      this[kStream] = undefined;
+    }
+  }
+
+  /*
+   * newSyntheticNative implements the native response of the Go side in JS,
+   * for the responses created by scripts, bytes is null for a null body.
+   */
+  function newSyntheticNative(status, statusText, type, bytes) {
+    const chunkSize = 65536;
+    let offset = 0;
+
+    return {
+      headers: [],
+      setCookies: [],
+      ok: status >= 200 && status <= 299,
+      redirected: false,
+      status,
+      statusText,
+      rawStatus: `${status} ${statusText}`,
+      url: "",
+      type,
+      nullBody: bytes === null,
+      read() {
+        if (bytes === null || offset >= bytes.length) {
+          return Promise.resolve(undefined);
+        }
+
+        const chunk = bytes.subarray(offset, offset + chunkSize);
+        offset += chunk.length;
+
+        return Promise.resolve(uint8ArrayToByteString(chunk));
+      },
+      cancel() {
+        offset = Infinity;
+      },
+      readAll: () => Promise.resolve(),
+      bytes: () => uint8ArrayToByteString(bytes || new Uint8Array(0)),
+      text: () => (bytes === null ? "" : utf8Decode(bytes)),
+      json() {
+        return JSON.parse(this.text());
+      },
+      trailers: () => [],
+      clone: () => newSyntheticNative(status, statusText, type, bytes),
+    };
+  }
+
+  // extractBytes is extractBody for the bodies kept in JS
+  function extractBytes(body) {
+    const extracted = extractBody(body);
+    if (extracted.formData !== undefined) {
+      throw new TypeError(
+        "Failed to construct 'Response': FormData bodies are not supported."
+      );
+    }
+
+    return {
+      bytes:
+        extracted.bodyEncoding === "bytes"
+          ? byteStringToUint8Array(extracted.body)
+          : utf8Encode(extracted.body),
+      type: extracted.bodyType,
+    };
+  }
+
+  // https://fetch.spec.whatwg.org/#null-body-status
+  const nullBodyStatuses = [101, 103, 204, 205, 304];
+
+  // https://www.rfc-editor.org/rfc/rfc9112#name-status-line
+  const reasonPhrasePattern = /^[\t\x20-\x7e\x80-\xff]*$/;
+
+  function isBodyUsed(res) {
+    const stream = res[kStream];
+    return res[kBodyUsed] || (stream !== undefined && stream[kDisturbed]);
+  }
+
+  function isBodyLocked(res) {
+    const stream = res[kStream];
+    return stream !== undefined && stream.locked;
+  }
+
+  function consumeBody(res) {
+    if (isBodyUsed(res)) {
+      return Promise.reject(new TypeError("Body has already been consumed."));
+    }
+
+    if (isBodyLocked(res)) {
+      return Promise.reject(new TypeError("Body stream is locked."));
+    }
+    res[kBodyUsed] = true;
+
+    return res[kNative].readAll();
+  }
+
+  // createResponse wraps the native response of a fetch
+  function createResponse(res) {
+    const response = Object.create(Response.prototype);
+    response[kNative] = res;
+
+    return response;
+  }
+
+  // https://fetch.spec.whatwg.org/#dom-response
+  class Response {
+    constructor(body = null, init = {}) {
+      if (init === null) {
+        init = {};
+      } else if (typeof init !== "object") {
+        throw new TypeError(
+          "Failed to construct 'Response': The provided value is not of type 'ResponseInit'."
+        );
+      }
+
+      const status = init.status === undefined ? 200 : Number(init.status);
+      if (!Number.isInteger(status) || status < 200 || status > 599) {
+        throw new RangeError(
+          `Failed to construct 'Response': The status provided (${init.status}) is outside the range [200, 599].`
+        );
+      }
+
+      const statusText =
+        init.statusText === undefined ? "" : String(init.statusText);
+      if (!reasonPhrasePattern.test(statusText)) {
+        throw new TypeError(
+          "Failed to construct 'Response': Invalid statusText."
+        );
+      }
+
+      const headers = new Headers(init.headers);
+
+      let bytes = null;
+      if (body !== null && body !== undefined) {
+        if (nullBodyStatuses.includes(status)) {
+          throw new TypeError(
+            "Failed to construct 'Response': Response with null body status cannot have body."
+          );
+        }
+
+        const extracted = extractBytes(body);
+        if (extracted.type !== "" && !headers.has("content-type")) {
+          headers.set("content-type", extracted.type);
+        }
+
+        bytes = extracted.bytes;
+      }
+
+      this[kNative] = newSyntheticNative(status, statusText, "default", bytes);
+      this[kHeaders] = headers;
+    }
+
+    // https://fetch.spec.whatwg.org/#dom-response-json
+    static json(data, init = {}) {
+      const text = JSON.stringify(data);
+      if (text === undefined) {
+        throw new TypeError(
+          "Failed to execute 'json' on 'Response': The data is not JSON serializable."
+        );
+      }
+
+      const headers = new Headers(init === null ? undefined : init.headers);
+      if (!headers.has("content-type")) {
+        headers.set("content-type", "application/json");
+      }
+
+      return new Response(text, { ...init, headers });
+    }
+
+    // https://fetch.spec.whatwg.org/#dom-response-error
+    static error() {
+      return createResponse(newSyntheticNative(0, "", "error", null));
+    }
+
+    get headers() {
+      if (this[kHeaders] === undefined) {
+        const res = this[kNative];
+
+        this[kHeaders] = native.exposeSetCookie
+          ? createHeaders([
+              ...res.headers,
+              ...res.setCookies.map((value) => ["set-cookie", value]),
+            ])
+          : createHeaders(res.headers, res.setCookies);
+      }
+
+      return this[kHeaders];
+    }
+
+    get ok() {
+      return this[kNative].ok;
+    }
+
+    get redirected() {
+      return this[kNative].redirected;
+    }
+
+    get status() {
+      return this[kNative].status;
+    }
+
+    get statusText() {
+      return this[kNative].statusText;
+    }
+
+    // non-standard, the status line as it was received, like "200 OK"
+    get [Symbol.for("v8go-polyfills.Response.rawStatus")]() {
+      return this[kNative].rawStatus;
+    }
+
+    get url() {
+      return this[kNative].url;
+    }
+
+    // non-standard, the HTTP trailers, they're empty until the body is read to its end
+    get trailers() {
+      return createHeaders(this[kNative].trailers(), []);
+    }
+
+    get type() {
+      return this[kNative].type || "basic";
+    }
+
+    get body() {
+      if (this[kNative].nullBody) {
+        return null;
+      }
+
+      if (this[kStream] === undefined) {
+        const res = this[kNative];
+
+        this[kStream] = new ReadableStream({
+          pull: () =>
+            res
+              .read()
+              .then((str) =>
+                str === undefined ? undefined : byteStringToUint8Array(str)
+              ),
+          cancel: () => res.cancel(),
+        });
+      }
+
+      return this[kStream];
+    }
+
+    get bodyUsed() {
+      return isBodyUsed(this);
+    }
+
+    clone() {
+      if (isBodyUsed(this) || isBodyLocked(this)) {
+        throw new TypeError("Response body has already been used.");
+      }
+
+      const res = createResponse(this[kNative].clone());
+
+      // the headers may have been changed, or not come from the native side
+      const headers = this[kHeaders];
+      if (headers !== undefined) {
+        res[kHeaders] = createHeaders(
+          [...headers[kHeaderList]],
+          headers[kSetCookies]
+        );
+      }
+
+      return res;
+    }
+
+    arrayBuffer() {
+      return consumeBody(this).then(
+        () => byteStringToUint8Array(this[kNative].bytes()).buffer
+      );
+    }
+
+    blob() {
+      return consumeBody(this).then(
+        () =>
+          new Blob([byteStringToUint8Array(this[kNative].bytes())], {
+            type: this.headers.get("content-type") ?? "",
+          })
+      );
+    }
+
+    text() {
+      return consumeBody(this).then(() => this[kNative].text());
+    }
+
+    json() {
+      return consumeBody(this).then(() => {
+        try {
+          return this[kNative].json();
+        } catch (e) {
+          // the native of a network response throws only the message
+          throw e instanceof SyntaxError ? e : new SyntaxError(e);
+        }
+      });
+    }
+    get [Symbol.toStringTag]() {
+      return "Response";
+    }
+  }
+
+  // https://fetch.spec.whatwg.org/#concept-method-normalize
+  const normalizedMethods = ["DELETE", "GET", "HEAD", "OPTIONS", "POST", "PUT"];
+
+  function normalizeMethod(method) {
+    method = String(method);
+    if (!headerNamePattern.test(method)) {
+      throw new TypeError(`'${method}' is not a valid HTTP method.`);
+    }
+
+    const upper = method.toUpperCase();
+    return normalizedMethods.includes(upper) ? upper : method;
+  }
+
+  // a URL of the url polyfill, or of another one, is fetched by its href,
+  // its stringification isn't relied on
+  function isURL(value) {
+    return (
+      typeof value === "object" &&
+      value !== null &&
+      Object.prototype.toString.call(value) === "[object URL]" &&
+      typeof value.href === "string"
+    );
+  }
+
+  /*
+   * https://fetch.spec.whatwg.org/#dom-request
+   * The url is kept as it is given, the Go side resolves it.
+   * redirect and credentials are checked by the Go side as well,
+   * undefined means its default.
+   */
+  class Request {
+    constructor(input, init = {}) {
+      if (arguments.length < 1) {
+        throw new TypeError(
+          "Failed to construct 'Request': 1 argument required, but only 0 present."
+        );
+      }
+
+      if (init === null) {
+        init = {};
+      } else if (typeof init !== "object") {
+        throw new TypeError(
+          "Failed to construct 'Request': The provided value is not of type 'RequestInit'."
+        );
+      }
+
+      let request;
+      if (input instanceof Request) {
+        request = { ...input[kRequest] };
+      } else {
+        request = {
+          url: isURL(input) ? input.href : String(input),
+          method: "GET",
+          body: null,
+          redirect: undefined,
+          credentials: undefined,
+          referrer: undefined,
+          referrerPolicy: undefined,
+          integrity: undefined,
+          signal: null,
+        };
+      }
+
+      if (init.method !== undefined) {
+        request.method = normalizeMethod(init.method);
+      }
+
+      for (const name of [
+        "redirect",
+        "credentials",
+        "referrer",
+        "referrerPolicy",
+        "integrity",
+      ]) {
+        if (init[name] !== undefined) {
+          request[name] = String(init[name]);
+        }
+      }
+
+      // the abort polyfill defines AbortSignal, if it's injected
+      if (init.signal !== undefined) {
+        if (
+          init.signal !== null &&
+          (typeof AbortSignal !== "function" ||
+            !(init.signal instanceof AbortSignal))
+        ) {
+          throw new TypeError(
+            "Failed to construct 'Request': member signal is not of type AbortSignal."
+          );
+        }
+
+        request.signal = init.signal;
+      }
+
+      const headers = new Headers(
+        init.headers !== undefined
+          ? init.headers
+          : input instanceof Request
+          ? input.headers
+          : undefined
+      );
+
+      const hasBody = init.body !== undefined && init.body !== null;
+      if (hasBody) {
+        request.body = extractBody(init.body);
+      }
+
+      if (
+        request.body !== null &&
+        (request.method === "GET" || request.method === "HEAD")
+      ) {
+        throw new TypeError(
+          `Request with ${request.method} method cannot have body.`
+        );
+      }
+
+      if (hasBody) {
+        const { bodyType } = request.body;
+        if (bodyType && !headers.has("content-type")) {
+          headers.set("content-type", bodyType);
+        }
+      } else if (request.body !== null) {
+        // the body of input moves to the new request
+        if (input[kBodyUsed]) {
+          throw new TypeError(
+            "Failed to construct 'Request': Cannot construct a Request with a Request object that has already been used."
+          );
+        }
+
+        input[kBodyUsed] = true;
+      }
+
+      this[kRequest] = request;
+      this[kHeaders] = headers;
+      this[kBodyUsed] = false;
+    }
+
+    get url() {
+      return this[kRequest].url;
+    }
+
+    get method() {
+      return this[kRequest].method;
+    }
+
+    get headers() {
+      return this[kHeaders];
+    }
+
+    get redirect() {
+      return this[kRequest].redirect ?? "follow";
+    }
+
+    get credentials() {
+      return this[kRequest].credentials ?? "same-origin";
+    }
+
+    get referrer() {
+      return this[kRequest].referrer ?? "about:client";
+    }
+
+    get referrerPolicy() {
+      return this[kRequest].referrerPolicy ?? "";
+    }
+
+    get integrity() {
+      return this[kRequest].integrity ?? "";
+    }
+
+    get signal() {
+      return this[kRequest].signal;
+    }
+
+    get bodyUsed() {
+      return this[kBodyUsed];
+    }
+
+    clone() {
+      if (this[kBodyUsed]) {
+        throw new TypeError("Request body has already been used.");
+      }
+
+      const req = Object.create(Request.prototype);
+      req[kRequest] = { ...this[kRequest] };
+      req[kHeaders] = new Headers(this[kHeaders]);
+      req[kBodyUsed] = false;
+
+      return req;
+    }
+
+    arrayBuffer() {
+      return consumeRequestBody(this).then((bytes) => bytes.buffer);
+    }
+
+    blob() {
+      return consumeRequestBody(this).then(
+        (bytes) =>
+          new Blob([bytes], { type: this.headers.get("content-type") ?? "" })
+      );
+    }
+
+    text() {
+      return consumeRequestBody(this).then(utf8Decode);
+    }
+
+    json() {
+      return this.text().then((text) => JSON.parse(text));
+    }
+
+    get [Symbol.toStringTag]() {
+      return "Request";
+    }
+  }
+
+  // consumeRequestBody resolves the bytes of the body, as it will be sent
+  function consumeRequestBody(req) {
+    if (req[kBodyUsed]) {
+      return Promise.reject(new TypeError("Body has already been consumed."));
+    }
+
+    const body = req[kRequest].body;
+    if (body === null) {
+      return Promise.resolve(new Uint8Array(0));
+    }
+
+    // the multipart encoding, with its boundary, is done by the Go side
+    if (body.formData !== undefined) {
+      return Promise.reject(
+        new TypeError("Reading a FormData body is not supported.")
+      );
+    }
+    req[kBodyUsed] = true;
+
+    return Promise.resolve(
+      body.bodyEncoding === "bytes"
+        ? byteStringToUint8Array(body.body)
+        : utf8Encode(body.body)
+    );
+  }
+
+  // https://fetch.spec.whatwg.org/#fetch-method
+  function fetch(...args) {
+    // the Go side rejects it, like other bad arguments
+    if (args.length === 0) {
+      return native.fetch().response.then((res) => createResponse(res));
+    }
+
+    // a Request-like object, as axios shims pass it, is taken apart into the
+    // url and the init, like undici does, other objects are stringified
+    const [input, inputInit] = args;
+    if (
+      typeof input === "object" &&
+      input !== null &&
+      !(input instanceof Request) &&
+      typeof input.url === "string"
+    ) {
+      const { url, ...rest } = input;
+      args = [url, { ...rest, ...inputInit }];
+    }
+
+    let request;
+    try {
+      request = new Request(...args);
+    } catch (e) {
+      return Promise.reject(e);
+    }
+
+    const {
+      url,
+      method,
+      body,
+      redirect,
+      credentials,
+      referrer,
+      referrerPolicy,
+      integrity,
+      signal,
+    } = request[kRequest];
+
+    // the Go side reads the header pairs, repeated names included
+    const init = {
+      method,
+      headers: request.headers[kHeaderList],
+      redirect,
+      credentials,
+      referrer,
+      referrerPolicy,
+      integrity,
+      ...body,
+    };
+
+    if (signal === null) {
+      return native
+        .fetch(url, init)
+        .response.then((res) => createResponse(res));
+    }
+
+    if (signal.aborted) {
+      return Promise.reject(signal.reason);
+    }
+
+    // the abort polyfill puts the deadline of AbortSignal.timeout here,
+    // the Go side times the request out, nothing has to listen to the signal
+    const timeout = signal[Symbol.for("v8go-polyfills.AbortSignal.timeout")];
+    if (timeout !== undefined) {
+      init.timeout = Math.max(1, timeout.deadline - Date.now());
+    }
+
+    const call = native.fetch(url, init);
+    const onAbort = () => call.abort();
+
+    if (timeout === undefined) {
+      signal.addEventListener("abort", onAbort);
+    }
+
+    // once the response is there, aborting doesn't affect it anymore
+    return call.response.then(
+      (res) => {
+        signal.removeEventListener("abort", onAbort);
+
+        if (signal.aborted) {
+          res.cancel();
+          throw signal.reason;
+        }
+
+        return createResponse(res);
+      },
+      (e) => {
+        signal.removeEventListener("abort", onAbort);
+
+        if (timeout !== undefined && call.timedOut()) {
+          timeout.fire();
+        }
+
+        throw signal.aborted ? signal.reason : e;
+      }
+    );
+  }
+
+  for (const [name, value] of Object.entries({
+    fetch,
+    Headers,
+    Request,
+    Response,
+  })) {
+    Object.defineProperty(globalThis, name, {
+      value,
+      writable: true,
+      configurable: true,
+    });
+  }
+
+  return { fetch, Headers, Request, Response };
+});
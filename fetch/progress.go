@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// the least time between two progress calls of a transfer, the last one isn't held back
+const progressInterval = 100 * time.Millisecond
+
+/*
+progressReader calls fn with the bytes read so far and the total, at most
+once per progressInterval. It's called once more with the full count at the
+end of the body, and never after the end or Close.
+*/
+type progressReader struct {
+	body  io.ReadCloser
+	url   string
+	total int64
+	fn    func(url string, n, total int64)
+
+	mu       sync.Mutex
+	n        int64
+	reported int64
+	last     time.Time
+	done     bool
+}
+
+func newProgressReader(body io.ReadCloser, url string, total int64, fn func(url string, n, total int64)) *progressReader {
+	if total < 0 {
+		total = -1
+	}
+
+	return &progressReader{body: body, url: url, total: total, fn: fn, reported: -1}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.done {
+		return n, err
+	}
+
+	r.n += int64(n)
+
+	switch {
+	case err == io.EOF:
+		r.done = true
+		if r.n != r.reported {
+			r.fn(r.url, r.n, r.total)
+		}
+	case n > 0 && time.Since(r.last) >= progressInterval:
+		r.last = time.Now()
+		r.reported = r.n
+		r.fn(r.url, r.n, r.total)
+	}
+
+	return n, err
+}
+
+func (r *progressReader) Close() error {
+	r.mu.Lock()
+	r.done = true
+	r.mu.Unlock()
+
+	return r.body.Close()
+}
+
+// trackUpload reports the progress of sending the body of req, and of its redirects
+func (f *fetcher) trackUpload(req *http.Request) {
+	if f.UploadProgress == nil || req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+
+	url := req.URL.String()
+	total := req.ContentLength
+	req.Body = newProgressReader(req.Body, url, total, f.UploadProgress)
+
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return newProgressReader(body, url, total, f.UploadProgress), nil
+		}
+	}
+}
+
+// trackDownload reports the progress of receiving the body of res, before it's decoded
+func (f *fetcher) trackDownload(res *http.Response) {
+	if f.DownloadProgress == nil || res.Body == nil || res.Body == http.NoBody {
+		return
+	}
+
+	res.Body = newProgressReader(res.Body, res.Request.URL.String(), res.ContentLength, f.DownloadProgress)
+}
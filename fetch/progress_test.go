@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// progressCalls records the calls of a progress callback
+type progressCalls struct {
+	mu    sync.Mutex
+	calls [][2]int64
+}
+
+func (c *progressCalls) record(_ string, n, total int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, [2]int64{n, total})
+}
+
+func (c *progressCalls) check(t *testing.T, name string, size, total int64, elapsed time.Duration) {
+	t.Helper()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.calls) == 0 {
+		t.Errorf("%s: no progress calls", name)
+		return
+	}
+
+	for i, call := range c.calls {
+		if call[1] != total {
+			t.Errorf("%s: expected the total %d but got %d", name, total, call[1])
+		}
+		if i > 0 && call[0] <= c.calls[i-1][0] {
+			t.Errorf("%s: progress not increasing, %d after %d", name, call[0], c.calls[i-1][0])
+		}
+	}
+
+	if last := c.calls[len(c.calls)-1][0]; last != size {
+		t.Errorf("%s: expected the last progress %d but got %d", name, size, last)
+	}
+
+	// one call per interval, and the last one
+	if max := int(elapsed/progressInterval) + 2; len(c.calls) > max {
+		t.Errorf("%s: expected at most %d calls but got %d", name, max, len(c.calls))
+	}
+}
+
+func TestFetchProgress(t *testing.T) {
+	t.Parallel()
+
+	const size = 4 << 20
+	const chunks = 32
+	chunk := bytes.Repeat([]byte("a"), size/chunks)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			n, _ := io.Copy(io.Discard, r.Body)
+			_, _ = fmt.Fprint(w, n)
+			return
+		}
+
+		if r.URL.Query().Get("chunked") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+		}
+
+		// slow enough for the rate limit to matter
+		for i := 0; i < chunks; i++ {
+			_, _ = w.Write(chunk)
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		Name   string
+		Script string
+		Total  int64
+		Upload bool
+	}{
+		{Name: "download", Script: fmt.Sprintf("fetch('%s').then(res => res.text()).then(t => t.length)", srv.URL), Total: size},
+		{Name: "chunked download", Script: fmt.Sprintf("fetch('%s?chunked=1').then(res => res.text()).then(t => t.length)", srv.URL), Total: -1},
+		{Name: "upload", Script: fmt.Sprintf("fetch('%s', {method: 'POST', body: 'a'.repeat(%d)}).then(res => res.text())", srv.URL, size), Total: size, Upload: true},
+	} {
+		uploads, downloads := &progressCalls{}, &progressCalls{}
+
+		ctx, err := newV8ContextWithFetch(WithUploadProgress(uploads.record), WithDownloadProgress(downloads.record))
+		if err != nil {
+			t.Fatalf("create v8: %s", err)
+		}
+
+		start := time.Now()
+
+		val, err := ctx.RunScript(tc.Script, "fetch_progress.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %s", tc.Name, err)
+			continue
+		}
+
+		elapsed := time.Since(start)
+
+		if res.String() != strconv.Itoa(size) {
+			t.Errorf("%s: expected %d bytes but got %s", tc.Name, size, res.String())
+		}
+
+		if tc.Upload {
+			uploads.check(t, tc.Name, size, tc.Total, elapsed)
+		} else {
+			downloads.check(t, tc.Name, size, tc.Total, elapsed)
+
+			uploads.mu.Lock()
+			if len(uploads.calls) != 0 {
+				t.Errorf("%s: expected no upload progress without a body", tc.Name)
+			}
+			uploads.mu.Unlock()
+		}
+	}
+}
+
+func TestProgressReaderAfterClose(t *testing.T) {
+	t.Parallel()
+
+	calls := &progressCalls{}
+	r := newProgressReader(io.NopCloser(bytes.NewReader(make([]byte, 10))), "http://example.com/", 10, calls.record)
+
+	buf := make([]byte, 4)
+	_, _ = r.Read(buf)
+	_ = r.Close()
+	_, _ = r.Read(buf)
+	_, _ = io.ReadAll(r)
+
+	if len(calls.calls) != 1 || calls.calls[0] != [2]int64{4, 10} {
+		t.Errorf("expected a single call before the close but got %v", calls.calls)
+	}
+}
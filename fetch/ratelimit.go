@@ -0,0 +1,217 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// the longest wait for WithRateLimit, unless WithRateLimitMaxDelay changes it
+const DefaultRateLimitMaxDelay = 10 * time.Second
+
+// rateLimit is a limit of WithRateLimit
+type rateLimit struct {
+	rps   float64
+	burst int
+}
+
+/*
+tokenBucket holds up to burst tokens, refilled at rps per second. A request
+takes one, when there is none its token is reserved ahead, so the waiting
+requests are let through in their order, each 1/rps after the one before.
+*/
+type tokenBucket struct {
+	limit rateLimit
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+/*
+reserve takes a token at now and returns how long to wait for it, it takes
+none and is false if that's longer than maxDelay.
+*/
+func (b *tokenBucket) reserve(now time.Time, maxDelay time.Duration) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.rps
+	if burst := float64(b.limit.burst); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.limit.rps * float64(time.Second))
+	}
+
+	if wait > maxDelay {
+		return 0, false
+	}
+
+	b.tokens--
+	return wait, true
+}
+
+// cancel gives back a token of reserve, for a request which didn't wait for it
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens++
+}
+
+// full tells if the bucket is refilled to its burst by now, it's the same as a new one then
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.tokens+now.Sub(b.last).Seconds()*b.limit.rps >= float64(b.limit.burst)
+}
+
+// rateLimitSweepInterval is how often the rate limiter drops the full buckets
+const rateLimitSweepInterval = time.Second
+
+/*
+rateLimiter is the token buckets of WithRateLimit, shared by all the fetches of
+the fetcher. A host without a limit of its own gets a bucket of the "*" limit.
+The full buckets are dropped, so the hosts of a script fetching ever new ones
+don't pile up, a new bucket is full too.
+*/
+type rateLimiter struct {
+	limits   map[string]rateLimit
+	maxDelay time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+func newRateLimiter(limits map[string]rateLimit, maxDelay time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limits:   limits,
+		maxDelay: maxDelay,
+		buckets:  make(map[string]*tokenBucket),
+		swept:    time.Now(),
+	}
+}
+
+/*
+reserve takes a token at now of the bucket of host and port like tokenBucket.reserve,
+the bucket is nil if there is no limit for them. It's looked up and reserved at once,
+so it's not dropped in between.
+*/
+func (l *rateLimiter) reserve(host, port string, now time.Time) (*tokenBucket, time.Duration, bool) {
+	host = canonicalHost(host)
+
+	key := host
+	limit, ok := rateLimit{}, false
+	if port != "" {
+		key = net.JoinHostPort(host, port)
+		limit, ok = l.limits[key]
+	}
+	if !ok {
+		key = host
+		limit, ok = l.limits[host]
+	}
+	if !ok {
+		limit, ok = l.limits["*"]
+	}
+	if !ok {
+		return nil, 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) >= rateLimitSweepInterval {
+		for k, b := range l.buckets {
+			if b.full(now) {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{limit: limit, tokens: float64(limit.burst), last: now}
+		l.buckets[key] = b
+	}
+
+	delay, ok := b.reserve(now, l.maxDelay)
+	return b, delay, ok
+}
+
+/*
+wait waits for a token of the host of u until ctx is done, it rejects with
+a TypeError right away when the wait would be longer than the max delay.
+*/
+func (l *rateLimiter) wait(ctx context.Context, u *url.URL) error {
+	b, delay, ok := l.reserve(u.Hostname(), u.Port(), time.Now())
+	if !ok {
+		return &typeError{&rateLimitError{host: u.Host}}
+	}
+
+	if delay <= 0 {
+		return nil
+	}
+
+	loggerFrom(ctx).Debug("rate limited", "host", u.Host, "delay", delay)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
+
+// rateLimitError rejects a request of WithRateLimit which would wait too long
+type rateLimitError struct {
+	host string
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("rate limited: too many requests to %s", e.host)
+}
+
+// waitRateLimit waits for a request to u, without WithRateLimit it returns right away
+func (f *fetcher) waitRateLimit(ctx context.Context, u *url.URL) error {
+	if f.RateLimiter == nil {
+		return nil
+	}
+
+	return f.RateLimiter.wait(ctx, u)
+}
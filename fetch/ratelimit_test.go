@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rogchap.com/v8go"
+)
+
+func TestTokenBucket(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	b := &tokenBucket{limit: rateLimit{rps: 5, burst: 2}, tokens: 2, last: now}
+
+	for i, tc := range []struct {
+		At    time.Duration
+		Wait  time.Duration
+		Taken bool
+	}{
+		// the burst, then the tokens reserved ahead, 200ms apart
+		{0, 0, true},
+		{0, 0, true},
+		{0, 200 * time.Millisecond, true},
+		{0, 400 * time.Millisecond, true},
+		// over the max delay of 500ms, no token is taken
+		{0, 0, false},
+		// 1.5 tokens later, the next one is 200ms after the last one reserved
+		{300 * time.Millisecond, 300 * time.Millisecond, true},
+		// a full bucket holds no more than the burst
+		{10 * time.Second, 0, true},
+		{10 * time.Second, 0, true},
+		{10 * time.Second, 200 * time.Millisecond, true},
+	} {
+		wait, ok := b.reserve(now.Add(tc.At), 500*time.Millisecond)
+		if ok != tc.Taken || (wait-tc.Wait).Abs() > time.Millisecond {
+			t.Errorf("%d: expected %v, %v but got %v, %v", i, tc.Wait, tc.Taken, wait, ok)
+		}
+	}
+}
+
+func TestRateLimiterDropsFullBuckets(t *testing.T) {
+	t.Parallel()
+
+	l := newRateLimiter(map[string]rateLimit{"*": {rps: 10, burst: 2}, "api.local": {rps: 0.5, burst: 1}}, time.Second)
+	now := time.Now()
+
+	// a bucket for each host of the "*" limit
+	for i := 0; i < 100; i++ {
+		if _, _, ok := l.reserve(fmt.Sprintf("host%d.local", i), "", now); !ok {
+			t.Fatalf("host %d: expected a token", i)
+		}
+	}
+	api, _, _ := l.reserve("api.local", "", now)
+
+	if n := len(l.buckets); n != 101 {
+		t.Errorf("expected 101 buckets but got %d", n)
+	}
+
+	// after 1.5s the "*" buckets are full again, the one of api.local isn't yet
+	b, delay, ok := l.reserve("api.local", "", now.Add(1500*time.Millisecond))
+	if !ok || b != api || (delay-500*time.Millisecond).Abs() > time.Millisecond {
+		t.Errorf("expected the token of the same bucket in 500ms but got %v, %v", delay, ok)
+	}
+
+	if n := len(l.buckets); n != 1 {
+		t.Errorf("expected the full buckets to be dropped but got %d", n)
+	}
+
+	if b, _, _ := l.reserve("other.local", "", now); b == nil {
+		t.Error("expected a bucket of the \"*\" limit")
+	}
+}
+
+// fetchAll fires n fetches of url in each of the contexts, in the same isolate,
+// and returns how many rejected with "rate limited" and how long they took
+func fetchAll(t *testing.T, iso *v8go.Isolate, contexts []*v8go.Context, url string, n int) (int, time.Duration) {
+	t.Helper()
+
+	start := time.Now()
+
+	var proms []*v8go.Promise
+	for _, ctx := range contexts {
+		val, err := ctx.RunScript(fmt.Sprintf(`Promise.all(Array.from({ length: %d }, () => fetch('%s').then(
+			res => res.text().then(() => 0),
+			e => String(e).includes('rate limited') ? 1 : Promise.reject(e),
+		))).then(n => n.reduce((a, b) => a + b, 0))`, n, url), "fetch_rate_limit.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := val.AsPromise()
+		if err != nil {
+			t.Fatal(err)
+		}
+		proms = append(proms, p)
+	}
+
+	runCtx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	if err := RunUntilIdle(runCtx, iso); err != nil {
+		t.Fatal(err)
+	}
+
+	limited := 0
+	for _, p := range proms {
+		if p.State() != v8go.Fulfilled {
+			t.Fatalf("expected the fetches to be fulfilled but got %v: %s", p.State(), p.Result())
+		}
+		limited += int(p.Result().Integer())
+	}
+
+	return limited, time.Since(start)
+}
+
+func TestFetchRateLimit(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		Name    string
+		Opts    []Option
+		Limited int
+		Min     time.Duration
+		Max     time.Duration
+	}{
+		// 5 right away, then the other 15 200ms apart
+		{"wait", []Option{WithRateLimit("127.0.0.1", 5, 5)}, 0, 3 * time.Second, 10 * time.Second},
+		// 5 right away, 2 within 500ms, the rest rejects right away
+		{"reject", []Option{WithRateLimit("*", 5, 5), WithRateLimitMaxDelay(500 * time.Millisecond)}, 13, 400 * time.Millisecond, 2 * time.Second},
+		{"other host", []Option{WithRateLimit("example.com", 1, 1)}, 0, 0, time.Second},
+	} {
+		iso := v8go.NewIsolate()
+		global := v8go.NewObjectTemplate(iso)
+
+		f, err := Inject(iso, global, tc.Opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the limit is shared by the contexts of the fetcher
+		contexts := []*v8go.Context{v8go.NewContext(iso, global), v8go.NewContext(iso, global)}
+
+		limited, elapsed := fetchAll(t, iso, contexts, srv.URL, 10)
+
+		if limited != tc.Limited {
+			t.Errorf("%s: expected %d fetches to be rate limited but got %d", tc.Name, tc.Limited, limited)
+		}
+
+		// a bit of slack, the first tokens refill while the fetches start
+		if elapsed < tc.Min-100*time.Millisecond || elapsed > tc.Max {
+			t.Errorf("%s: expected the fetches to take %s to %s but took %s", tc.Name, tc.Min, tc.Max, elapsed)
+		}
+
+		_ = f.Close()
+		for _, ctx := range contexts {
+			ctx.Close()
+		}
+		iso.Dispose()
+	}
+}
+
+func TestFetchRateLimitRedirect(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/target", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	// one request, the redirect would be the second one to the host
+	f, err := NewFetcher(WithRateLimit("127.0.0.1", 1, 1), WithRateLimitMaxDelay(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.Fetch(context.Background(), srv.URL+"/redirect", RequestInit{})
+	if err == nil || !strings.Contains(err.Error(), "rate limited: too many requests to "+strings.TrimPrefix(srv.URL, "http://")) {
+		t.Errorf("expected the redirect to be rate limited but got %v", err)
+	}
+
+	if _, err := NewFetcher(WithRateLimit("*", 0, 1)); err == nil {
+		t.Error("expected a rate limit of 0 to fail")
+	}
+}
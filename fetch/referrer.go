@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/weese/v8go-polyfills/fetch/internal"
+)
+
+// the referrer policies of the fetch spec
+const (
+	referrerPolicyNoReferrer                  = "no-referrer"
+	referrerPolicyNoReferrerWhenDowngrade     = "no-referrer-when-downgrade"
+	referrerPolicySameOrigin                  = "same-origin"
+	referrerPolicyOrigin                      = "origin"
+	referrerPolicyStrictOrigin                = "strict-origin"
+	referrerPolicyOriginWhenCrossOrigin       = "origin-when-cross-origin"
+	referrerPolicyStrictOriginWhenCrossOrigin = "strict-origin-when-cross-origin"
+	referrerPolicyUnsafeURL                   = "unsafe-url"
+)
+
+/*
+initReferrer sets the referrer of the request. "about:client" is the base url,
+there is none without WithBaseURL. A script's Referer header is taken like
+the referrer if there isn't one in the init.
+*/
+func (f *fetcher) initReferrer(req *internal.Request, reqInit internal.RequestInit) error {
+	switch p := reqInit.ReferrerPolicy; p {
+	case "":
+		req.ReferrerPolicy = referrerPolicyStrictOriginWhenCrossOrigin
+	case referrerPolicyNoReferrer, referrerPolicyNoReferrerWhenDowngrade, referrerPolicySameOrigin,
+		referrerPolicyOrigin, referrerPolicyStrictOrigin, referrerPolicyOriginWhenCrossOrigin,
+		referrerPolicyStrictOriginWhenCrossOrigin, referrerPolicyUnsafeURL:
+		req.ReferrerPolicy = p
+	default:
+		return &typeError{fmt.Errorf("unsupported referrer policy: %s", p)}
+	}
+
+	referrer := "about:client"
+	if reqInit.Referrer != nil {
+		referrer = *reqInit.Referrer
+	} else if h := req.Header.Get("Referer"); h != "" {
+		referrer = h
+	}
+	req.Header.Del("Referer")
+
+	switch referrer {
+	case "":
+		return nil
+	case "about:client":
+		req.Referrer = f.BaseURL
+		return nil
+	}
+
+	u, err := url.Parse(referrer)
+	if err == nil && f.BaseURL != nil {
+		u = f.BaseURL.ResolveReference(u)
+	}
+	if err != nil || !u.IsAbs() {
+		return &typeError{fmt.Errorf("invalid referrer %s", referrer)}
+	}
+
+	req.Referrer = u
+	return nil
+}
+
+/*
+refererFor is the Referer header of a request for target, an empty string sends none.
+A relative target is a local request, it's the same origin as any referrer.
+*/
+func refererFor(referrer *url.URL, policy string, target *url.URL) string {
+	// only http and https urls are sent, like about:blank or data: aren't
+	if referrer == nil || (referrer.Scheme != "http" && referrer.Scheme != "https") {
+		return ""
+	}
+
+	full := *referrer
+	full.User = nil
+	full.Fragment = ""
+	full.RawFragment = ""
+
+	origin := url.URL{Scheme: referrer.Scheme, Host: referrer.Host, Path: "/"}
+
+	crossOrigin := target.IsAbs() && (target.Scheme != referrer.Scheme || target.Host != referrer.Host)
+	downgrade := target.IsAbs() && referrer.Scheme == "https" && target.Scheme != "https"
+
+	switch policy {
+	case referrerPolicyNoReferrer:
+		return ""
+	case referrerPolicyNoReferrerWhenDowngrade:
+		if downgrade {
+			return ""
+		}
+		return full.String()
+	case referrerPolicySameOrigin:
+		if crossOrigin {
+			return ""
+		}
+		return full.String()
+	case referrerPolicyOrigin:
+		return origin.String()
+	case referrerPolicyStrictOrigin:
+		if downgrade {
+			return ""
+		}
+		return origin.String()
+	case referrerPolicyOriginWhenCrossOrigin:
+		if crossOrigin {
+			return origin.String()
+		}
+		return full.String()
+	case referrerPolicyUnsafeURL:
+		return full.String()
+	default: // strict-origin-when-cross-origin
+		switch {
+		case !crossOrigin:
+			return full.String()
+		case downgrade:
+			return ""
+		default:
+			return origin.String()
+		}
+	}
+}
+
+// setReferer sets the Referer header of a request, or removes it if there is none
+func setReferer(header http.Header, r *internal.Request, target *url.URL) {
+	if referer := refererFor(r.Referrer, r.ReferrerPolicy, target); referer != "" {
+		header.Set("Referer", referer)
+	} else {
+		header.Del("Referer")
+	}
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weese/v8go-polyfills/fetch/internal"
+)
+
+// the methods retried without WithRetryMethods, they are idempotent
+var defaultRetryMethods = []string{http.MethodGet, http.MethodHead}
+
+// the longest Retry-After a retry waits for, the fetch holds its slot meanwhile
+const maxRetryAfter = time.Minute
+
+func defaultRetryBackoff(attempt int) time.Duration {
+	return 100 * time.Millisecond << (attempt - 1)
+}
+
+// maxAttempts returns how often the request may be sent
+func (f *fetcher) maxAttempts(r *internal.Request) int {
+	if f.RetryMax <= 1 {
+		return 1
+	}
+
+	methods := f.RetryMethods
+	if methods == nil {
+		methods = defaultRetryMethods
+	}
+
+	for _, m := range methods {
+		if strings.EqualFold(m, r.Method) {
+			return f.RetryMax
+		}
+	}
+
+	return 1
+}
+
+/*
+shouldRetry tells if an attempt failed transiently: a connection error,
+or a 502, 503 or 504 response. Aborted requests and TypeErrors, like a
+blocked host, are final.
+*/
+func shouldRetry(ctx context.Context, res *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		var tErr *typeError
+		return !errors.As(err, &tErr)
+	}
+
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+/*
+retryDelay is the Retry-After of res if there is one, up to maxRetryAfter, or the backoff.
+It's false if the deadline of ctx comes first, waiting would only end in a timeout,
+so the attempt is final.
+*/
+func (f *fetcher) retryDelay(ctx context.Context, attempt int, res *http.Response) (time.Duration, bool) {
+	delay, ok := retryAfter(res)
+	if !ok {
+		backoff := f.RetryBackoff
+		if backoff == nil {
+			backoff = defaultRetryBackoff
+		}
+		delay = backoff(attempt)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && delay >= time.Until(deadline) {
+		return 0, false
+	}
+
+	return delay, true
+}
+
+// retryAfter parses the Retry-After of res, seconds or a date, up to maxRetryAfter
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	if res == nil {
+		return 0, false
+	}
+
+	v := res.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+
+	var d time.Duration
+	if seconds, err := strconv.ParseInt(v, 10, 64); err == nil && seconds >= 0 {
+		d = time.Duration(seconds) * time.Second
+		if seconds > int64(maxRetryAfter/time.Second) {
+			d = maxRetryAfter
+		}
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	} else {
+		return 0, false
+	}
+
+	switch {
+	case d < 0:
+		return 0, true
+	case d > maxRetryAfter:
+		return maxRetryAfter, true
+	}
+
+	return d, true
+}
+
+// discardResponse closes a response that is retried, reading a bit of it allows reusing the connection
+func discardResponse(res *http.Response) {
+	if res == nil {
+		return
+	}
+
+	_, _ = io.CopyN(ioutil.Discard, res.Body, 4096)
+	_ = res.Body.Close()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
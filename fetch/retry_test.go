@@ -0,0 +1,287 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/weese/v8go-polyfills/abort"
+)
+
+// newFlakyServer fails the first n requests to each path with 503
+func newFlakyServer(n int32, header http.Header) (*httptest.Server, *int32) {
+	var requests int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if atomic.AddInt32(&requests, 1) <= n {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		_, _ = fmt.Fprintf(w, "ok%s", body)
+	}))
+
+	return srv, &requests
+}
+
+func TestFetchRetry(t *testing.T) {
+	t.Parallel()
+
+	noBackoff := func(int) time.Duration { return time.Millisecond }
+
+	cases := []struct {
+		Name     string
+		Opts     []Option
+		Init     string
+		Expected string
+		Requests int32
+	}{
+		{"retried", []Option{WithRetry(3, noBackoff)}, "{}", "200 ok", 3},
+		{"not enough attempts", []Option{WithRetry(1, noBackoff)}, "{}", "503 ", 1},
+		{"head", []Option{WithRetry(3, noBackoff)}, "{method: 'HEAD'}", "200 ", 3},
+		{"post", []Option{WithRetry(3, noBackoff)}, "{method: 'POST', body: '!'}", "503 ", 1},
+		{"post allowed", []Option{WithRetry(3, noBackoff), WithRetryMethods("POST")}, "{method: 'POST', body: '!'}", "200 ok!", 3},
+		{"without retry", nil, "{}", "503 ", 1},
+	}
+
+	for _, c := range cases {
+		srv, requests := newFlakyServer(2, nil)
+		defer srv.Close()
+
+		ctx, err := newV8ContextWithFetch(c.Opts...)
+		if err != nil {
+			t.Errorf("create v8: %s", err)
+			return
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s', %s).then(res => res.text().then(text => res.status + ' ' + text))`, srv.URL, c.Init), "fetch_retry.js")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		if res.String() != c.Expected {
+			t.Errorf("%s: expected '%s' but got '%s'", c.Name, c.Expected, res.String())
+		}
+
+		if n := atomic.LoadInt32(requests); n != c.Requests {
+			t.Errorf("%s: expected %d requests, but got %d", c.Name, c.Requests, n)
+		}
+	}
+}
+
+func TestFetchRetryConnectionError(t *testing.T) {
+	t.Parallel()
+
+	// nothing listens on the address of a closed server
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	ctx, err := newV8ContextWithFetch(WithRetry(3, func(int) time.Duration { return time.Millisecond }))
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').catch(e => String(e.cause))`, srv.URL), "fetch_retry_connection_error.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !strings.HasSuffix(res.String(), "(after 3 attempts)") {
+		t.Errorf("expected the number of attempts in '%s'", res.String())
+	}
+}
+
+func TestFetchRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	srv, requests := newFlakyServer(1, http.Header{"Retry-After": []string{"1"}})
+	defer srv.Close()
+
+	ctx, err := newV8ContextWithFetch(WithRetry(2, func(int) time.Duration { return time.Millisecond }))
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	start := time.Now()
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => res.text())`, srv.URL), "fetch_retry_after.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected to wait for Retry-After, but took %s", elapsed)
+	}
+
+	if res.String() != "ok" || atomic.LoadInt32(requests) != 2 {
+		t.Errorf("expected 'ok' after 2 requests, but got '%s' after %d", res.String(), atomic.LoadInt32(requests))
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	t.Parallel()
+
+	f := &fetcher{RetryBackoff: func(int) time.Duration { return time.Millisecond }}
+
+	cases := []struct {
+		RetryAfter string
+		Expected   time.Duration
+	}{
+		{"", time.Millisecond},
+		{"2", 2 * time.Second},
+		{"86400", maxRetryAfter},
+		{"9999999999999999", maxRetryAfter},
+		{"-1", time.Millisecond},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+		{time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat), maxRetryAfter},
+	}
+
+	for i, c := range cases {
+		res := &http.Response{Header: http.Header{}}
+		if c.RetryAfter != "" {
+			res.Header.Set("Retry-After", c.RetryAfter)
+		}
+
+		delay, ok := f.retryDelay(context.Background(), 1, res)
+		if !ok || delay != c.Expected {
+			t.Errorf("case %d: expected '%s' but got '%s' (%v)", i, c.Expected, delay, ok)
+		}
+	}
+
+	// a wait past the deadline isn't worth it
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, ok := f.retryDelay(ctx, 1, &http.Response{Header: http.Header{"Retry-After": []string{"2"}}}); ok {
+		t.Error("expected no retry past the deadline")
+	}
+}
+
+func TestFetchRetryAfterDeadline(t *testing.T) {
+	t.Parallel()
+
+	srv, requests := newFlakyServer(1, http.Header{"Retry-After": []string{"86400"}})
+	defer srv.Close()
+
+	ctx, err := newV8ContextWithFetch(WithRetry(2, nil), WithDefaultTimeout(5*time.Second))
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	// the wait would outlast the timeout, so the 503 is the response
+	start := time.Now()
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => res.status)`, srv.URL), "fetch_retry_after_deadline.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected no wait for Retry-After, but took %s", elapsed)
+	}
+
+	if res.String() != "503" || atomic.LoadInt32(requests) != 1 {
+		t.Errorf("expected '503' after 1 request, but got '%s' after %d", res.String(), atomic.LoadInt32(requests))
+	}
+}
+
+func TestFetchRetryAbort(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := newFlakyServer(1, nil)
+	defer srv.Close()
+
+	ctx, err := newV8ContextWithFetch(WithRetry(2, func(int) time.Duration { return time.Hour }))
+	if err != nil {
+		t.Errorf("create v8: %s", err)
+		return
+	}
+
+	if err := abort.InjectTo(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+
+	start := time.Now()
+	val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s', {signal: AbortSignal.timeout(100)}).catch(e => e.name)`, srv.URL), "fetch_retry_abort.js")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the timeout to stop the backoff, but took %s", elapsed)
+	}
+
+	if res.String() != "TimeoutError" {
+		t.Errorf("expected 'TimeoutError' but got '%s'", res.String())
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"context"
+	"errors"
+)
+
+// errFetcherClosed rejects the fetches and body reads started after Close or Shutdown
+var errFetcherClosed = &typeError{errors.New("the fetcher is closed")}
+
+/*
+begin counts a goroutine of a fetch or a body read, Close waits for it to end.
+It's false once the fetcher is closed, nothing may be started then.
+*/
+func (f *fetcher) begin() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return false
+	}
+
+	f.inflight.Add(1)
+	return true
+}
+
+func (f *fetcher) end() {
+	f.inflight.Done()
+}
+
+/*
+dropped tells if the results have to be dropped instead of settling the
+promises, their context may be disposed right after Close returns.
+*/
+func (f *fetcher) dropped() bool {
+	return f.drop.Load()
+}
+
+// settle posts the task fn of BeginTask, unless the results are dropped by then
+func (f *fetcher) settle(post func(task func()), fn func()) {
+	if f.dropped() {
+		post(nil)
+		return
+	}
+
+	post(func() {
+		if !f.dropped() {
+			fn()
+		}
+	})
+}
+
+/*
+Close cancels the fetches and body reads in flight, and waits for their goroutines
+to end. Their promises are never settled, also not by tasks queued before, so the
+contexts of the fetcher can be disposed once it returns. Later fetches reject with a TypeError.
+*/
+func (f *fetcher) Close() error {
+	f.stop(true)
+	return nil
+}
+
+// stop cancels what's in flight and waits for it, with drop its results are never settled
+func (f *fetcher) stop(drop bool) {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+
+	if drop {
+		f.drop.Store(true)
+	}
+
+	f.closeAll()
+	f.inflight.Wait()
+	f.CloseIdleConnections()
+}
+
+/*
+Shutdown stops new fetches like Close, but lets the ones in flight finish until
+ctx is done, then it cancels the rest like Close and returns the error of ctx.
+When they finished in time their promises are settled by the next tasks of the
+isolate, but the bodies can't be read anymore.
+*/
+func (f *fetcher) Shutdown(ctx context.Context) error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		f.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		f.stop(false)
+		return nil
+	case <-ctx.Done():
+		f.stop(true)
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+	"rogchap.com/v8go"
+)
+
+func TestFetcherClose(t *testing.T) {
+	// not parallel, goleak would see the goroutines of the other tests
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	started := make(chan string, 10)
+	torndown := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/body" {
+			_, _ = w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+		}
+		started <- r.URL.Path
+
+		select {
+		case <-r.Context().Done():
+			torndown <- r.URL.Path
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	iso := v8go.NewIsolate()
+	defer iso.Dispose()
+	global := v8go.NewObjectTemplate(iso)
+
+	f, err := Inject(iso, global)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := v8go.NewContext(iso, global)
+
+	var proms []*v8go.Promise
+	for _, script := range []string{
+		fmt.Sprintf("fetch('%s/hang')", srv.URL),
+		fmt.Sprintf("fetch('%s/body').then(res => res.text())", srv.URL),
+	} {
+		val, err := ctx.RunScript(script, "fetch_close.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := val.AsPromise()
+		if err != nil {
+			t.Fatal(err)
+		}
+		proms = append(proms, p)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the requests didn't start")
+		}
+	}
+
+	// the body read of /body is in flight once its response has resolved
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected Close to return in 2s but took %s", elapsed)
+	}
+
+	// the results are dropped, the context can go right away
+	ProcessTasks(iso)
+	for i, p := range proms {
+		if p.State() != v8go.Pending {
+			t.Errorf("promise %d: expected pending but got %v", i, p.State())
+		}
+	}
+	ctx.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-torndown:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the requests weren't cancelled")
+		}
+	}
+
+	// a closed fetcher rejects later fetches, also of other contexts
+	ctx = v8go.NewContext(iso, global)
+	defer ctx.Close()
+
+	val, err := ctx.RunScript(fmt.Sprintf("fetch('%s/hang').catch(e => e.name + ': ' + e.message)", srv.URL), "fetch_closed.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(res.String(), "TypeError: ") || !strings.Contains(res.String(), "the fetcher is closed") {
+		t.Errorf("expected the fetcher to be closed but got '%s'", res.String())
+	}
+}
+
+func TestFetcherShutdown(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delay, _ := time.ParseDuration(r.URL.Query().Get("delay"))
+
+		select {
+		case <-r.Context().Done():
+		case <-time.After(delay):
+			_, _ = w.Write([]byte("done"))
+		}
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		Delay   string
+		Timeout time.Duration
+		Err     error
+	}{
+		{Delay: "100ms", Timeout: 5 * time.Second},
+		{Delay: "5s", Timeout: 100 * time.Millisecond, Err: context.DeadlineExceeded},
+	} {
+		iso := v8go.NewIsolate()
+		global := v8go.NewObjectTemplate(iso)
+
+		f, err := Inject(iso, global)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := v8go.NewContext(iso, global)
+
+		val, err := ctx.RunScript(fmt.Sprintf("fetch('%s/?delay=%s')", srv.URL, tc.Delay), "fetch_shutdown.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := val.AsPromise()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), tc.Timeout)
+		err = f.Shutdown(shutdownCtx)
+		cancel()
+
+		if !errors.Is(err, tc.Err) {
+			t.Errorf("%s: expected the error %v but got %v", tc.Delay, tc.Err, err)
+		}
+
+		// a fetch that finished in time is resolved, the rest is dropped
+		ProcessTasks(iso)
+		if expected := map[bool]v8go.PromiseState{true: v8go.Fulfilled, false: v8go.Pending}[tc.Err == nil]; p.State() != expected {
+			t.Errorf("%s: expected the promise state %v but got %v", tc.Delay, expected, p.State())
+		}
+
+		ctx.Close()
+		iso.Dispose()
+	}
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"context"
+
+	. "github.com/weese/v8go-polyfills/internal"
+
+	"rogchap.com/v8go"
+)
+
+/*
+The promises of fetch and of reading a body are settled by tasks of the isolate,
+v8go isolates aren't safe for concurrent use. The goroutine owning the isolate
+has to run them, between its scripts, with ProcessTasks, RunTasks or RunUntilIdle.
+The tasks of every fetcher injected into the isolate are run together.
+*/
+
+// ProcessTasks runs the queued tasks of iso without waiting for more, it returns how many ran
+func ProcessTasks(iso *v8go.Isolate) int {
+	return ProcessLoop(iso)
+}
+
+// RunTasks runs the tasks of iso as they come until ctx is done, and returns the error of ctx
+func RunTasks(ctx context.Context, iso *v8go.Isolate) error {
+	return RunLoop(ctx, iso)
+}
+
+/*
+RunUntilIdle runs the tasks of iso until no fetch or body read of it is pending,
+like when a script is done with its requests. It returns the error of ctx if it's
+done first.
+*/
+func RunUntilIdle(ctx context.Context, iso *v8go.Isolate) error {
+	return RunLoopUntilIdle(ctx, iso)
+}
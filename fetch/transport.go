@@ -0,0 +1,244 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+/*
+newTransport creates the transport of the remote requests,
+http.DefaultTransport is shared as long as no option changes it.
+*/
+func (f *fetcher) newTransport() http.RoundTripper {
+	if f.Proxy == nil && f.TLSConfig == nil && !f.InsecureSkipVerify && !f.BlockPrivateIPs && !f.hasPoolSettings() &&
+		!f.ForceHTTP1 && !f.HTTP2PriorKnowledge && len(f.UnixSockets) == 0 && len(f.ResolveOverrides) == 0 {
+		return http.DefaultTransport
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	// the fetcher decodes the responses, see WithAcceptEncodings
+	t.DisableCompression = true
+
+	if f.BlockPrivateIPs {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+
+		t.DialContext = (&privateIPDialer{
+			resolver: net.DefaultResolver,
+			dial:     dialer.DialContext,
+		}).DialContext
+	}
+
+	if f.Proxy != nil {
+		t.Proxy = f.Proxy
+	}
+
+	if len(f.ResolveOverrides) > 0 {
+		dial := t.DialContext
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if override, ok := f.resolveOverrideFor(addr); ok {
+				loggerFrom(ctx).Debug("resolve override", "addr", addr, "override", override)
+				addr = override
+			}
+
+			return dial(ctx, network, addr)
+		}
+	}
+
+	if len(f.UnixSockets) > 0 {
+		dial := t.DialContext
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if path, ok := f.unixSocketFor(addr); ok {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			}
+
+			return dial(ctx, network, addr)
+		}
+
+		if proxy := t.Proxy; proxy != nil {
+			t.Proxy = func(req *http.Request) (*url.URL, error) {
+				if _, ok := lookupHost(f.UnixSockets, req.URL.Hostname(), urlPort(req.URL)); ok {
+					return nil, nil
+				}
+
+				return proxy(req)
+			}
+		}
+	}
+
+	if f.TLSConfig != nil {
+		t.TLSClientConfig = f.TLSConfig.Clone()
+	}
+
+	if f.InsecureSkipVerify {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.InsecureSkipVerify = true
+	}
+
+	if f.MaxIdleConns != nil {
+		t.MaxIdleConns = *f.MaxIdleConns
+	}
+	if f.MaxIdleConnsPerHost != nil {
+		t.MaxIdleConnsPerHost = *f.MaxIdleConnsPerHost
+	}
+	if f.MaxConnsPerHost != nil {
+		t.MaxConnsPerHost = *f.MaxConnsPerHost
+	}
+	if f.IdleConnTimeout != nil {
+		t.IdleConnTimeout = *f.IdleConnTimeout
+	}
+
+	if f.ForceHTTP1 {
+		// a non-nil empty map turns HTTP/2 off
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+
+		// the config cloned from a used http.DefaultTransport offers h2 already
+		if t.TLSClientConfig != nil {
+			var protos []string
+			for _, p := range t.TLSClientConfig.NextProtos {
+				if p != http2.NextProtoTLS {
+					protos = append(protos, p)
+				}
+			}
+			t.TLSClientConfig.NextProtos = protos
+		}
+	}
+
+	if f.HTTP2PriorKnowledge {
+		return newPriorKnowledgeTransport(t)
+	}
+
+	return t
+}
+
+/*
+priorKnowledgeTransport speaks HTTP/2 right away on http urls (h2c), without
+an upgrade from HTTP/1.1. https urls negotiate HTTP/2 with ALPN as usual.
+*/
+type priorKnowledgeTransport struct {
+	h2c   *http2.Transport
+	https *http.Transport
+}
+
+func newPriorKnowledgeTransport(t *http.Transport) *priorKnowledgeTransport {
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	return &priorKnowledgeTransport{
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			// it's called for http urls too, the connection stays unencrypted
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+			IdleConnTimeout:    t.IdleConnTimeout,
+			DisableCompression: true,
+		},
+		https: t,
+	}
+}
+
+func (t *priorKnowledgeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return t.h2c.RoundTrip(req)
+	}
+
+	return t.https.RoundTrip(req)
+}
+
+func (t *priorKnowledgeTransport) CloseIdleConnections() {
+	t.h2c.CloseIdleConnections()
+	t.https.CloseIdleConnections()
+}
+
+// unixSocketFor returns the socket path of WithUnixSocket for the dialed addr, a host:port
+func (f *fetcher) unixSocketFor(addr string) (string, bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", false
+	}
+
+	return lookupHost(f.UnixSockets, host, port)
+}
+
+// resolveOverrideFor returns the address of WithResolveOverride for the dialed addr
+func (f *fetcher) resolveOverrideFor(addr string) (string, bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", false
+	}
+
+	return lookupHost(f.ResolveOverrides, host, port)
+}
+
+/*
+keepsAlive tells if the connections of the requests to u are kept for reuse,
+when the pool is configured or u is on a unix socket. Otherwise they are sent
+with "Connection: close".
+*/
+func (f *fetcher) keepsAlive(u *url.URL) bool {
+	if f.hasPoolSettings() {
+		return true
+	}
+
+	_, ok := lookupHost(f.UnixSockets, u.Hostname(), urlPort(u))
+	return ok
+}
+
+// urlPort is the port of u, or the default one of its scheme
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+
+	switch u.Scheme {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+
+	return ""
+}
+
+// hasPoolSettings tells if one of the connection pool options is used
+func (f *fetcher) hasPoolSettings() bool {
+	return f.MaxIdleConns != nil || f.MaxIdleConnsPerHost != nil || f.MaxConnsPerHost != nil || f.IdleConnTimeout != nil
+}
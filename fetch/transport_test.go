@@ -0,0 +1,457 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fetch
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"rogchap.com/v8go"
+)
+
+func TestPoolOptions(t *testing.T) {
+	t.Parallel()
+
+	f, err := NewFetcher(
+		WithMaxIdleConns(10),
+		WithMaxIdleConnsPerHost(3),
+		WithMaxConnsPerHost(5),
+		WithIdleConnTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr, ok := f.(*fetcher).Transport.(*http.Transport)
+	if !ok || tr == http.DefaultTransport {
+		t.Fatalf("expected an own *http.Transport but got %T", f.(*fetcher).Transport)
+	}
+
+	if tr.MaxIdleConns != 10 || tr.MaxIdleConnsPerHost != 3 || tr.MaxConnsPerHost != 5 || tr.IdleConnTimeout != time.Second {
+		t.Errorf("unexpected pool settings %d, %d, %d, %s", tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.MaxConnsPerHost, tr.IdleConnTimeout)
+	}
+
+	// the ones not set keep the defaults
+	f, err = NewFetcher(WithMaxConnsPerHost(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr = f.(*fetcher).Transport.(*http.Transport)
+	if def := http.DefaultTransport.(*http.Transport); tr.MaxIdleConns != def.MaxIdleConns || tr.IdleConnTimeout != def.IdleConnTimeout {
+		t.Errorf("expected the defaults but got %d, %s", tr.MaxIdleConns, tr.IdleConnTimeout)
+	}
+
+	for _, opt := range []Option{
+		WithMaxIdleConns(-1),
+		WithMaxIdleConnsPerHost(-1),
+		WithMaxConnsPerHost(-1),
+		WithIdleConnTimeout(-time.Second),
+	} {
+		if _, err := NewFetcher(opt); err == nil || !strings.HasPrefix(err.Error(), "v8go-polyfills/fetch: invalid") {
+			t.Errorf("expected an invalid option error but got %v", err)
+		}
+	}
+}
+
+func TestFetchConnectionReuse(t *testing.T) {
+	t.Parallel()
+
+	var conns int32
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, r.Header.Get("Connection"))
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	script := fmt.Sprintf(`{
+		const get = () => fetch('%s').then(res => res.text());
+		get().then(get).then(get)
+	}`, srv.URL)
+
+	run := func(ctx *v8go.Context) string {
+		val, err := ctx.RunScript(script, "fetch_connection_reuse.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return res.String()
+	}
+
+	cases := []struct {
+		Name     string
+		Opts     []Option
+		Expected string
+		Conns    []int32
+	}{
+		{"default", nil, "close", []int32{3, 6}},
+		{"pool", []Option{WithMaxIdleConnsPerHost(1)}, "", []int32{1, 2}},
+	}
+
+	for _, c := range cases {
+		atomic.StoreInt32(&conns, 0)
+
+		f, err := NewFetcher(c.Opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		iso := v8go.NewIsolate()
+		global := v8go.NewObjectTemplate(iso)
+		if err := global.Set("fetch", v8go.NewFunctionTemplate(iso, f.GetFetchFunctionCallback())); err != nil {
+			t.Fatal(err)
+		}
+		ctx := v8go.NewContext(iso, global)
+
+		// the second run dials again after the pool is drained
+		for i, expected := range c.Conns {
+			if i > 0 {
+				f.CloseIdleConnections()
+			}
+
+			if res := run(ctx); res != c.Expected {
+				t.Errorf("%s: expected the Connection header '%s' but got '%s'", c.Name, c.Expected, res)
+			}
+
+			if n := atomic.LoadInt32(&conns); n != expected {
+				t.Errorf("%s: expected %d connections but got %d", c.Name, expected, n)
+			}
+		}
+	}
+}
+
+func TestFetchHTTPProtocols(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, r.ProtoMajor)
+	})
+
+	newTLSServer := func(enableHTTP2 bool) *httptest.Server {
+		srv := httptest.NewUnstartedServer(handler)
+		srv.EnableHTTP2 = enableHTTP2
+		if enableHTTP2 {
+			// httptest would offer h2 only
+			srv.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+		}
+		srv.StartTLS()
+		return srv
+	}
+
+	h2Server := newTLSServer(true)
+	defer h2Server.Close()
+
+	h1Server := newTLSServer(false)
+	defer h1Server.Close()
+
+	// without prior knowledge the h2c handler serves HTTP/1.1
+	h2cServer := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer h2cServer.Close()
+
+	cases := []struct {
+		Name  string
+		Opts  []Option
+		URL   string
+		Proto int
+	}{
+		{"h2", nil, h2Server.URL, 2},
+		{"force http1", []Option{WithForceHTTP1()}, h2Server.URL, 1},
+		{"http1 only server", nil, h1Server.URL, 1},
+		{"h2c without prior knowledge", nil, h2cServer.URL, 1},
+		{"h2c", []Option{WithHTTP2PriorKnowledge()}, h2cServer.URL, 2},
+		{"prior knowledge over tls", []Option{WithHTTP2PriorKnowledge()}, h2Server.URL, 2},
+	}
+
+	for _, c := range cases {
+		var proto int
+
+		opts := append([]Option{
+			WithInsecureSkipVerify(),
+			WithResponseHook(func(req *http.Request, res *http.Response, d time.Duration) {
+				if res != nil {
+					proto = res.ProtoMajor
+				}
+			}),
+		}, c.Opts...)
+
+		ctx, err := newV8ContextWithFetch(opts...)
+		if err != nil {
+			t.Fatalf("create v8: %s", err)
+		}
+
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => res.text())`, c.URL), "fetch_http_protocols.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+
+		if res.String() != fmt.Sprint(c.Proto) || proto != c.Proto {
+			t.Errorf("%s: expected HTTP/%d but the server got HTTP/%s and the hook HTTP/%d", c.Name, c.Proto, res.String(), proto)
+		}
+	}
+
+	if _, err := NewFetcher(WithForceHTTP1(), WithHTTP2PriorKnowledge()); err == nil {
+		t.Error(errors.New("expected an error for both protocol options"))
+	}
+}
+
+func TestFetchUnixSocket(t *testing.T) {
+	t.Parallel()
+
+	// a socket path is limited to about 100 bytes, t.TempDir can be longer
+	dir, err := os.MkdirTemp("", "v8go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var conns int32
+
+	newUnixServer := func(name string) *httptest.Server {
+		ln, err := net.Listen("unix", filepath.Join(dir, name+".sock"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, "%s %s %s;", name, r.Host, r.URL.Path)
+		}))
+		srv.Listener.Close()
+		srv.Listener = ln
+		srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		}
+		srv.Start()
+
+		return srv
+	}
+
+	api := newUnixServer("api")
+	defer api.Close()
+
+	admin := newUnixServer("admin")
+	defer admin.Close()
+
+	tcp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "tcp;")
+	}))
+	defer tcp.Close()
+
+	ctx, err := newV8ContextWithFetch(
+		WithUnixSocket("Internal.sock", filepath.Join(dir, "api.sock")),
+		WithUnixSocket("admin.sock:8080", filepath.Join(dir, "admin.sock")),
+	)
+	if err != nil {
+		t.Fatalf("create v8: %s", err)
+	}
+
+	val, err := ctx.RunScript(fmt.Sprintf(`{
+		const get = (url) => () => fetch(url).then(res => res.text());
+		const texts = [];
+		const add = (text) => texts.push(text);
+
+		get('http://internal.sock/a')().then(add)
+			.then(get('http://internal.sock/b')).then(add)
+			.then(get('http://admin.sock:8080/c')).then(add)
+			.then(get('%s')).then(add)
+			.then(get('http://admin.sock/d')).catch(e => add(e.message))
+			.then(() => texts.join(''))
+	}`, tcp.URL), "fetch_unix_socket.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// admin.sock without its port isn't registered, so it's resolved from the network
+	if expected := "api internal.sock /a;api internal.sock /b;admin admin.sock:8080 /c;tcp;Failed to fetch"; res.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, res.String())
+	}
+
+	// the two requests to internal.sock share a connection
+	if n := atomic.LoadInt32(&conns); n != 2 {
+		t.Errorf("expected 2 connections but got %d", n)
+	}
+
+	if _, err := NewFetcher(WithUnixSocket("a.sock", "/a"), WithUnixSocket("A.sock", "/b")); err == nil {
+		t.Error("expected an error for a host registered twice")
+	}
+}
+
+func TestFetchResolveOverride(t *testing.T) {
+	t.Parallel()
+
+	srv, pool := newTLSServerWithCA(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "https://cdn.example.net/target", http.StatusFound)
+			return
+		}
+
+		_, _ = fmt.Fprintf(w, "%s %s %s", r.Host, r.TLS.ServerName, r.URL.Path)
+	}), "api.example.com", "cdn.example.net")
+	defer srv.Close()
+
+	addr := srv.Listener.Addr().String()
+	_, port, _ := net.SplitHostPort(addr)
+
+	records := &logRecords{}
+
+	ctx, err := newV8ContextWithFetch(
+		WithTLSConfig(&tls.Config{RootCAs: pool}),
+		WithLogger(slog.New(recordHandler{records: records})),
+		WithResolveOverride(map[string]string{
+			"API.example.com":     addr,
+			"cdn.example.net:443": addr,
+		}),
+	)
+	if err != nil {
+		t.Fatalf("create v8: %s", err)
+	}
+
+	val, err := ctx.RunScript(fmt.Sprintf(`Promise.all([
+		fetch('https://api.example.com:%[1]s/a').then(res => res.text()),
+		fetch('https://api.example.com:%[1]s/redirect').then(res => res.url + " " + res.status),
+	]).then(texts => texts.join('|'))`, port), "fetch_resolve_override.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := waitForPromise(ctx, val)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the redirect target has no body to tell its host, the url does
+	if expected := fmt.Sprintf("api.example.com:%s api.example.com /a|https://cdn.example.net/target 200", port); res.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, res.String())
+	}
+
+	overrides := 0
+	records.mu.Lock()
+	for _, r := range records.records {
+		if r["msg"] == "resolve override" && r["level"] == "DEBUG" && r["override"] == addr && r["request_id"] != "" {
+			overrides++
+		}
+	}
+	records.mu.Unlock()
+
+	if overrides != 3 {
+		t.Errorf("expected 3 logged overrides but got %d", overrides)
+	}
+
+	for _, overrides := range []map[string]string{{"a.example.com": "localhost:80"}, {"a.example.com": "127.0.0.1"}} {
+		if _, err := NewFetcher(WithResolveOverride(overrides)); err == nil {
+			t.Errorf("expected an error for %v", overrides)
+		}
+	}
+}
+
+func TestFetchIDNHost(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://Ü.example:"+r.URL.Query().Get("port")+"/target", http.StatusFound)
+			return
+		}
+
+		_, _ = fmt.Fprintf(w, "%s %s", r.Host, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	addr := srv.Listener.Addr().String()
+	_, port, _ := net.SplitHostPort(addr)
+
+	// the options name the unicode hosts, the urls are dialed in their ASCII form
+	ctx, err := newV8ContextWithFetch(
+		WithAllowedHosts("bücher.example", "ü.example"),
+		WithResolveOverride(map[string]string{
+			"bücher.example":  addr,
+			"xn--tda.example": addr,
+		}),
+	)
+	if err != nil {
+		t.Fatalf("create v8: %s", err)
+	}
+
+	for _, tc := range []struct {
+		Name     string
+		URL      string
+		Expected string
+	}{
+		{"unicode", "http://bücher.example:PORT/a", "http://xn--bcher-kva.example:PORT/a xn--bcher-kva.example:PORT /a"},
+		{"mixed case and trailing dot", "http://BÜCHER.Example.:PORT/b", "http://xn--bcher-kva.example:PORT/b xn--bcher-kva.example:PORT /b"},
+		{"redirect", "http://bücher.example:PORT/redirect?port=PORT", "http://xn--tda.example:PORT/target xn--tda.example:PORT /target"},
+		{"invalid label", "http://xn--zz-.example:PORT/", "TypeError: fetch: invalid host xn--zz-.example, idna: invalid label xn--zz-"},
+		{"not allowed", "http://xn--bcher-kva.example.com:PORT/", "TypeError: fetch: host not allowed: xn--bcher-kva.example.com"},
+	} {
+		val, err := ctx.RunScript(fmt.Sprintf(`fetch('%s').then(res => res.text().then(text => res.url + ' ' + text)).catch(e => String(e))`, strings.ReplaceAll(tc.URL, "PORT", port)), "fetch_idn_host.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := waitForPromise(ctx, val)
+		if err != nil {
+			t.Errorf("%s: %s", tc.Name, err)
+			continue
+		}
+
+		if expected := strings.ReplaceAll(tc.Expected, "PORT", port); res.String() != expected {
+			t.Errorf("%s: expected '%s' but got '%s'", tc.Name, expected, res.String())
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+(function () {
+  "use strict";
+
+  const kEntries = Symbol("entries");
+
+  // the fetch polyfill reads the entries, including file names, through this
+  const kEntryList = Symbol.for("v8go-polyfills.FormData.entryList");
+
+  function isBytes(value) {
+    return value instanceof ArrayBuffer || ArrayBuffer.isView(value);
+  }
+
+  // the blob polyfill defines Blob, if it's injected
+  function isBlob(value) {
+    return typeof Blob === "function" && value instanceof Blob;
+  }
+
+  function checkArgs(method, count, required) {
+    if (count < required) {
+      throw new TypeError(
+        `Failed to execute '${method}' on 'FormData': ${required} arguments required, but only ${count} present.`
+      );
+    }
+  }
+
+  /*
+   * Besides strings, a Blob and byte values (ArrayBuffer and its views) are
+   * stored as files, the filename defaults to "blob" like it does for a Blob.
+   * https://xhr.spec.whatwg.org/#create-an-entry
+   */
+  function createEntry(method, name, value, filename, count) {
+    name = String(name);
+
+    if (isBlob(value)) {
+      return {
+        name,
+        value,
+        filename: filename === undefined ? "blob" : String(filename),
+        type: value.type || "application/octet-stream",
+      };
+    }
+
+    if (isBytes(value)) {
+      return {
+        name,
+        value,
+        filename: filename === undefined ? "blob" : String(filename),
+        type: "application/octet-stream",
+      };
+    }
+
+    if (count > 2) {
+      throw new TypeError(
+        `Failed to execute '${method}' on 'FormData': parameter 2 is not a file.`
+      );
+    }
+
+    return { name, value: String(value) };
+  }
+
+  class FormData {
+    constructor(form) {
+      if (form !== undefined) {
+        throw new TypeError(
+          "Failed to construct 'FormData': form elements are not supported."
+        );
+      }
+
+      this[kEntries] = [];
+    }
+
+    append(name, value, filename) {
+      checkArgs("append", arguments.length, 2);
+
+      this[kEntries].push(
+        createEntry("append", name, value, filename, arguments.length)
+      );
+    }
+
+    delete(name) {
+      checkArgs("delete", arguments.length, 1);
+
+      name = String(name);
+      this[kEntries] = this[kEntries].filter((entry) => entry.name !== name);
+    }
+
+    get(name) {
+      checkArgs("get", arguments.length, 1);
+
+      name = String(name);
+      const entry = this[kEntries].find((entry) => entry.name === name);
+
+      return entry === undefined ? null : entry.value;
+    }
+
+    getAll(name) {
+      checkArgs("getAll", arguments.length, 1);
+
+      name = String(name);
+      return this[kEntries]
+        .filter((entry) => entry.name === name)
+        .map((entry) => entry.value);
+    }
+
+    has(name) {
+      checkArgs("has", arguments.length, 1);
+
+      name = String(name);
+      return this[kEntries].some((entry) => entry.name === name);
+    }
+
+    set(name, value, filename) {
+      checkArgs("set", arguments.length, 2);
+
+      const entry = createEntry("set", name, value, filename, arguments.length);
+      const index = this[kEntries].findIndex((e) => e.name === entry.name);
+
+      if (index < 0) {
+        this[kEntries].push(entry);
+        return;
+      }
+
+      // replace the first one, and remove the others
+      this[kEntries] = this[kEntries].filter(
+        (e, i) => i <= index || e.name !== entry.name
+      );
+      this[kEntries][index] = entry;
+    }
+
+    forEach(callback, thisArg) {
+      checkArgs("forEach", arguments.length, 1);
+
+      for (const [name, value] of this.entries()) {
+        callback.call(thisArg, value, name, this);
+      }
+    }
+
+    *entries() {
+      for (let i = 0; i < this[kEntries].length; i++) {
+        const entry = this[kEntries][i];
+        yield [entry.name, entry.value];
+      }
+    }
+
+    *keys() {
+      for (const [name] of this.entries()) {
+        yield name;
+      }
+    }
+
+    *values() {
+      for (const [, value] of this.entries()) {
+        yield value;
+      }
+    }
+
+    [Symbol.iterator]() {
+      return this.entries();
+    }
+
+    get [Symbol.toStringTag]() {
+      return "FormData";
+    }
+
+    [kEntryList]() {
+      return this[kEntries].map((entry) => ({ ...entry }));
+    }
+  }
+
+  Object.defineProperty(globalThis, "FormData", {
+    value: FormData,
+    writable: true,
+    configurable: true,
+  });
+})();
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package formdata
+
+import (
+	"testing"
+
+	"github.com/weese/v8go-polyfills/blob"
+	"rogchap.com/v8go"
+)
+
+func TestInject(t *testing.T) {
+	t.Parallel()
+
+	ctx := v8go.NewContext()
+
+	if err := InjectTo(ctx); err != nil {
+		t.Errorf("inject formdata polyfill: %v", err)
+	}
+
+	if val, _ := ctx.RunScript("typeof FormData", ""); val.String() != "function" {
+		t.Error("inject FormData failed")
+	}
+}
+
+func TestFormData(t *testing.T) {
+	t.Parallel()
+
+	ctx := v8go.NewContext()
+
+	if err := InjectTo(ctx); err != nil {
+		t.Errorf("inject formdata polyfill: %v", err)
+		return
+	}
+
+	if err := blob.InjectTo(ctx); err != nil {
+		t.Errorf("inject blob polyfill: %v", err)
+		return
+	}
+
+	cases := [][2]string{
+		{`const f = new FormData(); f.append("a", 1); f.append("a", "2"); f.getAll("a").join()`, "1,2"},
+		{`const f = new FormData(); f.get("a")`, "null"},
+		{`const f = new FormData(); f.append("a", "1"); f.has("a") + "," + f.has("b")`, "true,false"},
+		{`const f = new FormData(); f.append("a", "1"); f.append("b", "2"); f.delete("a"); [...f.keys()].join()`, "b"},
+		{`const f = new FormData(); f.append("a", "1"); f.append("b", "2"); f.append("a", "3"); f.set("a", "4"); JSON.stringify([...f])`, `[["a","4"],["b","2"]]`},
+		{`const f = new FormData(); f.set("a", "1"); f.get("a")`, "1"},
+		{`const f = new FormData(); f.append("a", "1"); f.append("b", "2"); const r = []; f.forEach((v, k) => r.push(k + "=" + v)); r.join("&")`, "a=1&b=2"},
+		{`const f = new FormData(); f.append("file", new Uint8Array([1, 2]), "a.bin"); f.get("file") instanceof Uint8Array`, "true"},
+		{`const f = new FormData(); const b = new Blob(["a"]); f.append("file", b); f.get("file") === b`, "true"},
+		{`const f = new FormData(); f.append("file", new Blob(["a"])); f[Symbol.for("v8go-polyfills.FormData.entryList")]()[0].filename`, "blob"},
+		{`const f = new FormData(); try { f.append("a", "1", "a.txt"); "no error" } catch (e) { e instanceof TypeError }`, "true"},
+		{`Object.prototype.toString.call(new FormData())`, "[object FormData]"},
+	}
+
+	for i, c := range cases {
+		// a block scopes the declarations, and keeps the completion value
+		val, err := ctx.RunScript("{"+c[0]+"}", "")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
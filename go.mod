@@ -1,10 +1,17 @@
 module github.com/weese/v8go-polyfills
 
-go 1.16
+go 1.21
 
 require (
 	github.com/andybalholm/brotli v1.0.5
+	go.uber.org/goleak v1.1.12
 	rogchap.com/v8go v0.7.0
 )
 
+require github.com/klauspost/compress v1.17.11
+
+require golang.org/x/text v0.21.0
+
+require golang.org/x/net v0.33.0
+
 retract [v0.1.0, v0.3.0]
@@ -23,9 +23,12 @@
 package polyfills
 
 import (
+	"github.com/weese/v8go-polyfills/abort"
 	"github.com/weese/v8go-polyfills/base64"
+	"github.com/weese/v8go-polyfills/blob"
 	"github.com/weese/v8go-polyfills/console"
 	"github.com/weese/v8go-polyfills/fetch"
+	"github.com/weese/v8go-polyfills/formdata"
 	"github.com/weese/v8go-polyfills/internal"
 	"github.com/weese/v8go-polyfills/timers"
 	"github.com/weese/v8go-polyfills/url"
@@ -70,6 +73,9 @@ func InjectToContext(ctx *v8go.Context, opt ...interface{}) error {
 
 	for _, p := range []func(*v8go.Context) error{
 		url.InjectTo,
+		blob.InjectTo,
+		formdata.InjectTo,
+		abort.InjectTo,
 	} {
 		if err := p(ctx); err != nil {
 			return err
@@ -0,0 +1,269 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"rogchap.com/v8go"
+)
+
+/*
+v8go passes strings through C, so they end at the first NUL and can't carry
+arbitrary bytes. Byte strings are shifted into U+0100 - U+01FF instead, one
+code unit per byte, the JS side reads them back with `charCodeAt(i) & 0xff`.
+*/
+const byteStringOffset = 0x100
+
+/*
+EncodeBytes returns the byte string of b, see byteStringOffset.
+*/
+func EncodeBytes(b []byte) string {
+	var sb strings.Builder
+	sb.Grow(len(b) * 2)
+
+	for _, c := range b {
+		sb.WriteRune(byteStringOffset + rune(c))
+	}
+
+	return sb.String()
+}
+
+/*
+DecodeBytes is the reverse of EncodeBytes, only the low byte
+of every code point is kept.
+*/
+func DecodeBytes(s string) []byte {
+	b := make([]byte, 0, utf8.RuneCountInString(s))
+
+	for _, r := range s {
+		b = append(b, byte(r))
+	}
+
+	return b
+}
+
+/*
+NewStringValue creates a JS string from s, including any NUL characters.
+*/
+func NewStringValue(ctx *v8go.Context, s string) (*v8go.Value, error) {
+	if strings.IndexByte(s, 0) < 0 {
+		return v8go.NewValue(ctx.Isolate(), s)
+	}
+
+	// a JSON string escapes NUL, so the string survives the trip through C
+	return v8go.JSONParse(ctx, quoteJSON(s))
+}
+
+// quoteJSON quotes s as a JSON string, only " \\ and the controls are escaped
+func quoteJSON(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s) + 2 + 5*strings.Count(s, "\x00"))
+
+	sb.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; needsJSONEscape(c) {
+			sb.WriteString(s[start:i])
+			writeJSONEscape(&sb, c)
+			start = i + 1
+		}
+	}
+	sb.WriteString(s[start:])
+	sb.WriteByte('"')
+
+	return sb.String()
+}
+
+func needsJSONEscape(c byte) bool {
+	return c < 0x20 || c == '"' || c == '\\'
+}
+
+func writeJSONEscape(sb *strings.Builder, c byte) {
+	const hexDigits = "0123456789abcdef"
+
+	if c == '"' || c == '\\' {
+		sb.WriteByte('\\')
+		sb.WriteByte(c)
+		return
+	}
+
+	sb.WriteString(`\u00`)
+	sb.WriteByte(hexDigits[c>>4])
+	sb.WriteByte(hexDigits[c&0xF])
+}
+
+/*
+NewLatin1Value creates a JS string from b with one code unit per byte, a binary
+string, including any NUL characters. The string is built once, quoted as JSON
+for a NUL.
+*/
+func NewLatin1Value(ctx *v8go.Context, b []byte) (*v8go.Value, error) {
+	quote := bytes.IndexByte(b, 0) >= 0
+
+	var sb strings.Builder
+	sb.Grow(2*len(b) + 2)
+
+	if quote {
+		sb.WriteByte('"')
+	}
+	for _, c := range b {
+		switch {
+		case c >= utf8.RuneSelf:
+			sb.WriteByte(0xC0 | c>>6)
+			sb.WriteByte(0x80 | c&0x3F)
+		case quote && needsJSONEscape(c):
+			writeJSONEscape(&sb, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	if !quote {
+		return v8go.NewValue(ctx.Isolate(), sb.String())
+	}
+
+	sb.WriteByte('"')
+	return v8go.JSONParse(ctx, sb.String())
+}
+
+/*
+ValueString returns the string of val, including any NUL characters, JSON
+escapes them on the way to Go. A lone surrogate becomes U+FFFD, like with
+val.String(). Other values than strings are converted by val.String().
+*/
+func ValueString(ctx *v8go.Context, val *v8go.Value) (string, error) {
+	if !val.IsString() {
+		return val.String(), nil
+	}
+
+	q, err := v8go.JSONStringify(ctx, val)
+	if err != nil {
+		return "", err
+	}
+
+	return unquoteJSON(q)
+}
+
+var errJSONString = errors.New("v8go-polyfills: invalid JSON string")
+
+/*
+unquoteJSON reads the JSON string of JSON.stringify, without an escape it's
+a slice of q. JSON.stringify escapes lone surrogates, they become U+FFFD.
+*/
+func unquoteJSON(q string) (string, error) {
+	if len(q) < 2 || q[0] != '"' || q[len(q)-1] != '"' {
+		return "", errJSONString
+	}
+	q = q[1 : len(q)-1]
+
+	i := strings.IndexByte(q, '\\')
+	if i < 0 {
+		return q, nil
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(q))
+
+	for i >= 0 {
+		sb.WriteString(q[:i])
+		q = q[i:]
+
+		if len(q) < 2 {
+			return "", errJSONString
+		}
+
+		switch c := q[1]; c {
+		case '"', '\\', '/':
+			sb.WriteByte(c)
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'u':
+			r, ok := parseHex4(q[2:])
+			if !ok {
+				return "", errJSONString
+			}
+			q = q[4:]
+
+			if utf16.IsSurrogate(r) {
+				r2, ok := rune(0), false
+				if len(q) >= 8 && q[2] == '\\' && q[3] == 'u' {
+					r2, ok = parseHex4(q[4:])
+				}
+
+				if dec := utf16.DecodeRune(r, r2); ok && dec != utf8.RuneError {
+					r = dec
+					q = q[6:]
+				} else {
+					r = utf8.RuneError
+				}
+			}
+
+			sb.WriteRune(r)
+		default:
+			return "", errJSONString
+		}
+
+		q = q[2:]
+		i = strings.IndexByte(q, '\\')
+	}
+	sb.WriteString(q)
+
+	return sb.String(), nil
+}
+
+// parseHex4 parses the 4 hex digits at the start of s
+func parseHex4(s string) (rune, bool) {
+	if len(s) < 4 {
+		return 0, false
+	}
+
+	var r rune
+	for _, c := range []byte(s[:4]) {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, false
+		}
+		r = r<<4 | rune(c)
+	}
+
+	return r, true
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internal
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+/*
+domainProfile converts domains the way the WHATWG URL standard does: UTS #46
+non-transitional processing, without the STD3 rules, so `_` stays valid, and
+without checking hyphens or DNS lengths.
+*/
+var domainProfile = idna.New(
+	idna.MapForLookup(),
+	idna.Transitional(false),
+	idna.StrictDomainName(false),
+	idna.CheckHyphens(false),
+	idna.CheckJoiners(true),
+	idna.BidiRule(),
+)
+
+// the forbidden domain code points, besides C0 controls and DEL
+const forbiddenDomainCodePoints = " #%/:<>?@[\\]^|"
+
+/*
+DomainToASCII returns the ASCII form of domain, lowercased with its labels
+mapped and punycoded, or an error if domain isn't valid. Punycoded labels
+are mapped like the unicode they stand for, the URL polyfill hands domains
+over the way core-js encoded them.
+*/
+func DomainToASCII(domain string) (string, error) {
+	labels := strings.Split(domain, ".")
+
+	for i, label := range labels {
+		if !strings.HasPrefix(strings.ToLower(label), "xn--") {
+			continue
+		}
+
+		// a punycoded label stands for some non-ASCII
+		unicode, err := idna.Punycode.ToUnicode(label)
+		if err != nil {
+			return "", err
+		}
+		if isASCII(unicode) {
+			return "", errors.New("idna: invalid label " + label)
+		}
+
+		labels[i] = unicode
+	}
+
+	ascii, err := domainProfile.ToASCII(strings.Join(labels, "."))
+	if err != nil {
+		return "", err
+	}
+
+	if ascii == "" {
+		return "", errors.New("idna: empty domain")
+	}
+
+	for i := 0; i < len(ascii); i++ {
+		if c := ascii[i]; c <= 0x1f || c == 0x7f || strings.IndexByte(forbiddenDomainCodePoints, c) >= 0 {
+			return "", errors.New("idna: forbidden code point in " + ascii)
+		}
+	}
+
+	return ascii, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internal
+
+import (
+	"context"
+	"sync"
+
+	"rogchap.com/v8go"
+)
+
+/*
+Loop queues the tasks which have to run on the goroutine owning an isolate,
+like settling the promise of a fetch done in another goroutine. v8go isolates
+aren't safe for concurrent use, so only the owner runs them, with ProcessLoop,
+RunLoop or RunLoopUntilIdle. There is one Loop per isolate, shared by all the polyfills.
+*/
+type Loop struct {
+	iso *v8go.Isolate
+
+	// the tasks to run, and the ones begun but not posted yet
+	tasks   []func()
+	pending int
+	// RunLoop and RunLoopUntilIdle wait on it, the loop is kept while they do
+	wake    chan struct{}
+	runners int
+}
+
+// loopsMu guards loops and the state of every Loop
+var (
+	loopsMu sync.Mutex
+	loops   = make(map[*v8go.Isolate]*Loop)
+)
+
+// loopOf returns the Loop of iso, loopsMu must be held
+func loopOf(iso *v8go.Isolate) *Loop {
+	l, ok := loops[iso]
+	if !ok {
+		l = &Loop{iso: iso, wake: make(chan struct{}, 1)}
+		loops[iso] = l
+	}
+
+	return l
+}
+
+// release drops an idle loop, so the isolate isn't kept, loopsMu must be held
+func (l *Loop) release() {
+	if l.pending == 0 && len(l.tasks) == 0 && l.runners == 0 && loops[l.iso] == l {
+		delete(loops, l.iso)
+	}
+}
+
+/*
+BeginTask counts a task of iso which runs later, like when a fetch is done.
+The returned post queues it from any goroutine, it must be called exactly once.
+A nil task only ends the count, it's for work which was dropped.
+*/
+func BeginTask(iso *v8go.Isolate) (post func(task func())) {
+	loopsMu.Lock()
+	defer loopsMu.Unlock()
+
+	l := loopOf(iso)
+	l.pending++
+
+	var once sync.Once
+	return func(task func()) {
+		once.Do(func() {
+			loopsMu.Lock()
+			defer loopsMu.Unlock()
+
+			l.pending--
+			if task != nil {
+				l.tasks = append(l.tasks, task)
+			}
+
+			select {
+			case l.wake <- struct{}{}:
+			default:
+			}
+
+			l.release()
+		})
+	}
+}
+
+/*
+ProcessLoop runs the queued tasks of iso on the calling goroutine, without
+waiting for more, and returns how many ran. Tasks queued meanwhile run too.
+*/
+func ProcessLoop(iso *v8go.Isolate) int {
+	n := 0
+
+	for {
+		loopsMu.Lock()
+		l, ok := loops[iso]
+		if !ok || len(l.tasks) == 0 {
+			loopsMu.Unlock()
+			return n
+		}
+
+		tasks := l.tasks
+		l.tasks = nil
+		l.release()
+		loopsMu.Unlock()
+
+		// a task may begin new ones, so none runs with the lock held
+		for _, task := range tasks {
+			task()
+		}
+		n += len(tasks)
+	}
+}
+
+/*
+RunLoop runs the tasks of iso as they are queued until ctx is done,
+and returns the error of ctx.
+*/
+func RunLoop(ctx context.Context, iso *v8go.Isolate) error {
+	return runTasks(ctx, iso, false)
+}
+
+/*
+RunLoopUntilIdle runs the tasks of iso until none is queued or pending anymore,
+or ctx is done, then it returns the error of ctx.
+*/
+func RunLoopUntilIdle(ctx context.Context, iso *v8go.Isolate) error {
+	return runTasks(ctx, iso, true)
+}
+
+func runTasks(ctx context.Context, iso *v8go.Isolate, untilIdle bool) error {
+	loopsMu.Lock()
+	l := loopOf(iso)
+	l.runners++
+	loopsMu.Unlock()
+
+	defer func() {
+		loopsMu.Lock()
+		l.runners--
+		l.release()
+		loopsMu.Unlock()
+	}()
+
+	for {
+		ProcessLoop(iso)
+
+		loopsMu.Lock()
+		idle := l.pending == 0 && len(l.tasks) == 0
+		loopsMu.Unlock()
+
+		if untilIdle && idle {
+			return nil
+		}
+
+		select {
+		case <-l.wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package performance
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/weese/v8go-polyfills/internal"
+	"rogchap.com/v8go"
+)
+
+/*
+InjectTo injects performance with its own timeline into ctx, now() counts from
+the time of InjectTo. The marks and measures are kept by Go, per context.
+*/
+func InjectTo(ctx *v8go.Context) error {
+	if ctx == nil {
+		return errors.New("v8go-polyfills/performance: ctx is required")
+	}
+
+	val, err := ctx.RunScript(performancePolyfill, "performance-polyfill.js")
+	if err != nil {
+		return fmt.Errorf("v8go-polyfills/performance: %w", err)
+	}
+
+	factory, err := val.AsFunction()
+	if err != nil {
+		return fmt.Errorf("v8go-polyfills/performance: %w", err)
+	}
+
+	natives, err := newNativeObject(ctx, newPerformance())
+	if err != nil {
+		return fmt.Errorf("v8go-polyfills/performance: %w", err)
+	}
+
+	if _, err := factory.Call(v8go.Undefined(ctx.Isolate()), natives); err != nil {
+		return fmt.Errorf("v8go-polyfills/performance: %w", err)
+	}
+
+	return nil
+}
+
+/*
+newNativeObject creates the object passed to the JS side of the polyfill, with the timeline of p.
+The JS side checks and converts the arguments, the names are strings by then, an undefined
+name or type matches all the entries, and the entries are passed back as JSON.
+*/
+func newNativeObject(ctx *v8go.Context, p *performance) (*v8go.Object, error) {
+	iso := ctx.Isolate()
+
+	callbacks := map[string]v8go.FunctionCallback{
+		"now": func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+			v, _ := v8go.NewValue(iso, p.now())
+			return v
+		},
+		"timeOrigin": func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+			v, _ := v8go.NewValue(iso, p.timeOrigin())
+			return v
+		},
+		// add(entryType, name, startTime, duration, detail)
+		"add": func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+			args := info.Args()
+			if len(args) < 5 {
+				return nil
+			}
+
+			e := entry{EntryType: args[0].String(), StartTime: args[2].Number(), Duration: args[3].Number()}
+			e.Name, _ = internal.ValueString(info.Context(), args[1])
+			if args[4].IsString() {
+				e.Detail, _ = internal.ValueString(info.Context(), args[4])
+			}
+
+			p.add(e)
+			return nil
+		},
+		// markTime(name) is the start time of the last mark named name, or undefined
+		"markTime": func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+			args := info.Args()
+			if len(args) < 1 {
+				return nil
+			}
+
+			name, _ := internal.ValueString(info.Context(), args[0])
+			if t, ok := p.markTime(name); ok {
+				v, _ := v8go.NewValue(iso, t)
+				return v
+			}
+
+			return nil
+		},
+		// getEntries(name, entryType)
+		"getEntries": func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+			match := newMatch(info.Context(), info.Args())
+
+			s, err := p.find(match)
+			if err != nil {
+				return nil
+			}
+
+			v, _ := internal.NewStringValue(info.Context(), s)
+			return v
+		},
+		// clear(name, entryType)
+		"clear": func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+			p.clear(newMatch(info.Context(), info.Args()))
+			return nil
+		},
+	}
+
+	nativeTmp := v8go.NewObjectTemplate(iso)
+
+	for name, cb := range callbacks {
+		if err := nativeTmp.Set(name, v8go.NewFunctionTemplate(iso, cb), v8go.ReadOnly); err != nil {
+			return nil, err
+		}
+	}
+
+	return nativeTmp.NewInstance(ctx)
+}
+
+// newMatch matches the entries with the name and the type of args, the undefined ones match all
+func newMatch(ctx *v8go.Context, args []*v8go.Value) func(e entry) bool {
+	var name, entryType *string
+
+	if len(args) > 0 && !args[0].IsUndefined() {
+		s, _ := internal.ValueString(ctx, args[0])
+		name = &s
+	}
+
+	if len(args) > 1 && !args[1].IsUndefined() {
+		s := args[1].String()
+		entryType = &s
+	}
+
+	return func(e entry) bool {
+		return (name == nil || e.Name == *name) && (entryType == nil || e.EntryType == *entryType)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package performance
+
+import (
+	_ "embed"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+//go:embed performance.js
+var performancePolyfill string
+
+// entry is a mark or a measure, Detail is the JSON of its detail, empty for null
+type entry struct {
+	Name      string  `json:"name"`
+	EntryType string  `json:"entryType"`
+	StartTime float64 `json:"startTime"`
+	Duration  float64 `json:"duration"`
+	Detail    string  `json:"detail,omitempty"`
+}
+
+/*
+performance is the timeline of a context, the times are milliseconds since origin.
+They're taken from the monotonic clock of origin, so changes of the wall clock
+don't move them.
+*/
+type performance struct {
+	origin  time.Time
+	entries []entry
+}
+
+func newPerformance() *performance {
+	return &performance{origin: time.Now()}
+}
+
+func (p *performance) now() float64 {
+	return float64(time.Since(p.origin).Nanoseconds()) / 1e6
+}
+
+// timeOrigin is the Unix time of origin in milliseconds
+func (p *performance) timeOrigin() float64 {
+	return float64(p.origin.UnixNano()) / 1e6
+}
+
+func (p *performance) add(e entry) {
+	p.entries = append(p.entries, e)
+}
+
+// markTime returns the start time of the last mark named name
+func (p *performance) markTime(name string) (float64, bool) {
+	for i := len(p.entries) - 1; i >= 0; i-- {
+		if e := p.entries[i]; e.EntryType == "mark" && e.Name == name {
+			return e.StartTime, true
+		}
+	}
+
+	return 0, false
+}
+
+/*
+find returns the JSON of the entries matching, in the order of their start times,
+the ones starting together in the order they were added.
+*/
+func (p *performance) find(match func(e entry) bool) (string, error) {
+	found := make([]entry, 0, len(p.entries))
+	for _, e := range p.entries {
+		if match(e) {
+			found = append(found, e)
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].StartTime < found[j].StartTime
+	})
+
+	b, err := json.Marshal(found)
+	return string(b), err
+}
+
+// clear drops the entries matching
+func (p *performance) clear(match func(e entry) bool) {
+	kept := make([]entry, 0, len(p.entries))
+	for _, e := range p.entries {
+		if !match(e) {
+			kept = append(kept, e)
+		}
+	}
+
+	p.entries = kept
+}
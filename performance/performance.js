@@ -0,0 +1,288 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+(function (native) {
+  "use strict";
+
+  const kEntry = Symbol("entry");
+
+  // guards the constructors, the entries come from performance
+  const kConstruct = Symbol("construct");
+
+  function domException(message, name) {
+    if (typeof globalThis.DOMException === "function") {
+      return new globalThis.DOMException(message, name);
+    }
+
+    const err = new Error(message);
+    err.name = name;
+    return err;
+  }
+
+  class PerformanceEntry {
+    constructor(key, entry) {
+      if (key !== kConstruct) {
+        throw new TypeError("Illegal constructor");
+      }
+
+      this[kEntry] = entry;
+    }
+
+    get name() {
+      return this[kEntry].name;
+    }
+
+    get entryType() {
+      return this[kEntry].entryType;
+    }
+
+    get startTime() {
+      return this[kEntry].startTime;
+    }
+
+    get duration() {
+      return this[kEntry].duration;
+    }
+
+    toJSON() {
+      const { name, entryType, startTime, duration } = this;
+      return { name, entryType, startTime, duration };
+    }
+
+    get [Symbol.toStringTag]() {
+      return "PerformanceEntry";
+    }
+  }
+
+  class PerformanceMark extends PerformanceEntry {
+    get detail() {
+      return this[kEntry].detail;
+    }
+
+    toJSON() {
+      return { ...super.toJSON(), detail: this.detail };
+    }
+
+    get [Symbol.toStringTag]() {
+      return "PerformanceMark";
+    }
+  }
+
+  class PerformanceMeasure extends PerformanceEntry {
+    get detail() {
+      return this[kEntry].detail;
+    }
+
+    toJSON() {
+      return { ...super.toJSON(), detail: this.detail };
+    }
+
+    get [Symbol.toStringTag]() {
+      return "PerformanceMeasure";
+    }
+  }
+
+  // the detail is kept as JSON by Go, every read gets a copy like the structured clone of the spec
+  function serializeDetail(detail) {
+    return detail === undefined || detail === null ? undefined : JSON.stringify(detail);
+  }
+
+  function toEntries(json) {
+    return JSON.parse(json).map((entry) => {
+      entry.detail = entry.detail === undefined ? null : JSON.parse(entry.detail);
+
+      const Entry = entry.entryType === "mark" ? PerformanceMark : PerformanceMeasure;
+      return new Entry(kConstruct, entry);
+    });
+  }
+
+  function toTimestamp(value, what) {
+    const time = Number(value);
+    if (time < 0) {
+      throw new TypeError(`Failed to execute '${what}' on 'Performance': '${value}' is a negative time stamp.`);
+    }
+
+    return time;
+  }
+
+  // https://w3c.github.io/user-timing/#convert-a-mark-to-a-timestamp
+  function markTime(mark) {
+    if (typeof mark === "string") {
+      const time = native.markTime(mark);
+      if (time === undefined) {
+        throw domException(`Failed to execute 'measure' on 'Performance': The mark '${mark}' does not exist.`, "SyntaxError");
+      }
+
+      return time;
+    }
+
+    return toTimestamp(mark, "measure");
+  }
+
+  function isEmptyOptions(options) {
+    return options === undefined || options === null || (typeof options === "object" && Object.keys(options).length === 0);
+  }
+
+  class Performance {
+    constructor(key) {
+      if (key !== kConstruct) {
+        throw new TypeError("Illegal constructor");
+      }
+    }
+
+    get timeOrigin() {
+      return native.timeOrigin();
+    }
+
+    now() {
+      return native.now();
+    }
+
+    // https://w3c.github.io/user-timing/#mark-method
+    mark(markName, markOptions = {}) {
+      if (arguments.length < 1) {
+        throw new TypeError("Failed to execute 'mark' on 'Performance': 1 argument required, but only 0 present.");
+      }
+
+      const name = String(markName);
+      const options = markOptions ?? {};
+      const startTime = options.startTime === undefined ? native.now() : toTimestamp(options.startTime, "mark");
+      const detail = serializeDetail(options.detail);
+
+      native.add("mark", name, startTime, 0, detail);
+      return new PerformanceMark(kConstruct, {
+        name,
+        entryType: "mark",
+        startTime,
+        duration: 0,
+        detail: detail === undefined ? null : JSON.parse(detail),
+      });
+    }
+
+    // https://w3c.github.io/user-timing/#measure-method
+    measure(measureName, startOrMeasureOptions = {}, endMark) {
+      if (arguments.length < 1) {
+        throw new TypeError("Failed to execute 'measure' on 'Performance': 1 argument required, but only 0 present.");
+      }
+
+      const name = String(measureName);
+      const options = typeof startOrMeasureOptions === "object" && !isEmptyOptions(startOrMeasureOptions) ? startOrMeasureOptions : undefined;
+
+      if (options !== undefined) {
+        if (endMark !== undefined) {
+          throw new TypeError("Failed to execute 'measure' on 'Performance': If a non-empty PerformanceMeasureOptions object was passed, |end_mark| must not be passed.");
+        }
+
+        if (options.start === undefined && options.end === undefined) {
+          throw new TypeError("Failed to execute 'measure' on 'Performance': If a non-empty PerformanceMeasureOptions object was passed, at least one of its 'start' or 'end' properties must be present.");
+        }
+
+        if (options.start !== undefined && options.duration !== undefined && options.end !== undefined) {
+          throw new TypeError("Failed to execute 'measure' on 'Performance': If a non-empty PerformanceMeasureOptions object was passed, it must not have all of its 'start', 'duration', and 'end' properties present.");
+        }
+      }
+
+      let end;
+      if (endMark !== undefined) {
+        end = markTime(endMark);
+      } else if (options !== undefined && options.end !== undefined) {
+        end = markTime(options.end);
+      } else if (options !== undefined && options.start !== undefined && options.duration !== undefined) {
+        end = markTime(options.start) + toTimestamp(options.duration, "measure");
+      } else {
+        end = native.now();
+      }
+
+      let start;
+      if (options !== undefined && options.start !== undefined) {
+        start = markTime(options.start);
+      } else if (options !== undefined && options.duration !== undefined && options.end !== undefined) {
+        start = end - toTimestamp(options.duration, "measure");
+      } else if (options === undefined && startOrMeasureOptions !== undefined && startOrMeasureOptions !== null && typeof startOrMeasureOptions !== "object") {
+        start = markTime(startOrMeasureOptions);
+      } else {
+        start = 0;
+      }
+
+      const detail = serializeDetail(options && options.detail);
+
+      native.add("measure", name, start, end - start, detail);
+      return new PerformanceMeasure(kConstruct, {
+        name,
+        entryType: "measure",
+        startTime: start,
+        duration: end - start,
+        detail: detail === undefined ? null : JSON.parse(detail),
+      });
+    }
+
+    getEntries() {
+      return toEntries(native.getEntries(undefined, undefined));
+    }
+
+    getEntriesByName(name, type) {
+      return toEntries(native.getEntries(String(name), type === undefined ? undefined : String(type)));
+    }
+
+    getEntriesByType(type) {
+      return toEntries(native.getEntries(undefined, String(type)));
+    }
+
+    clearMarks(markName) {
+      native.clear(markName === undefined ? undefined : String(markName), "mark");
+    }
+
+    clearMeasures(measureName) {
+      native.clear(measureName === undefined ? undefined : String(measureName), "measure");
+    }
+
+    toJSON() {
+      return { timeOrigin: this.timeOrigin };
+    }
+
+    get [Symbol.toStringTag]() {
+      return "Performance";
+    }
+  }
+
+  const exports = {
+    Performance,
+    PerformanceEntry,
+    PerformanceMark,
+    PerformanceMeasure,
+  };
+
+  for (const [name, value] of Object.entries(exports)) {
+    Object.defineProperty(globalThis, name, {
+      value,
+      writable: true,
+      configurable: true,
+    });
+  }
+
+  Object.defineProperty(globalThis, "performance", {
+    value: new Performance(kConstruct),
+    writable: true,
+    configurable: true,
+    enumerable: true,
+  });
+});
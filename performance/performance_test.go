@@ -0,0 +1,273 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package performance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weese/v8go-polyfills/abort"
+	"rogchap.com/v8go"
+)
+
+func TestInjectTo(t *testing.T) {
+	t.Parallel()
+
+	ctx := v8go.NewContext()
+
+	if err := InjectTo(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][2]string{
+		{`typeof performance`, "object"},
+		{`Object.prototype.toString.call(performance)`, "[object Performance]"},
+		{`performance instanceof Performance`, "true"},
+		{`[Performance, PerformanceEntry, PerformanceMark, PerformanceMeasure].map((c) => typeof c).join()`, "function,function,function,function"},
+		{`try { new Performance() } catch (e) { String(e) }`, "TypeError: Illegal constructor"},
+		{`try { new PerformanceMark() } catch (e) { String(e) }`, "TypeError: Illegal constructor"},
+		{`typeof performance.now()`, "number"},
+		{`performance.now() >= 0`, "true"},
+		{`Math.abs(performance.timeOrigin + performance.now() - Date.now()) < 50`, "true"},
+		{`JSON.stringify(performance.toJSON()) === JSON.stringify({ timeOrigin: performance.timeOrigin })`, "true"},
+	}
+
+	for i, c := range cases {
+		val, err := ctx.RunScript(c[0], "performance_inject.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
+
+func TestNow(t *testing.T) {
+	t.Parallel()
+
+	ctx := v8go.NewContext()
+
+	if err := InjectTo(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+
+	times := make([]float64, 3)
+	for i := range times {
+		if i > 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		val, err := ctx.RunScript("performance.now()", "performance_now.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+		times[i] = val.Number()
+	}
+
+	elapsed := float64(time.Since(before).Nanoseconds()) / 1e6
+
+	for i := 1; i < len(times); i++ {
+		if d := times[i] - times[i-1]; d < 20 || d > elapsed {
+			t.Errorf("now %d: expected 20 to %.3fms after the one before but got %.3fms", i, elapsed, d)
+		}
+	}
+
+	// the time is finer than milliseconds
+	val, err := ctx.RunScript(`
+	const times = [];
+	for (let i = 0; i < 100; i++) {
+		times.push(performance.now());
+	}
+	times.every((t, i) => i === 0 || t >= times[i - 1]) && times.some((t) => t % 1 !== 0)`, "performance_now.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !val.Boolean() {
+		t.Error("expected monotonic times with fractions of milliseconds")
+	}
+}
+
+func TestMarkMeasure(t *testing.T) {
+	t.Parallel()
+
+	ctx := v8go.NewContext()
+
+	if err := InjectTo(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx.RunScript(`var a = performance.mark("a", { detail: { step: 1 } })`, "performance_mark.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if _, err := ctx.RunScript(`var b = performance.mark("b")`, "performance_mark.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][2]string{
+		{`a instanceof PerformanceMark && a instanceof PerformanceEntry && [a.name, a.entryType, a.duration].join()`, "a,mark,0"},
+		{`b.startTime - a.startTime >= 30`, "true"},
+		{`JSON.stringify(a.detail) + " " + b.detail`, `{"step":1} null`},
+		{`const m = performance.measure("ab", "a", "b"); m instanceof PerformanceMeasure && m.startTime === a.startTime && m.duration === b.startTime - a.startTime`, "true"},
+		{`const m = performance.measure("a-", "a"); m.startTime === a.startTime && m.duration >= 30`, "true"},
+		{`const m = performance.measure("-b", undefined, "b"); m.startTime === 0 && m.duration === b.startTime`, "true"},
+		{`const m = performance.measure("all"); m.startTime === 0 && m.duration > b.startTime`, "true"},
+		{`const m = performance.measure("opts", { start: "a", duration: 5, detail: [1] }); m.startTime === a.startTime && m.duration === 5 && m.detail[0]`, "1"},
+		{`const m = performance.measure("end", { end: "b", duration: 10 }); m.startTime === b.startTime - 10 && m.duration`, "10"},
+		{`const m = performance.measure("nums", 10, 25); [m.startTime, m.duration].join()`, "10,15"},
+		{`performance.getEntriesByName("ab").map((e) => e.entryType + ":" + e.name).join()`, "measure:ab"},
+		{`performance.getEntriesByName("a", "mark").length`, "1"},
+		{`performance.getEntriesByType("mark").map((e) => e.name).join()`, "a,b"},
+		// by the start times
+		{`performance.getEntries().map((e) => e.name).join()`, "-b,all,a,ab,a-,opts,nums,end,b"},
+		{`performance.getEntriesByName("a")[0].detail.step`, "1"},
+		{`performance.getEntriesByName("a")[0].detail === performance.getEntriesByName("a")[0].detail`, "false"},
+		{`JSON.stringify(performance.getEntriesByName("nums")[0])`, `{"name":"nums","entryType":"measure","startTime":10,"duration":15,"detail":null}`},
+		{`performance.clearMeasures("ab"); performance.getEntriesByType("measure").length`, "6"},
+		{`performance.clearMeasures(); performance.getEntriesByType("measure").length + performance.getEntriesByType("mark").length`, "2"},
+		{`performance.mark("a"); performance.clearMarks("a"); performance.getEntriesByType("mark").map((e) => e.name).join()`, "b"},
+		{`performance.clearMarks(); performance.getEntries().length`, "0"},
+		{`performance.mark("m", { startTime: 12.5 }).startTime`, "12.5"},
+		{`performance.mark("n\u0000ul"); performance.getEntriesByName("n\u0000ul").length`, "1"},
+	}
+
+	for i, c := range cases {
+		val, err := ctx.RunScript("{"+c[0]+"}", "performance_measure.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
+
+func TestMarkMeasureErrors(t *testing.T) {
+	t.Parallel()
+
+	ctx := v8go.NewContext()
+
+	// DOMException comes with the abort polyfill
+	if err := abort.InjectTo(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InjectTo(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][2]string{
+		{`performance.mark()`, "TypeError: Failed to execute 'mark' on 'Performance': 1 argument required, but only 0 present."},
+		{`performance.mark("a", { startTime: -1 })`, "TypeError: Failed to execute 'mark' on 'Performance': '-1' is a negative time stamp."},
+		{`performance.measure()`, "TypeError: Failed to execute 'measure' on 'Performance': 1 argument required, but only 0 present."},
+		{`performance.measure("m", "missing")`, "SyntaxError: Failed to execute 'measure' on 'Performance': The mark 'missing' does not exist."},
+		{`performance.measure("m", undefined, "missing")`, "SyntaxError: Failed to execute 'measure' on 'Performance': The mark 'missing' does not exist."},
+		{`performance.measure("m", -5)`, "TypeError: Failed to execute 'measure' on 'Performance': '-5' is a negative time stamp."},
+		{`performance.measure("m", { start: 1 }, 2)`, "TypeError: Failed to execute 'measure' on 'Performance': If a non-empty PerformanceMeasureOptions object was passed, |end_mark| must not be passed."},
+		{`performance.measure("m", { duration: 1 })`, "TypeError: Failed to execute 'measure' on 'Performance': If a non-empty PerformanceMeasureOptions object was passed, at least one of its 'start' or 'end' properties must be present."},
+		{`performance.measure("m", { start: 1, duration: 1, end: 3 })`, "TypeError: Failed to execute 'measure' on 'Performance': If a non-empty PerformanceMeasureOptions object was passed, it must not have all of its 'start', 'duration', and 'end' properties present."},
+		{`try { performance.measure("m", "missing") } catch (e) { throw String(e instanceof DOMException) }`, "true"},
+	}
+
+	for i, c := range cases {
+		val, err := ctx.RunScript("try { "+c[0]+"; 'no error' } catch (e) { String(e) }", "performance_errors.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
+
+func TestPerContext(t *testing.T) {
+	t.Parallel()
+
+	iso := v8go.NewIsolate()
+	ctx1 := v8go.NewContext(iso)
+	ctx2 := v8go.NewContext(iso)
+
+	if err := InjectTo(ctx1); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if err := InjectTo(ctx2); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx1.RunScript(`performance.mark("one")`, "performance_context.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Ctx      *v8go.Context
+		Script   string
+		Expected string
+	}{
+		{ctx1, `performance.getEntries().length`, "1"},
+		{ctx2, `performance.getEntries().length`, "0"},
+		{ctx2, `try { performance.measure("m", "one") } catch (e) { e.name }`, "SyntaxError"},
+		{ctx1, `performance.now() - 20 >= 0`, "true"},
+	}
+
+	for i, c := range cases {
+		val, err := c.Ctx.RunScript(c.Script, "performance_context.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c.Expected {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c.Expected, val.String())
+		}
+	}
+
+	// the origins are the times of InjectTo
+	val, err := ctx1.RunScript("performance.timeOrigin", "performance_context.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin1 := val.Number()
+
+	if val, err = ctx2.RunScript("performance.timeOrigin", "performance_context.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := val.Number() - origin1; d < 20 {
+		t.Errorf("expected the origins 20ms apart but got %.3fms", d)
+	}
+}
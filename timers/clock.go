@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package timers
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"rogchap.com/v8go"
+)
+
+// MaxRunAll is how many callbacks RunAll runs at most, so intervals can't keep it running
+const MaxRunAll = 1000
+
+/*
+VirtualClock is the time of the timers of WithVirtualClock. It only goes on with Advance
+or RunAll, which run the callbacks on the calling goroutine, so tests of timers don't wait.
+The callbacks run in the order they're due, the ones due at the same time in the order
+they were set. Immediates run first, like when the loop of the isolate runs them.
+A clock can be shared by timers of several isolates, Advance runs the callbacks of them all.
+*/
+type VirtualClock struct {
+	mu      sync.Mutex
+	now     time.Duration
+	seq     uint64
+	pending timerQueue
+}
+
+/*
+scheduledTimer is a callback of a clock, fire tells the delay of the next run, if any.
+Once cleared tells it's cleared, it's dropped without moving the clock.
+The loop posts it as a task of iso, post is begun while it's pending.
+*/
+type scheduledTimer struct {
+	due     time.Duration
+	seq     uint64
+	fire    func() (time.Duration, bool)
+	cleared func() bool
+
+	iso  *v8go.Isolate
+	post func(task func())
+}
+
+func NewVirtualClock() *VirtualClock {
+	return &VirtualClock{}
+}
+
+// Now returns how far the clock is advanced, from 0
+func (c *VirtualClock) Now() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+/*
+Advance moves the clock d ahead, and runs the callbacks due until then.
+Those set by the callbacks run too if they're due by then, so do the ticks of intervals.
+*/
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	until := c.now + d
+	c.mu.Unlock()
+
+	for c.runNext(until) {
+	}
+
+	c.mu.Lock()
+	if c.now < until {
+		c.now = until
+	}
+	c.mu.Unlock()
+}
+
+/*
+RunAll advances the clock until no callback is pending anymore, running them all.
+It stops after MaxRunAll callbacks, the clock is where the last one was due then.
+*/
+func (c *VirtualClock) RunAll() {
+	for n := 0; n < MaxRunAll && c.runNext(-1); n++ {
+	}
+}
+
+/*
+runNext runs the next callback due until the time until, or any with a negative until,
+and tells if there was one. The clock is moved to when it was due.
+*/
+func (c *VirtualClock) runNext(until time.Duration) bool {
+	c.mu.Lock()
+
+	for len(c.pending) > 0 && c.pending[0].cleared != nil && c.pending[0].cleared() {
+		heap.Pop(&c.pending)
+	}
+
+	if len(c.pending) == 0 || (until >= 0 && c.pending[0].due > until) {
+		c.mu.Unlock()
+		return false
+	}
+
+	vt := heap.Pop(&c.pending).(*scheduledTimer)
+	c.now = vt.due
+	c.mu.Unlock()
+
+	if delay, ok := vt.fire(); ok {
+		c.mu.Lock()
+		c.seq++
+		vt.due += delay
+		vt.seq = c.seq
+		heap.Push(&c.pending, vt)
+		c.mu.Unlock()
+	}
+
+	return true
+}
+
+// schedule runs fire after delay, and again after the delay it returns, until cleared tells so
+func (c *VirtualClock) schedule(delay time.Duration, fire func() (time.Duration, bool), cleared func() bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	heap.Push(&c.pending, &scheduledTimer{due: c.now + delay, seq: c.seq, fire: fire, cleared: cleared})
+}
+
+// timerQueue is the heap of the callbacks of a clock, the next due first
+type timerQueue []*scheduledTimer
+
+func (q timerQueue) Len() int { return len(q) }
+
+func (q timerQueue) Less(i, j int) bool {
+	if q[i].due != q[j].due {
+		return q[i].due < q[j].due
+	}
+
+	return q[i].seq < q[j].seq
+}
+
+func (q timerQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *timerQueue) Push(x any) { *q = append(*q, x.(*scheduledTimer)) }
+
+func (q *timerQueue) Pop() any {
+	old := *q
+	vt := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+
+	return vt
+}
@@ -0,0 +1,244 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package timers
+
+import (
+	"testing"
+	"time"
+
+	"rogchap.com/v8go"
+)
+
+func TestVirtualClock(t *testing.T) {
+	t.Parallel()
+
+	vc := NewVirtualClock()
+	ctx, _, err := newV8ContextWithReport(NewTimers(WithVirtualClock(vc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx.RunScript(`
+	var log = [];
+	setTimeout(() => log.push("t30"), 30);
+	setTimeout(() => log.push("t10a"), 10);
+	setTimeout(() => log.push("t20"), 20);
+	setTimeout(() => log.push("t10b"), 10);
+	const id = setInterval(() => log.push("i15"), 15);
+	setImmediate(() => log.push("immediate"));
+	setTimeout(() => {
+		log.push("t5");
+		setTimeout(() => log.push("t5+10"), 10);
+		setTimeout(() => log.push("t5+40"), 40);
+	}, 5)`, "virtual_clock.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Advance  time.Duration
+		Expected string
+	}{
+		{0, "immediate"},
+		{4 * time.Millisecond, "immediate"},
+		// the timers due at the same time run in the order they were set, a tick when the one before ran
+		{31 * time.Millisecond, "immediate,t5,t10a,t10b,i15,t5+10,t20,t30,i15"},
+		{9 * time.Millisecond, "immediate,t5,t10a,t10b,i15,t5+10,t20,t30,i15"},
+		{time.Millisecond, "immediate,t5,t10a,t10b,i15,t5+10,t20,t30,i15,t5+40,i15"},
+		{5 * time.Millisecond, "immediate,t5,t10a,t10b,i15,t5+10,t20,t30,i15,t5+40,i15"},
+	}
+
+	for i, c := range cases {
+		vc.Advance(c.Advance)
+
+		val, err := ctx.RunScript("log.join()", "virtual_clock.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if val.String() != c.Expected {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c.Expected, val.String())
+		}
+	}
+
+	if now := vc.Now(); now != 50*time.Millisecond {
+		t.Errorf("expected the clock at 50ms but got %v", now)
+	}
+}
+
+func TestVirtualClockDebounce(t *testing.T) {
+	t.Parallel()
+
+	vc := NewVirtualClock()
+	ctx, _, err := newV8ContextWithReport(NewTimers(WithVirtualClock(vc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx.RunScript(`
+	function debounce(fn, wait) {
+		let id;
+		return (...args) => {
+			clearTimeout(id);
+			id = setTimeout(() => fn(...args), wait);
+		};
+	}
+
+	var calls = [];
+	var input = debounce((v) => calls.push(v), 100)`, "virtual_clock_debounce.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+
+	for i, step := range []struct {
+		Script   string
+		Advance  time.Duration
+		Expected string
+	}{
+		{`input(1)`, 50 * time.Millisecond, ""},
+		{`input(2)`, 99 * time.Millisecond, ""},
+		{``, time.Millisecond, "2"},
+		{`input(3); input(4)`, time.Second, "2,4"},
+		{`input(5)`, 0, "2,4"},
+	} {
+		if _, err := ctx.RunScript(step.Script, "virtual_clock_debounce.js"); err != nil {
+			t.Fatal(err)
+		}
+		vc.Advance(step.Advance)
+
+		val, err := ctx.RunScript("calls.join()", "virtual_clock_debounce.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if val.String() != step.Expected {
+			t.Errorf("step %d: expected '%s' but got '%s'", i, step.Expected, val.String())
+		}
+	}
+
+	vc.RunAll()
+
+	val, err := ctx.RunScript("calls.join()", "virtual_clock_debounce.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val.String() != "2,4,5" {
+		t.Errorf("expected '2,4,5' but got '%s'", val.String())
+	}
+
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expected no waiting but it took %v", elapsed)
+	}
+}
+
+func TestVirtualClockRunAll(t *testing.T) {
+	t.Parallel()
+
+	vc := NewVirtualClock()
+	tm := NewTimers(WithVirtualClock(vc))
+
+	ctx, _, err := newV8ContextWithReport(tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Script   string
+		Expected int32
+		Now      time.Duration
+	}{
+		{`var n = 0; const id = setInterval(() => ++n === 5 && clearInterval(id), 10); undefined`, 5, 50 * time.Millisecond},
+		// an interval which isn't cleared stops RunAll after MaxRunAll callbacks
+		{`n = 0; setInterval(() => n++, 10); undefined`, MaxRunAll, (50 + MaxRunAll*10) * time.Millisecond},
+	}
+
+	for i, c := range cases {
+		if _, err := ctx.RunScript(c.Script, "virtual_clock_run_all.js"); err != nil {
+			t.Fatal(err)
+		}
+
+		vc.RunAll()
+
+		val, err := ctx.RunScript("n", "virtual_clock_run_all.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if val.Int32() != c.Expected {
+			t.Errorf("case %d: expected %d calls but got %d", i, c.Expected, val.Int32())
+		}
+
+		if now := vc.Now(); now != c.Now {
+			t.Errorf("case %d: expected the clock at %v but got %v", i, c.Now, now)
+		}
+	}
+
+	if n := tm.PendingCount(); n != 1 {
+		t.Errorf("expected the interval to be pending but got %d timers", n)
+	}
+
+	// a stopped timer is dropped by the clock
+	tm.Stop()
+	vc.RunAll()
+
+	if now := vc.Now(); now != (50+MaxRunAll*10)*time.Millisecond {
+		t.Errorf("expected the clock to stay but got %v", now)
+	}
+}
+
+func TestVirtualClockIsolates(t *testing.T) {
+	t.Parallel()
+
+	vc := NewVirtualClock()
+
+	var ctxs []*v8go.Context
+	for i := 0; i < 2; i++ {
+		ctx, _, err := newV8ContextWithReport(NewTimers(WithVirtualClock(vc)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctxs = append(ctxs, ctx)
+	}
+
+	// both isolates log into the first context
+	if _, err := ctxs[0].RunScript(`var log = []; setTimeout(() => log.push("a20"), 20)`, "virtual_clock_isolates.js"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctxs[1].RunScript(`var times = []; setTimeout(() => times.push("b10"), 10)`, "virtual_clock_isolates.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	vc.Advance(15 * time.Millisecond)
+
+	for i, c := range [][2]string{{"log.join()", ""}, {"times.join()", "b10"}} {
+		val, err := ctxs[i].RunScript(c[0], "virtual_clock_isolates.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("context %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package timers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/weese/v8go-polyfills/timers/internal"
+	"rogchap.com/v8go"
+)
+
+func (t *timers) GetRequestAnimationFrameFunctionCallback() v8go.FunctionCallback {
+	return func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		ctx := info.Context()
+
+		id, err := t.requestAnimationFrame(ctx, info.Args())
+		if err != nil {
+			return throwTimerError(ctx, "requestAnimationFrame", err)
+		}
+
+		return newInt32Value(ctx, id)
+	}
+}
+
+func (t *timers) GetCancelAnimationFrameFunctionCallback() v8go.FunctionCallback {
+	return t.clearFunctionCallback
+}
+
+/*
+requestAnimationFrame queues the callback for the next frame. The frames are due
+every interval of WithFrameInterval, DefaultFrameInterval by default, at the same
+times for all the contexts. The callbacks of a frame get the same timestamp, the
+ones requested by them run in the next frame, like in the browsers,
+https://html.spec.whatwg.org/multipage/imagebitmap-and-animations.html#animation-frames
+The IDs are the ones of the other timers, any of the clear functions cancels a
+frame callback.
+*/
+func (t *timers) requestAnimationFrame(ctx *v8go.Context, args []*v8go.Value) (int32, error) {
+	if len(args) <= 0 {
+		return 0, errors.New("1 argument required, but only 0 present.")
+	}
+
+	if !args[0].IsFunction() {
+		return 0, errors.New("parameter 1 is not of type 'FrameRequestCallback'.")
+	}
+
+	fn, err := args[0].AsFunction()
+	if err != nil {
+		return 0, err
+	}
+
+	t.mu.Lock()
+	ct := t.timersOf(ctx)
+
+	item := &internal.Item{
+		FunctionCB: func(int) {
+			t.mu.Lock()
+			timestamp := ct.FrameTime
+			t.mu.Unlock()
+
+			t.call(func() error {
+				ts, err := v8go.NewValue(ctx.Isolate(), timestamp)
+				if err != nil {
+					return err
+				}
+
+				_, err = fn.Call(v8go.Undefined(ctx.Isolate()), ts)
+				return err
+			})
+		},
+	}
+
+	if err := t.addItem(ct, item); err != nil {
+		t.mu.Unlock()
+		return 0, err
+	}
+
+	ct.Frames = append(ct.Frames, item)
+	scheduled := ct.FrameScheduled
+	ct.FrameScheduled = true
+	t.mu.Unlock()
+
+	if !scheduled {
+		interval := t.FrameInterval
+		if interval <= 0 {
+			interval = DefaultFrameInterval
+		}
+
+		now := t.now()
+		next := (now/interval + 1) * interval
+
+		t.schedule(ctx.Isolate(), next-now, func() (time.Duration, bool) {
+			t.runFrame(ctx, ct)
+			return 0, false
+		}, nil)
+	}
+
+	return item.ID, nil
+}
+
+/*
+runFrame runs the callbacks of the frame requested so far. The timestamp is the one of
+performance.now when the context has it, so they can be compared, or the time of the clock.
+*/
+func (t *timers) runFrame(ctx *v8go.Context, ct *contextTimers) {
+	t.mu.Lock()
+	closed := ct.closed
+	frames := ct.Frames
+	ct.Frames = nil
+	ct.FrameScheduled = false
+	t.mu.Unlock()
+
+	if closed {
+		return
+	}
+
+	timestamp := t.frameTime(ctx)
+
+	t.mu.Lock()
+	ct.FrameTime = timestamp
+	t.mu.Unlock()
+
+	for _, item := range frames {
+		item.Call(0)
+		item.Clear()
+	}
+}
+
+// frameTime returns performance.now() of ctx in milliseconds, or the time of the clock
+func (t *timers) frameTime(ctx *v8go.Context) float64 {
+	if t.Clock == nil {
+		if perf, err := ctx.Global().Get("performance"); err == nil && perf.IsObject() {
+			obj, _ := perf.AsObject()
+
+			if now, err := obj.Get("now"); err == nil && now.IsFunction() {
+				fn, _ := now.AsFunction()
+
+				if val, err := fn.Call(perf); err == nil && val.IsNumber() {
+					return val.Number()
+				}
+			}
+		}
+	}
+
+	return float64(t.now()) / float64(time.Millisecond)
+}
+
+// now returns the time of the clock of the timers
+func (t *timers) now() time.Duration {
+	if t.Clock != nil {
+		return t.Clock.Now()
+	}
+
+	return t.loop.now()
+}
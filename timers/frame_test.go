@@ -0,0 +1,218 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package timers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/weese/v8go-polyfills/performance"
+	"rogchap.com/v8go"
+)
+
+const frameScript = `
+var times = [];
+(function frame(ts) {
+	if (ts !== undefined) {
+		times.push(ts);
+	}
+	requestAnimationFrame(frame);
+})()`
+
+func TestAnimationFrames(t *testing.T) {
+	t.Parallel()
+
+	vc := NewVirtualClock()
+	ctx, err := newV8ContextWithFrames(WithAnimationFrames(), WithVirtualClock(vc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx.RunScript(frameScript, "animation_frames.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 6 frames in 100ms at 60 frames per second
+	vc.Advance(100 * time.Millisecond)
+
+	val, err := ctx.RunScript("times.map((ts) => ts.toFixed(3)).join()", "animation_frames.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "16.667,33.333,50.000,66.667,83.333,100.000"; val.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, val.String())
+	}
+}
+
+func TestAnimationFramesRealClock(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFrames(WithAnimationFrames())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := performance.InjectTo(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx.RunScript(frameScript, "animation_frames_real.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	runCtx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	RunTasks(runCtx, ctx.Isolate())
+
+	// the timestamps are the ones of performance.now, increasing
+	val, err := ctx.RunScript(`
+	const now = performance.now();
+	String([times.length, times.every((ts, i) => ts <= now && (i === 0 || ts > times[i - 1]))])`, "animation_frames_real.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	var increasing bool
+	fmt.Sscanf(val.String(), "%d,%t", &n, &increasing)
+
+	if n < 5 || n > 7 || !increasing {
+		t.Errorf("expected about 6 increasing timestamps but got %s", val.String())
+	}
+}
+
+func TestAnimationFrameCallbacks(t *testing.T) {
+	t.Parallel()
+
+	cases := [][2]string{
+		// the callbacks of a frame share its timestamp, the ones they request wait for the next
+		{`
+		requestAnimationFrame((ts) => log.push("a" + ts.toFixed(3)));
+		requestAnimationFrame((ts) => {
+			log.push("b" + ts.toFixed(3));
+			requestAnimationFrame((ts) => log.push("c" + ts.toFixed(3)));
+		})`, "a16.667,b16.667,c33.333"},
+		{`const id = requestAnimationFrame(() => log.push("a")); requestAnimationFrame(() => log.push("b")); cancelAnimationFrame(id)`, "b"},
+		{`const id = requestAnimationFrame(() => { log.push("a"); cancelAnimationFrame(id2) }); const id2 = requestAnimationFrame(() => log.push("b"))`, "a"},
+		{`cancelAnimationFrame(); cancelAnimationFrame("abc"); cancelAnimationFrame(100); requestAnimationFrame(() => log.push("a"))`, "a"},
+		// the IDs are the ones of the timers
+		{`log.push(setTimeout(() => {}, 0), requestAnimationFrame(() => {}), setImmediate(() => {}))`, "1,2,3"},
+		{`requestAnimationFrame(function () { log.push(arguments.length, typeof this) })`, "1,undefined"},
+	}
+
+	for i, c := range cases {
+		vc := NewVirtualClock()
+		ctx, err := newV8ContextWithFrames(WithAnimationFrames(), WithVirtualClock(vc))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := ctx.RunScript(`"use strict"; var log = []; `+c[0], "animation_frame_callbacks.js"); err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		vc.Advance(50 * time.Millisecond)
+
+		val, err := ctx.RunScript("log.join()", "animation_frame_callbacks.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
+
+func TestAnimationFrameOptions(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithFrames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// not injected by default
+	val, err := ctx.RunScript("typeof requestAnimationFrame + ',' + typeof cancelAnimationFrame", "animation_frame_options.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "undefined,undefined"; val.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, val.String())
+	}
+
+	vc := NewVirtualClock()
+	if ctx, err = newV8ContextWithFrames(WithFrameInterval(40*time.Millisecond), WithVirtualClock(vc)); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][2]string{
+		{`requestAnimationFrame()`, "TypeError: Failed to execute 'requestAnimationFrame': 1 argument required, but only 0 present."},
+		{`requestAnimationFrame("log.push(1)")`, "TypeError: Failed to execute 'requestAnimationFrame': parameter 1 is not of type 'FrameRequestCallback'."},
+		{`requestAnimationFrame({})`, "TypeError: Failed to execute 'requestAnimationFrame': parameter 1 is not of type 'FrameRequestCallback'."},
+	}
+
+	for i, c := range cases {
+		val, err := ctx.RunScript("try { String("+c[0]+") } catch (e) { String(e) }", "animation_frame_options.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+
+	if _, err := ctx.RunScript(frameScript, "animation_frame_options.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	vc.Advance(100 * time.Millisecond)
+
+	if val, err = ctx.RunScript("times.join()", "animation_frame_options.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "40,80"; val.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, val.String())
+	}
+}
+
+func newV8ContextWithFrames(opt ...Option) (*v8go.Context, error) {
+	iso := v8go.NewIsolate()
+	global := v8go.NewObjectTemplate(iso)
+
+	if err := InjectTo(iso, global, opt...); err != nil {
+		return nil, err
+	}
+
+	return v8go.NewContext(iso, global), nil
+}
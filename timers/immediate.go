@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package timers
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/weese/v8go-polyfills/internal"
+	"github.com/weese/v8go-polyfills/timers/internal"
+	"rogchap.com/v8go"
+)
+
+func (t *timers) GetSetImmediateFunctionCallback() v8go.FunctionCallback {
+	return func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		ctx := info.Context()
+
+		id, err := t.setImmediate(ctx, info.This(), info.Args())
+		if err != nil {
+			return throwTimerError(ctx, "setImmediate", err)
+		}
+
+		return newInt32Value(ctx, id)
+	}
+}
+
+func (t *timers) GetClearImmediateFunctionCallback() v8go.FunctionCallback {
+	return t.clearFunctionCallback
+}
+
+/*
+setImmediate queues the callback with the arguments after it, like in Node.js. It runs once
+the script and its microtasks are done, before the timers which are due by then,
+and so do the immediates queued by an immediate.
+*/
+func (t *timers) setImmediate(ctx *v8go.Context, this v8go.Valuer, args []*v8go.Value) (int32, error) {
+	if len(args) <= 0 {
+		return 0, errors.New("1 argument required, but only 0 present.")
+	}
+
+	handler, err := newHandler(ctx, this, args[0], args[1:])
+	if err != nil {
+		return 0, err
+	}
+
+	t.mu.Lock()
+	ct := t.timersOf(ctx)
+
+	item := &internal.Item{
+		FunctionCB: func(int) {
+			t.call(handler)
+		},
+	}
+
+	if err := t.addItem(ct, item); err != nil {
+		t.mu.Unlock()
+		return 0, err
+	}
+
+	ct.Immediates = append(ct.Immediates, item)
+	t.mu.Unlock()
+
+	if t.Clock != nil {
+		t.Clock.schedule(0, func() (time.Duration, bool) {
+			t.runImmediates(ctx, ct)
+			return 0, false
+		}, nil)
+	} else {
+		// no clock is needed, the task is queued right away
+		BeginTask(ctx.Isolate())(func() {
+			t.runImmediates(ctx, ct)
+		})
+	}
+
+	return item.ID, nil
+}
+
+/*
+runImmediates runs the immediates queued so far, on the goroutine owning the isolate.
+It's false when the context is cleared, nothing may run in it anymore.
+*/
+func (t *timers) runImmediates(ctx *v8go.Context, ct *contextTimers) bool {
+	t.mu.Lock()
+	closed := ct.closed
+	t.mu.Unlock()
+
+	if closed {
+		return false
+	}
+
+	t.mu.Lock()
+	immediates := ct.Immediates
+	ct.Immediates = nil
+	t.mu.Unlock()
+
+	for _, item := range immediates {
+		item.Call(0)
+		item.Clear()
+	}
+
+	return true
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package timers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSetImmediate(t *testing.T) {
+	t.Parallel()
+
+	cases := [][2]string{
+		{`setImmediate(() => log.push("i"))`, "sync,i"},
+		{`setImmediate((a, b) => log.push(a + b), 1, 2); setImmediate((...args) => log.push(args.length))`, "sync,3,0"},
+		{`const o = {}; setImmediate((x) => log.push(x === o), o)`, "sync,true"},
+		{`setImmediate("log.push(typeof this)")`, "sync,object"},
+		// after the script and the microtasks, before the timers due by then
+		{`
+		setTimeout(() => log.push("t1"), 0);
+		setImmediate(() => {
+			log.push("i1");
+			queueMicrotask(() => log.push("m2"));
+			setImmediate(() => log.push("i3"));
+		});
+		queueMicrotask(() => log.push("m1"));
+		Promise.resolve().then(() => log.push("p1"));
+		setImmediate(() => log.push("i2"));
+		const busy = Date.now();
+		while (Date.now() - busy < 10) {}`, "sync,m1,p1,i1,m2,i2,i3,t1"},
+		{`
+		setTimeout(() => {
+			log.push("t1");
+			setImmediate(() => log.push("i1"));
+			setTimeout(() => log.push("t2"), 0);
+		}, 0)`, "sync,t1,i1,t2"},
+		{`const id = setImmediate(() => log.push("i1")); setImmediate(() => log.push("i2")); clearImmediate(id)`, "sync,i2"},
+		{`const id = setImmediate(() => { log.push("i1"); clearImmediate(id2) }); const id2 = setImmediate(() => log.push("i2"))`, "sync,i1"},
+		{`const id = setImmediate(() => log.push("i1")); clearTimeout(id)`, "sync"},
+		{`const id = setTimeout(() => log.push("t1"), 0); clearImmediate(id)`, "sync"},
+	}
+
+	for i, c := range cases {
+		i, c := i, c
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+
+			ctx, reports, err := newV8ContextWithReport(NewTimers())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ctx.RunScript("const log = [];"+c[0]+"; log.push('sync'); setTimeout(() => report(log.join()), 100)", "set_immediate.js"); err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := receive(ctx.Isolate(), reports, 2*time.Second)
+			if !ok {
+				t.Fatal("timed out")
+			}
+
+			if got != c[1] {
+				t.Errorf("expected '%s' but got '%s'", c[1], got)
+			}
+		})
+	}
+}
+
+func TestSetImmediateTypeError(t *testing.T) {
+	t.Parallel()
+
+	ctx, _, err := newV8ContextWithReport(NewTimers())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][2]string{
+		{`setImmediate()`, "TypeError: Failed to execute 'setImmediate': 1 argument required, but only 0 present."},
+		{`setImmediate(1)`, "TypeError: Failed to execute 'setImmediate': The callback provided as parameter 1 is not a function."},
+		{`clearImmediate()`, "undefined"},
+		{`clearImmediate("abc")`, "undefined"},
+		{`clearImmediate(-1)`, "undefined"},
+	}
+
+	for i, c := range cases {
+		val, err := ctx.RunScript("try { String("+c[0]+") } catch (e) { String(e) }", "set_immediate_type_error.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
@@ -28,24 +28,47 @@ import (
 	"rogchap.com/v8go"
 )
 
-func InjectTo(iso *v8go.Isolate, global *v8go.ObjectTemplate) error {
-	t := NewTimers()
+func InjectTo(iso *v8go.Isolate, global *v8go.ObjectTemplate, opt ...Option) error {
+	_, err := Inject(iso, global, opt...)
+	return err
+}
+
+/*
+Inject injects the timers into global, and returns them. Stopping them before
+the contexts are disposed makes sure no timer fires into a disposed context.
+*/
+func Inject(iso *v8go.Isolate, global *v8go.ObjectTemplate, opt ...Option) (Timers, error) {
+	t := NewTimers(opt...)
 
-	for _, f := range []struct {
+	type injected struct {
 		Name string
 		Func func() v8go.FunctionCallback
-	}{
+	}
+
+	funcs := []injected{
 		{Name: "setTimeout", Func: t.GetSetTimeoutFunctionCallback},
 		{Name: "setInterval", Func: t.GetSetIntervalFunctionCallback},
 		{Name: "clearTimeout", Func: t.GetClearTimeoutFunctionCallback},
 		{Name: "clearInterval", Func: t.GetClearIntervalFunctionCallback},
-	} {
+		{Name: "queueMicrotask", Func: t.GetQueueMicrotaskFunctionCallback},
+		{Name: "setImmediate", Func: t.GetSetImmediateFunctionCallback},
+		{Name: "clearImmediate", Func: t.GetClearImmediateFunctionCallback},
+	}
+
+	if t.(*timers).FrameInterval > 0 {
+		funcs = append(funcs,
+			injected{Name: "requestAnimationFrame", Func: t.GetRequestAnimationFrameFunctionCallback},
+			injected{Name: "cancelAnimationFrame", Func: t.GetCancelAnimationFrameFunctionCallback},
+		)
+	}
+
+	for _, f := range funcs {
 		fn := v8go.NewFunctionTemplate(iso, f.Func())
 
 		if err := global.Set(f.Name, fn, v8go.ReadOnly); err != nil {
-			return fmt.Errorf("v8go-polyfills/timers: %w", err)
+			return nil, fmt.Errorf("v8go-polyfills/timers: %w", err)
 		}
 	}
 
-	return nil
+	return t, nil
 }
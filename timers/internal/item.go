@@ -23,56 +23,97 @@
 package internal
 
 import (
+	"sync"
 	"time"
 )
 
-type FunctionCallback func()
+// FunctionCallback runs the timer, level is the nesting level of the run
+type FunctionCallback func(level int)
 
 type ClearCallback func(id int32)
 
+// MaxNestingLevel is the nesting level after which the delays are clamped to NestedDelay
+const MaxNestingLevel = 5
+
 type Item struct {
 	ID       int32
-	Done     bool
-	Cleared  bool
 	Interval bool
-	Delay    int32
+	Delay    time.Duration
+
+	// Nesting is the level of the timer that set the item, 0 outside of timers
+	Nesting     int
+	NestedDelay time.Duration
 
 	ClearCB    ClearCallback
 	FunctionCB FunctionCallback
+
+	mu      sync.Mutex
+	cleared bool
 }
 
+/*
+Clear stops the item, it doesn't run anymore, even if its run is queued already.
+The callbacks are dropped, and with them the values they hold, like the
+arguments of the function.
+*/
 func (t *Item) Clear() {
-	if !t.Cleared {
-		t.Cleared = true
+	t.mu.Lock()
+	if t.cleared {
+		t.mu.Unlock()
+		return
+	}
 
-		if t.ClearCB != nil {
-			t.ClearCB(t.ID)
-		}
+	t.cleared = true
+
+	clearCB := t.ClearCB
+	t.ClearCB, t.FunctionCB = nil, nil
+	t.mu.Unlock()
+
+	if clearCB != nil {
+		clearCB(t.ID)
 	}
+}
+
+// Cleared tells if the item is cleared, by Clear or after its last call
+func (t *Item) Cleared() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	t.Done = true
+	return t.cleared
 }
 
-func (t *Item) Start() {
-	go func() {
-		defer t.Clear() // self clear
+// Call runs the callback of the item, unless it's cleared, and tells if it ran
+func (t *Item) Call(level int) bool {
+	t.mu.Lock()
+	fn := t.FunctionCB
+	t.mu.Unlock()
 
-		ticker := time.NewTicker(time.Duration(t.Delay) * time.Millisecond)
-		defer ticker.Stop()
+	if fn == nil {
+		return false
+	}
+
+	fn(level)
+	return true
+}
+
+/*
+Run calls the item for its run-th time, counted from 1, and tells if it runs again.
+A timeout, or an interval cleared by its callback, is cleared once it ran.
+*/
+func (t *Item) Run(run int) bool {
+	if !t.Call(t.Nesting+run) || !t.Interval || t.Cleared() {
+		t.Clear()
+		return false
+	}
 
-		for range ticker.C {
-			if t.Done {
-				break
-			}
+	return true
+}
 
-			if t.FunctionCB != nil {
-				t.FunctionCB()
-			}
+// DelayOf is the delay before the run, from the second run on an interval nests one level deeper
+func (t *Item) DelayOf(run int) time.Duration {
+	if t.NestedDelay > 0 && t.Nesting+run-1 > MaxNestingLevel && t.Delay < t.NestedDelay {
+		return t.NestedDelay
+	}
 
-			if !t.Interval {
-				t.Done = true
-				break
-			}
-		}
-	}()
+	return t.Delay
 }
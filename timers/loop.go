@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package timers
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	. "github.com/weese/v8go-polyfills/internal"
+
+	"rogchap.com/v8go"
+)
+
+/*
+loopClock is the time of the timers, unless WithVirtualClock. Its goroutine waits for the
+next callback due and posts it as a task of the isolate, which the goroutine owning the
+isolate runs between its scripts. So a callback never runs while a script or its
+microtasks do, and the callbacks due at the same time run in the order they were set.
+A pending callback is a task begun on the loop, RunUntilIdle waits for it.
+*/
+type loopClock struct {
+	mu      sync.Mutex
+	origin  time.Time
+	seq     uint64
+	pending timerQueue
+	started bool
+	stopped bool
+
+	wake chan struct{}
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+func newLoopClock() *loopClock {
+	return &loopClock{
+		origin: time.Now(),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+}
+
+// now is the time since the clock was made, on the monotonic clock
+func (c *loopClock) now() time.Duration {
+	return time.Since(c.origin)
+}
+
+/*
+schedule posts fire to the loop of iso after delay, and again after the delay it returns,
+until cleared tells so. The goroutine of the clock is started with the first callback.
+*/
+func (c *loopClock) schedule(iso *v8go.Isolate, delay time.Duration, fire func() (time.Duration, bool), cleared func() bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		return
+	}
+
+	c.seq++
+	c.push(&scheduledTimer{due: c.now() + delay, seq: c.seq, fire: fire, cleared: cleared, iso: iso})
+
+	if !c.started {
+		c.started = true
+		c.done.Add(1)
+		go c.run()
+	}
+}
+
+// push begins the task of st on the loop of its isolate and queues st, c.mu must be held
+func (c *loopClock) push(st *scheduledTimer) {
+	st.post = BeginTask(st.iso)
+	heap.Push(&c.pending, st)
+	c.wakeUp()
+}
+
+// wakeUp has the goroutine look at the callbacks again, when one is sooner or cleared
+func (c *loopClock) wakeUp() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run is the goroutine of the clock, it ends with close
+func (c *loopClock) run() {
+	defer c.done.Done()
+
+	for {
+		c.mu.Lock()
+		c.dropCleared()
+
+		now := c.now()
+		for len(c.pending) > 0 && c.pending[0].due <= now {
+			st := heap.Pop(&c.pending).(*scheduledTimer)
+			post := st.post
+			st.post = nil
+
+			post(func() { c.fire(st) })
+		}
+
+		var timer *time.Timer
+		var due <-chan time.Time
+		if len(c.pending) > 0 {
+			timer = time.NewTimer(c.pending[0].due - now)
+			due = timer.C
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.stop:
+			if timer != nil {
+				timer.Stop()
+			}
+
+			c.mu.Lock()
+			for _, st := range c.pending {
+				st.post(nil)
+			}
+			c.pending = nil
+			c.mu.Unlock()
+
+			return
+		case <-c.wake:
+		case <-due:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+/*
+dropCleared drops all the cleared callbacks, not only the next one, so their tasks
+don't keep RunUntilIdle waiting until they would be due. c.mu must be held.
+*/
+func (c *loopClock) dropCleared() {
+	kept := c.pending[:0]
+	for _, st := range c.pending {
+		if st.cleared != nil && st.cleared() {
+			st.post(nil)
+			continue
+		}
+
+		kept = append(kept, st)
+	}
+
+	for i := len(kept); i < len(c.pending); i++ {
+		c.pending[i] = nil
+	}
+
+	c.pending = kept
+	heap.Init(&c.pending)
+}
+
+/*
+fire runs st on the goroutine owning its isolate. The ticks of an interval are due at
+start + n*delay, the time the callback takes doesn't add up, and the ticks missed
+meanwhile are skipped instead of run back to back.
+*/
+func (c *loopClock) fire(st *scheduledTimer) {
+	delay, ok := st.fire()
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		return
+	}
+
+	st.due += delay
+	if late := c.now() - st.due; late > 0 {
+		if delay <= 0 {
+			st.due += late
+		} else {
+			st.due += (late + delay - 1) / delay * delay
+		}
+	}
+
+	c.seq++
+	st.seq = c.seq
+	c.push(st)
+}
+
+// close ends the goroutine of the clock, the tasks of the pending callbacks are dropped
+func (c *loopClock) close() {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return
+	}
+
+	c.stopped = true
+	close(c.stop)
+	c.mu.Unlock()
+
+	c.done.Wait()
+}
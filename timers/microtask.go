@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package timers
+
+import (
+	"rogchap.com/v8go"
+)
+
+/*
+queueMicrotaskScript queues the callback as the reaction of a resolved promise, that's a job
+of the microtask queue of V8 like EnqueueMicrotask, so the callbacks interleave with
+the promise reactions in the order they're queued. The callback is called without arguments,
+and an exception rejects the promise of the reaction, it's reported as an unhandled rejection.
+*/
+const queueMicrotaskScript = `(function (callback) {
+  Promise.resolve().then(function () {
+    callback();
+  });
+})`
+
+func (t *timers) GetQueueMicrotaskFunctionCallback() v8go.FunctionCallback {
+	return func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		ctx := info.Context()
+		args := info.Args()
+
+		if len(args) <= 0 {
+			return throwTypeError(ctx, "Failed to execute 'queueMicrotask': 1 argument required, but only 0 present.")
+		}
+
+		if !args[0].IsFunction() {
+			return throwTypeError(ctx, "Failed to execute 'queueMicrotask': parameter 1 is not of type 'Function'.")
+		}
+
+		queue, err := t.queueMicrotask(ctx)
+		if err != nil {
+			return throwTypeError(ctx, "Failed to execute 'queueMicrotask': "+err.Error())
+		}
+
+		if _, err := queue.Call(v8go.Undefined(ctx.Isolate()), args[0]); err != nil {
+			return throwTypeError(ctx, "Failed to execute 'queueMicrotask': "+err.Error())
+		}
+
+		return nil
+	}
+}
+
+// queueMicrotask returns the function queueing the microtasks of ctx, it's compiled once per context
+func (t *timers) queueMicrotask(ctx *v8go.Context) (*v8go.Function, error) {
+	t.mu.Lock()
+	ct := t.timersOf(ctx)
+	queue := ct.QueueMicrotask
+	t.mu.Unlock()
+
+	if queue != nil {
+		return queue, nil
+	}
+
+	val, err := ctx.RunScript(queueMicrotaskScript, "queue-microtask.js")
+	if err != nil {
+		return nil, err
+	}
+
+	if queue, err = val.AsFunction(); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	ct.QueueMicrotask = queue
+	t.mu.Unlock()
+
+	return queue, nil
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package timers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueueMicrotask(t *testing.T) {
+	t.Parallel()
+
+	ctx, _, err := newV8ContextWithReport(NewTimers())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][2]string{
+		{`queueMicrotask(() => log.push("m1"))`, "m1"},
+		{`queueMicrotask(function () { log.push(typeof this, arguments.length) })`, "object,0"},
+		{`queueMicrotask(function () { "use strict"; log.push(typeof this) })`, "undefined"},
+		// the reactions of promises and the microtasks run in the order they're queued
+		{`
+		Promise.resolve().then(() => log.push("p1"));
+		queueMicrotask(() => log.push("m1"));
+		Promise.resolve().then(() => log.push("p2"));
+		queueMicrotask(() => {
+			log.push("m2");
+			Promise.resolve().then(() => log.push("p4"));
+			queueMicrotask(() => log.push("m4"));
+		});
+		Promise.resolve().then(() => {
+			log.push("p3");
+			queueMicrotask(() => log.push("m5"));
+		})`, "p1,m1,p2,m2,p3,p4,m4,m5"},
+		{`
+		(async () => {
+			log.push("a1");
+			await null;
+			log.push("a2");
+		})();
+		queueMicrotask(() => log.push("m1"));
+		log.push("sync")`, "a1,sync,a2,m1"},
+		// an exception doesn't stop the next microtasks
+		{`
+		queueMicrotask(() => { throw new Error("m1") });
+		queueMicrotask(() => log.push("m2"))`, "m2"},
+	}
+
+	for i, c := range cases {
+		if _, err := ctx.RunScript("var log = []; "+c[0], "queue_microtask.js"); err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		// the microtasks ran when the script returned
+		val, err := ctx.RunScript("log.join()", "queue_microtask.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
+
+func TestQueueMicrotaskBeforeTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx, reports, err := newV8ContextWithReport(NewTimers())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx.RunScript(`
+	const log = [];
+	setTimeout(() => {
+		log.push("t1");
+		queueMicrotask(() => log.push("m2"));
+		Promise.resolve().then(() => log.push("p2"));
+	}, 0);
+	setTimeout(() => {
+		log.push("t2");
+		report(log.join());
+	}, 50);
+	queueMicrotask(() => log.push("m1"));
+	Promise.resolve().then(() => log.push("p1"))`, "queue_microtask_timeout.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := receive(ctx.Isolate(), reports, 2*time.Second)
+	if !ok {
+		t.Fatal("timed out")
+	}
+
+	if expected := "m1,p1,t1,m2,p2,t2"; got != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, got)
+	}
+}
+
+func TestQueueMicrotaskTypeError(t *testing.T) {
+	t.Parallel()
+
+	ctx, _, err := newV8ContextWithReport(NewTimers())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][2]string{
+		{`queueMicrotask()`, "TypeError: Failed to execute 'queueMicrotask': 1 argument required, but only 0 present."},
+		{`queueMicrotask(undefined)`, "TypeError: Failed to execute 'queueMicrotask': parameter 1 is not of type 'Function'."},
+		{`queueMicrotask("log.push(1)")`, "TypeError: Failed to execute 'queueMicrotask': parameter 1 is not of type 'Function'."},
+		{`queueMicrotask({})`, "TypeError: Failed to execute 'queueMicrotask': parameter 1 is not of type 'Function'."},
+		{`queueMicrotask(Promise.resolve())`, "TypeError: Failed to execute 'queueMicrotask': parameter 1 is not of type 'Function'."},
+	}
+
+	for i, c := range cases {
+		val, err := ctx.RunScript("try { "+c[0]+" } catch (e) { String(e) }", "queue_microtask_type_error.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
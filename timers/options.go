@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package timers
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"rogchap.com/v8go"
+)
+
+const (
+	// DefaultMinDelay is the shortest delay of a timer, shorter ones wait that long
+	DefaultMinDelay = time.Millisecond
+
+	// NestedMinDelay is the delay browsers clamp nested timers to
+	NestedMinDelay = 4 * time.Millisecond
+
+	// DefaultFrameInterval is the time between two animation frames, 60 frames per second
+	DefaultFrameInterval = time.Second / 60
+)
+
+// ErrorHandler gets the exceptions thrown by the callbacks of the timers
+type ErrorHandler func(err *v8go.JSError)
+
+// defaultErrorHandler logs the exceptions to stderr, with their stack traces
+func defaultErrorHandler(err *v8go.JSError) {
+	fmt.Fprintf(os.Stderr, "v8go-polyfills/timers: %+v\n", err)
+}
+
+type Option interface {
+	apply(t *timers)
+}
+
+type optionFunc func(t *timers)
+
+func (f optionFunc) apply(t *timers) {
+	f(t)
+}
+
+/*
+WithMinDelay sets the shortest delay of setTimeout and setInterval, DefaultMinDelay by default.
+Shorter delays, 0 included, are clamped to it. With 0 an interval of 0 runs back to back.
+*/
+func WithMinDelay(d time.Duration) Option {
+	return optionFunc(func(t *timers) {
+		if d < 0 {
+			d = 0
+		}
+		t.MinDelay = d
+	})
+}
+
+/*
+WithNestingClamp clamps the delays to NestedMinDelay once the timers nest deeper than
+5 levels, like browsers do,
+https://html.spec.whatwg.org/multipage/timers-and-user-prompts.html#timer-initialisation-steps
+A timer set in the callback of another is one level deeper, so is every tick of an interval.
+*/
+func WithNestingClamp() Option {
+	return optionFunc(func(t *timers) {
+		t.NestedMinDelay = NestedMinDelay
+	})
+}
+
+/*
+WithErrorHandler sets the handler of the exceptions thrown by the callbacks of the timers,
+and of the panics of Go functions they call. By default they're logged to stderr.
+The timer goes on anyway, an interval keeps ticking.
+*/
+func WithErrorHandler(handler ErrorHandler) Option {
+	return optionFunc(func(t *timers) {
+		if handler == nil {
+			handler = defaultErrorHandler
+		}
+		t.ErrorHandler = handler
+	})
+}
+
+/*
+WithVirtualClock runs the timers on the time of clock instead of the real time,
+their callbacks run when Advance or RunAll of clock is called, on its goroutine.
+*/
+func WithVirtualClock(clock *VirtualClock) Option {
+	return optionFunc(func(t *timers) {
+		t.Clock = clock
+	})
+}
+
+/*
+WithMaxActive limits how many timers a context has pending, timeouts, intervals and
+immediates together. One more throws a RangeError until one is cleared or has fired.
+There is no limit by default, or with n <= 0.
+*/
+func WithMaxActive(n int) Option {
+	return optionFunc(func(t *timers) {
+		t.MaxActive = n
+	})
+}
+
+/*
+WithAnimationFrames injects requestAnimationFrame and cancelAnimationFrame too,
+their frames are DefaultFrameInterval apart. They're not injected by default,
+some scripts only fall back to timers when requestAnimationFrame is missing.
+*/
+func WithAnimationFrames() Option {
+	return WithFrameInterval(DefaultFrameInterval)
+}
+
+// WithFrameInterval is WithAnimationFrames with frames d apart, a d <= 0 is DefaultFrameInterval
+func WithFrameInterval(d time.Duration) Option {
+	return optionFunc(func(t *timers) {
+		if d <= 0 {
+			d = DefaultFrameInterval
+		}
+		t.FrameInterval = d
+	})
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package timers
+
+import (
+	"context"
+
+	"github.com/weese/v8go-polyfills/timers/internal"
+	"rogchap.com/v8go"
+)
+
+func (t *timers) PendingCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.pendingCount()
+}
+
+func (t *timers) ActiveCount(ctx *v8go.Context) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ct, ok := t.contexts[ctx]; ok {
+		return len(ct.Items)
+	}
+
+	return 0
+}
+
+// pendingCount is PendingCount, t.mu must be held
+func (t *timers) pendingCount() int {
+	n := 0
+	for _, ct := range t.contexts {
+		n += len(ct.Items)
+	}
+
+	return n
+}
+
+/*
+Wait blocks until the timeouts and immediates have run and the intervals are cleared,
+the timers they set included, then it returns nil. When ctx is done first,
+it returns the error of ctx, the timers keep running. The callbacks run on the loops
+of the isolates, which have to be run meanwhile by the goroutines owning them.
+*/
+func (t *timers) Wait(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		n, changed := t.pendingCount(), t.changed
+		t.mu.Unlock()
+
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+/*
+ClearContext clears the timers of ctx, none fires in ctx once it returns, not even
+the ones already queued on the loop. The state of ctx is dropped with the callbacks
+and their arguments, so ctx can be closed. The timers of the other contexts go on.
+*/
+func (t *timers) ClearContext(ctx *v8go.Context) {
+	t.mu.Lock()
+	ct, ok := t.contexts[ctx]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+
+	ct.closed = true
+	delete(t.contexts, ctx)
+
+	items := make([]*internal.Item, 0, len(ct.Items))
+	for _, item := range ct.Items {
+		items = append(items, item)
+	}
+	t.mu.Unlock()
+
+	for _, item := range items {
+		item.Clear()
+	}
+}
+
+/*
+Stop clears all the timers and ends the goroutine of their clock. None fires once
+it returns, not even the ones already queued on the loops, and the callbacks and their
+arguments are dropped, so the contexts can be disposed. Later timers throw a TypeError.
+*/
+func (t *timers) Stop() {
+	t.mu.Lock()
+	t.stopped = true
+
+	var items []*internal.Item
+	for _, ct := range t.contexts {
+		for _, item := range ct.Items {
+			items = append(items, item)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, item := range items {
+		item.Clear()
+	}
+
+	if t.loop != nil {
+		t.loop.close()
+	}
+
+	t.mu.Lock()
+	t.contexts = make(map[*v8go.Context]*contextTimers)
+	t.mu.Unlock()
+}
@@ -0,0 +1,316 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package timers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+	"rogchap.com/v8go"
+)
+
+func TestTimersStop(t *testing.T) {
+	// not parallel, goleak would see the goroutines of the other tests
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	iso := v8go.NewIsolate()
+	global := v8go.NewObjectTemplate(iso)
+
+	var fired atomic.Int32
+	firedFn := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		fired.Add(1)
+		return nil
+	})
+	if err := global.Set("fired", firedFn); err != nil {
+		t.Fatal(err)
+	}
+
+	tm, err := Inject(iso, global)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := v8go.NewContext(iso, global)
+
+	// 100 timers 10ms apart, and an interval and immediates for good measure
+	if _, err := ctx.RunScript(`
+	for (let i = 1; i <= 100; i++) {
+		setTimeout(fired, i * 10, { i });
+	}
+	setInterval(() => {}, 5);
+	setImmediate(() => {});`, "timers_stop.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := tm.PendingCount(); n != 102 {
+		t.Errorf("expected 102 pending timers but got %d", n)
+	}
+
+	for fired.Load() < 50 {
+		ProcessTasks(iso)
+		time.Sleep(time.Millisecond)
+	}
+
+	tm.Stop()
+
+	n := fired.Load()
+	if n < 50 || n >= 100 {
+		t.Errorf("expected about half of the timers to fire but got %d", n)
+	}
+
+	if n := tm.PendingCount(); n != 0 {
+		t.Errorf("expected no pending timers but got %d", n)
+	}
+
+	if err := tm.Wait(context.Background()); err != nil {
+		t.Errorf("expected no error but got %v", err)
+	}
+
+	val, err := ctx.RunScript(`try { setTimeout(fired, 0) } catch (e) { String(e) }`, "timers_stop.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "TypeError: Failed to execute 'setTimeout': The timers are stopped."; val.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, val.String())
+	}
+
+	// none fires after Stop, not even those queued already
+	time.Sleep(50 * time.Millisecond)
+	ProcessTasks(iso)
+
+	ctx.Close()
+	iso.Dispose()
+
+	if fired.Load() != n {
+		t.Errorf("expected %d timers to fire but got %d", n, fired.Load())
+	}
+}
+
+func TestTimersWait(t *testing.T) {
+	t.Parallel()
+
+	iso := v8go.NewIsolate()
+	global := v8go.NewObjectTemplate(iso)
+
+	tm, err := Inject(iso, global)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tm.Stop()
+
+	ctx := v8go.NewContext(iso, global)
+
+	// the timers set by timers are waited for too
+	if _, err := ctx.RunScript(`
+	globalThis.n = 0;
+	setTimeout(() => {
+		n++;
+		setTimeout(() => n++, 20);
+		setImmediate(() => n++);
+	}, 20);
+	const id = setInterval(() => ++n === 10 && clearInterval(id), 1)`, "timers_wait.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := RunUntilIdle(waitCtx, iso); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tm.Wait(waitCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := tm.PendingCount(); n != 0 {
+		t.Errorf("expected no pending timers but got %d", n)
+	}
+
+	val, err := ctx.RunScript("n", "timers_wait.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val.Int32() != 13 {
+		t.Errorf("expected 13 calls but got %d", val.Int32())
+	}
+
+	// an interval is pending until it's cleared
+	if _, err := ctx.RunScript(`setInterval(() => {}, 10)`, "timers_wait.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitCtx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := tm.Wait(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v but got %v", context.DeadlineExceeded, err)
+	}
+
+	if n := tm.PendingCount(); n != 1 {
+		t.Errorf("expected the interval to be pending but got %d timers", n)
+	}
+}
+
+func TestClearContext(t *testing.T) {
+	t.Parallel()
+
+	tm := NewTimers()
+
+	iso := v8go.NewIsolate()
+	global, reports, err := newGlobalWithReport(iso, tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx1 := v8go.NewContext(iso, global)
+	ctx2 := v8go.NewContext(iso, global)
+
+	for i, ctx := range []*v8go.Context{ctx1, ctx2} {
+		script := fmt.Sprintf(`setInterval(() => report("interval %d"), 5); setTimeout(() => report("timeout %d"), 20); setImmediate(() => {})`, i, i)
+		if _, err := ctx.RunScript(script, "clear_context.js"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tm.ClearContext(ctx1)
+	ctx1.Close()
+
+	if n := tm.ActiveCount(ctx1); n != 0 {
+		t.Errorf("expected no timers of the cleared context but got %d", n)
+	}
+
+	if n := tm.ActiveCount(ctx2); n != 3 {
+		t.Errorf("expected the 3 timers of the other context but got %d", n)
+	}
+
+	// only the other context gets its timers
+	for {
+		got, ok := receive(iso, reports, 2*time.Second)
+		if !ok {
+			t.Fatal("timed out")
+		}
+
+		if strings.HasSuffix(got, " 0") {
+			t.Fatalf("expected no calls in the cleared context but got '%s'", got)
+		}
+
+		if got == "timeout 1" {
+			tm.ClearContext(ctx2)
+			return
+		}
+	}
+}
+
+func TestTimersIsolates(t *testing.T) {
+	t.Parallel()
+
+	// the same timers in two isolates, each clears other timers
+	tm := NewTimers()
+	clears := []string{
+		`for (let i = 0; i < 2000; i += 2) clearTimeout(ids[i]);
+		for (let i = 1; i < 2000; i += 4) clearInterval(ids[i]);`,
+		`for (let i = 1; i < 2000; i += 2) clearTimeout(ids[i]);`,
+	}
+	expected := []int32{500, 1000}
+
+	ctxs := make([]*v8go.Context, len(clears))
+	errs := make(chan error, len(clears))
+
+	var wg sync.WaitGroup
+	for i, clear := range clears {
+		wg.Add(1)
+		go func(i int, clear string) {
+			defer wg.Done()
+
+			iso := v8go.NewIsolate()
+			global, _, err := newGlobalWithReport(iso, tm)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			ctx := v8go.NewContext(iso, global)
+			ctxs[i] = ctx
+
+			val, err := ctx.RunScript(`
+			var fired = 0;
+			const ids = [];
+			for (let i = 0; i < 2000; i++) {
+				ids.push(setTimeout(() => fired++, i % 5));
+			}
+			`+clear+`
+			ids.every((id, i) => id === i + 1)`, "timers_isolates.js")
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			if !val.Boolean() {
+				errs <- fmt.Errorf("isolate %d: expected the IDs 1 to 2000", i)
+				return
+			}
+
+			// each goroutine runs the timers of its isolate
+			runCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := RunUntilIdle(runCtx, iso); err != nil {
+				errs <- err
+			}
+		}(i, clear)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := tm.Wait(waitCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, ctx := range ctxs {
+		val, err := ctx.RunScript("fired", "timers_isolates.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if val.Int32() != expected[i] {
+			t.Errorf("isolate %d: expected %d timers to fire but got %d", i, expected[i], val.Int32())
+		}
+	}
+}
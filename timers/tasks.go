@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package timers
+
+import (
+	"context"
+
+	. "github.com/weese/v8go-polyfills/internal"
+
+	"rogchap.com/v8go"
+)
+
+/*
+The callbacks of the timers run as tasks of the isolate, v8go isolates aren't safe
+for concurrent use. The goroutine owning the isolate has to run them, between its scripts,
+with ProcessTasks, RunTasks or RunUntilIdle. So the script and its microtasks are done
+before a timer fires, like in the event loop of the browsers and Node.js.
+The tasks of fetch and of the other polyfills of the isolate are run together.
+*/
+
+// ProcessTasks runs the queued tasks of iso without waiting for more, it returns how many ran
+func ProcessTasks(iso *v8go.Isolate) int {
+	return ProcessLoop(iso)
+}
+
+// RunTasks runs the tasks of iso as they come until ctx is done, and returns the error of ctx
+func RunTasks(ctx context.Context, iso *v8go.Isolate) error {
+	return RunLoop(ctx, iso)
+}
+
+/*
+RunUntilIdle runs the tasks of iso until no timer of it is pending, an interval keeps
+it running until it's cleared. It returns the error of ctx if it's done first.
+*/
+func RunUntilIdle(ctx context.Context, iso *v8go.Isolate) error {
+	return RunLoopUntilIdle(ctx, iso)
+}
+
+/*
+RunScriptAndWait runs the script source in ctx, then the timers and the other tasks
+of its isolate until none is pending, like Node.js runs a script. It returns the value
+of the script, the exceptions of the timers go to the ErrorHandler.
+*/
+func RunScriptAndWait(ctx *v8go.Context, source, origin string) (*v8go.Value, error) {
+	val, err := ctx.RunScript(source, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	return val, RunLoopUntilIdle(context.Background(), ctx.Isolate())
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package timers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunScriptAndWait(t *testing.T) {
+	t.Parallel()
+
+	// the order is the one of Node.js running the same scripts
+	cases := [][2]string{
+		{`
+		log.push("script start");
+		setTimeout(() => log.push("setTimeout"), 0);
+		Promise.resolve().then(() => log.push("promise1")).then(() => log.push("promise2"));
+		queueMicrotask(() => log.push("microtask"));
+		log.push("script end");`, "script start,script end,promise1,microtask,promise2,setTimeout"},
+		{`
+		setTimeout(() => {
+			log.push("timeout1");
+			Promise.resolve().then(() => log.push("promise in timeout1"));
+		}, 0);
+		setTimeout(() => log.push("timeout2"), 0);`, "timeout1,promise in timeout1,timeout2"},
+		{`
+		setTimeout(() => log.push("c"), 20);
+		setTimeout(() => log.push("b"), 10);
+		setTimeout(() => log.push("a"), 0);`, "a,b,c"},
+		// the timeout is due long before the script is done, it still waits for it
+		{`
+		setTimeout(() => log.push("timeout"), 0);
+		const busy = Date.now();
+		while (Date.now() - busy < 20) {}
+		Promise.resolve().then(() => log.push("promise"));
+		log.push("script end");`, "script end,promise,timeout"},
+		{`
+		setTimeout(() => {
+			log.push("timeout1");
+			setTimeout(() => log.push("timeout2"), 0);
+			setImmediate(() => log.push("immediate"));
+		}, 0);`, "timeout1,immediate,timeout2"},
+		{`
+		setTimeout(() => {
+			log.push("t1");
+			setTimeout(() => log.push("t3"), 0);
+		}, 0);
+		setTimeout(() => log.push("t2"), 50);`, "t1,t3,t2"},
+	}
+
+	for i, c := range cases {
+		ctx, err := newV8ContextWithTimers()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := RunScriptAndWait(ctx, "const log = [];"+c[0], "run_script_and_wait.js"); err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		val, err := ctx.RunScript("log.join()", "run_script_and_wait.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
+
+func TestTimersWaitForTasks(t *testing.T) {
+	t.Parallel()
+
+	ctx, err := newV8ContextWithTimers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing runs until the tasks are, however long the timers are due
+	if _, err := ctx.RunScript(`var n = 0; setTimeout(() => n++, 0); setImmediate(() => n++)`, "timers_wait_for_tasks.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	val, err := ctx.RunScript("n", "timers_wait_for_tasks.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val.Int32() != 0 {
+		t.Errorf("expected no calls before the tasks run but got %d", val.Int32())
+	}
+
+	if n := ProcessTasks(ctx.Isolate()); n != 2 {
+		t.Errorf("expected 2 tasks but got %d", n)
+	}
+
+	val, err = ctx.RunScript("n", "timers_wait_for_tasks.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val.Int32() != 2 {
+		t.Errorf("expected 2 calls but got %d", val.Int32())
+	}
+
+	// an interval keeps the loop busy until it's cleared
+	if _, err := ctx.RunScript(`setInterval(() => n++, 5)`, "timers_wait_for_tasks.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	runCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := RunUntilIdle(runCtx, ctx.Isolate()); err != context.DeadlineExceeded {
+		t.Errorf("expected %v but got %v", context.DeadlineExceeded, err)
+	}
+}
@@ -23,7 +23,14 @@
 package timers
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/weese/v8go-polyfills/timers/internal"
 	"rogchap.com/v8go"
@@ -35,29 +42,103 @@ type Timers interface {
 
 	GetClearTimeoutFunctionCallback() v8go.FunctionCallback
 	GetClearIntervalFunctionCallback() v8go.FunctionCallback
+
+	GetQueueMicrotaskFunctionCallback() v8go.FunctionCallback
+
+	GetSetImmediateFunctionCallback() v8go.FunctionCallback
+	GetClearImmediateFunctionCallback() v8go.FunctionCallback
+
+	GetRequestAnimationFrameFunctionCallback() v8go.FunctionCallback
+	GetCancelAnimationFrameFunctionCallback() v8go.FunctionCallback
+
+	// PendingCount returns how many timeouts, intervals and immediates are pending
+	PendingCount() int
+
+	// ActiveCount returns how many timers of ctx are pending, WithMaxActive limits it
+	ActiveCount(ctx *v8go.Context) int
+
+	// Wait blocks until no timer is pending anymore, or ctx is done
+	Wait(ctx context.Context) error
+
+	// ClearContext clears the timers of ctx and drops its state, before ctx is closed
+	ClearContext(ctx *v8go.Context)
+
+	// Stop clears all the timers, none fires anymore once it returns
+	Stop()
 }
 
 type timers struct {
+	MinDelay       time.Duration
+	NestedMinDelay time.Duration
+	ErrorHandler   ErrorHandler
+	Clock          *VirtualClock
+	MaxActive      int
+	FrameInterval  time.Duration
+
+	mu       sync.Mutex
+	contexts map[*v8go.Context]*contextTimers
+	stopped  bool
+
+	// loop posts the callbacks to the loops of the isolates, unless Clock
+	loop *loopClock
+	// changed is closed when a timer is dropped
+	changed chan struct{}
+}
+
+/*
+contextTimers are the timers of one context. The IDs are counted per context,
+from 1 and never reused, so an ID of another context or of a fired timer can't
+clear a timer by accident.
+*/
+type contextTimers struct {
 	Items      map[int32]*internal.Item
 	NextItemID int32
+
+	// Nesting is the level of the timer running in the context, 0 when none
+	Nesting int
+
+	// Immediates are queued by setImmediate, they run before the next timer
+	Immediates []*internal.Item
+
+	// Frames are queued by requestAnimationFrame for the next frame, FrameTime is its timestamp
+	Frames         []*internal.Item
+	FrameScheduled bool
+	FrameTime      float64
+
+	// closed is set by ClearContext, no callback runs anymore
+	closed bool
+
+	QueueMicrotask *v8go.Function
 }
 
 const initNextItemID = 1
 
-func NewTimers() Timers {
-	return &timers{
-		Items:      make(map[int32]*internal.Item),
-		NextItemID: initNextItemID,
+func NewTimers(opt ...Option) Timers {
+	t := &timers{
+		MinDelay:     DefaultMinDelay,
+		ErrorHandler: defaultErrorHandler,
+		contexts:     make(map[*v8go.Context]*contextTimers),
+		changed:      make(chan struct{}),
+	}
+
+	for _, o := range opt {
+		o.apply(t)
+	}
+
+	if t.Clock == nil {
+		t.loop = newLoopClock()
 	}
+
+	return t
 }
 
 func (t *timers) GetSetTimeoutFunctionCallback() v8go.FunctionCallback {
 	return func(info *v8go.FunctionCallbackInfo) *v8go.Value {
 		ctx := info.Context()
 
-		id, err := t.startNewTimer(info.This(), info.Args(), false)
+		id, err := t.startNewTimer(ctx, info.This(), info.Args(), false)
 		if err != nil {
-			return newInt32Value(ctx, 0)
+			return throwTimerError(ctx, "setTimeout", err)
 		}
 
 		return newInt32Value(ctx, id)
@@ -68,9 +149,9 @@ func (t *timers) GetSetIntervalFunctionCallback() v8go.FunctionCallback {
 	return func(info *v8go.FunctionCallbackInfo) *v8go.Value {
 		ctx := info.Context()
 
-		id, err := t.startNewTimer(info.This(), info.Args(), true)
+		id, err := t.startNewTimer(ctx, info.This(), info.Args(), true)
 		if err != nil {
-			return newInt32Value(ctx, 0)
+			return throwTimerError(ctx, "setInterval", err)
 		}
 
 		return newInt32Value(ctx, id)
@@ -78,83 +159,341 @@ func (t *timers) GetSetIntervalFunctionCallback() v8go.FunctionCallback {
 }
 
 func (t *timers) GetClearTimeoutFunctionCallback() v8go.FunctionCallback {
-	return func(info *v8go.FunctionCallbackInfo) *v8go.Value {
-		args := info.Args()
-		if len(args) > 0 && args[0].IsInt32() {
-			t.clear(args[0].Int32(), false)
-		}
+	return t.clearFunctionCallback
+}
+
+func (t *timers) GetClearIntervalFunctionCallback() v8go.FunctionCallback {
+	return t.clearFunctionCallback
+}
+
+/*
+clearFunctionCallback is clearTimeout, clearInterval and clearImmediate, they share the list of timers,
+https://html.spec.whatwg.org/multipage/timers-and-user-prompts.html#dom-cleartimeout
+An ID that isn't a timer of the context is ignored, as the browsers do.
+*/
+func (t *timers) clearFunctionCallback(info *v8go.FunctionCallbackInfo) *v8go.Value {
+	args := info.Args()
+	if len(args) <= 0 {
+		return nil
+	}
 
+	id, ok := toTimerID(args[0])
+	if !ok {
 		return nil
 	}
+
+	if item := t.item(info.Context(), id); item != nil {
+		item.Clear()
+	}
+
+	return nil
 }
 
-func (t *timers) GetClearIntervalFunctionCallback() v8go.FunctionCallback {
-	return func(info *v8go.FunctionCallbackInfo) *v8go.Value {
-		args := info.Args()
-		if len(args) > 0 && args[0].IsInt32() {
-			t.clear(args[0].Int32(), true)
+// timersOf returns the timers of ctx, t.mu must be held
+func (t *timers) timersOf(ctx *v8go.Context) *contextTimers {
+	ct, ok := t.contexts[ctx]
+	if !ok {
+		ct = &contextTimers{
+			Items:      make(map[int32]*internal.Item),
+			NextItemID: initNextItemID,
 		}
+		t.contexts[ctx] = ct
+	}
 
-		return nil
+	return ct
+}
+
+func (t *timers) item(ctx *v8go.Context, id int32) *internal.Item {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ct, ok := t.contexts[ctx]; ok {
+		return ct.Items[id]
 	}
+
+	return nil
 }
 
-func (t *timers) clear(id int32, interval bool) {
-	if id < initNextItemID {
-		return
+/*
+toTimerID converts the handle to a long like the IDL of clearTimeout, for numbers and strings.
+Other values are never IDs, converting symbols would throw, so they're not converted at all.
+*/
+func toTimerID(val *v8go.Value) (int32, bool) {
+	var f float64
+	switch {
+	case val.IsNumber():
+		f = val.Number()
+	case val.IsString():
+		var err error
+		if f, err = strconv.ParseFloat(strings.TrimSpace(val.String()), 64); err != nil {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	f = math.Trunc(f)
+	if math.IsNaN(f) || f < initNextItemID || f > math.MaxInt32 {
+		return 0, false
 	}
 
-	if item, ok := t.Items[id]; ok && item.Interval == interval {
-		item.Clear()
+	return int32(f), true
+}
+
+/*
+toTimerDelay converts the timeout to a number like the IDL of setTimeout, then to a delay,
+https://html.spec.whatwg.org/multipage/timers-and-user-prompts.html#timer-initialisation-steps
+NaN and negative timeouts are 0, the ones above 2^31-1ms are clamped to it, where
+the browsers wrap them around. Objects are converted by Number, symbols and bigints throw.
+*/
+func toTimerDelay(ctx *v8go.Context, val *v8go.Value) (time.Duration, error) {
+	var f float64
+	switch {
+	case val.IsSymbol():
+		return 0, errors.New("Cannot convert a Symbol value to a number.")
+	case val.IsBigInt():
+		return 0, errors.New("Cannot convert a BigInt value to a number.")
+	case val.IsObject():
+		// valueOf may throw, converting it from Go would crash
+		num, err := ctx.Global().Get("Number")
+		if err != nil {
+			return 0, err
+		}
+
+		fn, err := num.AsFunction()
+		if err != nil {
+			return 0, err
+		}
+
+		if val, err = fn.Call(v8go.Undefined(ctx.Isolate()), val); err != nil {
+			return 0, err
+		}
+
+		f = val.Number()
+	default:
+		f = val.Number()
+	}
+
+	f = math.Trunc(f)
+	switch {
+	case math.IsNaN(f) || f < 0:
+		f = 0
+	case f > math.MaxInt32:
+		f = math.MaxInt32
 	}
+
+	return time.Duration(f) * time.Millisecond, nil
 }
 
-func (t *timers) startNewTimer(this v8go.Valuer, args []*v8go.Value, interval bool) (int32, error) {
+func (t *timers) startNewTimer(ctx *v8go.Context, this v8go.Valuer, args []*v8go.Value, interval bool) (int32, error) {
 	if len(args) <= 0 {
-		return 0, errors.New("1 argument required, but only 0 present")
+		return 0, errors.New("1 argument required, but only 0 present.")
 	}
 
-	fn, err := args[0].AsFunction()
+	handler, err := newHandler(ctx, this, args[0], args[min(len(args), 2):])
 	if err != nil {
 		return 0, err
 	}
 
-	var delay int32
-	if len(args) > 1 && args[1].IsInt32() {
-		delay = args[1].Int32()
+	var delay time.Duration
+	if len(args) > 1 {
+		if delay, err = toTimerDelay(ctx, args[1]); err != nil {
+			return 0, err
+		}
 	}
-	if delay < 10 {
-		delay = 10
+	if delay < t.MinDelay {
+		delay = t.MinDelay
 	}
 
-	var restArgs []v8go.Valuer
-	if len(args) > 2 {
-		restArgs = make([]v8go.Valuer, 0)
-		for _, arg := range args[2:] {
+	t.mu.Lock()
+	ct := t.timersOf(ctx)
+
+	var item *internal.Item
+	item = &internal.Item{
+		Delay:       delay,
+		Interval:    interval,
+		Nesting:     ct.Nesting,
+		NestedDelay: t.NestedMinDelay,
+		FunctionCB: func(level int) {
+			// an immediate may clear it
+			if !t.runImmediates(ctx, ct) || item.Cleared() {
+				return
+			}
+
+			t.setNesting(ct, level)
+			defer t.setNesting(ct, 0)
+
+			t.call(handler)
+		},
+	}
+
+	err = t.addItem(ct, item)
+	t.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	run := 0
+	t.schedule(ctx.Isolate(), item.DelayOf(1), func() (time.Duration, bool) {
+		run++
+		return item.DelayOf(run + 1), item.Run(run)
+	}, item.Cleared)
+
+	return item.ID, nil
+}
+
+// schedule runs fire on the loop of iso after delay, or on the VirtualClock
+func (t *timers) schedule(iso *v8go.Isolate, delay time.Duration, fire func() (time.Duration, bool), cleared func() bool) {
+	if t.Clock != nil {
+		t.Clock.schedule(delay, fire, cleared)
+	} else {
+		t.loop.schedule(iso, delay, fire, cleared)
+	}
+}
+
+// addItem gives item the next ID of the context, it's dropped when cleared, t.mu must be held
+func (t *timers) addItem(ct *contextTimers, item *internal.Item) error {
+	if t.stopped {
+		return errors.New("The timers are stopped.")
+	}
+
+	if ct.NextItemID == math.MaxInt32 {
+		return errors.New("The IDs of the timers are used up.")
+	}
+
+	if t.MaxActive > 0 && len(ct.Items) >= t.MaxActive {
+		return &rangeError{fmt.Errorf("The number of active timers exceeds the limit of %d.", t.MaxActive)}
+	}
+
+	item.ID = ct.NextItemID
+	item.ClearCB = func(id int32) {
+		t.mu.Lock()
+		delete(ct.Items, id)
+		close(t.changed)
+		t.changed = make(chan struct{})
+		t.mu.Unlock()
+
+		if t.loop != nil {
+			t.loop.wakeUp()
+		}
+	}
+
+	ct.NextItemID++
+	ct.Items[item.ID] = item
+
+	return nil
+}
+
+/*
+call runs the handler of a timer, its exception is passed to the ErrorHandler. So is a panic,
+it doesn't end the loop of the isolate, and with it the process.
+*/
+func (t *timers) call(handler func() error) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.ErrorHandler(&v8go.JSError{Message: fmt.Sprintf("panic: %v", r)})
+		}
+	}()
+
+	if err := handler(); err != nil {
+		var jsErr *v8go.JSError
+		if !errors.As(err, &jsErr) {
+			jsErr = &v8go.JSError{Message: err.Error()}
+		}
+
+		t.ErrorHandler(jsErr)
+	}
+}
+
+func (t *timers) setNesting(ct *contextTimers, level int) {
+	t.mu.Lock()
+	ct.Nesting = level
+	t.mu.Unlock()
+}
+
+/*
+newHandler returns the call of the function callback with the arguments args,
+https://html.spec.whatwg.org/multipage/timers-and-user-prompts.html#timer-initialisation-steps
+The arguments are the values v8go keeps until the context is closed, the handler holds them
+until the timer is cleared. A string is run as a script instead, without the arguments.
+*/
+func newHandler(ctx *v8go.Context, this v8go.Valuer, callback *v8go.Value, args []*v8go.Value) (func() error, error) {
+	if callback.IsFunction() {
+		fn, err := callback.AsFunction()
+		if err != nil {
+			return nil, err
+		}
+
+		var restArgs []v8go.Valuer
+		for _, arg := range args {
 			restArgs = append(restArgs, arg)
 		}
+
+		return func() error {
+			_, err := fn.Call(this, restArgs...)
+			return err
+		}, nil
 	}
 
-	item := &internal.Item{
-		ID:       t.NextItemID,
-		Done:     false,
-		Cleared:  false,
-		Delay:    delay,
-		Interval: interval,
-		FunctionCB: func() {
-			_, _ = fn.Call(this, restArgs...)
-		},
-		ClearCB: func(id int32) {
-			delete(t.Items, id)
-		},
+	if callback.IsString() {
+		source := callback.String()
+
+		return func() error {
+			_, err := ctx.RunScript(source, "timer-handler.js")
+			return err
+		}, nil
 	}
 
-	t.NextItemID++
-	t.Items[item.ID] = item
+	return nil, errors.New("The callback provided as parameter 1 is not a function.")
+}
 
-	item.Start()
+// rangeError is thrown as a RangeError, the other errors of the timers as a TypeError
+type rangeError struct {
+	err error
+}
 
-	return item.ID, nil
+func (e *rangeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rangeError) Unwrap() error {
+	return e.err
+}
+
+// throwTimerError throws the error of the timer function fn
+func throwTimerError(ctx *v8go.Context, fn string, err error) *v8go.Value {
+	msg := "Failed to execute '" + fn + "': " + err.Error()
+
+	var rangeErr *rangeError
+	if errors.As(err, &rangeErr) {
+		return throwError(ctx, "RangeError", msg)
+	}
+
+	return throwTypeError(ctx, msg)
+}
+
+func throwTypeError(ctx *v8go.Context, msg string) *v8go.Value {
+	return throwError(ctx, "TypeError", msg)
+}
+
+/*
+throwError throws an error of the constructor name with msg, v8go can't create one from Go,
+so it's made by the constructor of the context.
+*/
+func throwError(ctx *v8go.Context, name, msg string) *v8go.Value {
+	iso := ctx.Isolate()
+	msgVal, _ := v8go.NewValue(iso, msg)
+
+	if ctor, err := ctx.Global().Get(name); err == nil {
+		if fn, err := ctor.AsFunction(); err == nil {
+			if e, err := fn.NewInstance(msgVal); err == nil {
+				return iso.ThrowException(e.Value)
+			}
+		}
+	}
+
+	return iso.ThrowException(msgVal)
 }
 
 func newInt32Value(ctx *v8go.Context, i int32) *v8go.Value {
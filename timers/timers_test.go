@@ -23,6 +23,10 @@
 package timers
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
 	"testing"
 	"time"
 
@@ -42,7 +46,7 @@ func Test_SetTimeout(t *testing.T) {
 		return
 	}
 
-	val, err := ctx.RunScript(`
+	val, err := RunScriptAndWait(ctx, `
 	console.log(new Date().toUTCString());
 
 	setTimeout(function() {
@@ -62,8 +66,774 @@ func Test_SetTimeout(t *testing.T) {
 	if id := val.Int32(); id != 1 {
 		t.Errorf("except 1 but got %d", id)
 	}
+}
+
+func TestSetTimeoutArguments(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name     string
+		Script   string
+		Expected []string
+	}{
+		{"arguments", `setTimeout((a, b, c) => report(a + b, c), 10, 1, 2, "x")`, []string{"3 x"}},
+		{"no arguments", `setTimeout((...args) => report(args.length), 10)`, []string{"0"}},
+		{"delay only", `setTimeout((...args) => report(args.length), 10, undefined)`, []string{"1"}},
+		{"same object", `const o = { n: 1 }; setTimeout((x) => { x.n++; report(x === o, o.n) }, 10, o)`, []string{"true 2"}},
+		{"interval", `let n = 0; const id = setInterval((a, b) => { report(a, b, ++n); n === 2 && clearInterval(id) }, 10, "a", [1, 2])`, []string{"a 1,2 1", "a 1,2 2"}},
+		{"string", `setTimeout("report(typeof this, 1 + 1)", 10, "ignored")`, []string{"object 2"}},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, reports, err := newV8ContextWithReport(NewTimers())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ctx.RunScript(c.Script, "set_timeout_arguments.js"); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, expected := range c.Expected {
+				got, ok := receive(ctx.Isolate(), reports, 2*time.Second)
+				if !ok {
+					t.Fatalf("call %d: timed out", i)
+				}
+
+				if got != expected {
+					t.Errorf("call %d: expected '%s' but got '%s'", i, expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSetTimeoutInvalidCallback(t *testing.T) {
+	t.Parallel()
+
+	ctx, _, err := newV8ContextWithReport(NewTimers())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][2]string{
+		{`setTimeout()`, "TypeError: Failed to execute 'setTimeout': 1 argument required, but only 0 present."},
+		{`setInterval()`, "TypeError: Failed to execute 'setInterval': 1 argument required, but only 0 present."},
+		{`setTimeout(1, 10)`, "TypeError: Failed to execute 'setTimeout': The callback provided as parameter 1 is not a function."},
+		{`setTimeout({}, 10)`, "TypeError: Failed to execute 'setTimeout': The callback provided as parameter 1 is not a function."},
+		{`setInterval(null)`, "TypeError: Failed to execute 'setInterval': The callback provided as parameter 1 is not a function."},
+		{`setTimeout(() => {}, Symbol())`, "TypeError: Failed to execute 'setTimeout': Cannot convert a Symbol value to a number."},
+		{`setInterval(() => {}, 10n)`, "TypeError: Failed to execute 'setInterval': Cannot convert a BigInt value to a number."},
+		{`setTimeout(() => {}, { valueOf() { throw new Error("boom") } })`, "TypeError: Failed to execute 'setTimeout': Error: boom"},
+	}
+
+	for i, c := range cases {
+		val, err := ctx.RunScript("try { "+c[0]+" } catch (e) { String(e) }", "set_timeout_invalid.js")
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+
+		if val.String() != c[1] {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c[1], val.String())
+		}
+	}
+}
+
+func TestClearTimeoutReleasesArguments(t *testing.T) {
+	t.Parallel()
+
+	tm := NewTimers().(*timers)
+
+	ctx, reports, err := newV8ContextWithReport(tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := ctx.RunScript(`setTimeout((o) => report(o.n), 100000, { n: 1 })`, "clear_timeout_release.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := tm.item(ctx, val.Int32())
+	if item == nil || item.FunctionCB == nil {
+		t.Fatalf("expected the timer %d with its callback", val.Int32())
+	}
+
+	if _, err := ctx.RunScript(fmt.Sprintf("clearTimeout(%d)", val.Int32()), "clear_timeout_release.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the handler holds the function and its arguments, it's dropped with the timer
+	if !item.Cleared() || item.FunctionCB != nil || tm.item(ctx, val.Int32()) != nil {
+		t.Errorf("expected the timer to be dropped, cleared %v", item.Cleared())
+	}
+
+	if got, ok := receive(ctx.Isolate(), reports, 50*time.Millisecond); ok {
+		t.Errorf("expected no call but got '%s'", got)
+	}
+}
+
+func TestTimerIDs(t *testing.T) {
+	t.Parallel()
+
+	iso := v8go.NewIsolate()
+	global, _, err := newGlobalWithReport(iso, NewTimers())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := `
+	const ids = [setTimeout(() => {}, 100000), setInterval(() => {}, 100000)];
+	clearTimeout(ids[0]);
+	clearInterval(ids[1]);
+	ids.push(setInterval(() => {}, 100000), setTimeout(() => {}, 100000));
+	ids.forEach(clearTimeout);
+	ids.join()`
+
+	// every context counts its own IDs
+	for i := 0; i < 2; i++ {
+		ctx := v8go.NewContext(iso, global)
+
+		val, err := ctx.RunScript(script, "timer_ids.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if val.String() != "1,2,3,4" {
+			t.Errorf("context %d: expected '1,2,3,4' but got '%s'", i, val.String())
+		}
+	}
+}
+
+func TestClearTimerIgnored(t *testing.T) {
+	t.Parallel()
+
+	tm := NewTimers().(*timers)
+
+	ctx, _, err := newV8ContextWithReport(tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx.RunScript(`setTimeout(() => {}, 100000)`, "clear_timer_ignored.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []string{
+		``, `undefined`, `null`, `0`, `-1`, `0.5`, `2`, `99`, `2 ** 32 + 1`,
+		`NaN`, `Infinity`, `""`, `"abc"`, `"1abc"`, `true`, `{}`, `[1]`,
+		`{ valueOf() { return 1 } }`, `Symbol()`, `1n`,
+	}
+
+	for i, c := range cases {
+		for _, fn := range []string{"clearTimeout", "clearInterval"} {
+			val, err := ctx.RunScript("(function () { return String("+fn+"("+c+")) })()", "clear_timer_ignored.js")
+			if err != nil {
+				t.Errorf("case %d: %s: %v", i, fn, err)
+				continue
+			}
+
+			if val.String() != "undefined" {
+				t.Errorf("case %d: %s: expected 'undefined' but got '%s'", i, fn, val.String())
+			}
+
+			if tm.item(ctx, 1) == nil {
+				t.Fatalf("case %d: %s: expected the timer to be kept", i, fn)
+			}
+		}
+	}
+
+	// the same numbers as strings or with a fraction are the ID
+	for i, c := range []string{`clearTimeout("1")`, `setTimeout(() => {}, 100000); clearInterval(" 2 ")`, `setTimeout(() => {}, 100000); clearTimeout(3.9)`} {
+		if _, err := ctx.RunScript(c, "clear_timer_ignored.js"); err != nil {
+			t.Fatal(err)
+		}
+
+		if id := int32(i + 1); tm.item(ctx, id) != nil {
+			t.Errorf("case %d: expected the timer %d to be cleared", i, id)
+		}
+	}
+}
+
+func TestClearTimerKinds(t *testing.T) {
+	t.Parallel()
+
+	tm := NewTimers().(*timers)
+
+	ctx, _, err := newV8ContextWithReport(tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][2]string{
+		{`setTimeout(() => {}, 100000)`, "clearTimeout"},
+		{`setTimeout(() => {}, 100000)`, "clearInterval"},
+		{`setInterval(() => {}, 100000)`, "clearInterval"},
+		{`setInterval(() => {}, 100000)`, "clearTimeout"},
+	}
+
+	for i, c := range cases {
+		val, err := ctx.RunScript(c[0], "clear_timer_kinds.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id := val.Int32()
+		if _, err := ctx.RunScript(fmt.Sprintf("%s(%d)", c[1], id), "clear_timer_kinds.js"); err != nil {
+			t.Fatal(err)
+		}
+
+		if tm.item(ctx, id) != nil {
+			t.Errorf("case %d: expected %s to clear the timer of %s", i, c[1], c[0])
+		}
+	}
+}
+
+func TestClearTimerFired(t *testing.T) {
+	t.Parallel()
+
+	ctx, reports, err := newV8ContextWithReport(NewTimers())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx.RunScript(`const id = setTimeout(() => report("fired"), 10)`, "clear_timer_fired.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := receive(ctx.Isolate(), reports, 2*time.Second); !ok {
+		t.Fatal("timed out")
+	}
+
+	val, err := ctx.RunScript(`
+	clearTimeout(id);
+	clearTimeout(id);
+	clearInterval(id);
+	const next = setTimeout(() => {}, 100000);
+	clearTimeout(next);
+	next`, "clear_timer_fired.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val.Int32() != 2 {
+		t.Errorf("expected the next ID 2 but got %d", val.Int32())
+	}
+}
+
+func TestClearTimerOtherContext(t *testing.T) {
+	t.Parallel()
+
+	iso := v8go.NewIsolate()
+	global, reports, err := newGlobalWithReport(iso, NewTimers())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx1 := v8go.NewContext(iso, global)
+	ctx2 := v8go.NewContext(iso, global)
+
+	if _, err := ctx1.RunScript(`setTimeout(() => report("fired"), 50)`, "clear_timer_other.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx2.RunScript(`clearTimeout(1); clearInterval(1)`, "clear_timer_other.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := receive(iso, reports, 2*time.Second)
+	if !ok {
+		t.Fatal("expected the timer of the other context to fire")
+	}
+
+	if got != "fired" {
+		t.Errorf("expected 'fired' but got '%s'", got)
+	}
+}
+
+func TestSetIntervalDrift(t *testing.T) {
+	t.Parallel()
+
+	ctx, reports, err := newV8ContextWithReport(NewTimers())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 20 ticks at 50ms, the 20ms of every callback don't add up
+	if _, err := ctx.RunScript(`
+	let n = 0;
+	const start = Date.now();
+	const id = setInterval(() => {
+		const s = Date.now();
+		while (Date.now() - s < 20) {}
+
+		if (++n === 20) {
+			clearInterval(id);
+			report(Date.now() - start);
+		}
+	}, 50)`, "set_interval_drift.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := receive(ctx.Isolate(), reports, 5*time.Second)
+	if !ok {
+		t.Fatal("timed out")
+	}
+
+	var elapsed int
+	fmt.Sscan(got, &elapsed)
+
+	if elapsed < 1000 || elapsed > 1000+20+150 {
+		t.Errorf("expected about 1020ms but got %dms", elapsed)
+	}
+}
+
+func TestSetIntervalSkipsMissedTicks(t *testing.T) {
+	t.Parallel()
+
+	ctx, reports, err := newV8ContextWithReport(NewTimers())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first callback takes 2.5 intervals, the two ticks missed are skipped
+	if _, err := ctx.RunScript(`
+	const times = [];
+	const start = Date.now();
+	const id = setInterval(() => {
+		times.push(Date.now() - start);
+		if (times.length === 1) {
+			const s = Date.now();
+			while (Date.now() - s < 50) {}
+		}
+
+		if (times.length === 3) {
+			clearInterval(id);
+			report(...times);
+		}
+	}, 20)`, "set_interval_skip.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := receive(ctx.Isolate(), reports, 5*time.Second)
+	if !ok {
+		t.Fatal("timed out")
+	}
+
+	var times [3]int
+	fmt.Sscan(got, &times[0], &times[1], &times[2])
+
+	if times[1]-times[0] < 55 || times[2]-times[1] < 15 {
+		t.Errorf("expected the ticks at about 20, 80 and 100ms but got %s", got)
+	}
+}
+
+func TestClearIntervalInCallback(t *testing.T) {
+	t.Parallel()
+
+	tm := NewTimers().(*timers)
+
+	ctx, reports, err := newV8ContextWithReport(tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx.RunScript(`const id = setInterval(() => { clearInterval(id); report("tick") }, 0)`, "clear_interval_callback.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		got, ok := receive(ctx.Isolate(), reports, 100*time.Millisecond)
+		if !ok && i == 0 {
+			t.Fatal("timed out")
+		}
+
+		if ok && i > 0 {
+			t.Errorf("expected one tick but got '%s'", got)
+		}
+	}
+
+	if tm.item(ctx, 1) != nil {
+		t.Error("expected the interval to be cleared")
+	}
+}
+
+func TestMinDelay(t *testing.T) {
+	t.Parallel()
+
+	script := `
+	let n = 0;
+	const start = Date.now();
+	(function next() {
+		if (++n > 10) {
+			report(Date.now() - start);
+		} else {
+			setTimeout(next, 0);
+		}
+	})()`
+
+	cases := []struct {
+		Name     string
+		Options  []Option
+		Min, Max int
+	}{
+		{"default", nil, 10, 90},
+		{"min delay", []Option{WithMinDelay(20 * time.Millisecond)}, 200, 1000},
+		// the 7th timeout on nests 6 levels deep, so 4 of the 10 wait 4ms
+		{"nesting clamp", []Option{WithMinDelay(0), WithNestingClamp()}, 16, 1000},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, reports, err := newV8ContextWithReport(NewTimers(c.Options...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ctx.RunScript(script, "min_delay.js"); err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := receive(ctx.Isolate(), reports, 5*time.Second)
+			if !ok {
+				t.Fatal("timed out")
+			}
+
+			var elapsed int
+			fmt.Sscan(got, &elapsed)
+
+			if elapsed < c.Min || elapsed > c.Max {
+				t.Errorf("expected %d to %dms but got %dms", c.Min, c.Max, elapsed)
+			}
+		})
+	}
+}
+
+func TestSetTimeoutDelay(t *testing.T) {
+	t.Parallel()
+
+	const maxDelay = math.MaxInt32 * time.Millisecond
+
+	cases := []struct {
+		Delay    string
+		Expected time.Duration
+	}{
+		{"-1", time.Millisecond},
+		{"0", time.Millisecond},
+		{"NaN", time.Millisecond},
+		{"undefined", time.Millisecond},
+		{"null", time.Millisecond},
+		{`"abc"`, time.Millisecond},
+		{`"50"`, 50 * time.Millisecond},
+		{`" 0x10 "`, 16 * time.Millisecond},
+		{"true", time.Millisecond},
+		{"30.9", 30 * time.Millisecond},
+		{"{ valueOf() { return 40 } }", 40 * time.Millisecond},
+		// clamped instead of wrapped around
+		{"2**31 - 1", maxDelay},
+		{"2**31", maxDelay},
+		{"2**32", maxDelay},
+		{"2**32 + 5", maxDelay},
+		{"1e10", maxDelay},
+		{"Infinity", maxDelay},
+		{"-Infinity", time.Millisecond},
+	}
+
+	for i, c := range cases {
+		vc := NewVirtualClock()
+		ctx, _, err := newV8ContextWithReport(NewTimers(WithVirtualClock(vc)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, interval := range []string{"setTimeout", "setInterval"} {
+			if _, err := ctx.RunScript("var fired = 0; var id = "+interval+"(() => fired++, "+c.Delay+")", "set_timeout_delay.js"); err != nil {
+				t.Errorf("case %d: %v", i, err)
+				continue
+			}
+
+			vc.Advance(c.Expected - time.Millisecond)
+			if val, _ := ctx.RunScript("fired", "set_timeout_delay.js"); val.Int32() != 0 {
+				t.Errorf("case %d: %s fired before %v", i, interval, c.Expected)
+			}
+
+			vc.Advance(time.Millisecond)
+			if val, _ := ctx.RunScript("fired", "set_timeout_delay.js"); val.Int32() != 1 {
+				t.Errorf("case %d: expected %s to fire after %v", i, interval, c.Expected)
+			}
+
+			if _, err := ctx.RunScript("clearInterval(id)", "set_timeout_delay.js"); err != nil {
+				t.Errorf("case %d: %v", i, err)
+			}
+		}
+	}
+}
+
+func TestTimerExceptions(t *testing.T) {
+	t.Parallel()
+
+	errs := make(chan *v8go.JSError, 16)
+	tm := NewTimers(WithErrorHandler(func(err *v8go.JSError) {
+		errs <- err
+	}))
+
+	ctx, reports, err := newV8ContextWithReport(tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the interval goes on after the exception, and so do the other timers
+	if _, err := ctx.RunScript(`
+	let n = 0;
+	const id = setInterval(() => {
+		if (n++ === 0) {
+			throw new Error("first");
+		}
+
+		if (n === 4) {
+			clearInterval(id);
+			setTimeout("syntax error(", 0);
+			setImmediate(() => { throw new TypeError("immediate") });
+			setTimeout(() => report(n), 20);
+		}
+	}, 5)`, "timer_exceptions.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := receive(ctx.Isolate(), reports, 2*time.Second)
+	if !ok {
+		t.Fatal("timed out")
+	}
+
+	if got != "4" {
+		t.Errorf("expected 4 calls but got %s", got)
+	}
+
+	for i, expected := range []string{"Error: first", "TypeError: immediate", "SyntaxError: Unexpected identifier"} {
+		select {
+		case err := <-errs:
+			if err.Message != expected {
+				t.Errorf("error %d: expected '%s' but got '%s'", i, expected, err.Message)
+			}
+		default:
+			t.Errorf("error %d: expected '%s' but got none", i, expected)
+		}
+	}
+}
+
+func TestTimerPanic(t *testing.T) {
+	t.Parallel()
+
+	var got *v8go.JSError
+	tm := NewTimers(WithErrorHandler(func(err *v8go.JSError) {
+		got = err
+	})).(*timers)
+
+	tm.call(func() error {
+		panic("boom")
+	})
+
+	if got == nil || got.Message != "panic: boom" {
+		t.Errorf("expected 'panic: boom' but got %v", got)
+	}
+}
+
+func TestMaxActive(t *testing.T) {
+	t.Parallel()
+
+	vc := NewVirtualClock()
+	tm := NewTimers(WithMaxActive(3), WithVirtualClock(vc))
+
+	ctx, _, err := newV8ContextWithReport(tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Script   string
+		Advance  time.Duration
+		Expected string
+		Active   int
+	}{
+		{`var ticks = [0, 0, 0]; var ids = [0, 1, 2].map((i) => setInterval(() => ticks[i]++, 10)); ids.join()`, 0, "1,2,3", 3},
+		{`try { setInterval(() => {}, 10) } catch (e) { String(e) }`, 0, "RangeError: Failed to execute 'setInterval': The number of active timers exceeds the limit of 3.", 3},
+		{`try { setTimeout(() => {}, 10) } catch (e) { String(e) }`, 0, "RangeError: Failed to execute 'setTimeout': The number of active timers exceeds the limit of 3.", 3},
+		{`try { setImmediate(() => {}) } catch (e) { String(e) }`, 0, "RangeError: Failed to execute 'setImmediate': The number of active timers exceeds the limit of 3.", 3},
+		// the intervals keep working, the one which threw doesn't take an ID
+		{`ticks.join()`, 50 * time.Millisecond, "0,0,0", 3},
+		{`ticks.join()`, 0, "5,5,5", 3},
+		{`clearInterval(ids[0]); setTimeout(() => {}, 10)`, 10 * time.Millisecond, "4", 3},
+		{`setTimeout(() => {}, 10)`, 0, "5", 3},
+	}
+
+	for i, c := range cases {
+		val, err := ctx.RunScript(c.Script, "max_active.js")
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+
+		if val.String() != c.Expected {
+			t.Errorf("case %d: expected '%s' but got '%s'", i, c.Expected, val.String())
+		}
+
+		if n := tm.ActiveCount(ctx); n != c.Active {
+			t.Errorf("case %d: expected %d active timers but got %d", i, c.Active, n)
+		}
+
+		vc.Advance(c.Advance)
+	}
+}
+
+/*
+FuzzClearTimers runs the bytes as set and clear calls, with IDs of live, cleared
+and unknown timers, and compares the timers left with what's expected.
+*/
+func FuzzClearTimers(f *testing.F) {
+	f.Add([]byte{0, 1, 2, 3, 0, 6, 7})
+	f.Add([]byte{1, 1, 1, 10, 14, 18, 22, 0})
+	f.Add([]byte{0, 0, 255, 254, 2, 2, 3, 3, 35, 0})
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		tm := NewTimers().(*timers)
+
+		ctx, _, err := newV8ContextWithReport(tm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ctx.Isolate().Dispose()
+		defer ctx.Close()
+
+		live := make(map[int32]bool)
+		var last int32
+
+		for i, op := range ops {
+			var script string
+
+			switch op % 4 {
+			case 0:
+				script = `setTimeout(() => {}, 100000)`
+			case 1:
+				script = `setInterval(() => {}, 100000)`
+			case 2, 3:
+				fn := "clearTimeout"
+				if op%4 == 3 {
+					fn = "clearInterval"
+				}
+
+				// the IDs around the last one, the negative and unknown ones included
+				id := int32(op>>2) - 8 + last/2
+				script = fmt.Sprintf("%s(%d)", fn, id)
+				delete(live, id)
+			}
+
+			val, err := ctx.RunScript(script, "fuzz_clear_timers.js")
+			if err != nil {
+				t.Fatalf("op %d: %v", i, err)
+			}
+
+			if op%4 < 2 {
+				if id := val.Int32(); id != last+1 {
+					t.Fatalf("op %d: expected the ID %d but got %d", i, last+1, id)
+				}
+
+				last++
+				live[last] = true
+			}
+		}
+
+		for id := int32(-8); id <= last+8; id++ {
+			if got := tm.item(ctx, id) != nil; got != live[id] {
+				t.Errorf("timer %d: expected live %v but got %v", id, live[id], got)
+			}
+		}
+
+		for id := range live {
+			tm.item(ctx, id).Clear()
+		}
+	})
+}
+
+/*
+newV8ContextWithReport creates a context with the timers of tm and report(...args),
+which sends the arguments joined by spaces. The scripts report from the timer
+callbacks, the tests receive them while they run the tasks of the isolate.
+*/
+func newV8ContextWithReport(tm Timers) (*v8go.Context, <-chan string, error) {
+	iso := v8go.NewIsolate()
+
+	global, reports, err := newGlobalWithReport(iso, tm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return v8go.NewContext(iso, global), reports, nil
+}
+
+/*
+receive runs the tasks of iso until the next report, the callbacks of the timers only run
+on the goroutine owning the isolate. It's false when nothing is reported within timeout.
+*/
+func receive(iso *v8go.Isolate, reports <-chan string, timeout time.Duration) (string, bool) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		select {
+		case got := <-reports:
+			return got, true
+		default:
+		}
+
+		if time.Now().After(deadline) {
+			return "", false
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		RunTasks(ctx, iso)
+		cancel()
+	}
+}
+
+func newGlobalWithReport(iso *v8go.Isolate, tm Timers) (*v8go.ObjectTemplate, <-chan string, error) {
+	global := v8go.NewObjectTemplate(iso)
+
+	for name, cb := range map[string]v8go.FunctionCallback{
+		"setTimeout":     tm.GetSetTimeoutFunctionCallback(),
+		"setInterval":    tm.GetSetIntervalFunctionCallback(),
+		"clearTimeout":   tm.GetClearTimeoutFunctionCallback(),
+		"clearInterval":  tm.GetClearIntervalFunctionCallback(),
+		"queueMicrotask": tm.GetQueueMicrotaskFunctionCallback(),
+		"setImmediate":   tm.GetSetImmediateFunctionCallback(),
+		"clearImmediate": tm.GetClearImmediateFunctionCallback(),
+	} {
+		if err := global.Set(name, v8go.NewFunctionTemplate(iso, cb), v8go.ReadOnly); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	reports := make(chan string, 16)
+	reportFn := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		args := make([]string, len(info.Args()))
+		for i, arg := range info.Args() {
+			args[i] = arg.String()
+		}
+
+		reports <- strings.Join(args, " ")
+		return nil
+	})
+
+	if err := global.Set("report", reportFn, v8go.ReadOnly); err != nil {
+		return nil, nil, err
+	}
 
-	time.Sleep(time.Second * 6)
+	return global, reports, nil
 }
 
 func newV8ContextWithTimers() (*v8go.Context, error) {
@@ -25,6 +25,7 @@ package url
 import (
 	"errors"
 
+	. "github.com/weese/v8go-polyfills/internal"
 	"rogchap.com/v8go"
 )
 
@@ -33,6 +34,56 @@ func InjectTo(ctx *v8go.Context) error {
 		return errors.New("v8go-polyfills/url: ctx is required")
 	}
 
-	_, err := ctx.RunScript(urlPolyfill, "url-polyfill.js")
+	if _, err := ctx.RunScript(urlPolyfill, "url-polyfill.js"); err != nil {
+		return err
+	}
+
+	val, err := ctx.RunScript(urlPatches, "url-patches.js")
+	if err != nil {
+		return err
+	}
+
+	patch, err := val.AsFunction()
+	if err != nil {
+		return err
+	}
+
+	natives, err := newNativeObject(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = patch.Call(v8go.Undefined(ctx.Isolate()), natives)
 	return err
 }
+
+/*
+newNativeObject creates the object passed to url.js, holding the functions
+implemented in Go.
+*/
+func newNativeObject(ctx *v8go.Context) (*v8go.Object, error) {
+	iso := ctx.Isolate()
+
+	nativeTmp := v8go.NewObjectTemplate(iso)
+
+	domainToASCIIFn := v8go.NewFunctionTemplate(iso, domainToASCIICallback)
+
+	if err := nativeTmp.Set("domainToASCII", domainToASCIIFn, v8go.ReadOnly); err != nil {
+		return nil, err
+	}
+
+	return nativeTmp.NewInstance(ctx)
+}
+
+// domainToASCIICallback returns the ASCII form of a domain, or "" if it's invalid
+func domainToASCIICallback(info *v8go.FunctionCallbackInfo) *v8go.Value {
+	iso := info.Context().Isolate()
+
+	var ascii string
+	if args := info.Args(); len(args) > 0 {
+		ascii, _ = DomainToASCII(args[0].String())
+	}
+
+	val, _ := v8go.NewValue(iso, ascii)
+	return val
+}
@@ -28,3 +28,8 @@ import (
 
 //go:embed bundle.js
 var urlPolyfill string
+
+// url.js completes the core-js bundle where it falls short of the standard
+//
+//go:embed url.js
+var urlPatches string
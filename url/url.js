@@ -0,0 +1,468 @@
+/*
+ * Copyright (c) 2021 Xingwang Liao
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// completes the URL and URLSearchParams of core-js-pure in bundle.js where they
+// fall short of the WHATWG URL standard, it runs after the bundle, native holds
+// the functions implemented in Go
+(function (native) {
+  "use strict";
+
+  const NativeURL = globalThis.URL;
+  const NativeURLSearchParams = globalThis.URLSearchParams;
+  const searchParamsProto = NativeURLSearchParams.prototype;
+
+  // injected twice, the patches must not wrap themselves
+  const kPatched = Symbol.for("v8go-polyfills.url.patched");
+  if (searchParamsProto[kPatched]) {
+    return;
+  }
+
+  Object.defineProperty(searchParamsProto, kPatched, { value: true });
+
+  const loneSurrogates =
+    /[\uD800-\uDBFF](?![\uDC00-\uDFFF])|(?<![\uD800-\uDBFF])[\uDC00-\uDFFF]/g;
+
+  // WebIDL USVString, lone surrogates become U+FFFD, core-js would throw a URIError
+  // serializing them
+  function toUSVString(value) {
+    return `${value}`.replace(loneSurrogates, "\uFFFD");
+  }
+
+  function defineMethods(target, methods) {
+    for (const name of Object.keys(methods)) {
+      Object.defineProperty(target, name, {
+        value: methods[name],
+        writable: true,
+        enumerable: true,
+        configurable: true,
+      });
+    }
+  }
+
+  const {
+    append,
+    delete: remove,
+    entries,
+    get,
+    getAll,
+    has,
+    set,
+  } = searchParamsProto;
+
+  defineMethods(searchParamsProto, {
+    append(...args) {
+      return append.apply(this, args.map(toUSVString));
+    },
+    // with a value, only the pairs having both the name and the value
+    delete(...args) {
+      if (args.length < 2 || args[1] === undefined) {
+        return remove.apply(this, args.map(toUSVString));
+      }
+
+      const name = toUSVString(args[0]);
+      const value = toUSVString(args[1]);
+      const list = [...entries.call(this)];
+      const matches = ([k, v]) => k === name && v === value;
+
+      if (!list.some(matches)) {
+        return;
+      }
+
+      // core-js has no way to remove a single pair, the list is rebuilt in order
+      for (const k of new Set(list.map(([k]) => k))) {
+        remove.call(this, k);
+      }
+      for (const pair of list) {
+        if (!matches(pair)) {
+          append.call(this, pair[0], pair[1]);
+        }
+      }
+    },
+    get(...args) {
+      return get.apply(this, args.map(toUSVString));
+    },
+    getAll(...args) {
+      return getAll.apply(this, args.map(toUSVString));
+    },
+    // with a value, whether a pair has both the name and the value
+    has(...args) {
+      if (args.length < 2 || args[1] === undefined) {
+        return has.apply(this, args.map(toUSVString));
+      }
+
+      const name = toUSVString(args[0]);
+      const value = toUSVString(args[1]);
+
+      for (const [k, v] of entries.call(this)) {
+        if (k === name && v === value) {
+          return true;
+        }
+      }
+      return false;
+    },
+    set(...args) {
+      return set.apply(this, args.map(toUSVString));
+    },
+  });
+
+  Object.defineProperty(searchParamsProto, "size", {
+    get() {
+      return [...entries.call(this)].length;
+    },
+    enumerable: true,
+    configurable: true,
+  });
+
+  Object.defineProperty(
+    Object.getPrototypeOf(new NativeURLSearchParams().entries()),
+    Symbol.toStringTag,
+    { value: "URLSearchParams Iterator", configurable: true }
+  );
+
+  function constructError(message) {
+    return new TypeError(`Failed to construct 'URLSearchParams': ${message}`);
+  }
+
+  // the pairs of a sequence or record init, converted like the arguments of the
+  // methods, another URLSearchParams is a sequence too
+  function initPairs(init) {
+    if (typeof init[Symbol.iterator] === "function") {
+      return Array.from(init, (pair) => {
+        if (
+          pair === null ||
+          (typeof pair !== "object" && typeof pair !== "function") ||
+          typeof pair[Symbol.iterator] !== "function"
+        ) {
+          throw constructError(
+            "The provided value cannot be converted to a sequence."
+          );
+        }
+
+        const values = [...pair];
+        if (values.length !== 2) {
+          throw constructError(
+            "Sequence initializer must only contain pair elements"
+          );
+        }
+        return values.map(toUSVString);
+      });
+    }
+
+    // symbol keys are skipped, a later key equal to an earlier one after the
+    // conversion replaces its value
+    const record = new Map();
+    for (const key of Reflect.ownKeys(init)) {
+      const desc = Reflect.getOwnPropertyDescriptor(init, key);
+      if (typeof key === "string" && desc !== undefined && desc.enumerable) {
+        record.set(toUSVString(key), toUSVString(init[key]));
+      }
+    }
+    return [...record];
+  }
+
+  // the instances keep the prototype of core-js, which url.searchParams has as well
+  function URLSearchParams(init = "") {
+    if (new.target === undefined) {
+      throw constructError("Please use the 'new' operator");
+    }
+
+    if (
+      init !== null &&
+      (typeof init === "object" || typeof init === "function")
+    ) {
+      init = initPairs(init);
+    } else {
+      init = toUSVString(init);
+    }
+
+    return Reflect.construct(NativeURLSearchParams, [init], new.target);
+  }
+
+  URLSearchParams.prototype = searchParamsProto;
+  Object.defineProperty(searchParamsProto, "constructor", {
+    value: URLSearchParams,
+    writable: true,
+    configurable: true,
+  });
+
+  Object.defineProperty(globalThis, "URLSearchParams", {
+    value: URLSearchParams,
+    writable: true,
+    configurable: true,
+  });
+
+  const urlDescriptors = Object.getOwnPropertyDescriptors(NativeURL.prototype);
+  const getHref = urlDescriptors.href.get;
+  const setHref = urlDescriptors.href.set;
+  const getHostname = urlDescriptors.hostname.get;
+  const setHostname = urlDescriptors.hostname.set;
+  const getSearch = urlDescriptors.search.get;
+  const setSearch = urlDescriptors.search.set;
+
+  const specialSchemes = ["ftp:", "file:", "http:", "https:", "ws:", "wss:"];
+
+  // core-js punycodes hosts without the UTS #46 mapping and checks of browsers,
+  // the domains of special urls go through golang.org/x/net/idna after it
+  function domainToASCII(url) {
+    if (!specialSchemes.includes(url.protocol)) {
+      return;
+    }
+
+    const hostname = getHostname.call(url);
+
+    // IPv6, empty hosts and plain ASCII ones don't change
+    if (
+      hostname === "" ||
+      hostname[0] === "[" ||
+      (/^[0-9a-z._-]+$/.test(hostname) && !/(^|\.)xn--/.test(hostname))
+    ) {
+      return;
+    }
+
+    const ascii = native.domainToASCII(hostname);
+    if (ascii === "") {
+      throw new TypeError("Invalid host");
+    }
+
+    if (ascii !== hostname) {
+      setHostname.call(url, ascii);
+    }
+  }
+
+  // core-js leaves the ", < and > of queries as they are, they are in the query
+  // percent-encode set, a % which is there already stays as it is
+  function encodeQuery(url) {
+    const search = getSearch.call(url);
+
+    if (/["<>]/.test(search)) {
+      setSearch.call(url, search.replace(/["<>]/g, encodeURIComponent));
+    }
+  }
+
+  // core-js empties the host of a file url whose path starts with a Windows
+  // drive letter, file://host/C:/ keeps it like the base's host does for "/"
+  function fileHost(url, input, base) {
+    if (
+      url.protocol !== "file:" ||
+      getHostname.call(url) !== "" ||
+      !/^\/[a-zA-Z]:/.test(url.pathname)
+    ) {
+      return;
+    }
+
+    input = toUSVString(input)
+      .replace(/^[\0- ]+|[\0- ]+$/g, "")
+      .replace(/[\t\n\r]/g, "");
+
+    const scheme = /^[a-zA-Z][a-zA-Z0-9+.-]*:/.exec(input);
+    const rest = scheme ? input.slice(scheme[0].length) : input;
+    const authority = /^[\\/]{2}([^\\/?#]*)/.exec(rest);
+    let host = "";
+
+    if (authority) {
+      host = authority[1];
+    } else if (base !== undefined) {
+      base = new URL(base);
+      if (base.protocol === "file:") {
+        host = getHostname.call(base);
+      }
+    }
+
+    // a drive letter in place of the host belongs to the path
+    if (
+      host !== "" &&
+      host.toLowerCase() !== "localhost" &&
+      !/^[a-zA-Z][:|]$/.test(host)
+    ) {
+      setHostname.call(url, host);
+    }
+  }
+
+  function URL(...args) {
+    if (new.target === undefined) {
+      throw new TypeError(
+        "Failed to construct 'URL': Please use the 'new' operator"
+      );
+    }
+
+    const url = Reflect.construct(NativeURL, args, new.target);
+    fileHost(url, args[0], args[1]);
+    domainToASCII(url);
+    encodeQuery(url);
+    return url;
+  }
+
+  // the statics of core-js, like createObjectURL
+  for (const key of Reflect.ownKeys(NativeURL)) {
+    if (!["length", "name", "prototype"].includes(key)) {
+      Object.defineProperty(
+        URL,
+        key,
+        Object.getOwnPropertyDescriptor(NativeURL, key)
+      );
+    }
+  }
+
+  URL.prototype = NativeURL.prototype;
+  Object.defineProperty(NativeURL.prototype, "constructor", {
+    value: URL,
+    writable: true,
+    configurable: true,
+  });
+
+  // an href with an invalid domain throws, like other invalid ones
+  Object.defineProperty(NativeURL.prototype, "href", {
+    get: getHref,
+    set(value) {
+      const href = getHref.call(this);
+      setHref.call(this, value);
+
+      try {
+        fileHost(this, value);
+        domainToASCII(this);
+      } catch (e) {
+        setHref.call(this, href);
+        throw e;
+      }
+
+      encodeQuery(this);
+    },
+    enumerable: true,
+    configurable: true,
+  });
+
+  Object.defineProperty(NativeURL.prototype, "search", {
+    get: getSearch,
+    set(value) {
+      setSearch.call(this, value);
+      encodeQuery(this);
+    },
+    enumerable: true,
+    configurable: true,
+  });
+
+  // whether a port follows the host in value, outside of the brackets of IPv6
+  function hasPort(url, value) {
+    const ends = specialSchemes.includes(url.protocol) ? "/?#\\" : "/?#";
+    let brackets = false;
+
+    for (const c of value) {
+      if (ends.includes(c)) {
+        break;
+      } else if (c === "[") {
+        brackets = true;
+      } else if (c === "]") {
+        brackets = false;
+      } else if (c === ":" && !brackets) {
+        return true;
+      }
+    }
+    return false;
+  }
+
+  // invalid domains are ignored, like the other invalid hosts, and so is a hostname
+  // with a port, core-js would take the host before it
+  for (const name of ["host", "hostname"]) {
+    const { get, set } = urlDescriptors[name];
+
+    Object.defineProperty(NativeURL.prototype, name, {
+      get,
+      set(value) {
+        value = toUSVString(value);
+        if (name === "hostname" && hasPort(this, value)) {
+          return;
+        }
+
+        const href = getHref.call(this);
+        set.call(this, value);
+
+        try {
+          domainToASCII(this);
+        } catch (e) {
+          setHref.call(this, href);
+        }
+      },
+      enumerable: true,
+      configurable: true,
+    });
+  }
+
+  Object.defineProperty(globalThis, "URL", {
+    value: URL,
+    writable: true,
+    configurable: true,
+  });
+
+  // parses like the constructor does, so they can't disagree, a missing base is
+  // left out instead of being passed as undefined
+  function parse(args) {
+    const [url, base] = args.map(toUSVString);
+    return args.length < 2 || args[1] === undefined
+      ? new URL(url)
+      : new URL(url, base);
+  }
+
+  const origin = urlDescriptors.origin.get;
+
+  // read-only, core-js leaves the origin of a blob: url opaque, it's the one of
+  // the http or https url in its path
+  Object.defineProperty(NativeURL.prototype, "origin", {
+    get() {
+      const value = origin.call(this);
+      if (value !== "null" || this.protocol !== "blob:") {
+        return value;
+      }
+
+      try {
+        const inner = new URL(this.pathname);
+        if (inner.protocol === "http:" || inner.protocol === "https:") {
+          return inner.origin;
+        }
+      } catch (e) {
+        // an opaque origin then
+      }
+      return value;
+    },
+    enumerable: true,
+    configurable: true,
+  });
+
+  defineMethods(URL, {
+    // never throws, inputs which can't be converted to a string can't be parsed
+    canParse(...args) {
+      try {
+        parse(args);
+        return true;
+      } catch (e) {
+        return false;
+      }
+    },
+    // null instead of the TypeError of the constructor
+    parse(...args) {
+      try {
+        return parse(args);
+      } catch (e) {
+        return null;
+      }
+    },
+  });
+})